@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/syncserver"
+)
+
+// mako-sync - Self-hostable server that relays encrypted command history
+// between devices. Point clients at it with `mako sync init <url>`.
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8377", "address to listen on")
+	dbPath := flag.String("db", "mako-sync.db", "path to the server database")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file (serve HTTPS)")
+	tlsKey := flag.String("tls-key", "", "TLS key file")
+	flag.Parse()
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fmt.Fprintln(os.Stderr, "mako-sync: -tls-cert and -tls-key must be given together")
+		os.Exit(2)
+	}
+
+	server, err := syncserver.Open(*dbPath)
+	if err != nil {
+		log.Fatalf("mako-sync: %v", err)
+	}
+	defer server.Close()
+
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           logRequests(server.Handler()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	log.Printf("mako-sync listening on %s (database %s)", *addr, *dbPath)
+	if *tlsCert != "" {
+		err = httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("mako-sync: %v", err)
+	}
+}
+
+// logRequests logs each request without its query or headers, which may
+// identify the account
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start).Round(time.Millisecond))
+	})
+}
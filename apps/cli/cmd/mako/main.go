@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -13,13 +14,16 @@ import (
 
 	"github.com/creack/pty"
 	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/backup"
 	"github.com/fabiobrug/mako.git/internal/cache"
 	"github.com/fabiobrug/mako.git/internal/config"
 	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/importer"
 	"github.com/fabiobrug/mako.git/internal/onboarding"
 	"github.com/fabiobrug/mako.git/internal/shell"
 	"github.com/fabiobrug/mako.git/internal/stream"
 	"github.com/joho/godotenv"
+	"golang.org/x/term"
 )
 
 func main() {
@@ -65,6 +69,9 @@ func main() {
 		}
 	}
 
+	// Unlock the secrets store and move plaintext API keys into it
+	onboarding.UnlockSecrets()
+
 	// Load configuration
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -179,11 +186,19 @@ func runShellWrapper() {
 	fmt.Print(reset)
 	dbPath := filepath.Join(os.Getenv("HOME"), ".mako", "history.db")
 	db, err := database.NewDB(dbPath)
+	if errors.Is(err, database.ErrPassphraseRequired) {
+		db, err = openEncryptedDB(dbPath)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Could not open database: %v\n", err)
 		db = nil // Ensure db is nil on error
 	}
 	
+	// Record the tokens and cost of AI calls, embeddings included
+	if db != nil {
+		ai.SetUsageLog(shell.NewUsageLog(db))
+	}
+	
 	// Initialize embedding cache
 	embeddingCache := cache.NewEmbeddingCache(10000) // Max 10k entries
 	if db != nil && embeddingCache != nil {
@@ -205,11 +220,39 @@ func runShellWrapper() {
 			log.Printf("Warning: Failed to initialize embedding provider: %v", err)
 		}
 	}
-	
+
+	// Apply the retention policy, relearn preferences from the history and
+	// take scheduled backups in the background, at most once a day, once an
+	// hour and once per backup interval
+	if db != nil {
+		if cfg, err := config.LoadConfig(); err == nil {
+			policy := database.RetentionPolicy{
+				MaxCommands:     cfg.HistoryLimit,
+				MaxAge:          time.Duration(cfg.HistoryRetention) * 24 * time.Hour,
+				EmbeddingMaxAge: time.Duration(cfg.EmbeddingRetention) * 24 * time.Hour,
+			}
+			go func() {
+				if _, err := db.ApplyRetention(policy, 24*time.Hour); err != nil {
+					log.Printf("Warning: Failed to apply history retention: %v", err)
+				}
+				if _, err := db.LearnPreferences(time.Hour); err != nil {
+					log.Printf("Warning: Failed to learn preferences: %v", err)
+				}
+				if cfg.BackupInterval > 0 {
+					interval := time.Duration(cfg.BackupInterval) * 24 * time.Hour
+					if _, err := backup.RunScheduled(db, config.GetMakoDir(), backup.Dir(), interval, cfg.BackupKeep); err != nil {
+						log.Printf("Warning: Scheduled backup failed: %v", err)
+					}
+				}
+			}()
+		}
+	}
+
 	defer func() {
 		if db != nil {
-			// Save cache to database
-			if embeddingCache != nil {
+			// Save cache to database. Cache keys are plaintext commands, so an
+			// encrypted history keeps the cache in memory only
+			if embeddingCache != nil && !db.IsEncrypted() {
 				if err := embeddingCache.Save(db.GetConn()); err != nil {
 					// Log error but don't fail the shutdown process
 					log.Printf("Warning: Failed to save embedding cache: %v", err)
@@ -250,7 +293,7 @@ func runShellWrapper() {
 	
 	if strings.Contains(shellName, "zsh") {
 		// For zsh, use ZDOTDIR to point to custom rc file
-		makoRcPath := createMakoRc("zsh")
+		makoRcPath := createMakoRc("zsh", interceptor.ExitNonce())
 		makoDir := filepath.Dir(makoRcPath)
 		defer os.RemoveAll(makoDir)
 		
@@ -258,7 +301,7 @@ func runShellWrapper() {
 		cmd.Env = append(os.Environ(), fmt.Sprintf("ZDOTDIR=%s", makoDir))
 	} else {
 		// For bash and other shells, use --rcfile
-		makoRcPath := createMakoRc("bash")
+		makoRcPath := createMakoRc("bash", interceptor.ExitNonce())
 		defer os.Remove(makoRcPath)
 		cmd = exec.Command(shellPath, "--rcfile", makoRcPath, "-i")
 	}
@@ -354,7 +397,7 @@ func runShellWrapper() {
 	interceptor.Tee(os.Stdout, ptmx)
 }
 
-func createMakoRc(shellType string) string {
+func createMakoRc(shellType, exitNonce string) string {
 	homeDir := os.Getenv("HOME")
 	makoDir := filepath.Join(homeDir, ".mako")
 	cmdFile := filepath.Join(makoDir, "last_command.txt")
@@ -392,7 +435,33 @@ else
     # Only set prompt if user has no zshrc (and likely no theme)
     PROMPT='%%F{cyan}%%~%%f %%F{white}❯%%f '
 fi
-`, cmdFile, homeDir, homeDir)
+
+# Report commands that exit non-zero so mako can offer to explain them. The
+# nonce isn't exported, so programs can't forge a report.
+__mako_exit_nonce=%s
+__mako_remember_command() {
+    __mako_last_command="$1"
+}
+__mako_report_exit() {
+    local code=$?
+    if [ "$code" -ne 0 ] && [ -n "$__mako_last_command" ]; then
+        printf '\033]6973;mako-exit;%%s;%%d;%%s\007' "$__mako_exit_nonce" "$code" "$(printf '%%s' "$__mako_last_command" | base64 | tr -d '\n')"
+    fi
+    __mako_last_command=""
+    return $code
+}
+preexec_functions+=(__mako_remember_command)
+# First, so it sees the command's exit status
+precmd_functions=(__mako_report_exit $precmd_functions)
+
+# Alt-E explains the last failed command
+__mako_why() {
+    zle -I
+    mako why
+}
+zle -N __mako_why
+bindkey '\ee' __mako_why
+`, cmdFile, homeDir, homeDir, exitNonce)
 
 		rcPath := filepath.Join(tmpDir, ".zshrc")
 		if err := os.WriteFile(rcPath, []byte(content), 0644); err != nil {
@@ -426,8 +495,27 @@ mako() {
 # PS1
 PS1='\[\033[0;36m\]\w\[\033[1;37m\] ❯ \[\033[0m\]'
 
+# Report commands that exit non-zero so mako can offer to explain them. An
+# empty line leaves history alone, so a failure is only reported once. The
+# nonce isn't exported, so programs can't forge a report.
+__mako_exit_nonce=%s
+__mako_last_entry=$(HISTTIMEFORMAT= builtin history 1)
+__mako_report_exit() {
+    local code=$? entry
+    entry=$(HISTTIMEFORMAT= builtin history 1)
+    if [ "$code" -ne 0 ] && [ -n "$entry" ] && [ "$entry" != "$__mako_last_entry" ]; then
+        printf '\033]6973;mako-exit;%%s;%%d;%%s\007' "$__mako_exit_nonce" "$code" "$(printf '%%s' "$entry" | sed 's/^ *[0-9]* *//' | base64 | tr -d '\n')"
+    fi
+    __mako_last_entry=$entry
+    return $code
+}
+PROMPT_COMMAND="__mako_report_exit${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+
+# Alt-E explains the last failed command
+bind -x '"\ee": mako why' 2>/dev/null
+
 echo ""
-`, homeDir, homeDir, cmdFile)
+`, homeDir, homeDir, cmdFile, exitNonce)
 
 		tmpFile, err = os.CreateTemp("", "makorc-*.sh")
 		if err != nil {
@@ -446,22 +534,24 @@ func syncBashHistory(db *database.DB) {
 	
 	// Try bash history first, then zsh history
 	histFile := filepath.Join(homeDir, ".bash_history")
-	data, err := os.ReadFile(histFile)
-	if err != nil {
+	if _, err := os.Stat(histFile); err != nil {
 		// Try zsh history
 		histFile = filepath.Join(homeDir, ".zsh_history")
-		data, err = os.ReadFile(histFile)
-		if err != nil {
+		if _, err := os.Stat(histFile); err != nil {
 			// Try alternative zsh history location
 			histFile = filepath.Join(homeDir, ".zhistory")
-			data, err = os.ReadFile(histFile)
-			if err != nil {
+			if _, err := os.Stat(histFile); err != nil {
 				return
 			}
 		}
 	}
 
-	lines := strings.Split(string(data), "\n")
+	// Parse with the matching format so zsh's ": <start>:<elapsed>;" prefix
+	// is not stored as part of the command
+	entries, err := importer.ForFile(histFile).Read(histFile)
+	if err != nil {
+		return
+	}
 
 	recent, err := db.GetRecentCommands(20)
 	if err != nil {
@@ -477,22 +567,21 @@ func syncBashHistory(db *database.DB) {
 
 	embedService, _ := ai.NewEmbeddingProvider()
 
-	startIdx := len(lines) - 10
+	startIdx := len(entries) - 10
 	if startIdx < 0 {
 		startIdx = 0
 	}
 
-	for i := startIdx; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
-			continue
-		}
+	for _, entry := range entries[startIdx:] {
+		line := entry.Command
 
-		if existing[line] {
+		if line == "exit" || line == "clear" || line == "history" {
 			continue
 		}
 
-		if line == "exit" || line == "clear" || line == "history" {
+		// Redact before the command reaches the embedding provider
+		line = database.RedactSecrets(line)
+		if existing[line] {
 			continue
 		}
 
@@ -505,7 +594,7 @@ func syncBashHistory(db *database.DB) {
 			Command:    line,
 			Timestamp:  time.Now(),
 			ExitCode:   0,
-			Duration:   0,
+			Duration:   entry.Duration.Milliseconds(),
 			WorkingDir: workingDir,
 			Embedding:  embeddingBytes,
 		}
@@ -515,3 +604,27 @@ func syncBashHistory(db *database.DB) {
 	}
 }
 
+// openEncryptedDB prompts for the history passphrase, allowing a few attempts
+func openEncryptedDB(dbPath string) (*database.DB, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("%w (set %s)", database.ErrPassphraseRequired, database.PassphraseEnv)
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		fmt.Print("History passphrase: ")
+		passphrase, readErr := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		var db *database.DB
+		db, err = database.NewDBWithPassphrase(dbPath, string(passphrase))
+		if !errors.Is(err, database.ErrWrongKey) {
+			return db, err
+		}
+		fmt.Fprintln(os.Stderr, "Wrong passphrase, try again.")
+	}
+	return nil, err
+}
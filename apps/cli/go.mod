@@ -6,8 +6,9 @@ require (
 	github.com/atotto/clipboard v0.1.4
 	github.com/creack/pty v1.1.24
 	github.com/joho/godotenv v1.5.1
-	golang.org/x/term v0.18.0
+	golang.org/x/term v0.32.0
 	modernc.org/sqlite v1.45.0
+	mvdan.cc/sh/v3 v3.12.0
 )
 
 require (
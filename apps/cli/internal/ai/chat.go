@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/prompts"
+)
+
+// maxChatCommands caps how many commands a single chat reply can propose
+const maxChatCommands = 3
+
+// buildChatPrompt builds the prompt for one message in mako chat. Unlike
+// command generation the reply is prose, with any proposed commands in
+// fenced code blocks that ParseChatReply picks out.
+func buildChatPrompt(message string, context SystemContext, conversation *ConversationHistory, window ModelWindow) Prompt {
+	data := newPromptData(context)
+	data.Request = message
+	data.MaxCommands = maxChatCommands
+	return fitPrompt(prompts.Chat, data, context, conversation, window, chatReplyTokens)
+}
+
+var chatCodeBlock = regexp.MustCompile("(?s)```([A-Za-z]*)[ \t]*\n(.*?)```")
+
+// ParseChatReply splits a chat reply into its prose and the shell commands
+// proposed in fenced code blocks. Blocks tagged with a language other than
+// a shell are kept as text.
+func ParseChatReply(reply string) (string, []string) {
+	var commands []string
+	text := chatCodeBlock.ReplaceAllStringFunc(reply, func(block string) string {
+		match := chatCodeBlock.FindStringSubmatch(block)
+		switch strings.ToLower(match[1]) {
+		case "", "sh", "bash", "zsh", "shell", "console":
+		default:
+			return block
+		}
+		command := strings.TrimSpace(match[2])
+		if command == "" || len(commands) >= maxChatCommands {
+			return ""
+		}
+		commands = append(commands, strings.TrimPrefix(command, "$ "))
+		return ""
+	})
+
+	// Collapse the blank lines left where blocks were
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), commands
+}
@@ -0,0 +1,72 @@
+package ai
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChatReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		text     string
+		commands []string
+	}{
+		{
+			name:     "fenced block",
+			reply:    "This lists them by size:\n\n```\nls -lS\n```\n",
+			text:     "This lists them by size:",
+			commands: []string{"ls -lS"},
+		},
+		{
+			name:     "shell tags and prompt marker",
+			reply:    "Run:\n```bash\n$ git status\n```\nthen\n```sh \ngit diff\n```",
+			text:     "Run:\n\nthen",
+			commands: []string{"git status", "git diff"},
+		},
+		{
+			name:     "several commands between prose",
+			reply:    "First build it:\n\n```shell\nmake build\n```\n\nThen run the tests:\n\n```console\nmake test\n```\n\nBoth should pass.",
+			text:     "First build it:\n\nThen run the tests:\n\nBoth should pass.",
+			commands: []string{"make build", "make test"},
+		},
+		{
+			name:     "other languages stay text",
+			reply:    "In Python:\n```python\nprint('hi')\n```",
+			text:     "In Python:\n```python\nprint('hi')\n```",
+			commands: nil,
+		},
+		{
+			name:     "prose is never a command",
+			reply:    "Use `ls -la` to see hidden files, or type\n$ ls -a\nat the prompt.",
+			text:     "Use `ls -la` to see hidden files, or type\n$ ls -a\nat the prompt.",
+			commands: nil,
+		},
+		{
+			name:     "empty block",
+			reply:    "Nothing to run.\n```\n\n```",
+			text:     "Nothing to run.",
+			commands: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, commands := ParseChatReply(tt.reply)
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if !reflect.DeepEqual(commands, tt.commands) {
+				t.Errorf("commands = %q, want %q", commands, tt.commands)
+			}
+		})
+	}
+}
+
+func TestParseChatReplyLimitsCommands(t *testing.T) {
+	reply := strings.Repeat("```\necho step\n```\n", maxChatCommands+2)
+	if _, commands := ParseChatReply(reply); len(commands) != maxChatCommands {
+		t.Errorf("Expected at most %d commands, got %d", maxChatCommands, len(commands))
+	}
+}
@@ -17,7 +17,7 @@ type SystemContext struct {
 	RecentCommands []string
 	WorkingFiles   []string
 	Project        *context.ProjectType      // NEW: Detected project type
-	Preferences    *PersonalizationStore     // NEW: User preferences
+	Preferences    *PersonalizationStore     // Learned from the history; nil without a database
 }
 
 func GetSystemContext(recentOutput []string) SystemContext {
@@ -31,12 +31,6 @@ func GetSystemContext(recentOutput []string) SystemContext {
 
 	ctx.WorkingFiles = detectWorkingFiles()
 
-	// Load user preferences
-	prefs, err := LoadPreferences()
-	if err == nil {
-		ctx.Preferences = prefs
-	}
-
 	return ctx
 }
 
@@ -2,18 +2,35 @@ package ai
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/fabiobrug/mako.git/internal/config"
 )
 
 const (
-	conversationFile    = ".mako/conversation.json"
-	maxConversationTurns = 5 // Keep last 5 exchanges
-	conversationTimeout  = 5 * time.Minute // Auto-clear after 5 minutes of inactivity
+	conversationsFile     = ".mako/conversations.json"
+	legacyConversation    = ".mako/conversation.json" // Single global conversation used before threads
+	legacyThread          = "previous"                // The thread it is migrated into
+	defaultTurns          = 5                         // Recent turns kept word for word
+	defaultTimeoutMinutes = 30                        // Idle time before a terminal's thread starts over
+	defaultTokenBudget    = 1500                      // Rough size of the history sent with a request
+	maxSummaryLines       = 50
+	ephemeralRetention    = 24 * time.Hour // Idle terminal threads are removed after this
 )
 
+// ErrThreadNotFound is returned for a conversation thread that doesn't exist
+var ErrThreadNotFound = errors.New("conversation thread not found")
+
+var threadName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 // ConversationTurn represents a single user request and AI response
 type ConversationTurn struct {
 	Timestamp   time.Time `json:"timestamp"`
@@ -22,86 +39,165 @@ type ConversationTurn struct {
 	Executed    bool      `json:"executed"` // Whether the command was executed
 }
 
-// ConversationHistory manages the conversation state
+// ConversationLimits controls how much of a thread is kept and sent
+type ConversationLimits struct {
+	Turns       int           // Recent turns kept in full; older ones are summarised
+	Timeout     time.Duration // Idle time before a terminal thread starts over; 0 never
+	TokenBudget int           // Approximate tokens of history included in a prompt
+}
+
+// ConversationHistory is one named conversation thread
 type ConversationHistory struct {
+	Name         string             `json:"name"`
 	Turns        []ConversationTurn `json:"turns"`
+	Summary      []string           `json:"summary,omitempty"` // One line per turn folded out of Turns
+	CreatedAt    time.Time          `json:"created_at"`
 	LastActivity time.Time          `json:"last_activity"`
 	SessionID    string             `json:"session_id"`
+	Ephemeral    bool               `json:"ephemeral,omitempty"` // A terminal's default thread, which expires when idle
+
+	limits  ConversationLimits
+	added   int  // Turns added since loading, merged into the file on Save
+	expired bool // The previous conversation timed out and this one started over
 }
 
-// LoadConversation loads the conversation history from disk
-func LoadConversation() (*ConversationHistory, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
-	}
+type conversationStore struct {
+	Threads map[string]*ConversationHistory `json:"threads"`
+}
 
-	convPath := filepath.Join(homeDir, conversationFile)
-	
-	data, err := os.ReadFile(convPath)
+// LoadConversationLimits reads the limits from the config, falling back to
+// the defaults
+func LoadConversationLimits() ConversationLimits {
+	limits := ConversationLimits{
+		Turns:       defaultTurns,
+		Timeout:     defaultTimeoutMinutes * time.Minute,
+		TokenBudget: defaultTokenBudget,
+	}
+	cfg, err := config.LoadConfig()
 	if err != nil {
-		if os.IsNotExist(err) {
-			// No conversation exists, start fresh
-			return &ConversationHistory{
-				Turns:        []ConversationTurn{},
-				LastActivity: time.Now(),
-				SessionID:    generateSessionID(),
-			}, nil
-		}
-		return nil, fmt.Errorf("failed to read conversation: %w", err)
+		return limits
 	}
+	if cfg.ConversationTurns > 0 {
+		limits.Turns = cfg.ConversationTurns
+	}
+	if cfg.ConversationTimeout > 0 {
+		limits.Timeout = time.Duration(cfg.ConversationTimeout) * time.Minute
+	} else if cfg.ConversationTimeout < 0 {
+		limits.Timeout = 0
+	}
+	if cfg.ConversationTokens > 0 {
+		limits.TokenBudget = cfg.ConversationTokens
+	}
+	return limits
+}
 
-	var conv ConversationHistory
-	if err := json.Unmarshal(data, &conv); err != nil {
-		// Corrupted file, start fresh
-		return &ConversationHistory{
-			Turns:        []ConversationTurn{},
-			LastActivity: time.Now(),
-			SessionID:    generateSessionID(),
-		}, nil
+// ValidateThreadName checks a thread name is usable
+func ValidateThreadName(name string) error {
+	if !threadName.MatchString(name) {
+		return fmt.Errorf("invalid thread name %q: use letters, digits, '.', '_' and '-'", name)
 	}
+	return nil
+}
 
-	// Check if conversation has timed out
-	if time.Since(conv.LastActivity) > conversationTimeout {
-		return &ConversationHistory{
-			Turns:        []ConversationTurn{},
-			LastActivity: time.Now(),
-			SessionID:    generateSessionID(),
-		}, nil
+// LoadThread loads a conversation thread, starting a new one if it doesn't
+// exist. Ephemeral threads start over once they've been idle longer than the
+// configured timeout; named threads are kept until deleted.
+func LoadThread(name string, ephemeral bool) (*ConversationHistory, error) {
+	if err := ValidateThreadName(name); err != nil {
+		return nil, err
 	}
+	limits := LoadConversationLimits()
 
-	return &conv, nil
+	store, err := readConversations()
+	if err != nil {
+		return nil, err
+	}
+	conv, ok := store.Threads[name]
+	if !ok {
+		return newThread(name, ephemeral, limits), nil
+	}
+	conv.limits = limits
+	if conv.Ephemeral && limits.Timeout > 0 && time.Since(conv.LastActivity) > limits.Timeout {
+		fresh := newThread(name, ephemeral, limits)
+		fresh.expired = true
+		return fresh, nil
+	}
+	return conv, nil
 }
 
-// SaveConversation saves the conversation history to disk
-func (c *ConversationHistory) Save() error {
-	homeDir, err := os.UserHomeDir()
+// ListThreads returns every saved thread, most recently used first
+func ListThreads() ([]*ConversationHistory, error) {
+	store, err := readConversations()
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
-
-	convPath := filepath.Join(homeDir, conversationFile)
-	
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(convPath), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	threads := make([]*ConversationHistory, 0, len(store.Threads))
+	for _, conv := range store.Threads {
+		threads = append(threads, conv)
 	}
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].LastActivity.After(threads[j].LastActivity)
+	})
+	return threads, nil
+}
 
-	c.LastActivity = time.Now()
+// DeleteThread removes a saved thread
+func DeleteThread(name string) error {
+	return updateConversations(func(store *conversationStore) error {
+		if _, ok := store.Threads[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrThreadNotFound, name)
+		}
+		delete(store.Threads, name)
+		return nil
+	})
+}
 
-	data, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal conversation: %w", err)
+// ClearThread forgets a thread's turns, keeping nothing of it on disk
+func ClearThread(name string) error {
+	err := DeleteThread(name)
+	if errors.Is(err, ErrThreadNotFound) {
+		return nil
 	}
+	return err
+}
 
-	if err := os.WriteFile(convPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write conversation: %w", err)
+func newThread(name string, ephemeral bool, limits ConversationLimits) *ConversationHistory {
+	now := time.Now()
+	return &ConversationHistory{
+		Name:         name,
+		Turns:        []ConversationTurn{},
+		CreatedAt:    now,
+		LastActivity: now,
+		SessionID:    generateSessionID(),
+		Ephemeral:    ephemeral,
+		limits:       limits,
 	}
+}
 
-	return nil
+// Save writes the thread to disk. Turns another terminal added to the same
+// thread since it was loaded are kept, with this one's new turns after them.
+func (c *ConversationHistory) Save() error {
+	c.LastActivity = time.Now()
+
+	return updateConversations(func(store *conversationStore) error {
+		onDisk, ok := store.Threads[c.Name]
+		if ok && onDisk.SessionID == c.SessionID && c.added > 0 {
+			merged := *onDisk
+			merged.Turns = append(append([]ConversationTurn{}, onDisk.Turns...), c.Turns[max(len(c.Turns)-c.added, 0):]...)
+			merged.LastActivity = c.LastActivity
+			merged.limits = c.limits
+			merged.fold()
+			c.Turns, c.Summary = merged.Turns, merged.Summary
+		}
+		c.added = 0
+		c.CreatedAt = firstNonZero(c.CreatedAt, c.LastActivity)
+		store.Threads[c.Name] = c
+		return nil
+	})
 }
 
-// AddTurn adds a new conversation turn and maintains the limit
+// AddTurn adds a new conversation turn, summarising the oldest turns once
+// there are more than the limit
 func (c *ConversationHistory) AddTurn(userRequest, aiResponse string, executed bool) {
 	turn := ConversationTurn{
 		Timestamp:   time.Now(),
@@ -111,75 +207,144 @@ func (c *ConversationHistory) AddTurn(userRequest, aiResponse string, executed b
 	}
 
 	c.Turns = append(c.Turns, turn)
+	c.added++
+	c.fold()
 
-	// Keep only the last N turns
-	if len(c.Turns) > maxConversationTurns {
-		c.Turns = c.Turns[len(c.Turns)-maxConversationTurns:]
+	c.LastActivity = time.Now()
+}
+
+// fold moves turns beyond the limit into the one-line summary
+func (c *ConversationHistory) fold() {
+	limit := c.limits.Turns
+	if limit <= 0 {
+		limit = defaultTurns
+	}
+	for len(c.Turns) > limit {
+		c.Summary = append(c.Summary, summarizeTurn(c.Turns[0]))
+		c.Turns = c.Turns[1:]
+	}
+	if len(c.Summary) > maxSummaryLines {
+		c.Summary = c.Summary[len(c.Summary)-maxSummaryLines:]
 	}
+}
 
-	c.LastActivity = time.Now()
+func summarizeTurn(turn ConversationTurn) string {
+	response, _, _ := strings.Cut(strings.TrimSpace(turn.AIResponse), "\n")
+	line := fmt.Sprintf("%s → %s", truncateRunes(turn.UserRequest, 80), truncateRunes(response, 80))
+	if turn.Executed {
+		line += " ✓"
+	}
+	return line
 }
 
-// GetContext returns a formatted string of recent conversation for AI context
+func truncateRunes(s string, n int) string {
+	runes := []rune(strings.TrimSpace(s))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// estimateTokens approximates a token count at four characters per token
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// GetContext returns a formatted string of recent conversation for AI context.
+// Within the token budget the most recent turns are kept first, then as much
+// of the summary of earlier ones as fits.
 func (c *ConversationHistory) GetContext() string {
-	if len(c.Turns) == 0 {
-		return ""
+	return c.contextWithin(c.tokenBudget(), estimateTokens)
+}
+
+// tokenBudget is how much of a prompt the conversation may take
+func (c *ConversationHistory) tokenBudget() int {
+	if c.limits.TokenBudget <= 0 {
+		return defaultTokenBudget
 	}
+	return c.limits.TokenBudget
+}
 
-	var context string
-	context += "CONVERSATION HISTORY (most recent at bottom):\n"
+// contextWithin is GetContext with a smaller budget, for when the rest of
+// the prompt leaves less room, counted the way the model counts
+func (c *ConversationHistory) contextWithin(budget int, count func(string) int) string {
+	if len(c.Turns) == 0 && len(c.Summary) == 0 {
+		return ""
+	}
 
-	for i, turn := range c.Turns {
+	var turns []string
+	for i := len(c.Turns) - 1; i >= 0; i-- {
+		turn := c.Turns[i]
 		executedMarker := ""
 		if turn.Executed {
 			executedMarker = " ✓"
 		}
-		context += fmt.Sprintf("%d. User: %s\n", i+1, turn.UserRequest)
-		context += fmt.Sprintf("   AI: %s%s\n", turn.AIResponse, executedMarker)
+		text := fmt.Sprintf("User: %s\n   AI: %s%s\n", turn.UserRequest, turn.AIResponse, executedMarker)
+		// The latest turn is always sent; it's what follow-ups refer to
+		if len(turns) > 0 && count(text) > budget {
+			break
+		}
+		budget -= count(text)
+		turns = append(turns, text)
+	}
+	var summary []string
+	if len(turns) == len(c.Turns) {
+		for i := len(c.Summary) - 1; i >= 0; i-- {
+			line := "- " + c.Summary[i] + "\n"
+			if count(line) > budget {
+				break
+			}
+			budget -= count(line)
+			summary = append(summary, line)
+		}
 	}
 
-	context += "\nBuild upon this conversation context. The user's current request may be:\n"
-	context += "- A refinement of the previous command\n"
-	context += "- A follow-up question about the same topic\n"
-	context += "- A new request (if clearly unrelated)\n\n"
+	var context strings.Builder
+	if len(summary) > 0 {
+		context.WriteString("EARLIER IN THIS CONVERSATION (summarised):\n")
+		for i := len(summary) - 1; i >= 0; i-- {
+			context.WriteString(summary[i])
+		}
+		context.WriteString("\n")
+	}
+	context.WriteString("CONVERSATION HISTORY (most recent at bottom):\n")
+	for i := len(turns) - 1; i >= 0; i-- {
+		context.WriteString(fmt.Sprintf("%d. %s", len(turns)-i, turns[i]))
+	}
 
-	return context
+	return context.String()
 }
 
 // Clear removes all conversation history
 func (c *ConversationHistory) Clear() {
 	c.Turns = []ConversationTurn{}
+	c.Summary = nil
+	c.added = 0
 	c.LastActivity = time.Now()
 	c.SessionID = generateSessionID()
 }
 
 // IsActive returns whether there's an active conversation
 func (c *ConversationHistory) IsActive() bool {
-	return len(c.Turns) > 0 && time.Since(c.LastActivity) < conversationTimeout
-}
-
-// ClearConversation clears the conversation file
-func ClearConversation() error {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+	if len(c.Turns) == 0 && len(c.Summary) == 0 {
+		return false
 	}
+	return !c.Ephemeral || c.limits.Timeout <= 0 || time.Since(c.LastActivity) < c.limits.Timeout
+}
 
-	convPath := filepath.Join(homeDir, conversationFile)
-	
-	// Remove the file
-	if err := os.Remove(convPath); err != nil {
-		if os.IsNotExist(err) {
-			return nil // Already cleared
-		}
-		return fmt.Errorf("failed to clear conversation: %w", err)
-	}
+// Expired reports whether the thread started over because the previous
+// conversation in it had been idle too long
+func (c *ConversationHistory) Expired() bool {
+	return c.expired
+}
 
-	return nil
+// Limits returns the limits the thread was loaded with
+func (c *ConversationHistory) Limits() ConversationLimits {
+	return c.limits
 }
 
 func generateSessionID() string {
-	return fmt.Sprintf("session_%d", time.Now().Unix())
+	return fmt.Sprintf("session_%d", time.Now().UnixNano())
 }
 
 // GetLastCommand returns the last AI-generated command, if any
@@ -197,3 +362,124 @@ func (c *ConversationHistory) GetLastUserRequest() string {
 	}
 	return c.Turns[len(c.Turns)-1].UserRequest
 }
+
+func conversationsPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, conversationsFile), nil
+}
+
+func readConversations() (*conversationStore, error) {
+	path, err := conversationsPath()
+	if err != nil {
+		return nil, err
+	}
+	store := &conversationStore{Threads: make(map[string]*ConversationHistory)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read conversations: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, store); err != nil {
+			// Corrupted file, start fresh
+			store = &conversationStore{}
+		}
+	}
+	if store.Threads == nil {
+		store.Threads = make(map[string]*ConversationHistory)
+	}
+	for name, conv := range store.Threads {
+		conv.Name = name
+	}
+	addLegacyConversation(store, filepath.Join(filepath.Dir(filepath.Dir(path)), legacyConversation))
+	return store, nil
+}
+
+// addLegacyConversation adds the single conversation from before threads as
+// a named thread, unless one of that name exists. The next save writes it
+// to the threads file and removes the old one.
+func addLegacyConversation(store *conversationStore, path string) {
+	if _, ok := store.Threads[legacyThread]; ok {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var legacy ConversationHistory
+	if err := json.Unmarshal(data, &legacy); err != nil || len(legacy.Turns) == 0 {
+		return
+	}
+	legacy.Name = legacyThread
+	legacy.Ephemeral = false
+	legacy.limits = LoadConversationLimits()
+	legacy.fold()
+	legacy.CreatedAt = firstNonZero(legacy.Turns[0].Timestamp, legacy.LastActivity)
+	if legacy.SessionID == "" {
+		legacy.SessionID = generateSessionID()
+	}
+	store.Threads[legacyThread] = &legacy
+}
+
+// updateConversations applies fn to the saved threads while holding a lock,
+// so terminals saving at the same time don't lose each other's threads
+func updateConversations(fn func(store *conversationStore) error) error {
+	path, err := conversationsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to lock conversations: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock conversations: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	store, err := readConversations()
+	if err != nil {
+		return err
+	}
+	if err := fn(store); err != nil {
+		return err
+	}
+	for name, conv := range store.Threads {
+		if conv.Ephemeral && time.Since(conv.LastActivity) > ephemeralRetention {
+			delete(store.Threads, name)
+		}
+	}
+
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversations: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write conversations: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write conversations: %w", err)
+	}
+
+	// The single conversation from before threads is in the file now
+	os.Remove(filepath.Join(filepath.Dir(filepath.Dir(path)), legacyConversation))
+	return nil
+}
+
+func firstNonZero(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,184 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func loadThread(t *testing.T, name string, ephemeral bool) *ConversationHistory {
+	t.Helper()
+	conv, err := LoadThread(name, ephemeral)
+	if err != nil {
+		t.Fatalf("LoadThread(%q) failed: %v", name, err)
+	}
+	return conv
+}
+
+func TestSaveMergesConcurrentTurns(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+
+	first := loadThread(t, "work", false)
+	first.AddTurn("list files", "ls", true)
+	if err := first.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	// Two terminals continue the same thread at once
+	a, b := loadThread(t, "work", false), loadThread(t, "work", false)
+	a.AddTurn("show sizes", "ls -lh", true)
+	b.AddTurn("count them", "ls | wc -l", false)
+	if err := a.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if err := b.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	var requests []string
+	for _, turn := range loadThread(t, "work", false).Turns {
+		requests = append(requests, turn.UserRequest)
+	}
+	if got := strings.Join(requests, ", "); got != "list files, show sizes, count them" {
+		t.Errorf("Turns after both saves = %s", got)
+	}
+	if got := len(b.Turns); got != 3 {
+		t.Errorf("Expected the saving terminal to see the merged turns, got %d", got)
+	}
+}
+
+func TestFoldSummarisesOldTurns(t *testing.T) {
+	conv := &ConversationHistory{limits: ConversationLimits{Turns: 2}}
+	conv.AddTurn("list files", "ls\nmore text", true)
+	conv.AddTurn("show sizes", "ls -lh", false)
+	conv.AddTurn("count them", "ls | wc -l", false)
+	conv.AddTurn("sort them", "ls -S", false)
+
+	if len(conv.Turns) != 2 || conv.Turns[0].UserRequest != "count them" {
+		t.Errorf("Expected the two newest turns to be kept, got %+v", conv.Turns)
+	}
+	want := []string{"list files → ls ✓", "show sizes → ls -lh"}
+	if strings.Join(conv.Summary, "|") != strings.Join(want, "|") {
+		t.Errorf("Summary = %q, want %q", conv.Summary, want)
+	}
+
+	for i := 0; i < maxSummaryLines+10; i++ {
+		conv.AddTurn(fmt.Sprintf("request %d", i), "echo", false)
+	}
+	if len(conv.Summary) != maxSummaryLines {
+		t.Errorf("Expected the summary to be capped at %d lines, got %d", maxSummaryLines, len(conv.Summary))
+	}
+	if last := conv.Summary[len(conv.Summary)-1]; !strings.HasPrefix(last, fmt.Sprintf("request %d", maxSummaryLines+7)) {
+		t.Errorf("Expected the newest folded turn last in the summary, got %q", last)
+	}
+}
+
+func TestContextWithinBudget(t *testing.T) {
+	conv := &ConversationHistory{
+		Summary: []string{"set up the project → git init"},
+		Turns: []ConversationTurn{
+			{UserRequest: "list files", AIResponse: "ls"},
+			{UserRequest: "show sizes", AIResponse: "ls -lh", Executed: true},
+		},
+	}
+	count := func(s string) int { return len(s) }
+
+	// Everything fits
+	all := conv.contextWithin(1000, count)
+	for _, want := range []string{"EARLIER IN THIS CONVERSATION", "set up the project", "1. User: list files", "2. User: show sizes", "ls -lh ✓"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected %q in the full context:\n%s", want, all)
+		}
+	}
+
+	// Room for the turns but not the summary
+	turnsOnly := conv.contextWithin(70, count)
+	if strings.Contains(turnsOnly, "EARLIER") || !strings.Contains(turnsOnly, "list files") {
+		t.Errorf("Expected both turns without the summary:\n%s", turnsOnly)
+	}
+
+	// The latest turn is sent even when it alone is over budget
+	latest := conv.contextWithin(1, count)
+	if !strings.Contains(latest, "1. User: show sizes") || strings.Contains(latest, "list files") {
+		t.Errorf("Expected only the latest turn:\n%s", latest)
+	}
+
+	if (&ConversationHistory{}).contextWithin(1000, count) != "" {
+		t.Error("Expected no context for an empty thread")
+	}
+}
+
+func TestEphemeralThreadsExpire(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+	now := time.Now()
+
+	err := updateConversations(func(store *conversationStore) error {
+		store.Threads["terminal-1"] = &ConversationHistory{Name: "terminal-1", Ephemeral: true, LastActivity: now.Add(-time.Hour),
+			Turns: []ConversationTurn{{UserRequest: "idle", AIResponse: "ls"}}}
+		store.Threads["terminal-2"] = &ConversationHistory{Name: "terminal-2", Ephemeral: true, LastActivity: now.Add(-ephemeralRetention - time.Hour),
+			Turns: []ConversationTurn{{UserRequest: "abandoned", AIResponse: "ls"}}}
+		store.Threads["notes"] = &ConversationHistory{Name: "notes", LastActivity: now.Add(-30 * 24 * time.Hour),
+			Turns: []ConversationTurn{{UserRequest: "kept", AIResponse: "ls"}}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateConversations() failed: %v", err)
+	}
+
+	// An idle terminal thread starts over
+	idle := loadThread(t, "terminal-1", true)
+	if !idle.Expired() || len(idle.Turns) != 0 {
+		t.Errorf("Expected an expired, empty thread, got %+v", idle)
+	}
+
+	// Named threads are kept however long they are idle
+	if notes := loadThread(t, "notes", false); notes.Expired() || len(notes.Turns) != 1 {
+		t.Errorf("Expected the named thread to be kept, got %+v", notes)
+	}
+
+	// Terminal threads idle past the retention are removed from the file
+	threads, err := ListThreads()
+	if err != nil {
+		t.Fatalf("ListThreads() failed: %v", err)
+	}
+	for _, thread := range threads {
+		if thread.Name == "terminal-2" {
+			t.Error("Expected the abandoned terminal thread to be removed")
+		}
+	}
+}
+
+func TestLegacyConversationIsMigrated(t *testing.T) {
+	home := testutil.TempDir(t)
+	t.Setenv("HOME", home)
+	legacyPath := filepath.Join(home, legacyConversation)
+	os.MkdirAll(filepath.Dir(legacyPath), 0755)
+	legacy := `{"turns":[{"timestamp":"2024-05-01T10:00:00Z","user_request":"find big files","ai_response":"du -sh * | sort -h","executed":true}],` +
+		`"last_activity":"2024-05-01T10:00:00Z","session_id":"session_1"}`
+	if err := os.WriteFile(legacyPath, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := loadThread(t, legacyThread, false)
+	if len(previous.Turns) != 1 || previous.Turns[0].UserRequest != "find big files" || previous.Ephemeral {
+		t.Fatalf("Expected the old conversation as a named thread, got %+v", previous)
+	}
+
+	// Saving any thread writes it to the threads file and retires the old one
+	other := loadThread(t, "work", false)
+	other.AddTurn("list files", "ls", false)
+	if err := other.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Error("Expected the old conversation file to be removed")
+	}
+	if previous := loadThread(t, legacyThread, false); len(previous.Turns) != 1 {
+		t.Errorf("Expected the migrated thread to be kept, got %+v", previous)
+	}
+}
@@ -25,9 +25,11 @@ func NewDeepSeekProvider(cfg *ProviderConfig) (*OpenAIProvider, error) {
 	}
 	
 	return &OpenAIProvider{
-		apiKey:  cfg.APIKey,
-		model:   model,
-		baseURL: baseURL,
-		client:  &http.Client{},
+		provider: "deepseek",
+		apiKey:   cfg.APIKey,
+		model:    model,
+		baseURL:  baseURL,
+		client:   &http.Client{},
+		window:   WindowFor("deepseek", model, cfg.ContextWindow),
 	}, nil
 }
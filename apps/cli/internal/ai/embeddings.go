@@ -9,7 +9,6 @@ import (
 	"io"
 	"math"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -29,7 +28,7 @@ func NewGeminiEmbeddingProvider(cfg *ProviderConfig) (*GeminiEmbeddingProvider,
 	
 	// Fallback to environment variable
 	if apiKey == "" {
-		apiKey = os.Getenv("GEMINI_API_KEY")
+		apiKey = envAPIKey("GEMINI_API_KEY")
 	}
 	
 	if apiKey == "" {
@@ -116,7 +115,7 @@ func (e *GeminiEmbeddingProvider) Embed(text string) ([]float32, error) {
 	// Use resilient executor for retry + circuit breaker
 	ctx := context.Background()
 	embedding, err := e.executeWithRetry(ctx, func() ([]float32, error) {
-		return e.makeEmbeddingRequest(jsonData)
+		return e.makeEmbeddingRequest(text, jsonData)
 	})
 
 	return embedding, err
@@ -136,7 +135,7 @@ func (e *GeminiEmbeddingProvider) executeWithRetry(ctx context.Context, fn func(
 }
 
 // makeEmbeddingRequest performs the actual HTTP request for embeddings
-func (e *GeminiEmbeddingProvider) makeEmbeddingRequest(jsonData []byte) ([]float32, error) {
+func (e *GeminiEmbeddingProvider) makeEmbeddingRequest(text string, jsonData []byte) ([]float32, error) {
 	embedAPIURL := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:embedContent", e.model)
 	url := fmt.Sprintf("%s?key=%s", embedAPIURL, e.apiKey)
 	
@@ -147,6 +146,7 @@ func (e *GeminiEmbeddingProvider) makeEmbeddingRequest(jsonData []byte) ([]float
 
 	req.Header.Set("Content-Type", "application/json")
 
+	started := time.Now()
 	resp, err := e.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -172,6 +172,8 @@ func (e *GeminiEmbeddingProvider) makeEmbeddingRequest(jsonData []byte) ([]float
 		return nil, err
 	}
 
+	// embedContent doesn't report tokens, so they are estimated
+	recordCall("gemini", e.model, FeatureEmbedding, text, "", 0, 0, started)
 	return response.Embedding.Values, nil
 }
 
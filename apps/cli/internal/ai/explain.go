@@ -0,0 +1,76 @@
+package ai
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/prompts"
+)
+
+var inlineCode = regexp.MustCompile("`([^`\n]+)`")
+
+// SuggestedFix picks the corrected command out of an ExplainError reply, or
+// returns "" when the suggestion is advice rather than a command. A fenced
+// or backticked command wins; a bare SUGGESTION line counts only when it
+// starts with something on $PATH and doesn't read like a sentence.
+func SuggestedFix(explanation string) string {
+	if _, commands := ParseChatReply(explanation); len(commands) > 0 {
+		return commands[0]
+	}
+
+	lines := strings.Split(explanation, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(strings.Trim(strings.TrimSpace(line), "*"))
+		suggestion, ok := strings.CutPrefix(line, "SUGGESTION:")
+		if !ok {
+			continue
+		}
+		suggestion = strings.TrimSpace(strings.Trim(strings.TrimSpace(suggestion), "*"))
+		if suggestion == "" && i+1 < len(lines) {
+			suggestion = strings.TrimSpace(lines[i+1])
+		}
+
+		if match := inlineCode.FindStringSubmatch(suggestion); match != nil {
+			return strings.TrimPrefix(strings.TrimSpace(match[1]), "$ ")
+		}
+		suggestion = strings.TrimPrefix(suggestion, "$ ")
+		fields := strings.Fields(suggestion)
+		if len(fields) == 0 || strings.HasSuffix(suggestion, ".") || fields[0] != strings.ToLower(fields[0]) {
+			return ""
+		}
+		if _, err := exec.LookPath(fields[0]); err != nil && fields[0] != "cd" {
+			return ""
+		}
+		return suggestion
+	}
+	return ""
+}
+
+// buildExplainPartsPrompt asks about the parts of a command that no
+// documentation on the machine covers
+func buildExplainPartsPrompt(command string, parts []string, context SystemContext) Prompt {
+	data := newPromptData(context)
+	data.Command, data.Parts = command, parts
+	return renderPrompt(prompts.ExplainParts, data)
+}
+
+// ParsePartExplanations reads the "part: meaning" lines of an ExplainParts
+// reply, keeping only the parts that were asked about
+func ParsePartExplanations(reply string, parts []string) map[string]string {
+	meanings := make(map[string]string)
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(line)
+		for _, bullet := range []string{"- ", "* ", "• "} {
+			line = strings.TrimPrefix(line, bullet)
+		}
+		for _, part := range parts {
+			for _, prefix := range []string{part + ":", "`" + part + "`:", "**" + part + "**:", "**`" + part + "`**:"} {
+				if rest, ok := strings.CutPrefix(line, prefix); ok && strings.TrimSpace(rest) != "" {
+					meanings[part] = strings.TrimSpace(rest)
+				}
+			}
+		}
+	}
+	return meanings
+}
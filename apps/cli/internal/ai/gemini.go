@@ -20,6 +20,7 @@ type GeminiProvider struct {
 	model     string
 	client    *http.Client
 	executor  *retry.ResilientExecutor
+	window    ModelWindow
 }
 
 // NewGeminiProvider creates a new Gemini AI provider
@@ -28,14 +29,14 @@ func NewGeminiProvider(cfg *ProviderConfig) (*GeminiProvider, error) {
 	
 	// Fallback to environment variable
 	if apiKey == "" {
-		apiKey = os.Getenv("GEMINI_API_KEY")
+		apiKey = envAPIKey("GEMINI_API_KEY")
 	}
 	
 	// Fallback to config file
 	if apiKey == "" {
 		fileCfg, err := config.LoadConfig()
 		if err == nil && fileCfg.APIKey != "" {
-			apiKey = fileCfg.APIKey
+			apiKey, _ = config.ResolveSecret(fileCfg.APIKey)
 		}
 	}
 	
@@ -96,6 +97,7 @@ func NewGeminiProvider(cfg *ProviderConfig) (*GeminiProvider, error) {
 		model:    model,
 		client:   &http.Client{Timeout: 30 * time.Second},
 		executor: executor,
+		window:   WindowFor("gemini", model, cfg.ContextWindow),
 	}, nil
 }
 
@@ -113,37 +115,10 @@ func (g *GeminiProvider) GenerateCommand(userRequest string, systemCtx SystemCon
 
 // GenerateCommandWithConversation generates a command with conversation context
 func (g *GeminiProvider) GenerateCommandWithConversation(userRequest string, systemCtx SystemContext, conversation *ConversationHistory) (string, error) {
-	prompt := g.buildPromptWithConversation(userRequest, systemCtx, conversation)
-
-	requestBody := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{
-				"parts": []map[string]interface{}{
-					{"text": prompt},
-				},
-			},
-		},
-		"generationConfig": map[string]interface{}{
-			"temperature":     0.1,
-			"maxOutputTokens": 200,
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// Use resilient executor for retry + circuit breaker
-	ctx := context.Background()
-	command, err := g.executeWithRetry(ctx, func() (string, error) {
-		return g.makeRequest(jsonData)
-	})
-
+	command, err := g.sendRequest(buildCommandPrompt(userRequest, systemCtx, conversation, g.window), commandReplyTokens, 0.1)
 	if err != nil {
 		return "", err
 	}
-
 	return g.cleanCommand(command), nil
 }
 
@@ -161,7 +136,7 @@ func (g *GeminiProvider) executeWithRetry(ctx context.Context, fn func() (string
 }
 
 // makeRequest performs the actual HTTP request (extracted for retry/circuit breaker)
-func (g *GeminiProvider) makeRequest(jsonData []byte) (string, error) {
+func (g *GeminiProvider) makeRequest(prompt Prompt, jsonData []byte) (string, error) {
 	apiURL := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent", g.model)
 	url := fmt.Sprintf("%s?key=%s", apiURL, g.apiKey)
 	
@@ -172,6 +147,7 @@ func (g *GeminiProvider) makeRequest(jsonData []byte) (string, error) {
 
 	req.Header.Set("Content-Type", "application/json")
 
+	started := time.Now()
 	resp, err := g.client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("API request failed: %w", err)
@@ -195,6 +171,11 @@ func (g *GeminiProvider) makeRequest(jsonData []byte) (string, error) {
 				} `json:"parts"`
 			} `json:"content"`
 		} `json:"candidates"`
+		UsageMetadata struct {
+			PromptTokenCount     int `json:"promptTokenCount"`
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+			ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+		} `json:"usageMetadata"`
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
@@ -205,130 +186,15 @@ func (g *GeminiProvider) makeRequest(jsonData []byte) (string, error) {
 		return "", fmt.Errorf("no response from API")
 	}
 
-	return response.Candidates[0].Content.Parts[0].Text, nil
-}
-
-func (g *GeminiProvider) buildPromptWithConversation(userRequest string, systemCtx SystemContext, conversation *ConversationHistory) string {
-	var promptBuild strings.Builder
-
-	// Include conversation history if available
-	if conversation != nil && conversation.IsActive() {
-		promptBuild.WriteString(conversation.GetContext())
-	}
-
-	// Include user preferences if available
-	if systemCtx.Preferences != nil {
-		prefHints := systemCtx.Preferences.GetPreferenceHints()
-		if prefHints != "" {
-			promptBuild.WriteString(prefHints)
-		}
-	}
-
-	return g.buildPromptCore(userRequest, systemCtx, &promptBuild)
+	reply := response.Candidates[0].Content.Parts[0].Text
+	recordCall("gemini", g.model, prompt.Name, prompt.System+prompt.User, reply, response.UsageMetadata.PromptTokenCount,
+		response.UsageMetadata.CandidatesTokenCount+response.UsageMetadata.ThoughtsTokenCount, started)
+	return reply, nil
 }
 
-func (g *GeminiProvider) buildPrompt(userRequest string, systemCtx SystemContext) string {
-	var promptBuild strings.Builder
-	return g.buildPromptCore(userRequest, systemCtx, &promptBuild)
-}
-
-func (g *GeminiProvider) buildPromptCore(userRequest string, systemCtx SystemContext, promptBuild *strings.Builder) string {
-	promptBuild.WriteString("You are a shell command generator. Your ONLY job is to output a single shell command.\n\n")
-	promptBuild.WriteString("RULES:\n")
-	promptBuild.WriteString("- Output ONLY the command, nothing else\n")
-	promptBuild.WriteString("- NO explanations, NO markdown, NO code blocks\n")
-	promptBuild.WriteString("- Use proper flags and options for the task\n")
-	promptBuild.WriteString("- The command must be safe and correct\n\n")
-
-	promptBuild.WriteString(fmt.Sprintf("System: %s\n", systemCtx.OS))
-	promptBuild.WriteString(fmt.Sprintf("Shell: %s\n", systemCtx.Shell))
-	promptBuild.WriteString(fmt.Sprintf("Current directory: %s\n", systemCtx.CurrentDir))
-
-	// NEW: Include project context for smart suggestions
-	if systemCtx.Project != nil {
-		projectHint := systemCtx.Project.GetProjectHint()
-		if projectHint != "" {
-			promptBuild.WriteString(fmt.Sprintf("Project type: %s\n", projectHint))
-		}
-
-		// Give AI specific hints about available commands
-		if systemCtx.Project.TestCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Test command: %s\n", systemCtx.Project.TestCmd))
-		}
-		if systemCtx.Project.BuildCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Build command: %s\n", systemCtx.Project.BuildCmd))
-		}
-		if systemCtx.Project.RunCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Run command: %s\n", systemCtx.Project.RunCmd))
-		}
-	}
-
-	// NEW: Include files in current directory
-	if len(systemCtx.WorkingFiles) > 0 {
-		promptBuild.WriteString(fmt.Sprintf("Files in directory: %s\n", strings.Join(systemCtx.WorkingFiles, ", ")))
-	}
-
-	// NEW: Include recent commands for context
-	if len(systemCtx.RecentCommands) > 0 {
-		promptBuild.WriteString("\nRecent commands:\n")
-		for _, cmd := range systemCtx.RecentCommands {
-			promptBuild.WriteString(fmt.Sprintf("  %s\n", cmd))
-		}
-	}
-
-	// ENHANCED: Actually use the recent output!
-	if len(systemCtx.RecentOutput) > 0 {
-		promptBuild.WriteString("\nRecent terminal output:\n")
-
-		// Analyze output for context hints
-		hints := AnalyzeRecentOutput(systemCtx.RecentOutput)
-
-		// Add intelligent context based on output
-		if hints["has_errors"] == "true" {
-			promptBuild.WriteString("(Note: Recent output contains errors)\n")
-		}
-		if hints["needs_sudo"] == "true" {
-			promptBuild.WriteString("(Note: Previous command had permission issues)\n")
-		}
-		if workingWith, ok := hints["working_with"]; ok {
-			promptBuild.WriteString(fmt.Sprintf("(Note: User is working with %s)\n", workingWith))
-		}
-
-		// Include last 5 lines of output
-		startIdx := len(systemCtx.RecentOutput) - 5
-		if startIdx < 0 {
-			startIdx = 0
-		}
-		for _, line := range systemCtx.RecentOutput[startIdx:] {
-			if strings.TrimSpace(line) != "" {
-				promptBuild.WriteString(fmt.Sprintf("  %s\n", line))
-			}
-		}
-	}
-
-	promptBuild.WriteString("\nUser request: ")
-	promptBuild.WriteString(userRequest)
-	promptBuild.WriteString("\n\n")
-
-	// NEW: Enhanced guidance for command composition
-	promptBuild.WriteString("COMMAND COMPOSITION GUIDE:\n")
-	promptBuild.WriteString("- Use pipes (|) to chain commands: cmd1 | cmd2\n")
-	promptBuild.WriteString("- Use && for sequential execution: cmd1 && cmd2 (only run cmd2 if cmd1 succeeds)\n")
-	promptBuild.WriteString("- Use || for alternatives: cmd1 || cmd2 (run cmd2 only if cmd1 fails)\n")
-	promptBuild.WriteString("- Use ; for unconditional sequence: cmd1 ; cmd2 (always run both)\n")
-	promptBuild.WriteString("- Combine multiple operations when needed for complex tasks\n")
-	promptBuild.WriteString("- For filtering/processing: grep, awk, sed, sort, uniq, head, tail\n")
-	promptBuild.WriteString("- For monitoring: watch, tail -f\n\n")
-
-	promptBuild.WriteString("EXAMPLES:\n")
-	promptBuild.WriteString("- Find errors and count: grep ERROR log.txt | wc -l\n")
-	promptBuild.WriteString("- Find, count, show top 10: grep ERROR *.log | sort | uniq -c | sort -rn | head -10\n")
-	promptBuild.WriteString("- Build and run: make build && ./app\n")
-	promptBuild.WriteString("- Try command or fallback: command -v docker || echo \"Docker not installed\"\n\n")
-
-	promptBuild.WriteString("Generate ONLY the shell command. No markdown, no backticks, no explanations. Just the raw command.")
-
-	return promptBuild.String()
+// BuildPrompt returns the redacted prompt GenerateCommandWithConversation sends
+func (g *GeminiProvider) BuildPrompt(userRequest string, systemCtx SystemContext, conversation *ConversationHistory) Prompt {
+	return buildCommandPrompt(userRequest, systemCtx, conversation, g.window)
 }
 
 func (g *GeminiProvider) cleanCommand(command string) string {
@@ -343,40 +209,8 @@ func (g *GeminiProvider) cleanCommand(command string) string {
 }
 
 func (g *GeminiProvider) ExplainError(failedCommand string, errorOutput string, systemCtx SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Shell debugging assistant. Analyze this error briefly.
-
-System: %s | Shell: %s | Dir: %s
-
-Command: %s
-Error: %s
-
-Provide:
-EXPLANATION: Brief 1-2 sentence explanation of the error
-SUGGESTION: A corrected command (if applicable) or next steps
-
-Be concise and actionable.`,
-		systemCtx.OS,
-		systemCtx.Shell,
-		systemCtx.CurrentDir,
-		failedCommand,
-		errorOutput,
-	)
-
-	requestBody := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{
-				"parts": []map[string]interface{}{
-					{"text": prompt},
-				},
-			},
-		},
-		"generationConfig": map[string]interface{}{
-			"temperature":     0.3,
-			"maxOutputTokens": 2048,
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
+	prompt := buildExplainErrorPrompt(failedCommand, errorOutput, systemCtx, g.window)
+	jsonData, err := g.requestBody(prompt, errorReplyTokens, 0.3)
 	if err != nil {
 		return "", err
 	}
@@ -384,14 +218,14 @@ Be concise and actionable.`,
 	// Use resilient executor for retry + circuit breaker
 	ctx := context.Background()
 	explanation, err := g.executeWithRetry(ctx, func() (string, error) {
-		return g.makeRequestWithFinishReason(jsonData)
+		return g.makeRequestWithFinishReason(prompt, jsonData)
 	})
 
 	return explanation, err
 }
 
 // makeRequestWithFinishReason performs request and checks finish reason
-func (g *GeminiProvider) makeRequestWithFinishReason(jsonData []byte) (string, error) {
+func (g *GeminiProvider) makeRequestWithFinishReason(prompt Prompt, jsonData []byte) (string, error) {
 	apiURL := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent", g.model)
 	url := fmt.Sprintf("%s?key=%s", apiURL, g.apiKey)
 	
@@ -402,6 +236,7 @@ func (g *GeminiProvider) makeRequestWithFinishReason(jsonData []byte) (string, e
 
 	req.Header.Set("Content-Type", "application/json")
 
+	started := time.Now()
 	resp, err := g.client.Do(req)
 	if err != nil {
 		return "", err
@@ -426,6 +261,11 @@ func (g *GeminiProvider) makeRequestWithFinishReason(jsonData []byte) (string, e
 			} `json:"content"`
 			FinishReason string `json:"finishReason"`
 		} `json:"candidates"`
+		UsageMetadata struct {
+			PromptTokenCount     int `json:"promptTokenCount"`
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+			ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+		} `json:"usageMetadata"`
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
@@ -442,105 +282,72 @@ func (g *GeminiProvider) makeRequestWithFinishReason(jsonData []byte) (string, e
 		fmt.Fprintf(os.Stderr, "Warning: API response truncated (reason: %s)\n", finishReason)
 	}
 
-	return response.Candidates[0].Content.Parts[0].Text, nil
+	reply := response.Candidates[0].Content.Parts[0].Text
+	recordCall("gemini", g.model, prompt.Name, prompt.System+prompt.User, reply, response.UsageMetadata.PromptTokenCount,
+		response.UsageMetadata.CandidatesTokenCount+response.UsageMetadata.ThoughtsTokenCount, started)
+	return reply, nil
 }
 
 // ExplainCommand generates a human-readable explanation of what a command does
 func (g *GeminiProvider) ExplainCommand(command string, systemCtx SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Explain this shell command in simple, clear terms.
-
-System: %s | Shell: %s | Dir: %s
+	return g.sendRequest(buildExplainCommandPrompt(command, systemCtx), 1024, 0.3)
+}
 
-Command: %s
+// SuggestAlternatives generates alternative commands that accomplish the same goal
+func (g *GeminiProvider) SuggestAlternatives(command string, systemCtx SystemContext) (string, error) {
+	return g.sendRequest(buildAlternativesPrompt(command, systemCtx), 1024, 0.5)
+}
 
-Provide a brief explanation (2-3 sentences) covering:
-1. What the command does
-2. What the key flags/options mean
-3. Any potential side effects or warnings
-4. **Security warnings** if the command has any security implications (destructive operations, permission changes, network access, etc.)
+// Chat replies to a message in mako chat
+func (g *GeminiProvider) Chat(message string, systemCtx SystemContext, conversation *ConversationHistory) (string, error) {
+	return g.sendRequest(buildChatPrompt(message, systemCtx, conversation, g.window), chatReplyTokens, 0.4)
+}
 
-Be concise and user-friendly. If there are security concerns, highlight them clearly.`,
-		systemCtx.OS,
-		systemCtx.Shell,
-		systemCtx.CurrentDir,
-		command,
-	)
+// PlanTask plans the rest of a mako do task
+func (g *GeminiProvider) PlanTask(goal string, steps []ExecutedStep, systemCtx SystemContext) (string, error) {
+	return g.sendRequest(buildTaskPrompt(goal, steps, systemCtx, g.window), taskReplyTokens, 0.2)
+}
 
-	requestBody := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{
-				"parts": []map[string]interface{}{
-					{"text": prompt},
-				},
-			},
-		},
-		"generationConfig": map[string]interface{}{
-			"temperature":     0.3,
-			"maxOutputTokens": 1024,
-		},
-	}
+// ExplainParts explains what mako explain found no documentation for
+func (g *GeminiProvider) ExplainParts(command string, parts []string, systemCtx SystemContext) (string, error) {
+	return g.sendRequest(buildExplainPartsPrompt(command, parts, systemCtx), 512, 0.2)
+}
 
-	jsonData, err := json.Marshal(requestBody)
+// sendRequest sends a single prompt with the resilient executor
+func (g *GeminiProvider) sendRequest(prompt Prompt, maxTokens int, temperature float64) (string, error) {
+	jsonData, err := g.requestBody(prompt, maxTokens, temperature)
 	if err != nil {
 		return "", err
 	}
 
-	// Use resilient executor for retry + circuit breaker
-	ctx := context.Background()
-	explanation, err := g.executeWithRetry(ctx, func() (string, error) {
-		return g.makeRequest(jsonData)
+	return g.executeWithRetry(context.Background(), func() (string, error) {
+		return g.makeRequest(prompt, jsonData)
 	})
-
-	return explanation, err
 }
 
-// SuggestAlternatives generates alternative commands that accomplish the same goal
-func (g *GeminiProvider) SuggestAlternatives(command string, systemCtx SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Given this shell command, suggest 2-3 alternative ways to accomplish the same goal.
-
-System: %s | Shell: %s | Dir: %s
-
-Original Command: %s
-
-Provide alternatives that:
-1. Use different tools/approaches
-2. May be safer, faster, or more efficient
-3. Have different trade-offs (verbosity, portability, features)
-
-Format each alternative as:
-• [command] - brief explanation of difference/advantage
-
-Be concise and practical.`,
-		systemCtx.OS,
-		systemCtx.Shell,
-		systemCtx.CurrentDir,
-		command,
-	)
-
+// requestBody encodes a prompt as a generateContent request, with the
+// system part as the system instruction
+func (g *GeminiProvider) requestBody(prompt Prompt, maxTokens int, temperature float64) ([]byte, error) {
 	requestBody := map[string]interface{}{
 		"contents": []map[string]interface{}{
 			{
 				"parts": []map[string]interface{}{
-					{"text": prompt},
+					{"text": prompt.User},
 				},
 			},
 		},
 		"generationConfig": map[string]interface{}{
-			"temperature":     0.5,
-			"maxOutputTokens": 1024,
+			"temperature":     temperature,
+			"maxOutputTokens": maxTokens,
 		},
 	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
+	if prompt.System != "" {
+		requestBody["systemInstruction"] = map[string]interface{}{
+			"parts": []map[string]interface{}{
+				{"text": prompt.System},
+			},
+		}
 	}
 
-	// Use resilient executor for retry + circuit breaker
-	ctx := context.Background()
-	alternatives, err := g.executeWithRetry(ctx, func() (string, error) {
-		return g.makeRequest(jsonData)
-	})
-
-	return alternatives, err
+	return json.Marshal(requestBody)
 }
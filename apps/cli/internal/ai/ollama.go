@@ -7,12 +7,14 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type OllamaProvider struct {
 	model   string
 	baseURL string
 	client  *http.Client
+	window  ModelWindow
 }
 
 // NewOllamaProvider creates a new Ollama provider for local LLM inference
@@ -43,6 +45,7 @@ func NewOllamaProvider(cfg *ProviderConfig) (*OllamaProvider, error) {
 		model:   model,
 		baseURL: baseURL,
 		client:  client,
+		window:  WindowFor("ollama", model, cfg.ContextWindow),
 	}, nil
 }
 
@@ -51,140 +54,54 @@ func (o *OllamaProvider) GenerateCommand(userRequest string, context SystemConte
 }
 
 func (o *OllamaProvider) GenerateCommandWithConversation(userRequest string, context SystemContext, conversation *ConversationHistory) (string, error) {
-	prompt := o.buildPrompt(userRequest, context, conversation)
-	
-	requestBody := map[string]interface{}{
-		"model":  o.model,
-		"prompt": prompt,
-		"stream": false,
-		"options": map[string]interface{}{
-			"temperature": 0.1,
-			"num_predict": 200,
-		},
-	}
-	
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-	
-	url := fmt.Sprintf("%s/api/generate", o.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := o.client.Do(req)
+	command, err := o.sendRequest(buildCommandPrompt(userRequest, context, conversation, o.window), commandReplyTokens, 0.1)
 	if err != nil {
-		return "", fmt.Errorf("API request failed: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-	
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
-	}
-	
-	var response struct {
-		Response string `json:"response"`
-		Done     bool   `json:"done"`
-	}
-	
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+		return "", err
 	}
-	
-	command := o.cleanCommand(response.Response)
-	return command, nil
+	return o.cleanCommand(command), nil
 }
 
 func (o *OllamaProvider) ExplainError(failedCommand string, errorOutput string, context SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Shell debugging assistant. Analyze this error briefly.
-
-System: %s | Shell: %s | Dir: %s
-
-Command: %s
-Error: %s
-
-Provide:
-EXPLANATION: Brief 1-2 sentence explanation of the error
-SUGGESTION: A corrected command (if applicable) or next steps
-
-Be concise and actionable.`,
-		context.OS,
-		context.Shell,
-		context.CurrentDir,
-		failedCommand,
-		errorOutput,
-	)
-	
-	return o.sendRequest(prompt, 2048, 0.3)
+	return o.sendRequest(buildExplainErrorPrompt(failedCommand, errorOutput, context, o.window), errorReplyTokens, 0.3)
 }
 
 func (o *OllamaProvider) ExplainCommand(command string, context SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Explain this shell command in simple, clear terms.
-
-System: %s | Shell: %s | Dir: %s
-
-Command: %s
-
-Provide a brief explanation (2-3 sentences) covering:
-1. What the command does
-2. What the key flags/options mean
-3. Any potential side effects or warnings
-4. Security warnings if the command has any security implications
-
-Be concise and user-friendly.`,
-		context.OS,
-		context.Shell,
-		context.CurrentDir,
-		command,
-	)
-	
-	return o.sendRequest(prompt, 1024, 0.3)
+	return o.sendRequest(buildExplainCommandPrompt(command, context), 1024, 0.3)
 }
 
 func (o *OllamaProvider) SuggestAlternatives(command string, context SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Given this shell command, suggest 2-3 alternative ways to accomplish the same goal.
-
-System: %s | Shell: %s | Dir: %s
-
-Original Command: %s
+	return o.sendRequest(buildAlternativesPrompt(command, context), 1024, 0.5)
+}
 
-Provide alternatives that:
-1. Use different tools/approaches
-2. May be safer, faster, or more efficient
-3. Have different trade-offs (verbosity, portability, features)
+// Chat replies to a message in mako chat
+func (o *OllamaProvider) Chat(message string, context SystemContext, conversation *ConversationHistory) (string, error) {
+	return o.sendRequest(buildChatPrompt(message, context, conversation, o.window), chatReplyTokens, 0.4)
+}
 
-Format each alternative as:
-• [command] - brief explanation of difference/advantage
+// PlanTask plans the rest of a mako do task
+func (o *OllamaProvider) PlanTask(goal string, steps []ExecutedStep, context SystemContext) (string, error) {
+	return o.sendRequest(buildTaskPrompt(goal, steps, context, o.window), taskReplyTokens, 0.2)
+}
 
-Be concise and practical.`,
-		context.OS,
-		context.Shell,
-		context.CurrentDir,
-		command,
-	)
-	
-	return o.sendRequest(prompt, 1024, 0.5)
+// ExplainParts explains what mako explain found no documentation for
+func (o *OllamaProvider) ExplainParts(command string, parts []string, context SystemContext) (string, error) {
+	return o.sendRequest(buildExplainPartsPrompt(command, parts, context), 512, 0.2)
 }
 
-func (o *OllamaProvider) sendRequest(prompt string, maxTokens int, temperature float64) (string, error) {
+func (o *OllamaProvider) sendRequest(prompt Prompt, maxTokens int, temperature float64) (string, error) {
 	requestBody := map[string]interface{}{
 		"model":  o.model,
-		"prompt": prompt,
+		"prompt": prompt.User,
 		"stream": false,
 		"options": map[string]interface{}{
 			"temperature": temperature,
 			"num_predict": maxTokens,
+			"num_ctx":     o.window.Tokens,
 		},
 	}
+	if prompt.System != "" {
+		requestBody["system"] = prompt.System
+	}
 	
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
@@ -199,6 +116,7 @@ func (o *OllamaProvider) sendRequest(prompt string, maxTokens int, temperature f
 	
 	req.Header.Set("Content-Type", "application/json")
 	
+	started := time.Now()
 	resp, err := o.client.Do(req)
 	if err != nil {
 		return "", err
@@ -215,107 +133,23 @@ func (o *OllamaProvider) sendRequest(prompt string, maxTokens int, temperature f
 	}
 	
 	var response struct {
-		Response string `json:"response"`
+		Response        string `json:"response"`
+		PromptEvalCount int    `json:"prompt_eval_count"`
+		EvalCount       int    `json:"eval_count"`
 	}
 	
 	if err := json.Unmarshal(body, &response); err != nil {
 		return "", err
 	}
 	
+	recordCall("ollama", o.model, prompt.Name, prompt.System+prompt.User, response.Response,
+		response.PromptEvalCount, response.EvalCount, started)
 	return response.Response, nil
 }
 
-func (o *OllamaProvider) buildPrompt(userRequest string, context SystemContext, conversation *ConversationHistory) string {
-	var promptBuild strings.Builder
-	
-	promptBuild.WriteString("You are a shell command generator. Your ONLY job is to output a single shell command.\n\n")
-	promptBuild.WriteString("RULES:\n")
-	promptBuild.WriteString("- Output ONLY the command, nothing else\n")
-	promptBuild.WriteString("- NO explanations, NO markdown, NO code blocks\n")
-	promptBuild.WriteString("- Use proper flags and options for the task\n")
-	promptBuild.WriteString("- The command must be safe and correct\n\n")
-	
-	// Include conversation history if available
-	if conversation != nil && conversation.IsActive() {
-		promptBuild.WriteString(conversation.GetContext())
-	}
-	
-	// Include user preferences if available
-	if context.Preferences != nil {
-		prefHints := context.Preferences.GetPreferenceHints()
-		if prefHints != "" {
-			promptBuild.WriteString(prefHints)
-		}
-	}
-	
-	promptBuild.WriteString(fmt.Sprintf("System: %s\n", context.OS))
-	promptBuild.WriteString(fmt.Sprintf("Shell: %s\n", context.Shell))
-	promptBuild.WriteString(fmt.Sprintf("Current directory: %s\n", context.CurrentDir))
-	
-	// Include project context
-	if context.Project != nil {
-		projectHint := context.Project.GetProjectHint()
-		if projectHint != "" {
-			promptBuild.WriteString(fmt.Sprintf("Project type: %s\n", projectHint))
-		}
-		
-		if context.Project.TestCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Test command: %s\n", context.Project.TestCmd))
-		}
-		if context.Project.BuildCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Build command: %s\n", context.Project.BuildCmd))
-		}
-		if context.Project.RunCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Run command: %s\n", context.Project.RunCmd))
-		}
-	}
-	
-	// Include files in current directory
-	if len(context.WorkingFiles) > 0 {
-		promptBuild.WriteString(fmt.Sprintf("Files in directory: %s\n", strings.Join(context.WorkingFiles, ", ")))
-	}
-	
-	// Include recent commands
-	if len(context.RecentCommands) > 0 {
-		promptBuild.WriteString("\nRecent commands:\n")
-		for _, cmd := range context.RecentCommands {
-			promptBuild.WriteString(fmt.Sprintf("  %s\n", cmd))
-		}
-	}
-	
-	// Include recent output analysis
-	if len(context.RecentOutput) > 0 {
-		promptBuild.WriteString("\nRecent terminal output:\n")
-		
-		hints := AnalyzeRecentOutput(context.RecentOutput)
-		
-		if hints["has_errors"] == "true" {
-			promptBuild.WriteString("(Note: Recent output contains errors)\n")
-		}
-		if hints["needs_sudo"] == "true" {
-			promptBuild.WriteString("(Note: Previous command had permission issues)\n")
-		}
-		if workingWith, ok := hints["working_with"]; ok {
-			promptBuild.WriteString(fmt.Sprintf("(Note: User is working with %s)\n", workingWith))
-		}
-		
-		// Include last 5 lines of output
-		startIdx := len(context.RecentOutput) - 5
-		if startIdx < 0 {
-			startIdx = 0
-		}
-		for _, line := range context.RecentOutput[startIdx:] {
-			if strings.TrimSpace(line) != "" {
-				promptBuild.WriteString(fmt.Sprintf("  %s\n", line))
-			}
-		}
-	}
-	
-	promptBuild.WriteString("\nUser request: ")
-	promptBuild.WriteString(userRequest)
-	promptBuild.WriteString("\n\nGenerate ONLY the shell command. No markdown, no backticks, no explanations. Just the raw command.")
-	
-	return promptBuild.String()
+// BuildPrompt returns the redacted prompt GenerateCommandWithConversation sends
+func (o *OllamaProvider) BuildPrompt(userRequest string, context SystemContext, conversation *ConversationHistory) Prompt {
+	return buildCommandPrompt(userRequest, context, conversation, o.window)
 }
 
 func (o *OllamaProvider) cleanCommand(command string) string {
@@ -371,6 +205,7 @@ func (o *OllamaEmbeddingProvider) GenerateEmbedding(text string) ([]byte, error)
 	
 	req.Header.Set("Content-Type", "application/json")
 	
+	started := time.Now()
 	resp, err := o.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -398,5 +233,7 @@ func (o *OllamaEmbeddingProvider) GenerateEmbedding(text string) ([]byte, error)
 		return nil, fmt.Errorf("no embedding in response")
 	}
 	
+	recordCall("ollama", o.model, FeatureEmbedding, text, "", 0, 0, started)
+	
 	return VectorToBytes(response.Embedding), nil
 }
@@ -7,13 +7,16 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type OpenAIProvider struct {
-	apiKey  string
-	model   string
-	baseURL string
-	client  *http.Client
+	provider string // openai, or openrouter or deepseek for their compatible APIs
+	apiKey   string
+	model    string
+	baseURL  string
+	client   *http.Client
+	window   ModelWindow
 }
 
 // NewOpenAIProvider creates a new OpenAI provider
@@ -33,10 +36,12 @@ func NewOpenAIProvider(cfg *ProviderConfig) (*OpenAIProvider, error) {
 	}
 	
 	return &OpenAIProvider{
-		apiKey:  cfg.APIKey,
-		model:   model,
-		baseURL: baseURL,
-		client:  &http.Client{},
+		provider: "openai",
+		apiKey:   cfg.APIKey,
+		model:    model,
+		baseURL:  baseURL,
+		client:   &http.Client{},
+		window:   WindowFor("openai", model, cfg.ContextWindow),
 	}, nil
 }
 
@@ -45,155 +50,52 @@ func (o *OpenAIProvider) GenerateCommand(userRequest string, context SystemConte
 }
 
 func (o *OpenAIProvider) GenerateCommandWithConversation(userRequest string, context SystemContext, conversation *ConversationHistory) (string, error) {
-	prompt := o.buildPrompt(userRequest, context, conversation)
-	
-	messages := []map[string]interface{}{
-		{
-			"role":    "system",
-			"content": "You are a shell command generator. Output ONLY the command, nothing else. NO explanations, NO markdown, NO code blocks.",
-		},
-		{
-			"role":    "user",
-			"content": prompt,
-		},
-	}
-	
-	requestBody := map[string]interface{}{
-		"model":       o.model,
-		"messages":    messages,
-		"temperature": 0.1,
-		"max_tokens":  200,
-	}
-	
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-	
-	url := fmt.Sprintf("%s/chat/completions", o.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	command, err := o.sendRequest(buildCommandPrompt(userRequest, context, conversation, o.window), commandReplyTokens, 0.1)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.apiKey))
-	
-	resp, err := o.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("API request failed: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-	
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
-	}
-	
-	var response struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-	
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
-	
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no response from API")
+		return "", err
 	}
-	
-	command := response.Choices[0].Message.Content
-	command = o.cleanCommand(command)
-	
-	return command, nil
+	return o.cleanCommand(command), nil
 }
 
 func (o *OpenAIProvider) ExplainError(failedCommand string, errorOutput string, context SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Shell debugging assistant. Analyze this error briefly.
-
-System: %s | Shell: %s | Dir: %s
-
-Command: %s
-Error: %s
-
-Provide:
-EXPLANATION: Brief 1-2 sentence explanation of the error
-SUGGESTION: A corrected command (if applicable) or next steps
-
-Be concise and actionable.`,
-		context.OS,
-		context.Shell,
-		context.CurrentDir,
-		failedCommand,
-		errorOutput,
-	)
-	
-	return o.sendRequest(prompt, 2048, 0.3)
+	return o.sendRequest(buildExplainErrorPrompt(failedCommand, errorOutput, context, o.window), errorReplyTokens, 0.3)
 }
 
 func (o *OpenAIProvider) ExplainCommand(command string, context SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Explain this shell command in simple, clear terms.
-
-System: %s | Shell: %s | Dir: %s
-
-Command: %s
-
-Provide a brief explanation (2-3 sentences) covering:
-1. What the command does
-2. What the key flags/options mean
-3. Any potential side effects or warnings
-4. **Security warnings** if the command has any security implications
-
-Be concise and user-friendly.`,
-		context.OS,
-		context.Shell,
-		context.CurrentDir,
-		command,
-	)
-	
-	return o.sendRequest(prompt, 1024, 0.3)
+	return o.sendRequest(buildExplainCommandPrompt(command, context), 1024, 0.3)
 }
 
 func (o *OpenAIProvider) SuggestAlternatives(command string, context SystemContext) (string, error) {
-	prompt := fmt.Sprintf(`Given this shell command, suggest 2-3 alternative ways to accomplish the same goal.
-
-System: %s | Shell: %s | Dir: %s
-
-Original Command: %s
+	return o.sendRequest(buildAlternativesPrompt(command, context), 1024, 0.5)
+}
 
-Provide alternatives that:
-1. Use different tools/approaches
-2. May be safer, faster, or more efficient
-3. Have different trade-offs (verbosity, portability, features)
+// Chat replies to a message in mako chat
+func (o *OpenAIProvider) Chat(message string, context SystemContext, conversation *ConversationHistory) (string, error) {
+	return o.sendRequest(buildChatPrompt(message, context, conversation, o.window), chatReplyTokens, 0.4)
+}
 
-Format each alternative as:
-• [command] - brief explanation of difference/advantage
+// PlanTask plans the rest of a mako do task
+func (o *OpenAIProvider) PlanTask(goal string, steps []ExecutedStep, context SystemContext) (string, error) {
+	return o.sendRequest(buildTaskPrompt(goal, steps, context, o.window), taskReplyTokens, 0.2)
+}
 
-Be concise and practical.`,
-		context.OS,
-		context.Shell,
-		context.CurrentDir,
-		command,
-	)
-	
-	return o.sendRequest(prompt, 1024, 0.5)
+// ExplainParts explains what mako explain found no documentation for
+func (o *OpenAIProvider) ExplainParts(command string, parts []string, context SystemContext) (string, error) {
+	return o.sendRequest(buildExplainPartsPrompt(command, parts, context), 512, 0.2)
 }
 
-func (o *OpenAIProvider) sendRequest(prompt string, maxTokens int, temperature float64) (string, error) {
-	messages := []map[string]interface{}{
-		{
-			"role":    "user",
-			"content": prompt,
-		},
+func (o *OpenAIProvider) sendRequest(prompt Prompt, maxTokens int, temperature float64) (string, error) {
+	var messages []map[string]interface{}
+	if prompt.System != "" {
+		messages = append(messages, map[string]interface{}{
+			"role":    "system",
+			"content": prompt.System,
+		})
 	}
+	messages = append(messages, map[string]interface{}{
+		"role":    "user",
+		"content": prompt.User,
+	})
 	
 	requestBody := map[string]interface{}{
 		"model":       o.model,
@@ -216,6 +118,7 @@ func (o *OpenAIProvider) sendRequest(prompt string, maxTokens int, temperature f
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.apiKey))
 	
+	started := time.Now()
 	resp, err := o.client.Do(req)
 	if err != nil {
 		return "", err
@@ -237,6 +140,10 @@ func (o *OpenAIProvider) sendRequest(prompt string, maxTokens int, temperature f
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
 	}
 	
 	if err := json.Unmarshal(body, &response); err != nil {
@@ -247,92 +154,15 @@ func (o *OpenAIProvider) sendRequest(prompt string, maxTokens int, temperature f
 		return "", fmt.Errorf("no response from API")
 	}
 	
-	return response.Choices[0].Message.Content, nil
+	reply := response.Choices[0].Message.Content
+	recordCall(o.provider, o.model, prompt.Name, prompt.System+prompt.User, reply,
+		response.Usage.PromptTokens, response.Usage.CompletionTokens, started)
+	return reply, nil
 }
 
-func (o *OpenAIProvider) buildPrompt(userRequest string, context SystemContext, conversation *ConversationHistory) string {
-	var promptBuild strings.Builder
-	
-	// Include conversation history if available
-	if conversation != nil && conversation.IsActive() {
-		promptBuild.WriteString(conversation.GetContext())
-	}
-	
-	// Include user preferences if available
-	if context.Preferences != nil {
-		prefHints := context.Preferences.GetPreferenceHints()
-		if prefHints != "" {
-			promptBuild.WriteString(prefHints)
-		}
-	}
-	
-	promptBuild.WriteString(fmt.Sprintf("System: %s\n", context.OS))
-	promptBuild.WriteString(fmt.Sprintf("Shell: %s\n", context.Shell))
-	promptBuild.WriteString(fmt.Sprintf("Current directory: %s\n", context.CurrentDir))
-	
-	// Include project context
-	if context.Project != nil {
-		projectHint := context.Project.GetProjectHint()
-		if projectHint != "" {
-			promptBuild.WriteString(fmt.Sprintf("Project type: %s\n", projectHint))
-		}
-		
-		if context.Project.TestCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Test command: %s\n", context.Project.TestCmd))
-		}
-		if context.Project.BuildCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Build command: %s\n", context.Project.BuildCmd))
-		}
-		if context.Project.RunCmd != "" {
-			promptBuild.WriteString(fmt.Sprintf("Run command: %s\n", context.Project.RunCmd))
-		}
-	}
-	
-	// Include files in current directory
-	if len(context.WorkingFiles) > 0 {
-		promptBuild.WriteString(fmt.Sprintf("Files in directory: %s\n", strings.Join(context.WorkingFiles, ", ")))
-	}
-	
-	// Include recent commands
-	if len(context.RecentCommands) > 0 {
-		promptBuild.WriteString("\nRecent commands:\n")
-		for _, cmd := range context.RecentCommands {
-			promptBuild.WriteString(fmt.Sprintf("  %s\n", cmd))
-		}
-	}
-	
-	// Include recent output analysis
-	if len(context.RecentOutput) > 0 {
-		promptBuild.WriteString("\nRecent terminal output:\n")
-		
-		hints := AnalyzeRecentOutput(context.RecentOutput)
-		
-		if hints["has_errors"] == "true" {
-			promptBuild.WriteString("(Note: Recent output contains errors)\n")
-		}
-		if hints["needs_sudo"] == "true" {
-			promptBuild.WriteString("(Note: Previous command had permission issues)\n")
-		}
-		if workingWith, ok := hints["working_with"]; ok {
-			promptBuild.WriteString(fmt.Sprintf("(Note: User is working with %s)\n", workingWith))
-		}
-		
-		// Include last 5 lines of output
-		startIdx := len(context.RecentOutput) - 5
-		if startIdx < 0 {
-			startIdx = 0
-		}
-		for _, line := range context.RecentOutput[startIdx:] {
-			if strings.TrimSpace(line) != "" {
-				promptBuild.WriteString(fmt.Sprintf("  %s\n", line))
-			}
-		}
-	}
-	
-	promptBuild.WriteString("\nUser request: ")
-	promptBuild.WriteString(userRequest)
-	
-	return promptBuild.String()
+// BuildPrompt returns the redacted prompt GenerateCommandWithConversation sends
+func (o *OpenAIProvider) BuildPrompt(userRequest string, context SystemContext, conversation *ConversationHistory) Prompt {
+	return buildCommandPrompt(userRequest, context, conversation, o.window)
 }
 
 func (o *OpenAIProvider) cleanCommand(command string) string {
@@ -395,6 +225,7 @@ func (o *OpenAIEmbeddingProvider) GenerateEmbedding(text string) ([]byte, error)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.apiKey))
 	
+	started := time.Now()
 	resp, err := o.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -414,6 +245,9 @@ func (o *OpenAIEmbeddingProvider) GenerateEmbedding(text string) ([]byte, error)
 		Data []struct {
 			Embedding []float32 `json:"embedding"`
 		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
 	}
 	
 	if err := json.Unmarshal(body, &response); err != nil {
@@ -424,5 +258,7 @@ func (o *OpenAIEmbeddingProvider) GenerateEmbedding(text string) ([]byte, error)
 		return nil, fmt.Errorf("no embedding in response")
 	}
 	
+	recordCall("openai", o.model, FeatureEmbedding, text, "", response.Usage.PromptTokens, 0, started)
+	
 	return VectorToBytes(response.Data[0].Embedding), nil
 }
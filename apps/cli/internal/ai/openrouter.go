@@ -26,9 +26,11 @@ func NewOpenRouterProvider(cfg *ProviderConfig) (*OpenAIProvider, error) {
 	}
 	
 	return &OpenAIProvider{
-		apiKey:  cfg.APIKey,
-		model:   model,
-		baseURL: baseURL,
-		client:  &http.Client{},
+		provider: "openrouter",
+		apiKey:   cfg.APIKey,
+		model:    model,
+		baseURL:  baseURL,
+		client:   &http.Client{},
+		window:   WindowFor("openrouter", model, cfg.ContextWindow),
 	}, nil
 }
@@ -1,167 +1,92 @@
 package ai
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
-const (
-	preferencesFile = ".mako/preferences.json"
-)
+// MinHintUses is how often a command must have been run with its flags
+// before the model is told about them
+const MinHintUses = 3
+
+// maxPreferenceHints bounds how many preferences go into a prompt
+const maxPreferenceHints = 10
 
 // CommandPreference stores learned preferences for a command
 type CommandPreference struct {
-	BaseCommand     string            `json:"base_command"`     // e.g., "ls", "git commit"
-	CommonFlags     map[string]int    `json:"common_flags"`     // e.g., {"-lah": 10, "-la": 2}
-	PreferredFlag   string            `json:"preferred_flag"`   // Most commonly used flag combo
-	UsageCount      int               `json:"usage_count"`      // Total times this command was used
-	LastUsed        string            `json:"last_used"`        // Timestamp of last use
+	BaseCommand   string         `json:"base_command"`      // e.g., "ls", "git commit"
+	CommonFlags   map[string]int `json:"common_flags"`      // e.g., {"-lah": 10, "-la": 2}
+	PreferredFlag string         `json:"preferred_flag"`    // Most used flag combo, weighing recent uses more
+	UsageCount    int            `json:"usage_count"`       // Total times this command was used
+	LastUsed      time.Time      `json:"last_used"`         // When it was last run with flags
+	Project       string         `json:"project,omitempty"` // Set when learned in the current project
 }
 
-// PersonalizationStore manages user preferences
+// PersonalizationStore manages user preferences. They are learned from the
+// command history in the background; the store holds the ones that apply
+// in the current directory.
 type PersonalizationStore struct {
 	Preferences map[string]*CommandPreference `json:"preferences"`
 }
 
-// LoadPreferences loads the personalization store from disk
-func LoadPreferences() (*PersonalizationStore, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
-	}
-
-	prefPath := filepath.Join(homeDir, preferencesFile)
-	
-	data, err := os.ReadFile(prefPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// No preferences exist, start fresh
-			return &PersonalizationStore{
-				Preferences: make(map[string]*CommandPreference),
-			}, nil
-		}
-		return nil, fmt.Errorf("failed to read preferences: %w", err)
-	}
-
-	var store PersonalizationStore
-	if err := json.Unmarshal(data, &store); err != nil {
-		// Corrupted file, start fresh
-		return &PersonalizationStore{
-			Preferences: make(map[string]*CommandPreference),
-		}, nil
-	}
-
-	if store.Preferences == nil {
-		store.Preferences = make(map[string]*CommandPreference)
-	}
-
-	return &store, nil
-}
-
-// Save saves the personalization store to disk
-func (p *PersonalizationStore) Save() error {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
-	}
-
-	prefPath := filepath.Join(homeDir, preferencesFile)
-	
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(prefPath), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-
-	data, err := json.MarshalIndent(p, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal preferences: %w", err)
-	}
-
-	if err := os.WriteFile(prefPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write preferences: %w", err)
-	}
-
-	return nil
+// NewPersonalizationStore returns an empty store
+func NewPersonalizationStore() *PersonalizationStore {
+	return &PersonalizationStore{Preferences: make(map[string]*CommandPreference)}
 }
 
-// LearnFromCommand learns user preferences from an executed command
-func (p *PersonalizationStore) LearnFromCommand(command string) {
-	// Extract base command and flags
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return
-	}
-
-	baseCmd := parts[0]
-	
-	// Handle git subcommands as separate commands
-	if baseCmd == "git" && len(parts) > 1 {
-		baseCmd = "git " + parts[1]
-		parts = parts[1:]
-	}
-
-	// Extract flags (starts with -)
-	var flags []string
-	for i := 1; i < len(parts); i++ {
-		if strings.HasPrefix(parts[i], "-") {
-			flags = append(flags, parts[i])
-		}
+// Add records a learned flag combination. Combinations are added heaviest
+// first, so the first one for a command is its preferred flag. What was
+// learned in the current project replaces what was learned everywhere.
+func (p *PersonalizationStore) Add(project, baseCommand, flags string, uses int, lastUsed time.Time) {
+	pref, exists := p.Preferences[baseCommand]
+	if exists && pref.Project == "" && project != "" {
+		exists = false
 	}
-
-	// Only learn if there are flags
-	if len(flags) == 0 {
+	if exists && pref.Project != project {
 		return
 	}
-
-	// Get or create preference entry
-	pref, exists := p.Preferences[baseCmd]
 	if !exists {
 		pref = &CommandPreference{
-			BaseCommand: baseCmd,
-			CommonFlags: make(map[string]int),
+			BaseCommand:   baseCommand,
+			CommonFlags:   make(map[string]int),
+			PreferredFlag: flags,
+			Project:       project,
 		}
-		p.Preferences[baseCmd] = pref
+		p.Preferences[baseCommand] = pref
 	}
 
-	// Update usage count
-	pref.UsageCount++
-	pref.LastUsed = fmt.Sprintf("%d", os.Getpid()) // Simple timestamp
-
-	// Combine flags into a single string
-	flagCombo := strings.Join(flags, " ")
-	pref.CommonFlags[flagCombo]++
-
-	// Update preferred flag
-	maxCount := 0
-	for flag, count := range pref.CommonFlags {
-		if count > maxCount {
-			maxCount = count
-			pref.PreferredFlag = flag
-		}
+	pref.CommonFlags[flags] += uses
+	pref.UsageCount += uses
+	if lastUsed.After(pref.LastUsed) {
+		pref.LastUsed = lastUsed
 	}
 }
 
 // GetPreferenceHints returns AI-friendly hints about user preferences
 func (p *PersonalizationStore) GetPreferenceHints() string {
-	if len(p.Preferences) == 0 {
-		return ""
-	}
-
-	var hints strings.Builder
-	hints.WriteString("\nLEARNED USER PREFERENCES:\n")
-
-	// Show top preferences (commands used more than twice)
-	for cmd, pref := range p.Preferences {
-		if pref.UsageCount >= 3 && pref.PreferredFlag != "" {
-			hints.WriteString(fmt.Sprintf("- User typically uses '%s %s' (used %d times)\n", 
-				cmd, pref.PreferredFlag, pref.CommonFlags[pref.PreferredFlag]))
+	var lines []string
+	for _, pref := range p.GetTopCommands(len(p.Preferences)) {
+		uses := pref.CommonFlags[pref.PreferredFlag]
+		if uses < MinHintUses || pref.PreferredFlag == "" {
+			continue
+		}
+		where := ""
+		if pref.Project != "" {
+			where = " in this project"
+		}
+		lines = append(lines, fmt.Sprintf("- User typically uses '%s %s'%s (used %d times)\n",
+			pref.BaseCommand, pref.PreferredFlag, where, uses))
+		if len(lines) == maxPreferenceHints {
+			break
 		}
 	}
+	if len(lines) == 0 {
+		return ""
+	}
 
-	return hints.String()
+	return "\nLEARNED USER PREFERENCES:\n" + strings.Join(lines, "")
 }
 
 // GetPreferenceForCommand returns the preferred flags for a specific command
@@ -172,7 +97,7 @@ func (p *PersonalizationStore) GetPreferenceForCommand(baseCommand string) strin
 	}
 
 	// Only suggest if used at least 3 times
-	if pref.UsageCount < 3 {
+	if pref.UsageCount < MinHintUses {
 		return ""
 	}
 
@@ -181,20 +106,17 @@ func (p *PersonalizationStore) GetPreferenceForCommand(baseCommand string) strin
 
 // GetTopCommands returns the most frequently used commands
 func (p *PersonalizationStore) GetTopCommands(limit int) []CommandPreference {
-	// Convert map to slice
 	prefs := make([]CommandPreference, 0, len(p.Preferences))
 	for _, pref := range p.Preferences {
 		prefs = append(prefs, *pref)
 	}
 
-	// Simple bubble sort by usage count (good enough for small datasets)
-	for i := 0; i < len(prefs)-1; i++ {
-		for j := 0; j < len(prefs)-i-1; j++ {
-			if prefs[j].UsageCount < prefs[j+1].UsageCount {
-				prefs[j], prefs[j+1] = prefs[j+1], prefs[j]
-			}
+	sort.Slice(prefs, func(i, j int) bool {
+		if prefs[i].UsageCount != prefs[j].UsageCount {
+			return prefs[i].UsageCount > prefs[j].UsageCount
 		}
-	}
+		return prefs[i].BaseCommand < prefs[j].BaseCommand
+	})
 
 	// Return top N
 	if limit > len(prefs) {
@@ -0,0 +1,262 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/prompts"
+)
+
+// contextTokenBudget caps the assembled context, so a model with a large
+// window isn't sent more than helps
+const contextTokenBudget = 3000
+
+// outputLines caps the recent output lines given to a template; the others
+// get as many as fit
+var outputLines = map[string]int{
+	prompts.Command: 5,
+}
+
+// Tokens kept free for the replies whose prompts carry context
+const (
+	commandReplyTokens = 200
+	chatReplyTokens    = 1024
+	taskReplyTokens    = 1024
+	errorReplyTokens   = 2048
+)
+
+// Prompt is a rendered prompt. System holds the instructions providers send
+// as the system message; it is empty for templates without any.
+type Prompt struct {
+	Name   string // The template it was rendered from
+	System string
+	User   string
+	Budget *TokenBudget // For prompts fitted to the model's window
+}
+
+// String shows the prompt as mako ask --show-prompt prints it
+func (p Prompt) String() string {
+	if p.System == "" {
+		return p.User
+	}
+	return "[system]\n" + p.System + "\n\n[user]\n" + p.User
+}
+
+// promptContext is the context around a request, trimmed to the budget
+type promptContext struct {
+	Conversation   string
+	Preferences    string
+	Project        []string // "Project type: Go", "Test command: go test ./..."
+	Files          []string
+	RecentCommands []string
+	OutputNotes    []string // What AnalyzeRecentOutput noticed
+	RecentOutput   []string // Non-blank lines, most recent last
+}
+
+// promptStep is an ExecutedStep as the task template sees it
+type promptStep struct {
+	Number   int
+	Command  string
+	Skipped  bool
+	ExitCode int
+	Output   []string
+}
+
+// promptData is what the templates are executed with
+type promptData struct {
+	Request     string
+	Command     string
+	Error       string
+	Goal        string
+	Parts       []string
+	Steps       []promptStep
+	MaxCommands int
+	OS          string
+	Shell       string
+	Dir         string
+	Context     promptContext
+}
+
+func newPromptData(context SystemContext) promptData {
+	return promptData{OS: context.OS, Shell: context.Shell, Dir: context.CurrentDir}
+}
+
+// renderPrompt renders a template and redacts secrets from the result
+func renderPrompt(name string, data promptData) Prompt {
+	system, user, err := prompts.Render(name, data)
+	if err != nil {
+		// The built-in templates are tested to render; this is a bug
+		user = fmt.Sprintf("%s\n\n(prompt template %s failed: %v)", data.Request, name, err)
+	}
+	return Prompt{Name: name, System: RedactPrompt(system), User: RedactPrompt(user)}
+}
+
+// fitPrompt renders a template with as much context as fits: what the
+// model's window has left once the reply and the rest of the prompt are
+// accounted for, up to contextTokenBudget
+func fitPrompt(name string, data promptData, context SystemContext, conversation *ConversationHistory, window ModelWindow, reply int) Prompt {
+	bare := renderPrompt(name, data)
+	fixed := window.Count(bare.System) + window.Count(bare.User)
+	// Section headings and rounding aren't counted, so leave some slack
+	budget := min(contextTokenBudget, window.Tokens-reply-fixed-window.Tokens/20)
+
+	var trimmed []string
+	data.Context, trimmed = assembleContext(context, conversation, window, max(budget, 0), outputLines[name])
+	prompt := renderPrompt(name, data)
+	prompt.Budget = &TokenBudget{
+		Model:   window.Model,
+		Window:  window.Tokens,
+		Reply:   reply,
+		Used:    window.Count(prompt.System) + window.Count(prompt.User),
+		Context: window.Count(prompt.System) + window.Count(prompt.User) - fixed,
+		Trimmed: trimmed,
+	}
+	return prompt
+}
+
+// assembleContext gathers the context sections in order of priority,
+// project facts first, and keeps what fits in budget tokens. Lists lose
+// their oldest items first; the conversation keeps its most recent turns.
+// Recent output is cut to its last maxOutput lines first, unless that is 0.
+// It also says which sections were shortened or left out.
+func assembleContext(context SystemContext, conversation *ConversationHistory, window ModelWindow, budget, maxOutput int) (promptContext, []string) {
+	var c promptContext
+	var trimmed []string
+
+	var project []string
+	if context.Project != nil {
+		if projectHint := context.Project.GetProjectHint(); projectHint != "" {
+			project = append(project, "Project type: "+projectHint)
+		}
+		if context.Project.TestCmd != "" {
+			project = append(project, "Test command: "+context.Project.TestCmd)
+		}
+		if context.Project.BuildCmd != "" {
+			project = append(project, "Build command: "+context.Project.BuildCmd)
+		}
+		if context.Project.RunCmd != "" {
+			project = append(project, "Run command: "+context.Project.RunCmd)
+		}
+	}
+	if cost := window.Count(strings.Join(project, "\n")); cost <= budget {
+		c.Project = project
+		budget -= cost
+	} else {
+		trimmed = append(trimmed, "project")
+	}
+
+	if conversation != nil && conversation.IsActive() {
+		full := conversation.contextWithin(conversation.tokenBudget(), window.Count)
+		c.Conversation = conversation.contextWithin(min(budget, conversation.tokenBudget()), window.Count)
+		if c.Conversation != full {
+			trimmed = append(trimmed, "conversation")
+		}
+		budget -= window.Count(c.Conversation)
+	}
+
+	if context.Preferences != nil {
+		if hints := strings.TrimSpace(context.Preferences.GetPreferenceHints()); window.Count(hints) <= budget {
+			c.Preferences = hints
+			budget -= window.Count(hints)
+		} else {
+			trimmed = append(trimmed, "preferences")
+		}
+	}
+
+	c.RecentCommands, budget = newestWithin(context.RecentCommands, window, budget)
+	trimmed = appendTrimmed(trimmed, "recent commands", len(c.RecentCommands), len(context.RecentCommands))
+
+	if len(context.RecentOutput) > 0 {
+		hints := AnalyzeRecentOutput(context.RecentOutput)
+		if hints["has_errors"] == "true" {
+			c.OutputNotes = append(c.OutputNotes, "Recent output contains errors")
+		}
+		if hints["needs_sudo"] == "true" {
+			c.OutputNotes = append(c.OutputNotes, "Previous command had permission issues")
+		}
+		if workingWith, ok := hints["working_with"]; ok {
+			c.OutputNotes = append(c.OutputNotes, "User is working with "+workingWith)
+		}
+	}
+	var output []string
+	for _, line := range context.RecentOutput {
+		if strings.TrimSpace(line) != "" {
+			output = append(output, line)
+		}
+	}
+	if maxOutput > 0 && len(output) > maxOutput {
+		output = output[len(output)-maxOutput:]
+	}
+	c.RecentOutput, budget = newestWithin(output, window, budget)
+	trimmed = appendTrimmed(trimmed, "recent output", len(c.RecentOutput), len(output))
+
+	c.Files, _ = newestWithin(context.WorkingFiles, window, budget)
+	trimmed = appendTrimmed(trimmed, "files", len(c.Files), len(context.WorkingFiles))
+	return c, trimmed
+}
+
+// appendTrimmed notes a list section that lost items
+func appendTrimmed(trimmed []string, section string, kept, total int) []string {
+	switch {
+	case kept == total:
+		return trimmed
+	case kept == 0:
+		return append(trimmed, section)
+	}
+	return append(trimmed, fmt.Sprintf("%s (%d of %d)", section, kept, total))
+}
+
+// newestWithin keeps the last items of a list that fit in budget tokens,
+// returning them and the budget left
+func newestWithin(items []string, window ModelWindow, budget int) ([]string, int) {
+	start := len(items)
+	for start > 0 {
+		cost := window.Count(items[start-1]) + 1
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start--
+	}
+	if start == len(items) {
+		return nil, budget
+	}
+	return items[start:], budget
+}
+
+// buildCommandPrompt asks for the command for a request
+func buildCommandPrompt(userRequest string, context SystemContext, conversation *ConversationHistory, window ModelWindow) Prompt {
+	data := newPromptData(context)
+	data.Request = userRequest
+	return fitPrompt(prompts.Command, data, context, conversation, window, commandReplyTokens)
+}
+
+// buildExplainErrorPrompt asks why a command failed. Error output that
+// doesn't fit the window loses its beginning; the end usually says most.
+func buildExplainErrorPrompt(failedCommand, errorOutput string, context SystemContext, window ModelWindow) Prompt {
+	data := newPromptData(context)
+	data.Command = failedCommand
+	bare := renderPrompt(prompts.ExplainError, data)
+	room := window.Tokens - errorReplyTokens - window.Count(bare.System) - window.Count(bare.User) - window.Tokens/20
+	if window.Count(errorOutput) > room {
+		lines := strings.Split(errorOutput, "\n")
+		kept, _ := newestWithin(lines, window, max(room, 0))
+		errorOutput = "...\n" + strings.Join(kept, "\n")
+	}
+	data.Error = errorOutput
+	return renderPrompt(prompts.ExplainError, data)
+}
+
+// buildExplainCommandPrompt asks what a command does
+func buildExplainCommandPrompt(command string, context SystemContext) Prompt {
+	data := newPromptData(context)
+	data.Command = command
+	return renderPrompt(prompts.ExplainCommand, data)
+}
+
+// buildAlternativesPrompt asks for other ways to do what a command does
+func buildAlternativesPrompt(command string, context SystemContext) Prompt {
+	data := newPromptData(context)
+	data.Command = command
+	return renderPrompt(prompts.Alternatives, data)
+}
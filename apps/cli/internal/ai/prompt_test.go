@@ -0,0 +1,181 @@
+package ai
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	makocontext "github.com/fabiobrug/mako.git/internal/context"
+	"github.com/fabiobrug/mako.git/internal/prompts"
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+// sizedContext has a 7 token project section and three lists of four
+// items that cost 3 tokens each at four characters per token
+func sizedContext() SystemContext {
+	items := func(prefix string) []string {
+		var list []string
+		for i := 1; i <= 4; i++ {
+			list = append(list, fmt.Sprintf("%s-%04d", prefix, i))
+		}
+		return list
+	}
+	return SystemContext{
+		OS:             "linux/amd64",
+		Shell:          "bash",
+		CurrentDir:     "/src",
+		Project:        &makocontext.ProjectType{TestCmd: "go test ./..."},
+		RecentCommands: items("cmd"),
+		RecentOutput:   items("out"),
+		WorkingFiles:   items("fil"),
+	}
+}
+
+func TestAssembleContextTrimsLowestPriorityFirst(t *testing.T) {
+	window := ModelWindow{Model: "test", Tokens: 100000, CharsPerToken: 4}
+
+	tests := []struct {
+		budget   int
+		trimmed  []string
+		commands int
+		output   int
+		files    int
+	}{
+		{43, nil, 4, 4, 4},
+		{37, []string{"files (2 of 4)"}, 4, 4, 2},
+		{25, []string{"recent output (2 of 4)", "files"}, 4, 2, 0},
+		{5, []string{"project", "recent commands (1 of 4)", "recent output", "files"}, 1, 0, 0},
+		{0, []string{"project", "recent commands", "recent output", "files"}, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("budget %d", tt.budget), func(t *testing.T) {
+			c, trimmed := assembleContext(sizedContext(), nil, window, tt.budget, 0)
+			if !reflect.DeepEqual(trimmed, tt.trimmed) {
+				t.Errorf("trimmed = %q, want %q", trimmed, tt.trimmed)
+			}
+			if len(c.RecentCommands) != tt.commands || len(c.RecentOutput) != tt.output || len(c.Files) != tt.files {
+				t.Errorf("kept %d commands, %d output lines, %d files; want %d, %d, %d",
+					len(c.RecentCommands), len(c.RecentOutput), len(c.Files), tt.commands, tt.output, tt.files)
+			}
+			// Lists keep their newest items
+			if tt.commands > 0 && c.RecentCommands[len(c.RecentCommands)-1] != "cmd-0004" {
+				t.Errorf("Expected the newest command to be kept, got %q", c.RecentCommands)
+			}
+		})
+	}
+}
+
+func TestFitPrompt(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+	context := sizedContext()
+	data := newPromptData(context)
+	data.Request = "list files"
+
+	// A large window takes everything
+	large := ModelWindow{Model: "large", Tokens: 100000, CharsPerToken: 4}
+	prompt := fitPrompt(prompts.Command, data, context, nil, large, commandReplyTokens)
+	if prompt.Budget == nil || len(prompt.Budget.Trimmed) != 0 {
+		t.Fatalf("Expected nothing trimmed, got %+v", prompt.Budget)
+	}
+	for _, want := range []string{"go test ./...", "cmd-0001", "out-0001", "fil-0001"} {
+		if !strings.Contains(prompt.User+prompt.System, want) {
+			t.Errorf("Expected the prompt to contain %q", want)
+		}
+	}
+
+	// A window with room for a little context loses the files first, then
+	// the output, and never goes over what it has
+	fixed := prompt.Budget.Used - prompt.Budget.Context
+	small := ModelWindow{Model: "small", Tokens: fixed + commandReplyTokens + 40, CharsPerToken: 4}
+	small.Tokens += small.Tokens / 20
+	prompt = fitPrompt(prompts.Command, data, context, nil, small, commandReplyTokens)
+	if prompt.Budget.Used > prompt.Budget.Available() {
+		t.Errorf("Prompt uses %d tokens, more than the %d available", prompt.Budget.Used, prompt.Budget.Available())
+	}
+	trimmed := strings.Join(prompt.Budget.Trimmed, ", ")
+	if !strings.Contains(trimmed, "files") || strings.Contains(trimmed, "project") {
+		t.Errorf("Expected files but not the project to be trimmed, got %q", trimmed)
+	}
+	if !strings.Contains(prompt.User+prompt.System, "go test ./...") {
+		t.Error("Expected the project section to survive trimming")
+	}
+
+	// A window too small for any context still renders the request
+	tiny := ModelWindow{Model: "tiny", Tokens: fixed, CharsPerToken: 4}
+	prompt = fitPrompt(prompts.Command, data, context, nil, tiny, commandReplyTokens)
+	if !strings.Contains(prompt.User, "list files") {
+		t.Errorf("Expected the request in the prompt, got %q", prompt.User)
+	}
+	if len(prompt.Budget.Trimmed) != 4 {
+		t.Errorf("Expected every section to be left out, got %q", prompt.Budget.Trimmed)
+	}
+}
+
+func TestCommandPromptKeepsLastOutputLines(t *testing.T) {
+	context := SystemContext{OS: "linux/amd64", Shell: "bash", CurrentDir: "/src"}
+	for i := 1; i <= 8; i++ {
+		context.RecentOutput = append(context.RecentOutput, fmt.Sprintf("line-%02d", i))
+	}
+	window := ModelWindow{Model: "test", Tokens: 100000, CharsPerToken: 4}
+
+	c, _ := assembleContext(context, nil, window, 1000, outputLines[prompts.Command])
+	if len(c.RecentOutput) != 5 || c.RecentOutput[0] != "line-04" {
+		t.Errorf("Expected the last 5 output lines, got %q", c.RecentOutput)
+	}
+
+	// What is rendered is what the budget counted
+	prompt := buildCommandPrompt("list files", context, nil, window)
+	if strings.Contains(prompt.User, "line-03") || !strings.Contains(prompt.User, "line-08") {
+		t.Errorf("Expected only the last 5 output lines in the prompt, got %q", prompt.User)
+	}
+	bare := buildCommandPrompt("list files", SystemContext{OS: "linux/amd64", Shell: "bash", CurrentDir: "/src"}, nil, window)
+	if got := prompt.Budget.Context; got != prompt.Budget.Used-bare.Budget.Used {
+		t.Errorf("Budget counts %d context tokens, the prompt grew by %d", got, prompt.Budget.Used-bare.Budget.Used)
+	}
+}
+
+func TestPromptsRedactRandomTokens(t *testing.T) {
+	token := "Xk9fP2qLm8RtZ4vBn7YcW1sDa3Hj6Ge5Nu0Tq8Lw"
+	if len(token) != 40 {
+		t.Fatalf("Expected a 40 character token, got %d", len(token))
+	}
+	window := ModelWindow{Model: "test", Tokens: 100000, CharsPerToken: 4}
+	context := SystemContext{
+		OS:           "linux/amd64",
+		Shell:        "bash",
+		CurrentDir:   "/home/user/projects/MyAwesomeProject2024Backend",
+		RecentOutput: []string{"$ ./issue-token", token},
+	}
+
+	// What mako ask --show-prompt prints
+	shown := buildCommandPrompt("list files", context, nil, window).String()
+	if strings.Contains(shown, token) {
+		t.Errorf("Expected the token to be masked in the prompt, got %q", shown)
+	}
+	if !strings.Contains(shown, "MyAwesomeProject2024Backend") {
+		t.Errorf("Expected path components to survive, got %q", shown)
+	}
+
+	explained := buildExplainErrorPrompt("./deploy", "auth failed for "+token, context, window).String()
+	if strings.Contains(explained, token) {
+		t.Errorf("Expected the token to be masked in the error payload, got %q", explained)
+	}
+}
+
+func TestTaskPromptCutsOutputOnCharacters(t *testing.T) {
+	// 1501 bytes: a cut at 1500 from the end would land inside the first é
+	output := "é" + strings.Repeat("a", taskOutputBytes-1)
+	steps := []ExecutedStep{{Command: "cat notes", Output: output}}
+	window := ModelWindow{Model: "test", Tokens: 100000, CharsPerToken: 4}
+
+	prompt := buildTaskPrompt("tidy notes", steps, SystemContext{OS: "linux/amd64", Shell: "bash", CurrentDir: "/src"}, window)
+	if !utf8.ValidString(prompt.User) {
+		t.Error("Expected the step output to be cut on a character boundary")
+	}
+	if !strings.Contains(prompt.User, "..."+strings.Repeat("a", taskOutputBytes-1)) {
+		t.Errorf("Expected the last %d bytes of output, got %q", taskOutputBytes-1, prompt.User)
+	}
+}
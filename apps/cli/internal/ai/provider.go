@@ -3,6 +3,7 @@ package ai
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/fabiobrug/mako.git/internal/config"
@@ -24,6 +25,21 @@ type AIProvider interface {
 	
 	// SuggestAlternatives suggests alternative ways to accomplish the same goal
 	SuggestAlternatives(command string, context SystemContext) (string, error)
+	
+	// BuildPrompt returns the exact (redacted) prompt sent for a command request
+	BuildPrompt(userRequest string, context SystemContext, conversation *ConversationHistory) Prompt
+	
+	// Chat replies to a message in an interactive conversation, proposing
+	// commands in fenced code blocks
+	Chat(message string, context SystemContext, conversation *ConversationHistory) (string, error)
+	
+	// PlanTask returns the JSON plan for the rest of a multi-step task,
+	// given the steps taken so far
+	PlanTask(goal string, steps []ExecutedStep, context SystemContext) (string, error)
+	
+	// ExplainParts explains the parts of a command that no documentation on
+	// the machine covers, one "part: meaning" line each
+	ExplainParts(command string, parts []string, context SystemContext) (string, error)
 }
 
 // EmbeddingProvider defines the interface for embedding generation
@@ -34,10 +50,11 @@ type EmbeddingProvider interface {
 
 // ProviderConfig holds configuration for initializing a provider
 type ProviderConfig struct {
-	Provider string
-	Model    string
-	APIKey   string
-	BaseURL  string
+	Provider      string
+	Model         string
+	APIKey        string
+	BaseURL       string
+	ContextWindow int // Tokens the model takes in; 0 looks the model up
 }
 
 // LoadProviderConfig loads LLM provider configuration from environment or config file
@@ -47,6 +64,7 @@ func LoadProviderConfig() (*ProviderConfig, error) {
 	model := os.Getenv("LLM_MODEL")
 	apiKey := os.Getenv("LLM_API_KEY")
 	baseURL := os.Getenv("LLM_API_BASE")
+	contextWindow, _ := strconv.Atoi(os.Getenv("LLM_CONTEXT_WINDOW"))
 	
 	// Fallback to config file if env vars not set
 	if provider == "" {
@@ -56,6 +74,7 @@ func LoadProviderConfig() (*ProviderConfig, error) {
 			model = cfg.LLMModel
 			apiKey = cfg.APIKey
 			baseURL = cfg.LLMBaseURL
+			contextWindow = cfg.LLMContextWindow
 		}
 	}
 	
@@ -79,11 +98,17 @@ func LoadProviderConfig() (*ProviderConfig, error) {
 		return nil, fmt.Errorf("unsupported LLM provider: %s. Supported: openai, anthropic, openrouter, gemini, deepseek, ollama", provider)
 	}
 	
+	apiKey, err := resolveAPIKey(apiKey, provider)
+	if err != nil {
+		return nil, err
+	}
+	
 	return &ProviderConfig{
-		Provider: provider,
-		Model:    model,
-		APIKey:   apiKey,
-		BaseURL:  baseURL,
+		Provider:      provider,
+		Model:         model,
+		APIKey:        apiKey,
+		BaseURL:       baseURL,
+		ContextWindow: contextWindow,
 	}, nil
 }
 
@@ -114,6 +139,11 @@ func LoadEmbeddingProviderConfig() (*ProviderConfig, error) {
 	
 	provider = strings.ToLower(strings.TrimSpace(provider))
 	
+	apiKey, err := resolveAPIKey(apiKey, provider)
+	if err != nil {
+		return nil, err
+	}
+	
 	return &ProviderConfig{
 		Provider: provider,
 		Model:    model,
@@ -122,13 +152,22 @@ func LoadEmbeddingProviderConfig() (*ProviderConfig, error) {
 	}, nil
 }
 
-// NewAIProvider creates a new AI provider based on configuration
+// NewAIProvider creates a new AI provider based on configuration. Past the
+// monthly budget it warns, or fails with ErrBudgetExceeded when set to block.
 func NewAIProvider() (AIProvider, error) {
 	cfg, err := LoadProviderConfig()
 	if err != nil {
 		return nil, err
 	}
 	
+	warning, err := CheckBudget()
+	if err != nil {
+		return nil, err
+	}
+	if warning != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	
 	switch cfg.Provider {
 	case "gemini":
 		return NewGeminiProvider(cfg)
@@ -165,7 +204,34 @@ func NewEmbeddingProvider() (EmbeddingProvider, error) {
 		// For providers without embedding support, fall back to Gemini
 		return NewGeminiEmbeddingProvider(&ProviderConfig{
 			Provider: "gemini",
-			APIKey:   os.Getenv("GEMINI_API_KEY"),
+			APIKey:   envAPIKey("GEMINI_API_KEY"),
 		})
 	}
 }
+
+// resolveAPIKey reads secret:// references from the secrets store. Without a
+// key it falls back to the one stored for the provider by setup or migration.
+func resolveAPIKey(apiKey, provider string) (string, error) {
+	if apiKey == "" {
+		if provider == "ollama" {
+			return "", nil
+		}
+		return config.LookupProviderKey(provider), nil
+	}
+	
+	key, err := config.ResolveSecret(apiKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s API key: %w", provider, err)
+	}
+	return key, nil
+}
+
+// envAPIKey returns an API key from the environment, resolving secret://
+// references
+func envAPIKey(name string) string {
+	key, err := config.ResolveSecret(os.Getenv(name))
+	if err != nil {
+		return ""
+	}
+	return key
+}
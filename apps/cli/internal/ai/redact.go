@@ -0,0 +1,13 @@
+package ai
+
+import "github.com/fabiobrug/mako.git/internal/safety"
+
+var promptRedactor = safety.NewValidator()
+
+// RedactPrompt strips secrets from text before it leaves the machine. Every
+// prompt sent to a provider goes through here, including terminal output,
+// recent commands, file names and error output. Tokens of unknown formats are
+// caught by their randomness, so path components and flags are left alone.
+func RedactPrompt(text string) string {
+	return promptRedactor.RedactSecretsThorough(text)
+}
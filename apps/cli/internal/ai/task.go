@@ -0,0 +1,109 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fabiobrug/mako.git/internal/prompts"
+)
+
+// taskOutputBytes caps how much of a step's output goes back to the model
+const taskOutputBytes = 1500
+
+// PlannedStep is a command the model wants to run next
+type PlannedStep struct {
+	Command string `json:"command"`
+	Reason  string `json:"reason"`
+}
+
+// TaskPlan is the model's plan for the rest of a task. Only the first step
+// is run before asking again.
+type TaskPlan struct {
+	Done    bool          `json:"done"`
+	Summary string        `json:"summary"`
+	Steps   []PlannedStep `json:"steps"`
+}
+
+// ExecutedStep is what happened to a step, sent back to the model
+type ExecutedStep struct {
+	Command  string
+	Skipped  bool // The user declined to run it
+	ExitCode int
+	Output   string
+}
+
+// buildTaskPrompt asks for the plan for the rest of goal, given the steps
+// taken so far. When they don't fit the window the oldest steps lose their
+// output first.
+func buildTaskPrompt(goal string, steps []ExecutedStep, context SystemContext, window ModelWindow) Prompt {
+	data := newPromptData(context)
+	data.Goal = goal
+	for i, step := range steps {
+		output := strings.TrimSpace(step.Output)
+		if len(output) > taskOutputBytes {
+			// Start on a whole character
+			start := len(output) - taskOutputBytes
+			for start < len(output) && !utf8.RuneStart(output[start]) {
+				start++
+			}
+			output = "..." + output[start:]
+		}
+		var lines []string
+		for _, line := range strings.Split(output, "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		data.Steps = append(data.Steps, promptStep{
+			Number:   i + 1,
+			Command:  step.Command,
+			Skipped:  step.Skipped,
+			ExitCode: step.ExitCode,
+			Output:   lines,
+		})
+	}
+
+	taskContext := SystemContext{Project: context.Project, WorkingFiles: context.WorkingFiles}
+	prompt := fitPrompt(prompts.Task, data, taskContext, nil, window, taskReplyTokens)
+	var dropped []string
+	for i := 0; i < len(data.Steps)-1 && prompt.Budget.Used > prompt.Budget.Available(); i++ {
+		if data.Steps[i].Output == nil {
+			continue
+		}
+		data.Steps[i].Output = []string{"(output left out to fit the model's context window)"}
+		dropped = append(dropped, fmt.Sprintf("output of step %d", i+1))
+		prompt = fitPrompt(prompts.Task, data, taskContext, nil, window, taskReplyTokens)
+	}
+	prompt.Budget.Trimmed = append(prompt.Budget.Trimmed, dropped...)
+	return prompt
+}
+
+// ParseTaskPlan reads the model's JSON plan, tolerating code fences and
+// text around it
+func ParseTaskPlan(reply string) (*TaskPlan, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no plan in the model's reply: %q", truncateRunes(reply, 120))
+	}
+
+	var plan TaskPlan
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &plan); err != nil {
+		return nil, fmt.Errorf("invalid plan from the model: %w", err)
+	}
+
+	steps := plan.Steps[:0]
+	for _, step := range plan.Steps {
+		step.Command = strings.TrimSpace(step.Command)
+		if step.Command != "" {
+			steps = append(steps, step)
+		}
+	}
+	plan.Steps = steps
+	if !plan.Done && len(plan.Steps) == 0 {
+		return nil, fmt.Errorf("the model's plan has no steps")
+	}
+	return &plan, nil
+}
@@ -0,0 +1,113 @@
+package ai
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultWindow is assumed for models mako doesn't know
+const defaultWindow = 8192
+
+// ollamaWindow is the window mako asks Ollama for. Ollama's own default is
+// smaller than most models allow, and a bigger one costs memory.
+const ollamaWindow = 8192
+
+// ModelWindow is how much a model takes in and roughly how its tokenizer
+// counts
+type ModelWindow struct {
+	Model         string
+	Tokens        int     // Prompt and reply together
+	CharsPerToken float64 // For ASCII text; other characters count one each
+}
+
+// modelWindows are matched by prefix against the model name, without any
+// "vendor/" of OpenRouter; the first match wins, so longer prefixes come first
+var modelWindows = []ModelWindow{
+	{"claude", 200000, 3.5},
+	{"gpt-5", 400000, 4},
+	{"gpt-4.1", 1047576, 4},
+	{"gpt-4o", 128000, 4},
+	{"gpt-4-turbo", 128000, 3.8},
+	{"gpt-4", 8192, 3.8},
+	{"gpt-3.5", 16385, 3.8},
+	{"o1", 200000, 4},
+	{"o3", 200000, 4},
+	{"o4", 200000, 4},
+	{"gemini", 1048576, 4},
+	{"deepseek", 64000, 3.6},
+	{"llama3", 131072, 3.8},
+	{"llama2", 4096, 3.5},
+	{"codellama", 16384, 3.5},
+	{"mistral", 32768, 3.5},
+	{"mixtral", 32768, 3.5},
+	{"qwen", 32768, 3.3},
+	{"gemma", 8192, 3.8},
+	{"phi3", 4096, 3.5},
+	{"phi", 2048, 3.5},
+}
+
+// WindowFor returns the window of a provider's model. A positive override,
+// from llm_context_window, replaces the table's size.
+func WindowFor(provider, model string, override int) ModelWindow {
+	name := strings.ToLower(model)
+	if _, after, ok := strings.Cut(name, "/"); ok {
+		name = after
+	}
+	window := ModelWindow{Model: model, Tokens: defaultWindow, CharsPerToken: 4}
+	for _, known := range modelWindows {
+		if strings.HasPrefix(name, known.Model) {
+			window.Tokens, window.CharsPerToken = known.Tokens, known.CharsPerToken
+			break
+		}
+	}
+	if provider == "ollama" {
+		window.Tokens = min(window.Tokens, ollamaWindow)
+	}
+	if override > 0 {
+		window.Tokens = override
+	}
+	return window
+}
+
+// Count estimates the tokens of s for the model
+func (w ModelWindow) Count(s string) int {
+	ascii, other := 0, 0
+	for i := 0; i < len(s); {
+		if s[i] < utf8.RuneSelf {
+			ascii++
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		other++
+		i += size
+	}
+	charsPerToken := w.CharsPerToken
+	if charsPerToken <= 0 {
+		charsPerToken = 4
+	}
+	return int(math.Ceil(float64(ascii)/charsPerToken)) + other
+}
+
+// TokenBudget is how a prompt's tokens were spent
+type TokenBudget struct {
+	Model   string
+	Window  int      // The model's context window
+	Reply   int      // Kept free for the reply
+	Used    int      // Estimated tokens of the prompt
+	Context int      // Of which the assembled context
+	Trimmed []string // Context sections shortened or left out to fit
+}
+
+// Available is what the prompt may take
+func (b TokenBudget) Available() int {
+	return b.Window - b.Reply
+}
+
+// String summarises the budget in one line
+func (b TokenBudget) String() string {
+	return fmt.Sprintf("~%d of %d tokens (%d context) · %s window %d, %d kept for the reply",
+		b.Used, b.Available(), b.Context, b.Model, b.Window, b.Reply)
+}
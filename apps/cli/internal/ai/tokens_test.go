@@ -0,0 +1,71 @@
+package ai
+
+import "testing"
+
+func TestWindowFor(t *testing.T) {
+	tests := []struct {
+		provider string
+		model    string
+		override int
+		tokens   int
+		chars    float64
+	}{
+		{"openai", "gpt-4o", 0, 128000, 4},
+		{"openai", "gpt-4o-mini", 0, 128000, 4},
+		{"openai", "GPT-4o", 0, 128000, 4},
+		{"openai", "gpt-4", 0, 8192, 3.8},
+		{"openai", "gpt-4-0613", 0, 8192, 3.8},
+		{"openai", "gpt-4-turbo", 0, 128000, 3.8},
+		{"openai", "gpt-4.1-mini", 0, 1047576, 4},
+		{"ollama", "phi3:mini", 0, 4096, 3.5},
+		{"ollama", "phi", 0, 2048, 3.5},
+		{"openrouter", "anthropic/claude-3.5-sonnet", 0, 200000, 3.5},
+		{"openrouter", "openai/gpt-4o", 0, 128000, 4},
+		{"openrouter", "meta-llama/llama3-70b", 0, 131072, 3.8},
+		// Ollama is asked for at most its own window
+		{"ollama", "llama3.1:8b", 0, ollamaWindow, 3.8},
+		{"ollama", "llama2", 0, 4096, 3.5},
+		{"anthropic", "some-new-model", 0, defaultWindow, 4},
+		// An override replaces the table and the Ollama cap
+		{"openai", "gpt-4", 32768, 32768, 3.8},
+		{"ollama", "llama3.1:8b", 65536, 65536, 3.8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider+"/"+tt.model, func(t *testing.T) {
+			w := WindowFor(tt.provider, tt.model, tt.override)
+			if w.Tokens != tt.tokens || w.CharsPerToken != tt.chars {
+				t.Errorf("WindowFor(%q, %q, %d) = %d tokens at %.1f chars, want %d at %.1f",
+					tt.provider, tt.model, tt.override, w.Tokens, w.CharsPerToken, tt.tokens, tt.chars)
+			}
+			if w.Model != tt.model {
+				t.Errorf("Expected the model name to be kept, got %q", w.Model)
+			}
+		})
+	}
+}
+
+func TestModelWindowCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		window ModelWindow
+		text   string
+		want   int
+	}{
+		{"empty", ModelWindow{CharsPerToken: 4}, "", 0},
+		{"exact", ModelWindow{CharsPerToken: 4}, "abcdefgh", 2},
+		{"rounds up", ModelWindow{CharsPerToken: 4}, "abcde", 2},
+		{"denser tokenizer", ModelWindow{CharsPerToken: 3.5}, "abcdefg", 2},
+		{"non-ASCII counts one each", ModelWindow{CharsPerToken: 4}, "héllo wörld", 5},
+		{"CJK", ModelWindow{CharsPerToken: 4}, "日本語", 3},
+		{"unset ratio", ModelWindow{}, "abcdefgh", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Count(tt.text); got != tt.want {
+				t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
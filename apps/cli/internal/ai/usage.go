@@ -0,0 +1,173 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/config"
+)
+
+// FeatureEmbedding is the feature of embedding calls; other calls are named
+// after their prompt template
+const FeatureEmbedding = "embedding"
+
+// budgetWarnShare is the share of the monthly budget past which mako warns
+const budgetWarnShare = 0.8
+
+// ErrBudgetExceeded is returned by NewAIProvider when the month's spend has
+// reached usage_monthly_budget and usage_budget_action is "block"
+var ErrBudgetExceeded = errors.New("monthly AI budget reached")
+
+// Usage is one finished call to a provider
+type Usage struct {
+	CalledAt     time.Time
+	Provider     string
+	Model        string
+	Feature      string
+	InputTokens  int
+	OutputTokens int
+	Estimated    bool // The response didn't report its tokens
+	Latency      time.Duration
+	Cost         float64 // Estimated USD
+	Priced       bool    // False when the model's price isn't known
+}
+
+// UsageLog stores calls and reports the spend; main sets it to the history
+// database
+type UsageLog interface {
+	RecordUsage(Usage) error
+	SpentSince(time.Time) (float64, error)
+}
+
+var usageLog UsageLog
+
+// SetUsageLog makes every provider record its calls to log
+func SetUsageLog(log UsageLog) {
+	usageLog = log
+}
+
+// modelPrice is what a model costs in USD per million tokens
+type modelPrice struct {
+	Model  string
+	Input  float64
+	Output float64
+}
+
+// modelPrices are matched like modelWindows: by prefix, without OpenRouter's
+// "vendor/", longer prefixes first. They are list prices and only estimates.
+var modelPrices = []modelPrice{
+	{"claude-opus-4", 15, 75},
+	{"claude-sonnet-4", 3, 15},
+	{"claude-haiku-4", 1, 5},
+	{"claude-3-7-sonnet", 3, 15},
+	{"claude-3-5-sonnet", 3, 15},
+	{"claude-3-5-haiku", 0.80, 4},
+	{"claude-3-opus", 15, 75},
+	{"claude-3-haiku", 0.25, 1.25},
+	{"gpt-5-nano", 0.05, 0.40},
+	{"gpt-5-mini", 0.25, 2},
+	{"gpt-5", 1.25, 10},
+	{"gpt-4.1-nano", 0.10, 0.40},
+	{"gpt-4.1-mini", 0.40, 1.60},
+	{"gpt-4.1", 2, 8},
+	{"gpt-4o-mini", 0.15, 0.60},
+	{"gpt-4o", 2.50, 10},
+	{"gpt-4-turbo", 10, 30},
+	{"gpt-4", 30, 60},
+	{"gpt-3.5-turbo", 0.50, 1.50},
+	{"o1-mini", 1.10, 4.40},
+	{"o3-mini", 1.10, 4.40},
+	{"o4-mini", 1.10, 4.40},
+	{"o1", 15, 60},
+	{"o3", 2, 8},
+	{"text-embedding-3-small", 0.02, 0},
+	{"text-embedding-3-large", 0.13, 0},
+	{"text-embedding-ada-002", 0.10, 0},
+	{"gemini-2.5-pro", 1.25, 10},
+	{"gemini-2.5-flash-lite", 0.10, 0.40},
+	{"gemini-2.5-flash", 0.30, 2.50},
+	{"gemini-2.0-flash", 0.10, 0.40},
+	{"gemini-1.5-flash", 0.075, 0.30},
+	{"gemini-embedding", 0.15, 0},
+	{"deepseek-reasoner", 0.55, 2.19},
+	{"deepseek-chat", 0.27, 1.10},
+}
+
+// EstimateCost prices a call, reporting false when the model's price isn't
+// known. Ollama runs locally and costs nothing.
+func EstimateCost(provider, model string, inputTokens, outputTokens int) (float64, bool) {
+	if provider == "ollama" {
+		return 0, true
+	}
+	name := strings.ToLower(model)
+	if _, after, ok := strings.Cut(name, "/"); ok {
+		name = after
+	}
+	for _, price := range modelPrices {
+		if strings.HasPrefix(name, price.Model) {
+			return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1e6, true
+		}
+	}
+	return 0, false
+}
+
+// recordCall logs a successful call. Tokens the response didn't report are
+// estimated from the text sent and received.
+func recordCall(provider, model, feature, sent, received string, inputTokens, outputTokens int, started time.Time) {
+	if usageLog == nil {
+		return
+	}
+	usage := Usage{
+		CalledAt:     started,
+		Provider:     provider,
+		Model:        model,
+		Feature:      feature,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		Latency:      time.Since(started),
+	}
+	if usage.InputTokens == 0 {
+		window := WindowFor(provider, model, 0)
+		usage.InputTokens, usage.Estimated = window.Count(sent), true
+		if usage.OutputTokens == 0 {
+			usage.OutputTokens = window.Count(received)
+		}
+	}
+	usage.Cost, usage.Priced = EstimateCost(provider, model, usage.InputTokens, usage.OutputTokens)
+	if err := usageLog.RecordUsage(usage); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// BudgetPeriodStart is when the monthly budget's period containing t began:
+// the start of its calendar month
+func BudgetPeriodStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// CheckBudget compares this month's spend with usage_monthly_budget. It
+// returns ErrBudgetExceeded once the budget is reached if the action is
+// "block", and otherwise a warning, empty while under 80% of it.
+func CheckBudget() (string, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil || cfg.UsageBudget <= 0 || usageLog == nil {
+		return "", nil
+	}
+	spent, err := usageLog.SpentSince(BudgetPeriodStart(time.Now()))
+	if err != nil {
+		return "", nil
+	}
+	switch {
+	case spent >= cfg.UsageBudget && cfg.UsageBudgetAction == "block":
+		return "", fmt.Errorf("%w: about $%.2f of $%.2f spent this month (see mako usage, or raise usage_monthly_budget)",
+			ErrBudgetExceeded, spent, cfg.UsageBudget)
+	case spent >= cfg.UsageBudget:
+		return fmt.Sprintf("AI spend this month is about $%.2f, over the $%.2f budget", spent, cfg.UsageBudget), nil
+	case spent >= cfg.UsageBudget*budgetWarnShare:
+		return fmt.Sprintf("AI spend this month is about $%.2f of the $%.2f budget", spent, cfg.UsageBudget), nil
+	}
+	return "", nil
+}
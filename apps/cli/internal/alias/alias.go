@@ -6,8 +6,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 )
 
 type AliasInfo struct {
@@ -42,32 +40,41 @@ func NewAliasStore() (*AliasStore, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read aliases file: %w", err)
 		}
-
-		// Try new format first
-		var newFormat struct {
-			Aliases map[string]AliasInfo `json:"aliases"`
-		}
-		if err := json.Unmarshal(data, &newFormat); err == nil && len(newFormat.Aliases) > 0 {
-			store.Aliases = newFormat.Aliases
-		} else {
-			// Try old format (backward compatibility)
-			var oldFormat struct {
-				Aliases map[string]string `json:"aliases"`
-			}
-			if err := json.Unmarshal(data, &oldFormat); err == nil {
-				// Convert old format to new
-				for name, command := range oldFormat.Aliases {
-					store.Aliases[name] = AliasInfo{Command: command, Tags: []string{}}
-				}
-			} else {
-				return nil, fmt.Errorf("failed to parse aliases file: %w", err)
-			}
+		aliases, err := parseAliasFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse aliases file: %w", err)
 		}
+		store.Aliases = aliases
 	}
 
 	return store, nil
 }
 
+// parseAliasFile reads an {"aliases": ...} file in the current format, or the
+// old one that mapped names straight to commands
+func parseAliasFile(data []byte) (map[string]AliasInfo, error) {
+	// Try new format first
+	var newFormat struct {
+		Aliases map[string]AliasInfo `json:"aliases"`
+	}
+	if err := json.Unmarshal(data, &newFormat); err == nil && len(newFormat.Aliases) > 0 {
+		return newFormat.Aliases, nil
+	}
+
+	// Try old format (backward compatibility)
+	var oldFormat struct {
+		Aliases map[string]string `json:"aliases"`
+	}
+	if err := json.Unmarshal(data, &oldFormat); err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]AliasInfo)
+	for name, command := range oldFormat.Aliases {
+		aliases[name] = AliasInfo{Command: command, Tags: []string{}}
+	}
+	return aliases, nil
+}
+
 // Save writes the aliases to disk
 func (s *AliasStore) Save() error {
 	data, err := json.MarshalIndent(s, "", "  ")
@@ -155,25 +162,6 @@ func (s *AliasStore) GetAllTags() []string {
 	return tags
 }
 
-// ExpandParameters replaces $1, $2, ... $n with actual arguments
-func ExpandParameters(command string, args []string) string {
-	result := command
-	
-	// Replace numbered parameters
-	for i, arg := range args {
-		placeholder := fmt.Sprintf("$%d", i+1)
-		result = strings.ReplaceAll(result, placeholder, arg)
-	}
-	
-	// Replace $@ with all arguments
-	result = strings.ReplaceAll(result, "$@", strings.Join(args, " "))
-	
-	// Replace $# with argument count
-	result = strings.ReplaceAll(result, "$#", strconv.Itoa(len(args)))
-	
-	return result
-}
-
 // ExportToFile exports aliases to a specified file path
 func (s *AliasStore) ExportToFile(filepath string) error {
 	data, err := json.MarshalIndent(s.Aliases, "", "  ")
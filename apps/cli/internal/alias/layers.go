@@ -0,0 +1,381 @@
+package alias
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Source names the layer an alias comes from
+type Source string
+
+const (
+	SourceProject Source = "project" // .mako/aliases.json in the project, versioned with the repo
+	SourceUser    Source = "user"    // ~/.mako/aliases.json
+	SourceTeam    Source = "team"    // A shared URL, git repository or file; read-only
+)
+
+// Sources lists the layers in precedence order: a personal alias overrides
+// a project's, which overrides the team's. A cloned repository can add
+// aliases but never replace one the user defined.
+var Sources = []Source{SourceUser, SourceProject, SourceTeam}
+
+// ProjectFile is where project aliases live, relative to the project root
+const ProjectFile = ".mako/aliases.json"
+
+// teamCacheTTL is how long a fetched team source is used before it is
+// fetched again
+const teamCacheTTL = time.Hour
+
+// LayerFile is the format of project and team alias files. SHA256 is the
+// checksum of the aliases; when present the file is refused if it doesn't
+// match, so hand edits that skip `mako alias checksum` are caught.
+type LayerFile struct {
+	Aliases map[string]AliasInfo `json:"aliases"`
+	SHA256  string               `json:"sha256,omitempty"`
+}
+
+// Layer is one source of aliases. A layer that failed to load or verify has
+// Err set and contributes no aliases.
+type Layer struct {
+	Source   Source
+	Location string
+	ReadOnly bool
+	Checksum string // SHA-256 of the aliases
+	Verified bool   // Checksum matched the file's own or a pinned one
+	Aliases  map[string]AliasInfo
+	Err      error
+}
+
+// Entry is an alias with the layer it resolved from. Shadows lists the
+// lower layers that define the same name.
+type Entry struct {
+	Name string
+	AliasInfo
+	Source  Source
+	Shadows []Source
+}
+
+// LayerOptions configures the project and team layers
+type LayerOptions struct {
+	ProjectRoot  string // Empty skips the project layer
+	TeamSource   string // URL, git repository or file; empty skips the team layer
+	TeamChecksum string // SHA-256 the team aliases must match, if pinned
+	CacheDir     string // Where fetched team sources are kept
+	Refresh      bool   // Fetch the team source even if the cached copy is fresh
+}
+
+// LayeredStore resolves aliases across the user, project and team layers.
+// Personal aliases are still edited through User.
+type LayeredStore struct {
+	User   *AliasStore
+	layers []*Layer
+}
+
+// NewLayeredStore loads the user aliases and whichever other layers are
+// configured. A project or team layer that can't be loaded doesn't fail the
+// store; its error is reported on the layer.
+func NewLayeredStore(opts LayerOptions) (*LayeredStore, error) {
+	user, err := NewAliasStore()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LayeredStore{User: user}
+	s.layers = append(s.layers, &Layer{
+		Source:   SourceUser,
+		Location: user.path,
+		Checksum: Checksum(user.Aliases),
+		Aliases:  user.Aliases,
+	})
+	// In the home directory the project file would be the user's own
+	if path := filepath.Join(opts.ProjectRoot, ProjectFile); opts.ProjectRoot != "" && path != user.path {
+		layer := &Layer{Source: SourceProject, Location: path, Aliases: make(map[string]AliasInfo)}
+		if data, err := os.ReadFile(path); err == nil {
+			loadLayer(layer, data, "")
+		} else if !errors.Is(err, os.ErrNotExist) {
+			layer.Err = err
+		}
+		s.layers = append(s.layers, layer)
+	}
+	if opts.TeamSource != "" {
+		layer := &Layer{Source: SourceTeam, Location: opts.TeamSource, ReadOnly: true}
+		data, err := fetchTeam(opts)
+		if err != nil {
+			layer.Err = err
+		} else {
+			loadLayer(layer, data, opts.TeamChecksum)
+		}
+		s.layers = append(s.layers, layer)
+	}
+	return s, nil
+}
+
+// Layers returns the loaded layers in precedence order
+func (s *LayeredStore) Layers() []*Layer {
+	return s.layers
+}
+
+// Layer returns the layer for source, or nil if it isn't configured
+func (s *LayeredStore) Layer(source Source) *Layer {
+	for _, layer := range s.layers {
+		if layer.Source == source {
+			return layer
+		}
+	}
+	return nil
+}
+
+// Resolve finds an alias in the highest layer that defines it
+func (s *LayeredStore) Resolve(name string) (Entry, bool) {
+	var entry Entry
+	found := false
+	for _, layer := range s.layers {
+		info, ok := layer.Aliases[name]
+		if !ok {
+			continue
+		}
+		if found {
+			entry.Shadows = append(entry.Shadows, layer.Source)
+			continue
+		}
+		entry = Entry{Name: name, AliasInfo: info, Source: layer.Source}
+		found = true
+	}
+	return entry, found
+}
+
+// List returns the aliases sorted by name. An empty source lists the
+// aliases that resolve, one per name; otherwise only that layer's aliases.
+func (s *LayeredStore) List(source Source) []Entry {
+	names := make(map[string]bool)
+	for _, layer := range s.layers {
+		if source != "" && layer.Source != source {
+			continue
+		}
+		for name := range layer.Aliases {
+			names[name] = true
+		}
+	}
+
+	entries := make([]Entry, 0, len(names))
+	for name := range names {
+		entry, _ := s.Resolve(name)
+		if source != "" && entry.Source != source {
+			// Shadowed by a higher layer, but still listed under its own
+			entry = Entry{Name: name, AliasInfo: s.Layer(source).Aliases[name], Source: source}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// SetProject adds or updates an alias in the project layer and rewrites the
+// file with its new checksum
+func (s *LayeredStore) SetProject(name, command string, tags []string) error {
+	if name == "" {
+		return fmt.Errorf("alias name cannot be empty")
+	}
+	if command == "" {
+		return fmt.Errorf("command cannot be empty")
+	}
+	layer, err := s.projectLayer()
+	if err != nil {
+		return err
+	}
+	layer.Aliases[name] = AliasInfo{Command: command, Tags: tags}
+	return saveLayer(layer)
+}
+
+// DeleteProject removes an alias from the project layer
+func (s *LayeredStore) DeleteProject(name string) error {
+	layer, err := s.projectLayer()
+	if err != nil {
+		return err
+	}
+	if _, ok := layer.Aliases[name]; !ok {
+		return fmt.Errorf("alias '%s' not found in the project", name)
+	}
+	delete(layer.Aliases, name)
+	return saveLayer(layer)
+}
+
+// projectLayer returns the project layer if it can be written. A layer that
+// failed its checksum is not rewritten, since that would seal the edit.
+func (s *LayeredStore) projectLayer() (*Layer, error) {
+	layer := s.Layer(SourceProject)
+	if layer == nil {
+		return nil, fmt.Errorf("not inside a project")
+	}
+	if layer.Err != nil {
+		return nil, fmt.Errorf("%s: %w", layer.Location, layer.Err)
+	}
+	return layer, nil
+}
+
+// Checksum returns the SHA-256 of a set of aliases. JSON encodes map keys in
+// order, so the same aliases always hash the same.
+func Checksum(aliases map[string]AliasInfo) string {
+	if aliases == nil {
+		aliases = map[string]AliasInfo{}
+	}
+	data, _ := json.Marshal(aliases)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SealFile writes the checksum of an alias file's aliases into it, for files
+// edited by hand, and returns the checksum to pin
+func SealFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var file LayerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return "", fmt.Errorf("invalid alias file: %w", err)
+	}
+	layer := &Layer{Location: path, Aliases: file.Aliases}
+	if layer.Aliases == nil {
+		layer.Aliases = make(map[string]AliasInfo)
+	}
+	if err := saveLayer(layer); err != nil {
+		return "", err
+	}
+	return layer.Checksum, nil
+}
+
+// loadLayer parses a layer file and checks it against its own checksum and
+// the pinned one
+func loadLayer(layer *Layer, data []byte, pinned string) {
+	var file LayerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		layer.Err = fmt.Errorf("invalid alias file: %w", err)
+		return
+	}
+	if file.Aliases == nil {
+		file.Aliases = make(map[string]AliasInfo)
+	}
+
+	sum := Checksum(file.Aliases)
+	if file.SHA256 != "" && !strings.EqualFold(file.SHA256, sum) {
+		layer.Err = fmt.Errorf("checksum mismatch: the file was changed without updating its sha256")
+		return
+	}
+	if pinned != "" && !strings.EqualFold(pinned, sum) {
+		layer.Err = fmt.Errorf("checksum %s does not match the pinned %s", sum[:12], pinned)
+		return
+	}
+	layer.Aliases = file.Aliases
+	layer.Checksum = sum
+	layer.Verified = file.SHA256 != "" || pinned != ""
+}
+
+// saveLayer writes a layer file with the checksum of its aliases
+func saveLayer(layer *Layer) error {
+	layer.Checksum = Checksum(layer.Aliases)
+	layer.Verified = true
+	data, err := json.MarshalIndent(LayerFile{Aliases: layer.Aliases, SHA256: layer.Checksum}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal aliases: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(layer.Location), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(layer.Location), err)
+	}
+	if err := os.WriteFile(layer.Location, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write aliases file: %w", err)
+	}
+	return nil
+}
+
+// fetchTeam reads the team source: a git repository is cloned and pulled, a
+// URL is downloaded, anything else is read as a file. Fetched copies are
+// cached so the team layer keeps working offline.
+func fetchTeam(opts LayerOptions) ([]byte, error) {
+	source := opts.TeamSource
+	sum := sha256.Sum256([]byte(source))
+	cache := filepath.Join(opts.CacheDir, "team-aliases-"+hex.EncodeToString(sum[:6]))
+
+	switch {
+	case isGitSource(source):
+		return fetchGit(source, cache, opts.Refresh)
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		return fetchURL(source, cache+".json", opts.Refresh)
+	default:
+		return os.ReadFile(source)
+	}
+}
+
+func isGitSource(source string) bool {
+	return strings.HasSuffix(source, ".git") || strings.HasPrefix(source, "git@") ||
+		strings.HasPrefix(source, "git://") || strings.HasPrefix(source, "ssh://")
+}
+
+// fetchURL downloads the source unless the cached copy is fresh, falling
+// back to the cached copy when the download fails
+func fetchURL(url, cache string, refresh bool) ([]byte, error) {
+	if info, err := os.Stat(cache); err == nil && !refresh && time.Since(info.ModTime()) < teamCacheTTL {
+		return os.ReadFile(cache)
+	}
+
+	data, err := download(url)
+	if err != nil {
+		if cached, cacheErr := os.ReadFile(cache); cacheErr == nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(cache), 0755); err == nil {
+		os.WriteFile(cache, data, 0644)
+	}
+	return data, nil
+}
+
+func download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch team aliases: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch team aliases: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
+
+// fetchGit keeps a shallow clone of the repository and reads aliases.json or
+// .mako/aliases.json from it. A failed pull keeps the previous checkout.
+func fetchGit(repo, dir string, refresh bool) ([]byte, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		os.RemoveAll(dir)
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return nil, err
+		}
+		if out, err := exec.Command("git", "clone", "--quiet", "--depth", "1", repo, dir).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("git clone failed: %s", strings.TrimSpace(string(out)))
+		}
+	} else if info, err := os.Stat(dir); err == nil && (refresh || time.Since(info.ModTime()) >= teamCacheTTL) {
+		exec.Command("git", "-C", dir, "pull", "--quiet", "--ff-only").Run()
+		now := time.Now()
+		os.Chtimes(dir, now, now)
+	}
+
+	for _, name := range []string{"aliases.json", ProjectFile} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("no aliases.json or %s in %s", ProjectFile, repo)
+}
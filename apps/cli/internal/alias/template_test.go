@@ -0,0 +1,135 @@
+package alias
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpandParametersQuoting(t *testing.T) {
+	args := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "tenth"}
+	tests := []struct {
+		command string
+		args    []string
+		want    string
+	}{
+		{"echo $10 $1", args, "echo tenth a"},
+		{"echo $1", []string{"x; rm -rf ~"}, "echo 'x; rm -rf ~'"},
+		{"echo '$1'", []string{"it's"}, `echo 'it'\''s'`},
+		{`echo "$1"`, []string{"$(id) `id` \"q\""}, `echo "\$(id) \` + "`id\\`" + ` \"q\""`},
+		{"ls $@", []string{"my file", "b"}, "ls 'my file' b"},
+		{"echo \\$1 $HOME", []string{"x"}, "echo \\$1 $HOME"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := ExpandParameters(tt.command, tt.args); got != tt.want {
+				t.Errorf("ExpandParameters(%q, %q) = %q, want %q", tt.command, tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParams(t *testing.T) {
+	params, err := Params("deploy {{env:prod|staging=staging}} {{tag?}} --port {{port=8080~[0-9]+}} {{env}}")
+	if err != nil {
+		t.Fatalf("Params() failed: %v", err)
+	}
+	if len(params) != 3 {
+		t.Fatalf("Params() returned %d params, want 3 (repeats count once)", len(params))
+	}
+	env, tag, port := params[0], params[1], params[2]
+	if env.Name != "env" || len(env.Choices) != 2 || env.Default != "staging" || env.Required() {
+		t.Errorf("env = %+v", env)
+	}
+	if !tag.Optional || tag.Required() {
+		t.Errorf("tag = %+v, want optional", tag)
+	}
+	if port.Pattern == nil || port.Validate("80a") == nil || port.Validate("443") != nil {
+		t.Errorf("port pattern not applied: %+v", port)
+	}
+
+	// A ~ in the default and braces in the pattern
+	params, err = Params(`cd {{dir=~/src}} && make -j{{n~[0-9]{1,2}}} {{out=a\~b~[a-z~]+}} {{kv~a=b}}`)
+	if err != nil {
+		t.Fatalf("Params() failed: %v", err)
+	}
+	if len(params) != 4 {
+		t.Fatalf("Params() returned %d params, want 4", len(params))
+	}
+	dir, n, out, kv := params[0], params[1], params[2], params[3]
+	if dir.Default != "~/src" || dir.Pattern != nil {
+		t.Errorf("dir = %+v, want the default ~/src and no pattern", dir)
+	}
+	if n.Pattern == nil || n.Validate("16") != nil || n.Validate("123") == nil {
+		t.Errorf("n pattern not applied: %+v", n)
+	}
+	if out.Default != "a~b" || out.Pattern == nil || out.Validate("x~y") != nil {
+		t.Errorf("out = %+v, want the default a~b and a pattern", out)
+	}
+	if kv.HasDefault || kv.Pattern == nil || kv.Validate("a=b") != nil {
+		t.Errorf("kv = %+v, want a pattern containing =", kv)
+	}
+
+	for _, bad := range []string{"{{}}", "{{env:a|b=c}}", "{{n~[}}", "{{n#x}}"} {
+		if _, err := Params(bad); err == nil {
+			t.Errorf("Params(%q) accepted an invalid parameter", bad)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	const deploy = "kubectl --context {{env:prod|staging}} rollout restart {{app}} {{flags?}} $@"
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		want    string
+	}{
+		{"positional", deploy, []string{"staging", "api"}, "kubectl --context staging rollout restart api  "},
+		{"named", deploy, []string{"--app", "web", "--env=prod", "-w", "--timeout=5m"}, "kubectl --context prod rollout restart web -w --timeout=5m"},
+		{"defaults", "curl localhost:{{port=8080}}/{{path?}}", nil, "curl localhost:8080/"},
+		{"quoted", "git commit -m {{msg}}", []string{"fix: it's done; rm -rf /"}, `git commit -m 'fix: it'\''s done; rm -rf /'`},
+		{"undeclared flags pass through", "grep {{pattern}} $@", []string{"TODO", "--color"}, "grep TODO --color"},
+		{"pattern with braces", "head -n {{n~[0-9]{1,3}}} log", []string{"20"}, "head -n 20 log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(tt.command, tt.args, nil)
+			if err != nil {
+				t.Fatalf("Expand() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Expand(deploy, []string{"dev", "api"}, nil); err == nil || !strings.Contains(err.Error(), "must be one of") {
+		t.Errorf("invalid choice: err = %v", err)
+	}
+	if _, err := Expand(deploy, []string{"prod"}, nil); !errors.Is(err, ErrMissingParam) {
+		t.Errorf("missing app without a prompter: err = %v, want ErrMissingParam", err)
+	}
+
+	// Missing values are prompted for and validated
+	var asked []string
+	prompt := func(p Param) (string, error) {
+		asked = append(asked, p.Name)
+		if len(p.Choices) > 0 {
+			return p.Choices[0], nil
+		}
+		return "billing svc", nil
+	}
+	got, err := Expand(deploy, nil, prompt)
+	if err != nil {
+		t.Fatalf("Expand() with prompts failed: %v", err)
+	}
+	if want := "kubectl --context prod rollout restart 'billing svc'  "; got != want {
+		t.Errorf("Expand() = %q, want %q", got, want)
+	}
+	if strings.Join(asked, ",") != "env,app" {
+		t.Errorf("prompted for %v, want env and app only", asked)
+	}
+}
@@ -0,0 +1,551 @@
+// Package backup snapshots the whole ~/.mako state (history database,
+// aliases, preferences, conversation and config) into a single archive that
+// can be restored on another machine.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/secrets"
+)
+
+// FormatVersion is the archive layout written by this build. Archives with a
+// newer version are refused rather than half restored.
+const FormatVersion = 1
+
+const (
+	manifestName = "manifest.json"
+	historyName  = "history.db"
+	configName   = "config.json"
+)
+
+// stateFiles are copied as they are, if they exist. config.json is handled
+// separately so plaintext API keys never leave the machine. Learned
+// preferences live in the history database.
+var stateFiles = []string{"aliases.json", "conversations.json"}
+
+// Kind records why a backup was taken. Only scheduled backups are rotated.
+type Kind string
+
+const (
+	KindManual     Kind = "manual"
+	KindScheduled  Kind = "scheduled"
+	KindPreRestore Kind = "pre-restore"
+)
+
+// File is one file in the archive
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest is the first entry of every archive and describes the rest
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Kind          Kind      `json:"kind"`
+	MakoVersion   string    `json:"mako_version"`
+	Hostname      string    `json:"hostname,omitempty"`
+	SchemaVersion int       `json:"schema_version,omitempty"`
+	Encryption    string    `json:"encryption,omitempty"` // History encryption mode, see database.DB.EncryptionMode
+	Files         []File    `json:"files"`
+}
+
+// Backup is an archive on disk
+type Backup struct {
+	Path     string
+	Size     int64
+	Manifest Manifest
+}
+
+// Dir is where backups are kept
+func Dir() string {
+	return filepath.Join(config.GetMakoDir(), "backups")
+}
+
+// Create writes a backup of makoDir and the open history database into dir
+func Create(db *database.DB, makoDir, dir string, kind Kind) (*Backup, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	manifest := Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now(),
+		Kind:          kind,
+		MakoVersion:   config.CurrentVersion,
+	}
+	manifest.Hostname, _ = os.Hostname()
+
+	// Collect the contents first: the manifest holds their checksums and
+	// must come first in the archive
+	type entry struct {
+		name string
+		path string // Read from disk when data is nil
+		data []byte
+	}
+	var entries []entry
+
+	if db != nil {
+		snapshot, err := os.CreateTemp(dir, ".history-*.db")
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Close()
+		defer os.Remove(snapshot.Name())
+
+		if err := db.BackupTo(snapshot.Name()); err != nil {
+			return nil, fmt.Errorf("failed to snapshot history: %w", err)
+		}
+		manifest.SchemaVersion, _ = db.SchemaVersion()
+		manifest.Encryption = db.EncryptionMode()
+		entries = append(entries, entry{name: historyName, path: snapshot.Name()})
+	}
+
+	for _, name := range stateFiles {
+		data, err := os.ReadFile(filepath.Join(makoDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{name: name, data: data})
+	}
+
+	if data, err := os.ReadFile(filepath.Join(makoDir, configName)); err == nil {
+		data, err = withoutSecrets(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", configName, err)
+		}
+		entries = append(entries, entry{name: configName, data: data})
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		file := File{Name: e.name}
+		var err error
+		if e.data != nil {
+			file.Size, file.SHA256 = int64(len(e.data)), checksum(e.data)
+		} else {
+			file.Size, file.SHA256, err = checksumFile(e.path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	path := archivePath(dir, manifest.CreatedAt, kind)
+	tmp := path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	err = writeEntry(tw, manifestName, manifest.CreatedAt, bytes.NewReader(manifestData), int64(len(manifestData)))
+	for i := 0; err == nil && i < len(entries); i++ {
+		e, file := entries[i], manifest.Files[i]
+		if e.data != nil {
+			err = writeEntry(tw, e.name, manifest.CreatedAt, bytes.NewReader(e.data), file.Size)
+			continue
+		}
+		var f *os.File
+		if f, err = os.Open(e.path); err == nil {
+			err = writeEntry(tw, e.name, manifest.CreatedAt, f, file.Size)
+			f.Close()
+		}
+	}
+	for _, closer := range []io.Closer{tw, gz, out} {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{Path: path, Size: info.Size(), Manifest: manifest}, nil
+}
+
+// List returns the backups in dir, newest first. Files that are not mako
+// backups are ignored.
+func List(dir string) ([]Backup, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "mako-*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, path := range matches {
+		manifest, err := ReadManifest(path)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: path, Size: info.Size(), Manifest: *manifest})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Manifest.CreatedAt.After(backups[j].Manifest.CreatedAt)
+	})
+	return backups, nil
+}
+
+// Find resolves a backup given as a path, a file name in dir or "latest"
+func Find(dir, name string) (string, error) {
+	if name == "latest" {
+		backups, err := List(dir)
+		if err != nil {
+			return "", err
+		}
+		if len(backups) == 0 {
+			return "", fmt.Errorf("no backups in %s", dir)
+		}
+		return backups[0].Path, nil
+	}
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+	for _, candidate := range []string{name, name + ".tar.gz"} {
+		path := filepath.Join(dir, candidate)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("backup not found: %s", name)
+}
+
+// ReadManifest reads the manifest at the start of an archive
+func ReadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a mako backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	return readManifest(tr)
+}
+
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, fmt.Errorf("not a mako backup: missing manifest")
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if manifest.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("backup format %d is newer than this mako supports (%d); please update mako", manifest.FormatVersion, FormatVersion)
+	}
+	return &manifest, nil
+}
+
+// Restore verifies every checksum in the archive, takes a pre-restore backup
+// of the current state, then replaces the history and state files. The
+// passphrase is only needed for passphrase-encrypted history.
+func Restore(db *database.DB, makoDir, dir, archive, passphrase string) (*Manifest, error) {
+	staging, err := os.MkdirTemp(dir, ".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := extract(archive, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := Create(db, makoDir, dir, KindPreRestore); err != nil {
+		return nil, fmt.Errorf("failed to back up current state: %w", err)
+	}
+
+	for _, file := range manifest.Files {
+		src := filepath.Join(staging, file.Name)
+		switch file.Name {
+		case historyName:
+			if db == nil {
+				return nil, fmt.Errorf("history database is not open")
+			}
+			if err := db.RestoreFrom(src, passphrase); err != nil {
+				return nil, fmt.Errorf("failed to restore history: %w", err)
+			}
+		case configName:
+			if err := restoreConfig(src, filepath.Join(makoDir, configName)); err != nil {
+				return nil, err
+			}
+		default:
+			data, err := os.ReadFile(src)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeAtomic(filepath.Join(makoDir, file.Name), data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// extract unpacks the archive into dir, checking each file against the
+// manifest. Nothing outside dir is touched, so a corrupt archive changes
+// nothing.
+func extract(archive, dir string) (*Manifest, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a mako backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[string]File)
+	for _, file := range manifest.Files {
+		if file.Name != filepath.Base(file.Name) || strings.HasPrefix(file.Name, ".") {
+			return nil, fmt.Errorf("invalid file name in backup: %q", file.Name)
+		}
+		expected[file.Name] = file
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("backup is corrupt: %w", err)
+		}
+		file, ok := expected[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("backup contains unexpected file %q", header.Name)
+		}
+		delete(expected, header.Name)
+
+		out, err := os.OpenFile(filepath.Join(dir, file.Name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		n, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(tr, file.Size+1))
+		out.Close()
+		if err != nil {
+			return nil, fmt.Errorf("backup is corrupt: %w", err)
+		}
+		if n != file.Size || hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s: backup is corrupt", file.Name)
+		}
+	}
+
+	for _, file := range manifest.Files {
+		if _, missing := expected[file.Name]; missing {
+			return nil, fmt.Errorf("backup is missing %s", file.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// RunScheduled takes a scheduled backup if the newest one is older than
+// interval, then removes all but the newest keep scheduled backups. It
+// returns nil when no backup was due.
+func RunScheduled(db *database.DB, makoDir, dir string, interval time.Duration, keep int) (*Backup, error) {
+	backups, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backups {
+		if b.Manifest.Kind == KindScheduled {
+			if time.Since(b.Manifest.CreatedAt) < interval {
+				return nil, nil
+			}
+			break
+		}
+	}
+
+	created, err := Create(db, makoDir, dir, KindScheduled)
+	if err != nil {
+		return nil, err
+	}
+	_, err = Rotate(dir, KindScheduled, keep)
+	return created, err
+}
+
+// Rotate deletes all but the newest keep backups of a kind and returns the
+// paths it removed
+func Rotate(dir string, kind Kind, keep int) ([]string, error) {
+	backups, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	kept := 0
+	for _, b := range backups {
+		if b.Manifest.Kind != kind {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if err := os.Remove(b.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.Path)
+	}
+	return removed, nil
+}
+
+// withoutSecrets drops plaintext API and sync keys from config.json. secret://
+// references are kept: they name an entry in the secrets store, which is
+// not part of the backup.
+func withoutSecrets(data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"api_key", "sync_key"} {
+		var value string
+		if raw, ok := fields[name]; ok {
+			json.Unmarshal(raw, &value)
+			if !secrets.IsRef(value) {
+				delete(fields, name)
+			}
+		}
+	}
+	return json.MarshalIndent(fields, "", "  ")
+}
+
+// restoreConfig writes the backed up config, keeping the API key configured
+// on this machine since the backup has none
+func restoreConfig(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	var restored map[string]json.RawMessage
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return fmt.Errorf("invalid %s in backup: %w", configName, err)
+	}
+
+	if current, err := os.ReadFile(dst); err == nil {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(current, &fields) == nil {
+			if key, ok := fields["api_key"]; ok {
+				if _, ok := restored["api_key"]; !ok {
+					restored["api_key"] = key
+				}
+			}
+		}
+	}
+
+	data, err = json.MarshalIndent(restored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(dst, data)
+}
+
+// writeAtomic replaces path with data. Restored files are only readable by
+// the user: conversations.json holds chat and terminal output.
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// archivePath names a new archive after its time and kind, without
+// replacing an existing one taken in the same second
+func archivePath(dir string, t time.Time, kind Kind) string {
+	base := fmt.Sprintf("mako-%s-%s", t.Format("20060102-150405"), kind)
+	path := filepath.Join(dir, base+".tar.gz")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d.tar.gz", base, i))
+	}
+}
+
+func writeEntry(tw *tar.Writer, name string, modTime time.Time, r io.Reader, size int64) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func checksumFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
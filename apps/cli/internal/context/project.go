@@ -279,11 +279,20 @@ func FindProjectRoot() string {
 	if err != nil {
 		return ""
 	}
+	if root := ProjectRootOf(dir); root != "" {
+		return root
+	}
+
+	// Return current directory if no project root found
+	return dir
+}
 
+// ProjectRootOf returns the nearest directory at or above dir holding a
+// project marker, or "" when dir isn't inside a project
+func ProjectRootOf(dir string) string {
 	projectMarkers := []string{
 		"go.mod", "package.json", "Cargo.toml", "requirements.txt",
 		"pom.xml", "build.gradle", "Gemfile", "composer.json",
-		".git",
 	}
 
 	for {
@@ -292,16 +301,16 @@ func FindProjectRoot() string {
 				return dir
 			}
 		}
+		// .git is a directory, or a file in worktrees and submodules
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
 
 		parent := filepath.Dir(dir)
 		if parent == dir {
 			// Reached root directory
-			break
+			return ""
 		}
 		dir = parent
 	}
-
-	// Return current directory if no project root found
-	currentDir, _ := os.Getwd()
-	return currentDir
 }
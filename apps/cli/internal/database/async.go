@@ -143,6 +143,7 @@ func (w *EmbeddingWorker) processCommand(cmdID int64) {
 		w.incrementFailed()
 		return
 	}
+	command = w.db.openText(command)
 
 	// Generate embedding with retries
 	var embedding []byte
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"modernc.org/sqlite"
+)
+
+// backuper is implemented by the driver's connections
+type backuper interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// BackupTo copies the database to path with SQLite's online backup API, which
+// gives a consistent snapshot that includes changes still in the WAL
+func (db *DB) BackupTo(path string) error {
+	return db.runBackup(func(c backuper) (*sqlite.Backup, error) {
+		return c.NewBackup(path)
+	})
+}
+
+// RestoreFrom replaces the contents of the database with the backup at path,
+// while it stays open. The backup is migrated to the current schema and must
+// be readable with the key file next to this database or the passphrase;
+// otherwise nothing is changed.
+func (db *DB) RestoreFrom(path, passphrase string) error {
+	src, err := openDB(path, db.dir, passphrase)
+	if err != nil {
+		return err
+	}
+	cipher := src.cipher
+	if err := src.Close(); err != nil {
+		return err
+	}
+
+	err = db.runBackup(func(c backuper) (*sqlite.Backup, error) {
+		return c.NewRestore(path)
+	})
+	if err != nil {
+		return err
+	}
+	db.cipher = cipher
+	return nil
+}
+
+// runBackup copies every page in one step on a dedicated connection
+func (db *DB) runBackup(start func(backuper) (*sqlite.Backup, error)) error {
+	conn, err := db.conn.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(backuper)
+		if !ok {
+			return fmt.Errorf("sqlite driver does not support online backup")
+		}
+		b, err := start(c)
+		if err != nil {
+			return fmt.Errorf("failed to start backup: %w", err)
+		}
+		for more := true; more; {
+			if more, err = b.Step(-1); err != nil {
+				b.Finish()
+				return fmt.Errorf("backup failed: %w", err)
+			}
+		}
+		return b.Finish()
+	})
+}
+
+// EncryptionMode returns how command text is encrypted: "keyfile",
+// "passphrase" or empty when it is stored as plaintext
+func (db *DB) EncryptionMode() string {
+	mode, _ := db.getMetadata("encryption")
+	return mode
+}
@@ -8,13 +8,18 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math"
+	"os"
+	"path/filepath"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
 type DB struct {
-	conn *sql.DB
+	conn   *sql.DB
+	path   string
+	dir    string       // Directory holding the database (and key file)
+	cipher *fieldCipher // Set when command text is encrypted at rest
 }
 
 type Command struct {
@@ -29,15 +34,31 @@ type Command struct {
 	CommandHash     string
 	LastUsed        time.Time
 	EmbeddingStatus string // "pending", "processing", "completed", "failed"
+	Hostname        string // Set for commands imported from other machines
+	Session         string // Shell session ID from the importing tool
+	OriginDevice    string // Sync device the command came from; empty when recorded here
 }
 
+// NewDB opens the history database. Passphrase-encrypted databases read the
+// passphrase from MAKO_DB_PASSPHRASE.
 func NewDB(dbPath string) (*DB, error) {
+	return NewDBWithPassphrase(dbPath, os.Getenv(PassphraseEnv))
+}
+
+// NewDBWithPassphrase opens the history database, using passphrase to unlock
+// it if it is passphrase-encrypted
+func NewDBWithPassphrase(dbPath, passphrase string) (*DB, error) {
+	return openDB(dbPath, filepath.Dir(dbPath), passphrase)
+}
+
+// openDB opens a database whose key file, if any, is in dir
+func openDB(dbPath, dir, passphrase string) (*DB, error) {
 	conn, err := sql.Open("sqlite", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	db := &DB{conn: conn}
+	db := &DB{conn: conn, path: dbPath, dir: dir}
 
 	// Enable WAL mode for better concurrent access
 	_, err = conn.Exec("PRAGMA journal_mode=WAL")
@@ -46,134 +67,22 @@ func NewDB(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
 
-	if err := db.initTables(); err != nil {
+	if err := db.migrate(); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	return db, nil
-}
-
-func (db *DB) initTables() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS commands (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		command TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		exit_code INTEGER DEFAULT 0,
-		duration_ms INTEGER DEFAULT 0,
-		working_dir TEXT,
-		output_preview TEXT,
-		embedding BLOB,
-		command_hash TEXT,
-		last_used DATETIME,
-		embedding_status TEXT DEFAULT 'pending'
-	);
-
-	CREATE VIRTUAL TABLE IF NOT EXISTS commands_fts USING fts5(
-		command,
-		output_preview,
-		content='commands',
-		content_rowid='id'
-	);
-	
-	CREATE TRIGGER IF NOT EXISTS commands_ai AFTER INSERT ON commands BEGIN
-		INSERT INTO commands_fts(rowid, command, output_preview)
-		VALUES (new.id, new.command, new.output_preview);
-	END;
-	
-	CREATE TRIGGER IF NOT EXISTS commands_ad AFTER DELETE ON commands BEGIN
-		DELETE FROM commands_fts WHERE rowid = old.id;
-	END;
-	
-	CREATE TRIGGER IF NOT EXISTS commands_au AFTER UPDATE ON commands BEGIN
-		UPDATE commands_fts 
-		SET command = new.command, output_preview = new.output_preview
-		WHERE rowid = new.id;
-	END;
-	
-	CREATE INDEX IF NOT EXISTS idx_timestamp ON commands(timestamp DESC);
-	CREATE INDEX IF NOT EXISTS idx_working_dir ON commands(working_dir);
-	CREATE INDEX IF NOT EXISTS idx_has_embedding ON commands(embedding) WHERE embedding IS NOT NULL;
-
-	-- Embedding cache table
-	CREATE TABLE IF NOT EXISTS embedding_cache (
-		command_text TEXT PRIMARY KEY,
-		embedding BLOB NOT NULL,
-		hit_count INTEGER DEFAULT 0,
-		last_accessed DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	-- Sync metadata table
-	CREATE TABLE IF NOT EXISTS sync_metadata (
-		key TEXT PRIMARY KEY,
-		value TEXT NOT NULL,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	if _, err := db.conn.Exec(schema); err != nil {
-		return err
-	}
-
-	// Run migrations for existing databases
-	return db.runMigrations()
-}
-
-func (db *DB) runMigrations() error {
-	// Check if command_hash column exists
-	var columnExists bool
-	err := db.conn.QueryRow(`
-		SELECT COUNT(*) > 0 
-		FROM pragma_table_info('commands') 
-		WHERE name='command_hash'
-	`).Scan(&columnExists)
-	
-	if err != nil {
-		return fmt.Errorf("failed to check schema: %w", err)
-	}
-
-	if !columnExists {
-		migrations := []string{
-			"ALTER TABLE commands ADD COLUMN command_hash TEXT",
-			"ALTER TABLE commands ADD COLUMN last_used DATETIME",
-			"ALTER TABLE commands ADD COLUMN embedding_status TEXT DEFAULT 'completed'",
-		}
-
-		for _, migration := range migrations {
-			if _, err := db.conn.Exec(migration); err != nil {
-				// Ignore errors for columns that might already exist
-				continue
-			}
-		}
-
-		// Update embedding_status for existing rows
-		_, _ = db.conn.Exec(`
-			UPDATE commands 
-			SET embedding_status = CASE 
-				WHEN embedding IS NOT NULL THEN 'completed'
-				ELSE 'pending'
-			END
-			WHERE embedding_status IS NULL
-		`)
-	}
-
-	// Create indexes after ensuring columns exist (safe to run multiple times)
-	indexCreations := []string{
-		"CREATE INDEX IF NOT EXISTS idx_embedding_status ON commands(embedding_status)",
-		// Note: Don't create unique index on command_hash for existing databases
-		// as it may have NULL values. New databases get it from schema above.
-	}
-
-	for _, indexSQL := range indexCreations {
-		_, _ = db.conn.Exec(indexSQL)
+	if err := db.loadEncryption(passphrase); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	return nil
+	return db, nil
 }
 
 func (db *DB) SaveCommand(cmd Command) error {
-	hash := hashCommand(cmd.Command)
+	cmd = redactCommand(cmd)
+	hash := db.commandHash(cmd.Command)
 	
 	query := `
 		INSERT INTO commands (command, timestamp, exit_code, duration_ms, working_dir, output_preview, embedding, command_hash, embedding_status)
@@ -187,12 +96,12 @@ func (db *DB) SaveCommand(cmd Command) error {
 
 	_, err := db.conn.Exec(
 		query,
-		cmd.Command,
+		db.sealText(cmd.Command),
 		cmd.Timestamp,
 		cmd.ExitCode,
 		cmd.Duration,
 		cmd.WorkingDir,
-		cmd.OutputPreview,
+		db.sealText(cmd.OutputPreview),
 		cmd.Embedding,
 		hash,
 		embeddingStatus,
@@ -202,6 +111,10 @@ func (db *DB) SaveCommand(cmd Command) error {
 }
 
 func (db *DB) SearchCommands(query string, limit int) ([]Command, error) {
+	if db.cipher != nil {
+		return db.searchEncrypted(query, limit)
+	}
+
 	sqlQuery := `
 		SELECT c.id, c.command, c.timestamp, c.exit_code, c.duration_ms, c.working_dir, c.output_preview,
 		       c.embedding, COALESCE(c.embedding_status, 'pending') as embedding_status
@@ -238,7 +151,7 @@ func (db *DB) SearchCommands(query string, limit int) ([]Command, error) {
 		commands = append(commands, cmd)
 	}
 
-	return commands, nil
+	return db.openCommands(commands), nil
 }
 
 func (db *DB) GetRecentCommands(limit int) ([]Command, error) {
@@ -276,7 +189,7 @@ func (db *DB) GetRecentCommands(limit int) ([]Command, error) {
 		commands = append(commands, cmd)
 	}
 
-	return commands, nil
+	return db.openCommands(commands), nil
 }
 
 // GetCommandsByExitCode returns commands filtered by success/failure
@@ -328,7 +241,7 @@ func (db *DB) GetCommandsByExitCode(successful bool, limit int) ([]Command, erro
 		commands = append(commands, cmd)
 	}
 
-	return commands, nil
+	return db.openCommands(commands), nil
 }
 
 func (db *DB) GetCommandsByDirectory(dir string, limit int) ([]Command, error) {
@@ -364,7 +277,7 @@ func (db *DB) GetCommandsByDirectory(dir string, limit int) ([]Command, error) {
 		commands = append(commands, cmd)
 	}
 
-	return commands, nil
+	return db.openCommands(commands), nil
 }
 
 func (db *DB) GetStats() (map[string]interface{}, error) {
@@ -507,7 +420,7 @@ func (db *DB) SearchCommandsSemantic(query string, queryEmbedding []byte, limit
 	}
 
 	// Phase 2: Rank by vector similarity
-	for _, cmd := range ftsResults {
+	for _, cmd := range db.openCommands(ftsResults) {
 		similarity := calculateSimilarity(queryEmbedding, cmd.Embedding)
 
 		if similarity >= threshold {
@@ -570,23 +483,27 @@ func calculateSimilarity(a, b []byte) float32 {
 
 // SaveCommandAsync saves a command without blocking on embedding generation
 func (db *DB) SaveCommandAsync(cmd Command) (int64, error) {
-	hash := hashCommand(cmd.Command)
+	cmd = redactCommand(cmd)
+	hash := db.commandHash(cmd.Command)
 	
 	query := `
-		INSERT INTO commands (command, timestamp, exit_code, duration_ms, working_dir, output_preview, command_hash, last_used, embedding_status)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'pending')
+		INSERT INTO commands (command, timestamp, exit_code, duration_ms, working_dir, output_preview, command_hash, last_used, embedding_status, hostname, session, origin_device)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?, ?)
 	`
 
 	result, err := db.conn.Exec(
 		query,
-		cmd.Command,
+		db.sealText(cmd.Command),
 		cmd.Timestamp,
 		cmd.ExitCode,
 		cmd.Duration,
 		cmd.WorkingDir,
-		cmd.OutputPreview,
+		db.sealText(cmd.OutputPreview),
 		hash,
 		cmd.Timestamp,
+		cmd.Hostname,
+		cmd.Session,
+		originDevice(cmd),
 	)
 
 	if err != nil {
@@ -600,7 +517,8 @@ func (db *DB) SaveCommandAsync(cmd Command) (int64, error) {
 // If command exists, updates last_used timestamp
 // Returns (isNew, commandID, error)
 func (db *DB) SaveCommandDeduplicated(cmd Command) (bool, int64, error) {
-	hash := hashCommand(cmd.Command)
+	cmd = redactCommand(cmd)
+	hash := db.commandHash(cmd.Command)
 	
 	// Check if command exists
 	var existingID int64
@@ -655,6 +573,8 @@ func (db *DB) GetCommandByHash(hash string) (*Command, error) {
 		cmd.EmbeddingStatus = embeddingStatus.String
 	}
 
+	cmd.Command = db.openText(cmd.Command)
+	cmd.OutputPreview = db.openText(cmd.OutputPreview)
 	return &cmd, nil
 }
 
@@ -716,7 +636,7 @@ func (db *DB) GetPendingEmbeddings(limit int) ([]Command, error) {
 		commands = append(commands, cmd)
 	}
 
-	return commands, nil
+	return db.openCommands(commands), nil
 }
 
 // BulkInsertCommands inserts multiple commands in a transaction
@@ -737,14 +657,15 @@ func (db *DB) BulkInsertCommands(cmds []Command) error {
 	defer stmt.Close()
 
 	for _, cmd := range cmds {
-		hash := hashCommand(cmd.Command)
+		cmd = redactCommand(cmd)
+		hash := db.commandHash(cmd.Command)
 		_, err = stmt.Exec(
-			cmd.Command,
+			db.sealText(cmd.Command),
 			cmd.Timestamp,
 			cmd.ExitCode,
 			cmd.Duration,
 			cmd.WorkingDir,
-			cmd.OutputPreview,
+			db.sealText(cmd.OutputPreview),
 			hash,
 			cmd.Timestamp,
 		)
@@ -756,6 +677,75 @@ func (db *DB) BulkInsertCommands(cmds []Command) error {
 	return tx.Commit()
 }
 
+// ImportCommands stores commands read from another history tool, keeping
+// every run along with its metadata. A run already stored (same command at
+// the same time) is skipped, so importing the same file twice is harmless.
+func (db *DB) ImportCommands(cmds []Command) (imported, skipped int, err error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	exists, err := tx.Prepare(`SELECT COUNT(*) FROM commands WHERE command_hash = ? AND timestamp = ?`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer exists.Close()
+
+	insert, err := tx.Prepare(`
+		INSERT INTO commands (command, timestamp, exit_code, duration_ms, working_dir, output_preview, command_hash, last_used, embedding_status, hostname, session)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?)
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer insert.Close()
+
+	for _, cmd := range cmds {
+		cmd = redactCommand(cmd)
+		hash := db.commandHash(cmd.Command)
+
+		var count int
+		if err := exists.QueryRow(hash, cmd.Timestamp).Scan(&count); err != nil {
+			return 0, 0, err
+		}
+		if count > 0 {
+			skipped++
+			continue
+		}
+
+		_, err = insert.Exec(
+			db.sealText(cmd.Command),
+			cmd.Timestamp,
+			cmd.ExitCode,
+			cmd.Duration,
+			cmd.WorkingDir,
+			db.sealText(cmd.OutputPreview),
+			hash,
+			cmd.Timestamp,
+			cmd.Hostname,
+			cmd.Session,
+		)
+		if err != nil {
+			return 0, 0, err
+		}
+		imported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return imported, skipped, nil
+}
+
+// DeleteCommand removes every stored copy of a command
+func (db *DB) DeleteCommand(command string) error {
+	hash := db.commandHash(redactor.RedactStoredSecrets(command))
+	_, err := db.conn.Exec("DELETE FROM commands WHERE command_hash = ?", hash)
+	return err
+}
+
 // GetLastSyncTime retrieves the last sync timestamp
 func (db *DB) GetLastSyncTime() (time.Time, error) {
 	var timeStr string
@@ -0,0 +1,464 @@
+package database
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	encryptedPrefix  = "enc:v1:"
+	keyFileName      = "history.key"
+	pbkdf2Iterations = 600000
+	encryptionCheck  = "mako-history"
+
+	// PassphraseEnv can hold the passphrase for passphrase-encrypted databases
+	PassphraseEnv = "MAKO_DB_PASSPHRASE"
+)
+
+var (
+	// ErrPassphraseRequired is returned when opening a passphrase-encrypted
+	// database without a passphrase
+	ErrPassphraseRequired = errors.New("history database is encrypted with a passphrase")
+
+	// ErrWrongKey is returned when the key or passphrase does not match
+	ErrWrongKey = errors.New("wrong key or passphrase for history database")
+)
+
+// fieldCipher encrypts the command and output_preview columns. Hashes used
+// for deduplication become HMACs so identical commands can still be found
+// without revealing them.
+type fieldCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+func newFieldCipher(key []byte) (*fieldCipher, error) {
+	encKey := deriveSubkey(key, "mako-history-encryption")
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldCipher{aead: aead, macKey: deriveSubkey(key, "mako-history-hash")}, nil
+}
+
+func deriveSubkey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (c *fieldCipher) seal(text string) string {
+	if text == "" {
+		return ""
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(text), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func (c *fieldCipher) open(text string) (string, error) {
+	if !strings.HasPrefix(text, encryptedPrefix) {
+		return text, nil // Plaintext row
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < c.aead.NonceSize() {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func (c *fieldCipher) hash(text string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(text))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted reports whether command text is encrypted at rest
+func (db *DB) IsEncrypted() bool {
+	return db.cipher != nil
+}
+
+// commandHash returns the deduplication hash for a command
+func (db *DB) commandHash(command string) string {
+	if db.cipher != nil {
+		return db.cipher.hash(command)
+	}
+	return hashCommand(command)
+}
+
+// sealText encrypts text for storage when encryption is enabled
+func (db *DB) sealText(text string) string {
+	if db.cipher == nil {
+		return text
+	}
+	return db.cipher.seal(text)
+}
+
+// openText decrypts a stored value. Undecryptable values are masked rather
+// than failing the whole query.
+func (db *DB) openText(text string) string {
+	if db.cipher == nil {
+		return text
+	}
+	plain, err := db.cipher.open(text)
+	if err != nil {
+		return "[undecryptable]"
+	}
+	return plain
+}
+
+// openCommands decrypts the text fields of commands read from the database
+func (db *DB) openCommands(commands []Command) []Command {
+	if db.cipher == nil {
+		return commands
+	}
+	for i := range commands {
+		commands[i].Command = db.openText(commands[i].Command)
+		commands[i].OutputPreview = db.openText(commands[i].OutputPreview)
+	}
+	return commands
+}
+
+// loadEncryption sets up the cipher if the database is encrypted
+func (db *DB) loadEncryption(passphrase string) error {
+	mode, err := db.getMetadata("encryption")
+	if err != nil || mode == "" {
+		return err
+	}
+
+	var key []byte
+	switch mode {
+	case "keyfile":
+		key, err = readKeyFile(db.keyPath())
+		if err != nil {
+			return fmt.Errorf("history database is encrypted but the key could not be read from %s: %w", db.keyPath(), err)
+		}
+	case "passphrase":
+		if passphrase == "" {
+			return ErrPassphraseRequired
+		}
+		saltHex, err := db.getMetadata("encryption_salt")
+		if err != nil {
+			return err
+		}
+		salt, err := hex.DecodeString(saltHex)
+		if err != nil {
+			return fmt.Errorf("invalid encryption salt: %w", err)
+		}
+		key, err = pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, 32)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported encryption mode: %s", mode)
+	}
+
+	c, err := newFieldCipher(key)
+	if err != nil {
+		return err
+	}
+
+	check, err := db.getMetadata("encryption_check")
+	if err != nil {
+		return err
+	}
+	if plain, err := c.open(check); err != nil || plain != encryptionCheck {
+		return ErrWrongKey
+	}
+
+	db.cipher = c
+	return nil
+}
+
+// EnableEncryption encrypts every stored command and output preview. With an
+// empty passphrase a random key is written to history.key next to the
+// database; otherwise the key is derived from the passphrase and nothing is
+// stored on disk. A key file only protects copies of the database made
+// without it (backups, synced folders): whoever can read the database can
+// usually read the key too. Pre-migration backups, which are plaintext, are deleted.
+func (db *DB) EnableEncryption(passphrase string) (int, error) {
+	if db.cipher != nil {
+		return 0, fmt.Errorf("history database is already encrypted")
+	}
+
+	mode := "keyfile"
+	var key, salt []byte
+	var err error
+	if passphrase != "" {
+		mode = "passphrase"
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return 0, err
+		}
+		key, err = pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, 32)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return 0, err
+		}
+		if err := writeKeyFile(db.keyPath(), key); err != nil {
+			return 0, fmt.Errorf("failed to write key file: %w", err)
+		}
+	}
+
+	c, err := newFieldCipher(key)
+	if err != nil {
+		return 0, err
+	}
+
+	metadata := map[string]string{
+		"encryption":       mode,
+		"encryption_check": c.seal(encryptionCheck),
+	}
+	if salt != nil {
+		metadata["encryption_salt"] = hex.EncodeToString(salt)
+	}
+
+	count, err := db.rewriteCommands(c, metadata, nil)
+	if err != nil {
+		if mode == "keyfile" {
+			os.Remove(db.keyPath())
+		}
+		return 0, err
+	}
+
+	db.cipher = c
+	if _, err := db.RemoveMigrationBackups(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// DisableEncryption decrypts the database back to plaintext
+func (db *DB) DisableEncryption() (int, error) {
+	if db.cipher == nil {
+		return 0, fmt.Errorf("history database is not encrypted")
+	}
+
+	count, err := db.rewriteCommands(nil, nil, []string{"encryption", "encryption_check", "encryption_salt"})
+	if err != nil {
+		return 0, err
+	}
+
+	db.cipher = nil
+	os.Remove(db.keyPath())
+	return count, nil
+}
+
+// rewriteCommands re-stores every command under the target cipher (nil for
+// plaintext) in a single transaction, then removes the old text from disk
+func (db *DB) rewriteCommands(target *fieldCipher, setMetadata map[string]string, deleteMetadata []string) (int, error) {
+	ctx := context.Background()
+
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA secure_delete = ON"); err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA secure_delete = OFF")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	type row struct {
+		id            int64
+		command       string
+		outputPreview string
+	}
+	var stored []row
+
+	rows, err := tx.Query(`SELECT id, command, COALESCE(output_preview, '') FROM commands`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.command, &r.outputPreview); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stored = append(stored, r)
+	}
+	rows.Close()
+
+	dst := &DB{cipher: target}
+	for _, r := range stored {
+		command := db.openText(r.command)
+		output := db.openText(r.outputPreview)
+		_, err := tx.Exec(`UPDATE commands SET command = ?, output_preview = ?, command_hash = ? WHERE id = ?`,
+			dst.sealText(command), dst.sealText(output), dst.commandHash(command), r.id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rewrite command %d: %w", r.id, err)
+		}
+	}
+
+	if err := db.rewriteTasks(tx, dst); err != nil {
+		return 0, err
+	}
+	if err := db.rewritePreferences(tx, dst); err != nil {
+		return 0, err
+	}
+
+	// Cache keys are plaintext command text
+	if _, err := tx.Exec(`DELETE FROM embedding_cache`); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO commands_fts(commands_fts) VALUES('rebuild')`); err != nil {
+		return 0, fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+
+	for key, value := range setMetadata {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO sync_metadata (key, value, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, key, value); err != nil {
+			return 0, err
+		}
+	}
+	for _, key := range deleteMetadata {
+		if _, err := tx.Exec(`DELETE FROM sync_metadata WHERE key = ?`, key); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// Best effort: secure_delete already zeroed the old cells, VACUUM also
+	// drops them from free pages and the WAL
+	conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+	conn.ExecContext(ctx, "VACUUM")
+	conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+
+	return len(stored), nil
+}
+
+// searchEncrypted is the SearchCommands fallback for encrypted databases,
+// where the FTS index only contains ciphertext
+func (db *DB) searchEncrypted(query string, limit int) ([]Command, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, command, timestamp, exit_code, duration_ms, working_dir, output_preview,
+		       embedding, COALESCE(embedding_status, 'pending') as embedding_status
+		FROM commands
+		ORDER BY timestamp DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := strings.Fields(strings.ToLower(query))
+
+	var commands []Command
+	for rows.Next() && len(commands) < limit {
+		var cmd Command
+		var output sql.NullString
+		err := rows.Scan(
+			&cmd.ID,
+			&cmd.Command,
+			&cmd.Timestamp,
+			&cmd.ExitCode,
+			&cmd.Duration,
+			&cmd.WorkingDir,
+			&output,
+			&cmd.Embedding,
+			&cmd.EmbeddingStatus,
+		)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Command = db.openText(cmd.Command)
+		cmd.OutputPreview = db.openText(output.String)
+
+		haystack := strings.ToLower(cmd.Command + "\n" + cmd.OutputPreview)
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(haystack, strings.Trim(term, `"*`)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			commands = append(commands, cmd)
+		}
+	}
+
+	return commands, nil
+}
+
+func (db *DB) keyPath() string {
+	return filepath.Join(db.dir, keyFileName)
+}
+
+func (db *DB) getMetadata(key string) (string, error) {
+	var value string
+	err := db.conn.QueryRow(`SELECT value FROM sync_metadata WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (db *DB) setMetadata(key, value string) error {
+	_, err := db.conn.Exec(`
+		INSERT OR REPLACE INTO sync_metadata (key, value, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+	`, key, value)
+	return err
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid key file")
+	}
+	return key, nil
+}
+
+func writeKeyFile(path string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
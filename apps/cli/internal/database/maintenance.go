@@ -0,0 +1,223 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TableSize is the space one table and its indexes take on disk
+type TableSize struct {
+	Name  string
+	Rows  int64
+	Bytes int64
+}
+
+// StorageStats describes what the database file is made of
+type StorageStats struct {
+	FileBytes      int64 // Database file plus write-ahead log
+	FreeBytes      int64 // Unused pages VACUUM would return
+	Tables         []TableSize
+	Commands       int64
+	Embeddings     int64
+	EmbeddingBytes int64
+	Oldest         time.Time
+}
+
+// StorageStats measures each table with the dbstat virtual table
+func (db *DB) StorageStats() (*StorageStats, error) {
+	stats := &StorageStats{FileBytes: fileSize(db.path) + fileSize(db.path+"-wal")}
+
+	rows, err := db.conn.Query(`
+		SELECT COALESCE(m.tbl_name, s.name), SUM(s.pgsize)
+		FROM dbstat s
+		LEFT JOIN sqlite_master m ON m.name = s.name
+		GROUP BY 1
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure tables: %w", err)
+	}
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var bytes int64
+		if err := rows.Scan(&name, &bytes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// FTS5 keeps its index in shadow tables named after the virtual table
+		if strings.HasPrefix(name, "commands_fts_") {
+			name = "commands_fts"
+		}
+		sizes[name] += bytes
+	}
+	rows.Close()
+
+	for name, bytes := range sizes {
+		table := TableSize{Name: name, Bytes: bytes}
+		if db.hasTable(name) && !strings.HasPrefix(name, "sqlite_") {
+			db.conn.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, name)).Scan(&table.Rows)
+		}
+		stats.Tables = append(stats.Tables, table)
+	}
+	sort.Slice(stats.Tables, func(i, j int) bool {
+		return stats.Tables[i].Bytes > stats.Tables[j].Bytes
+	})
+
+	var freePages, pageSize int64
+	db.conn.QueryRow(`PRAGMA freelist_count`).Scan(&freePages)
+	db.conn.QueryRow(`PRAGMA page_size`).Scan(&pageSize)
+	stats.FreeBytes = freePages * pageSize
+
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*), COUNT(embedding), COALESCE(SUM(LENGTH(embedding)), 0)
+		FROM commands
+	`).Scan(&stats.Commands, &stats.Embeddings, &stats.EmbeddingBytes)
+	if err != nil {
+		return nil, err
+	}
+	db.conn.QueryRow(`SELECT timestamp FROM commands ORDER BY timestamp ASC LIMIT 1`).Scan(&stats.Oldest)
+
+	return stats, nil
+}
+
+// Vacuum rebuilds the database file without free pages and returns the
+// number of bytes reclaimed
+func (db *DB) Vacuum() (int64, error) {
+	before := fileSize(db.path) + fileSize(db.path+"-wal")
+	if _, err := db.conn.Exec(`VACUUM`); err != nil {
+		return 0, fmt.Errorf("vacuum failed: %w", err)
+	}
+	db.conn.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	after := fileSize(db.path) + fileSize(db.path+"-wal")
+	return before - after, nil
+}
+
+// Optimize merges the full-text index segments and refreshes the query
+// planner's statistics
+func (db *DB) Optimize() error {
+	statements := []string{
+		`INSERT INTO commands_fts(commands_fts) VALUES('optimize')`,
+		`ANALYZE`,
+		`PRAGMA optimize`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.conn.Exec(stmt); err != nil {
+			return fmt.Errorf("optimize failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// PruneOptions selects what to remove. A command is pruned if it is older
+// than Before or beyond the newest KeepNewest, unless it is protected.
+type PruneOptions struct {
+	Before               time.Time // Prune commands run before this time
+	KeepNewest           int       // Prune all but the newest N commands; 0 keeps all
+	KeepSuccessfulUnique bool      // Never prune the latest successful run of a command
+	EmbeddingsOnly       bool      // Drop embeddings but keep the commands
+	DryRun               bool      // Only count what would be pruned
+}
+
+// PruneResult counts what was (or would be) removed
+type PruneResult struct {
+	Commands   int64
+	Embeddings int64
+}
+
+// Prune removes old history, or just its embeddings, which are most of the
+// file. Pruned embeddings are marked so the worker doesn't regenerate them.
+func (db *DB) Prune(opts PruneOptions) (*PruneResult, error) {
+	var criteria []string
+	var args []interface{}
+	if !opts.Before.IsZero() {
+		criteria = append(criteria, "timestamp < ?")
+		args = append(args, opts.Before)
+	}
+	if opts.KeepNewest > 0 {
+		criteria = append(criteria, "id NOT IN (SELECT id FROM commands ORDER BY timestamp DESC, id DESC LIMIT ?)")
+		args = append(args, opts.KeepNewest)
+	}
+	if len(criteria) == 0 {
+		return nil, fmt.Errorf("nothing to prune: give an age or a number of commands to keep")
+	}
+
+	where := "(" + strings.Join(criteria, " OR ") + ")"
+	if opts.KeepSuccessfulUnique {
+		where += ` AND id NOT IN (
+			SELECT MAX(id) FROM commands WHERE exit_code = 0
+			GROUP BY COALESCE(command_hash, command)
+		)`
+	}
+
+	result := &PruneResult{}
+	err := db.conn.QueryRow(`SELECT COUNT(*), COUNT(embedding) FROM commands WHERE `+where, args...).
+		Scan(&result.Commands, &result.Embeddings)
+	if err != nil {
+		return nil, err
+	}
+	if opts.EmbeddingsOnly {
+		result.Commands = 0
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	var query string
+	if opts.EmbeddingsOnly {
+		query = `UPDATE commands SET embedding = NULL, embedding_status = 'pruned' WHERE embedding IS NOT NULL AND ` + where
+	} else {
+		query = `DELETE FROM commands WHERE ` + where
+	}
+	if _, err := db.conn.Exec(query, args...); err != nil {
+		return nil, fmt.Errorf("prune failed: %w", err)
+	}
+	return result, nil
+}
+
+// RetentionPolicy is the automatic pruning configured by the user. Zero
+// values disable each rule.
+type RetentionPolicy struct {
+	MaxCommands     int           // Keep at most this many commands
+	MaxAge          time.Duration // Delete commands older than this
+	EmbeddingMaxAge time.Duration // Drop embeddings older than this
+}
+
+// ApplyRetention prunes according to the policy. It runs at most once per
+// interval, remembering the last run in the metadata table, and reports
+// whether it ran.
+func (db *DB) ApplyRetention(policy RetentionPolicy, interval time.Duration) (bool, error) {
+	if last, _ := db.getMetadata("last_retention"); last != "" {
+		if t, err := time.Parse(time.RFC3339, last); err == nil && time.Since(t) < interval {
+			return false, nil
+		}
+	}
+
+	if policy.MaxCommands > 0 || policy.MaxAge > 0 {
+		opts := PruneOptions{KeepNewest: policy.MaxCommands}
+		if policy.MaxAge > 0 {
+			opts.Before = time.Now().Add(-policy.MaxAge)
+		}
+		if _, err := db.Prune(opts); err != nil {
+			return true, err
+		}
+	}
+	if policy.EmbeddingMaxAge > 0 {
+		opts := PruneOptions{Before: time.Now().Add(-policy.EmbeddingMaxAge), EmbeddingsOnly: true}
+		if _, err := db.Prune(opts); err != nil {
+			return true, err
+		}
+	}
+
+	return true, db.setMetadata("last_retention", time.Now().Format(time.RFC3339))
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
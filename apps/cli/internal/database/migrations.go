@@ -0,0 +1,414 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migration is one step in the history of the schema. Migrations run in
+// order, each in its own transaction, and are never changed once released:
+// schema changes are made by appending a new one.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations is the ordered registry. New and upgraded databases both run
+// every step, so they always end up with the same schema.
+var migrations = []migration{
+	{1, "Create command history, search, cache and sync tables", migrateBaseSchema},
+	{2, "Track command hash, last use and embedding status", migrateDeduplication},
+	{3, "Replace full-text search triggers that corrupted the index", migrateFTSTriggers},
+	{4, "Record hostname and session of imported commands", migrateImportMetadata},
+	{5, "Index command hashes", migrateHashIndex},
+	{6, "Record the device synced commands came from", migrateSyncOrigin},
+	{7, "Store mako do task transcripts", migrateTasks},
+	{8, "Store command preferences learned from the history", migratePreferences},
+	{9, "Record the tokens and cost of AI calls", migrateUsage},
+}
+
+// MigrationInfo describes a migration and whether it has been applied
+type MigrationInfo struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// LatestSchemaVersion is the schema version this build of mako creates
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Migrate applies any pending migrations. NewDB already does this; it is
+// exposed for `mako db migrate`.
+func (db *DB) Migrate() error {
+	return db.migrate()
+}
+
+// migrate brings the schema up to date. Databases that already hold history
+// are copied to a backup file first, so a failed upgrade loses nothing.
+func (db *DB) migrate() error {
+	if _, err := db.conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("history database is at schema version %d, newer than this mako supports (%d); please update mako", current, LatestSchemaVersion())
+	}
+	if current == LatestSchemaVersion() {
+		return nil
+	}
+
+	if db.hasTable("commands") {
+		if _, err := db.backupBeforeMigration(current); err != nil {
+			return fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := db.apply(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// apply runs one migration and records it in the same transaction
+func (db *DB) apply(m migration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, description) VALUES (?, ?)`, m.version, m.description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the highest migration applied to the database
+func (db *DB) SchemaVersion() (int, error) {
+	var version int
+	err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func (db *DB) MigrationStatus() ([]MigrationInfo, error) {
+	rows, err := db.conn.Query(`SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationInfo, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.version]
+		status = append(status, MigrationInfo{
+			Version:     m.version,
+			Description: m.description,
+			Applied:     ok,
+			AppliedAt:   appliedAt,
+		})
+	}
+	return status, nil
+}
+
+// MigrationBackups lists the copies taken before migrations, newest first
+func (db *DB) MigrationBackups() []string {
+	matches, _ := filepath.Glob(filepath.Join(db.dir, filepath.Base(db.path)+".pre-v*.bak"))
+	sort.Slice(matches, func(i, j int) bool {
+		a, errA := os.Stat(matches[i])
+		b, errB := os.Stat(matches[j])
+		return errA == nil && errB == nil && a.ModTime().After(b.ModTime())
+	})
+	return matches
+}
+
+// RemoveMigrationBackups deletes the copies taken before migrations. They
+// hold history as it was then, so they go once secrets are scrubbed or the
+// history is encrypted.
+func (db *DB) RemoveMigrationBackups() (int, error) {
+	removed := 0
+	for _, path := range db.MigrationBackups() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// backupBeforeMigration copies the database next to itself, named after the
+// schema version it was at. Only the latest copy is kept.
+func (db *DB) backupBeforeMigration(version int) (string, error) {
+	if db.path == "" || db.path == ":memory:" {
+		return "", nil
+	}
+
+	backupPath := fmt.Sprintf("%s.pre-v%d.bak", db.path, version)
+	os.Remove(backupPath) // VACUUM INTO refuses to overwrite
+
+	if _, err := db.conn.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return "", err
+	}
+	os.Chmod(backupPath, 0600)
+
+	for _, old := range db.MigrationBackups() {
+		if old != backupPath {
+			os.Remove(old)
+		}
+	}
+	return backupPath, nil
+}
+
+// hasTable reports whether a table exists
+func (db *DB) hasTable(name string) bool {
+	var count int
+	db.conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count)
+	return count > 0
+}
+
+// addColumn adds a column unless an earlier, unversioned mako already did
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check schema: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBaseSchema creates the schema as it was before versioning. Every
+// statement tolerates existing objects, since databases from before
+// versioning start at version 0.
+func migrateBaseSchema(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS commands (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			command TEXT NOT NULL,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			exit_code INTEGER DEFAULT 0,
+			duration_ms INTEGER DEFAULT 0,
+			working_dir TEXT,
+			output_preview TEXT,
+			embedding BLOB
+		)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS commands_fts USING fts5(
+			command,
+			output_preview,
+			content='commands',
+			content_rowid='id'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS commands_ai AFTER INSERT ON commands BEGIN
+			INSERT INTO commands_fts(rowid, command, output_preview)
+			VALUES (new.id, new.command, new.output_preview);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS commands_ad AFTER DELETE ON commands BEGIN
+			INSERT INTO commands_fts(commands_fts, rowid, command, output_preview)
+			VALUES ('delete', old.id, old.command, old.output_preview);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS commands_au AFTER UPDATE OF command, output_preview ON commands BEGIN
+			INSERT INTO commands_fts(commands_fts, rowid, command, output_preview)
+			VALUES ('delete', old.id, old.command, old.output_preview);
+			INSERT INTO commands_fts(rowid, command, output_preview)
+			VALUES (new.id, new.command, new.output_preview);
+		END`,
+		`CREATE INDEX IF NOT EXISTS idx_timestamp ON commands(timestamp DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_working_dir ON commands(working_dir)`,
+		`CREATE INDEX IF NOT EXISTS idx_has_embedding ON commands(embedding) WHERE embedding IS NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS embedding_cache (
+			command_text TEXT PRIMARY KEY,
+			embedding BLOB NOT NULL,
+			hit_count INTEGER DEFAULT 0,
+			last_accessed DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_metadata (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+	)
+}
+
+func migrateDeduplication(tx *sql.Tx) error {
+	columns := [][2]string{
+		{"command_hash", "TEXT"},
+		{"last_used", "DATETIME"},
+		{"embedding_status", "TEXT DEFAULT 'pending'"},
+	}
+	for _, column := range columns {
+		if err := addColumn(tx, "commands", column[0], column[1]); err != nil {
+			return err
+		}
+	}
+
+	return execAll(tx,
+		`UPDATE commands
+		SET embedding_status = CASE
+			WHEN embedding IS NOT NULL THEN 'completed'
+			ELSE 'pending'
+		END
+		WHERE embedding_status IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_embedding_status ON commands(embedding_status)`,
+	)
+}
+
+// migrateFTSTriggers replaces the original FTS triggers, which updated the
+// external content index in place. FTS5 then removed the new tokens instead
+// of the old ones, leaving stale entries and eventually a corrupt index.
+func migrateFTSTriggers(tx *sql.Tx) error {
+	var triggerSQL string
+	err := tx.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'trigger' AND name = 'commands_au'`).Scan(&triggerSQL)
+	if err != nil || !strings.Contains(triggerSQL, "UPDATE commands_fts") {
+		return nil
+	}
+
+	return execAll(tx,
+		"DROP TRIGGER IF EXISTS commands_ad",
+		"DROP TRIGGER IF EXISTS commands_au",
+		`CREATE TRIGGER commands_ad AFTER DELETE ON commands BEGIN
+			INSERT INTO commands_fts(commands_fts, rowid, command, output_preview)
+			VALUES ('delete', old.id, old.command, old.output_preview);
+		END`,
+		`CREATE TRIGGER commands_au AFTER UPDATE OF command, output_preview ON commands BEGIN
+			INSERT INTO commands_fts(commands_fts, rowid, command, output_preview)
+			VALUES ('delete', old.id, old.command, old.output_preview);
+			INSERT INTO commands_fts(rowid, command, output_preview)
+			VALUES (new.id, new.command, new.output_preview);
+		END`,
+		"INSERT INTO commands_fts(commands_fts) VALUES('rebuild')",
+	)
+}
+
+func migrateImportMetadata(tx *sql.Tx) error {
+	for _, column := range []string{"hostname", "session"} {
+		if err := addColumn(tx, "commands", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateHashIndex speeds up duplicate lookups. The index is not unique:
+// every run of a command is kept, and older rows may have no hash at all.
+func migrateHashIndex(tx *sql.Tx) error {
+	return execAll(tx, `CREATE INDEX IF NOT EXISTS idx_command_hash ON commands(command_hash)`)
+}
+
+// migrateSyncOrigin marks commands pulled from a sync server with the device
+// that recorded them, so they are never pushed back. Local commands keep NULL.
+func migrateSyncOrigin(tx *sql.Tx) error {
+	return addColumn(tx, "commands", "origin_device", "TEXT")
+}
+
+// migrateTasks stores the transcripts of `mako do` runs. The goal and the
+// transcript are encrypted along with command text.
+func migrateTasks(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS tasks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			goal TEXT NOT NULL,
+			status TEXT NOT NULL,
+			working_dir TEXT,
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME,
+			step_count INTEGER DEFAULT 0,
+			transcript TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_started ON tasks(started_at)`,
+	)
+}
+
+func migratePreferences(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS preferences (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project TEXT NOT NULL DEFAULT '',
+			base_command TEXT NOT NULL,
+			flags TEXT NOT NULL,
+			weight REAL NOT NULL,
+			uses INTEGER NOT NULL,
+			last_used DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_preferences_project ON preferences(project)`,
+		`CREATE TABLE IF NOT EXISTS preference_resets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			base_command TEXT NOT NULL,
+			reset_at DATETIME NOT NULL
+		)`,
+	)
+}
+
+func migrateUsage(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			called_at DATETIME NOT NULL,
+			provider TEXT NOT NULL,
+			model TEXT NOT NULL,
+			feature TEXT NOT NULL,
+			input_tokens INTEGER NOT NULL,
+			output_tokens INTEGER NOT NULL,
+			estimated INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER NOT NULL,
+			cost REAL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_called ON usage(called_at)`,
+	)
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	projectcontext "github.com/fabiobrug/mako.git/internal/context"
+)
+
+const (
+	// preferenceHalfLife is how long it takes a use to count half as much
+	preferenceHalfLife = 30 * 24 * time.Hour
+	// preferenceWindow is how far back the history is read; older uses
+	// weigh next to nothing
+	preferenceWindow = 6 * preferenceHalfLife
+	// minPreferenceWeight drops flags that haven't been used lately: the
+	// weight of a single use a month ago, or of four used three months ago
+	minPreferenceWeight = 0.5
+)
+
+// subcommandTools have their preferences learned per subcommand, so
+// "git log --oneline" doesn't count towards "git commit"
+var subcommandTools = map[string]bool{
+	"git": true, "docker": true, "kubectl": true, "npm": true, "cargo": true,
+	"go": true, "systemctl": true, "brew": true, "apt": true, "pip": true,
+}
+
+// Preference is a flag combination learned from the history
+type Preference struct {
+	Project     string  // Project root, or "" for every directory
+	BaseCommand string  // "ls", "git log"
+	Flags       string  // "-lah"
+	Weight      float64 // Uses, each discounted by its age
+	Uses        int
+	LastUsed    time.Time
+}
+
+// LearnPreferences relearns the preferences from the history. It runs at
+// most once per interval, remembering the last run in the metadata table,
+// and reports whether it ran.
+func (db *DB) LearnPreferences(interval time.Duration) (bool, error) {
+	if last, _ := db.getMetadata("last_preferences"); last != "" {
+		if t, err := time.Parse(time.RFC3339, last); err == nil && time.Since(t) < interval {
+			return false, nil
+		}
+	}
+	if err := db.relearnPreferences(time.Now()); err != nil {
+		return true, err
+	}
+	return true, db.setMetadata("last_preferences", time.Now().Format(time.RFC3339))
+}
+
+// relearnPreferences replaces the stored preferences with ones computed
+// from every successful command in the window. Each use is weighed by its
+// age, and counted both across all directories and for the project it was
+// run in.
+func (db *DB) relearnPreferences(now time.Time) error {
+	resets, err := db.preferenceResets()
+	if err != nil {
+		return err
+	}
+
+	type key struct{ project, base, flags string }
+	learned := map[key]*Preference{}
+	roots := map[string]string{}
+
+	err = db.EachCommand(CommandQuery{SuccessOnly: true, From: now.Add(-preferenceWindow)}, func(cmd Command) error {
+		base, flags, ok := preferenceKey(cmd.Command)
+		if !ok || forgotten(resets, base, cmd.Timestamp) {
+			return nil
+		}
+
+		root, seen := roots[cmd.WorkingDir]
+		if !seen && cmd.WorkingDir != "" {
+			root = projectcontext.ProjectRootOf(cmd.WorkingDir)
+			roots[cmd.WorkingDir] = root
+		}
+		age := max(now.Sub(cmd.Timestamp), 0)
+		weight := math.Pow(0.5, float64(age)/float64(preferenceHalfLife))
+
+		projects := []string{""}
+		if root != "" {
+			projects = append(projects, root)
+		}
+		for _, project := range projects {
+			k := key{project, base, flags}
+			pref := learned[k]
+			if pref == nil {
+				pref = &Preference{Project: project, BaseCommand: base, Flags: flags}
+				learned[k] = pref
+			}
+			pref.Weight += weight
+			pref.Uses++
+			if cmd.Timestamp.After(pref.LastUsed) {
+				pref.LastUsed = cmd.Timestamp
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM preferences`); err != nil {
+		return err
+	}
+	for _, pref := range learned {
+		if pref.Weight < minPreferenceWeight {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO preferences (project, base_command, flags, weight, uses, last_used)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, pref.Project, db.sealText(pref.BaseCommand), db.sealText(pref.Flags), pref.Weight, pref.Uses, pref.LastUsed)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Preferences returns the learned preferences that apply in a project: the
+// ones learned across all directories and, when project isn't empty, the
+// ones learned in it. They are sorted by weight, heaviest first.
+func (db *DB) Preferences(project string) ([]Preference, error) {
+	rows, err := db.conn.Query(`
+		SELECT project, base_command, flags, weight, uses, last_used
+		FROM preferences
+		WHERE project = '' OR project = ?
+	`, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prefs []Preference
+	for rows.Next() {
+		var pref Preference
+		if err := rows.Scan(&pref.Project, &pref.BaseCommand, &pref.Flags, &pref.Weight, &pref.Uses, &pref.LastUsed); err != nil {
+			return nil, err
+		}
+		pref.BaseCommand = db.openText(pref.BaseCommand)
+		pref.Flags = db.openText(pref.Flags)
+		prefs = append(prefs, pref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].Weight > prefs[j].Weight })
+	return prefs, nil
+}
+
+// ForgetPreference deletes what was learned about a command, including
+// its subcommands when given just the program, and keeps the history from
+// before now out of future learning. It returns how many preferences were
+// deleted.
+func (db *DB) ForgetPreference(base string) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, base_command FROM preferences`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if matchesBase(db.openText(stored), base) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := tx.Exec(`DELETE FROM preferences WHERE id = ?`, id); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO preference_resets (base_command, reset_at) VALUES (?, ?)`, db.sealText(base), time.Now()); err != nil {
+		return 0, err
+	}
+	return len(ids), tx.Commit()
+}
+
+// ResetPreferences deletes everything learned, so learning starts over
+// from the commands run after now
+func (db *DB) ResetPreferences() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM preferences`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM preference_resets`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO preference_resets (base_command, reset_at) VALUES (?, ?)`, db.sealText("*"), time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// preferenceResets maps each forgotten command, or "*" after a reset, to
+// when it was forgotten
+func (db *DB) preferenceResets() (map[string]time.Time, error) {
+	rows, err := db.conn.Query(`SELECT base_command, reset_at FROM preference_resets`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resets := map[string]time.Time{}
+	for rows.Next() {
+		var base string
+		var at time.Time
+		if err := rows.Scan(&base, &at); err != nil {
+			return nil, err
+		}
+		base = db.openText(base)
+		if at.After(resets[base]) {
+			resets[base] = at
+		}
+	}
+	return resets, rows.Err()
+}
+
+// forgotten reports whether a use of base at t was made before it, or
+// everything, was forgotten
+func forgotten(resets map[string]time.Time, base string, t time.Time) bool {
+	for forgottenBase, at := range resets {
+		if (forgottenBase == "*" || matchesBase(base, forgottenBase)) && !t.After(at) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesBase reports whether base is the forgotten command or one of its
+// subcommands
+func matchesBase(base, forgottenBase string) bool {
+	return base == forgottenBase || strings.HasPrefix(base, forgottenBase+" ")
+}
+
+// preferenceKey splits a command into the command it runs, with the
+// subcommand for tools that have them, and the flags it was given. Only
+// the first command of a pipeline or list counts, and commands without
+// flags teach nothing.
+func preferenceKey(command string) (string, string, bool) {
+	fields := strings.Fields(command)
+	for len(fields) > 0 && (fields[0] == "sudo" || strings.Contains(fields[0], "=")) {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || fields[0] == "mako" || strings.HasPrefix(fields[0], "-") || strings.ContainsAny(fields[0], "/$`'\"();|&") {
+		return "", "", false
+	}
+
+	base := fields[0]
+	rest := fields[1:]
+	if subcommandTools[base] && len(rest) > 0 && !strings.HasPrefix(rest[0], "-") && isSubcommand(rest[0]) {
+		base += " " + rest[0]
+		rest = rest[1:]
+	}
+
+	var flags []string
+	for _, field := range rest {
+		if field == "|" || field == "||" || field == "&&" || field == ";" || field == "--" {
+			break
+		}
+		last := strings.HasSuffix(field, ";")
+		field = strings.TrimSuffix(field, ";")
+		// Values can hold anything; quoted or expanded ones are left out
+		if strings.HasPrefix(field, "-") && field != "-" && !strings.ContainsAny(field, "$`'\"") {
+			flags = append(flags, field)
+		}
+		if last {
+			break
+		}
+	}
+	if len(flags) == 0 {
+		return "", "", false
+	}
+	return base, strings.Join(flags, " "), true
+}
+
+func isSubcommand(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r == '-') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// rewritePreferences re-stores the forgotten commands under the target
+// cipher. The preferences themselves are dropped and relearned on the
+// next run.
+func (db *DB) rewritePreferences(tx *sql.Tx, dst *DB) error {
+	if _, err := tx.Exec(`DELETE FROM preferences`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sync_metadata WHERE key = 'last_preferences'`); err != nil {
+		return err
+	}
+
+	type row struct {
+		id   int64
+		base string
+	}
+	var stored []row
+	rows, err := tx.Query(`SELECT id, base_command FROM preference_resets`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.base); err != nil {
+			rows.Close()
+			return err
+		}
+		stored = append(stored, r)
+	}
+	rows.Close()
+
+	for _, r := range stored {
+		if _, err := tx.Exec(`UPDATE preference_resets SET base_command = ? WHERE id = ?`, dst.sealText(db.openText(r.base)), r.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// CommandQuery selects commands. Every set field narrows the result, so
+// filters combine freely in a single SQL query.
+type CommandQuery struct {
+	WorkingDir  string    // Only commands run in this directory
+	SuccessOnly bool      // Only commands that exited 0
+	FailedOnly  bool      // Only commands that exited non-zero
+	From        time.Time // Only commands at or after this time
+	To          time.Time // Only commands at or before this time
+	IDs         []int64   // Only these commands; nil means any, empty means none
+	AfterID     int64     // Only commands stored after this ID
+	MaxID       int64     // Only commands up to this ID; 0 means no bound
+	LocalOnly   bool      // Skip commands synced from other devices
+	Limit       int       // The most recent N matches; 0 means all
+	OldestFirst bool      // Return matches in the order they were run
+}
+
+// build returns the SQL and arguments for the query
+func (q CommandQuery) build() (string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	if q.WorkingDir != "" {
+		where = append(where, "working_dir = ?")
+		args = append(args, q.WorkingDir)
+	}
+	if q.SuccessOnly {
+		where = append(where, "exit_code = 0")
+	}
+	if q.FailedOnly {
+		where = append(where, "exit_code != 0")
+	}
+	if !q.From.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		where = append(where, "timestamp <= ?")
+		args = append(args, q.To)
+	}
+	if q.AfterID > 0 {
+		where = append(where, "id > ?")
+		args = append(args, q.AfterID)
+	}
+	if q.MaxID > 0 {
+		where = append(where, "id <= ?")
+		args = append(args, q.MaxID)
+	}
+	if q.LocalOnly {
+		where = append(where, "origin_device IS NULL")
+	}
+	if q.IDs != nil {
+		if len(q.IDs) == 0 {
+			where = append(where, "0")
+		} else {
+			where = append(where, "id IN (?"+strings.Repeat(", ?", len(q.IDs)-1)+")")
+			for _, id := range q.IDs {
+				args = append(args, id)
+			}
+		}
+	}
+
+	query := `
+		SELECT id, command, timestamp, exit_code, duration_ms, working_dir, output_preview,
+		       COALESCE(hostname, ''), COALESCE(session, '')
+		FROM commands`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+
+	// The limit always keeps the most recent matches, then the outer query
+	// puts them back in the order they were run if asked
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1 // No limit
+	}
+	query += "\n\t\tORDER BY timestamp DESC, id DESC\n\t\tLIMIT ?"
+	args = append(args, limit)
+
+	if q.OldestFirst {
+		query = "SELECT * FROM (" + query + "\n\t) ORDER BY timestamp ASC, id ASC"
+	}
+	return query, args
+}
+
+// EachCommand calls fn for every command matching q, reading rows one at a
+// time so large histories aren't held in memory
+func (db *DB) EachCommand(q CommandQuery, fn func(Command) error) error {
+	query, args := q.build()
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cmd Command
+		err := rows.Scan(
+			&cmd.ID,
+			&cmd.Command,
+			&cmd.Timestamp,
+			&cmd.ExitCode,
+			&cmd.Duration,
+			&cmd.WorkingDir,
+			&cmd.OutputPreview,
+			&cmd.Hostname,
+			&cmd.Session,
+		)
+		if err != nil {
+			return err
+		}
+		cmd.Command = db.openText(cmd.Command)
+		cmd.OutputPreview = db.openText(cmd.OutputPreview)
+		if err := fn(cmd); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// QueryCommands returns every command matching q
+func (db *DB) QueryCommands(q CommandQuery) ([]Command, error) {
+	var commands []Command
+	err := db.EachCommand(q, func(cmd Command) error {
+		commands = append(commands, cmd)
+		return nil
+	})
+	return commands, err
+}
+
+// LastCommandID returns the highest command ID, 0 for an empty history
+func (db *DB) LastCommandID() (int64, error) {
+	var id int64
+	err := db.conn.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM commands`).Scan(&id)
+	return id, err
+}
+
+// CountCommands returns how many commands match q
+func (db *DB) CountCommands(q CommandQuery) (int, error) {
+	query, args := q.build()
+	var n int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM ("+query+"\n\t)", args...).Scan(&n)
+	return n, err
+}
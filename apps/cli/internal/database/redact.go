@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fabiobrug/mako.git/internal/safety"
+)
+
+var redactor = safety.NewValidator()
+
+// ScrubResult summarizes a retroactive redaction pass
+type ScrubResult struct {
+	CommandsScanned  int
+	CommandsRedacted int
+	CacheEntries     int // Embedding cache entries removed
+	BackupsRemoved   int // Pre-migration copies, which still hold the secrets
+}
+
+// RedactSecrets returns text with tokens and keys masked the way history is
+// stored. Every insert path applies it, callers only need it for text they
+// send elsewhere (e.g. to an embedding provider) before saving. Only
+// unmistakable secrets are masked, so ordinary commands keep their flags.
+func RedactSecrets(text string) string {
+	return redactor.RedactStoredSecrets(text)
+}
+
+// redactCommand masks secrets in the stored text fields of cmd. An embedding
+// computed from the unredacted text is dropped so it gets regenerated.
+func redactCommand(cmd Command) Command {
+	command := redactor.RedactStoredSecrets(cmd.Command)
+	if command != cmd.Command {
+		cmd.Command = command
+		cmd.Embedding = nil
+		cmd.EmbeddingStatus = "pending"
+	}
+	cmd.OutputPreview = redactor.RedactStoredSecrets(cmd.OutputPreview)
+	return cmd
+}
+
+// ScrubSecrets redacts secrets in rows saved before redaction was applied on
+// insert. Changed commands lose their embedding (it was generated from the
+// secret), the FTS index is rebuilt and matching embedding cache entries are
+// removed. The database is vacuumed so the old text does not linger on disk,
+// and pre-migration backups are deleted for the same reason.
+func (db *DB) ScrubSecrets() (*ScrubResult, error) {
+	result := &ScrubResult{}
+	ctx := context.Background()
+
+	// Pragmas are per connection, so keep the whole scrub on one
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA secure_delete = ON"); err != nil {
+		return nil, fmt.Errorf("failed to enable secure delete: %w", err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA secure_delete = OFF")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	type scrubbed struct {
+		id             int64
+		command        string
+		outputPreview  string
+		commandChanged bool
+	}
+	var updates []scrubbed
+
+	rows, err := tx.Query(`SELECT id, command, COALESCE(output_preview, '') FROM commands`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var command, outputPreview string
+		if err := rows.Scan(&id, &command, &outputPreview); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result.CommandsScanned++
+
+		command, outputPreview = db.openText(command), db.openText(outputPreview)
+		safeCommand := redactor.RedactStoredSecrets(command)
+		safeOutput := redactor.RedactStoredSecrets(outputPreview)
+		if safeCommand != command || safeOutput != outputPreview {
+			updates = append(updates, scrubbed{id, safeCommand, safeOutput, safeCommand != command})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, u := range updates {
+		if u.commandChanged {
+			_, err = tx.Exec(`
+				UPDATE commands
+				SET command = ?, output_preview = ?, command_hash = ?, embedding = NULL, embedding_status = 'pending'
+				WHERE id = ?
+			`, db.sealText(u.command), db.sealText(u.outputPreview), db.commandHash(u.command), u.id)
+		} else {
+			_, err = tx.Exec(`UPDATE commands SET output_preview = ? WHERE id = ?`, db.sealText(u.outputPreview), u.id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to redact command %d: %w", u.id, err)
+		}
+		result.CommandsRedacted++
+	}
+
+	// The update trigger cannot remove old tokens from an external content
+	// FTS index, so rebuild it from the scrubbed table
+	if _, err := tx.Exec(`INSERT INTO commands_fts(commands_fts) VALUES('rebuild')`); err != nil {
+		return nil, fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+
+	var cacheKeys []string
+	cacheRows, err := tx.Query(`SELECT command_text FROM embedding_cache`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding cache: %w", err)
+	}
+	for cacheRows.Next() {
+		var key string
+		if err := cacheRows.Scan(&key); err != nil {
+			cacheRows.Close()
+			return nil, err
+		}
+		if redactor.RedactStoredSecrets(key) != key {
+			cacheKeys = append(cacheKeys, key)
+		}
+	}
+	cacheRows.Close()
+
+	for _, key := range cacheKeys {
+		if _, err := tx.Exec(`DELETE FROM embedding_cache WHERE command_text = ?`, key); err != nil {
+			return nil, fmt.Errorf("failed to clear embedding cache: %w", err)
+		}
+		result.CacheEntries++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Make sure the old text is gone from the WAL and free pages
+	if result.CommandsRedacted > 0 || result.CacheEntries > 0 {
+		conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+		if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+			return result, fmt.Errorf("redacted rows but failed to vacuum: %w", err)
+		}
+		conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+	}
+
+	result.BackupsRemoved, err = db.RemoveMigrationBackups()
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,439 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata keys for remote sync. Cursors are saved after every batch, so an
+// interrupted sync resumes where it stopped.
+const (
+	syncDeviceKey     = "sync_device_id"
+	syncServerKey     = "sync_server"
+	syncPushCursorKey = "sync_push_cursor" // Last local command ID pushed
+	syncPullCursorKey = "sync_pull_cursor" // Last server sequence pulled
+	syncLastRemoteKey = "sync_last_remote"
+
+	syncBatchSize = 500
+)
+
+// syncRecord is the plaintext of an uploaded command. Embeddings are not
+// synced; each device computes its own.
+type syncRecord struct {
+	Command       string    `json:"command"`
+	Timestamp     time.Time `json:"timestamp"`
+	ExitCode      int       `json:"exit_code"`
+	DurationMS    int64     `json:"duration_ms"`
+	WorkingDir    string    `json:"working_dir,omitempty"`
+	OutputPreview string    `json:"output_preview,omitempty"`
+	Hostname      string    `json:"hostname,omitempty"`
+	Session       string    `json:"session,omitempty"`
+}
+
+// wireRecord matches the records exchanged with mako-sync
+type wireRecord struct {
+	Seq      int64  `json:"seq,omitempty"`
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id,omitempty"`
+	Data     string `json:"data"`
+}
+
+// SyncResult counts what one sync moved
+type SyncResult struct {
+	Pushed     int // Records the server did not have yet
+	Pulled     int // Commands from other devices added here
+	Duplicates int // Records both sides already had
+}
+
+// SyncStatus describes the sync state of this device
+type SyncStatus struct {
+	DeviceID   string
+	Server     string
+	Pending    int // Local commands not pushed yet
+	PullCursor int64
+	LastSync   time.Time
+}
+
+// SyncClient syncs history with a mako-sync server. Records are encrypted
+// with a key derived from the shared sync key before they leave the device,
+// and the server account is a token derived from the same key, so devices
+// sharing the key share a history that the server cannot read.
+type SyncClient struct {
+	db       *DB
+	server   string
+	token    string
+	cipher   *fieldCipher
+	deviceID string
+	http     *http.Client
+}
+
+// GenerateSyncKey returns a new random sync key to share between devices
+func GenerateSyncKey() string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(key)
+}
+
+// NewSyncClient prepares syncing with server. Cursors belong to a server, so
+// they are reset when the server changes.
+func NewSyncClient(db *DB, server, syncKey string) (*SyncClient, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(syncKey))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid sync key: expected 64 hex characters")
+	}
+	server = strings.TrimRight(server, "/")
+	if u, err := url.Parse(server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid sync server URL: %s", server)
+	}
+
+	c, err := newFieldCipher(deriveSubkey(key, "mako-sync-records"))
+	if err != nil {
+		return nil, err
+	}
+	deviceID, err := db.DeviceID()
+	if err != nil {
+		return nil, err
+	}
+
+	if previous, _ := db.getMetadata(syncServerKey); previous != server {
+		for _, k := range []string{syncPushCursorKey, syncPullCursorKey, syncLastRemoteKey} {
+			if err := db.setMetadata(k, ""); err != nil {
+				return nil, err
+			}
+		}
+		if err := db.setMetadata(syncServerKey, server); err != nil {
+			return nil, err
+		}
+	}
+
+	return &SyncClient{
+		db:       db,
+		server:   server,
+		token:    hex.EncodeToString(deriveSubkey(key, "mako-sync-auth")),
+		cipher:   c,
+		deviceID: deviceID,
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// DeviceID returns this database's sync device ID, creating it on first use
+func (db *DB) DeviceID() (string, error) {
+	if id, err := db.getMetadata(syncDeviceKey); err != nil || id != "" {
+		return id, err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	return id, db.setMetadata(syncDeviceKey, id)
+}
+
+// RemoteSyncStatus reports the device ID, cursors and pending commands
+func (db *DB) RemoteSyncStatus() (*SyncStatus, error) {
+	deviceID, err := db.DeviceID()
+	if err != nil {
+		return nil, err
+	}
+	status := &SyncStatus{DeviceID: deviceID}
+	status.Server, _ = db.getMetadata(syncServerKey)
+	status.PullCursor = db.cursor(syncPullCursorKey)
+	if last, _ := db.getMetadata(syncLastRemoteKey); last != "" {
+		status.LastSync, _ = time.Parse(time.RFC3339, last)
+	}
+	err = db.conn.QueryRow(`SELECT COUNT(*) FROM commands WHERE id > ? AND origin_device IS NULL`,
+		db.cursor(syncPushCursorKey)).Scan(&status.Pending)
+	return status, err
+}
+
+// Sync pushes local commands, then pulls those of other devices
+func (c *SyncClient) Sync() (*SyncResult, error) {
+	result := &SyncResult{}
+	if err := c.push(result); err != nil {
+		return result, fmt.Errorf("push failed: %w", err)
+	}
+	if err := c.pull(result); err != nil {
+		return result, fmt.Errorf("pull failed: %w", err)
+	}
+	return result, c.db.setMetadata(syncLastRemoteKey, time.Now().Format(time.RFC3339))
+}
+
+// push uploads commands recorded on this device since the push cursor
+func (c *SyncClient) push(result *SyncResult) error {
+	hostname, _ := os.Hostname()
+	for {
+		cursor := c.db.cursor(syncPushCursorKey)
+		rows, err := c.db.conn.Query(`
+			SELECT id, command, timestamp, exit_code, duration_ms, COALESCE(working_dir, ''),
+			       COALESCE(output_preview, ''), COALESCE(hostname, ''), COALESCE(session, '')
+			FROM commands
+			WHERE id > ? AND origin_device IS NULL
+			ORDER BY id
+			LIMIT ?
+		`, cursor, syncBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var records []wireRecord
+		last := cursor
+		for rows.Next() {
+			var r syncRecord
+			err := rows.Scan(&last, &r.Command, &r.Timestamp, &r.ExitCode, &r.DurationMS,
+				&r.WorkingDir, &r.OutputPreview, &r.Hostname, &r.Session)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			r.Command = c.db.openText(r.Command)
+			r.OutputPreview = c.db.openText(r.OutputPreview)
+			if r.Hostname == "" {
+				r.Hostname = hostname
+			}
+			record, err := c.seal(r)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			records = append(records, record)
+		}
+		rows.Close()
+		if len(records) == 0 {
+			return nil
+		}
+
+		var resp struct {
+			Accepted   int `json:"accepted"`
+			Duplicates int `json:"duplicates"`
+		}
+		body := map[string]interface{}{"device_id": c.deviceID, "records": records}
+		if err := c.do(http.MethodPost, "/v1/records", body, &resp); err != nil {
+			return err
+		}
+		result.Pushed += resp.Accepted
+		result.Duplicates += resp.Duplicates
+
+		if err := c.db.setMetadata(syncPushCursorKey, strconv.FormatInt(last, 10)); err != nil {
+			return err
+		}
+	}
+}
+
+// pull downloads records after the pull cursor and merges those from other
+// devices. Records are immutable and identified by command and time, so
+// merging is a set union and needs no conflict resolution.
+func (c *SyncClient) pull(result *SyncResult) error {
+	for {
+		cursor := c.db.cursor(syncPullCursorKey)
+		var resp struct {
+			Records []wireRecord `json:"records"`
+			More    bool         `json:"more"`
+		}
+		path := fmt.Sprintf("/v1/records?after=%d&limit=%d", cursor, syncBatchSize)
+		if err := c.do(http.MethodGet, path, nil, &resp); err != nil {
+			return err
+		}
+
+		var commands []Command
+		for _, record := range resp.Records {
+			cursor = record.Seq
+			if record.DeviceID == c.deviceID {
+				continue
+			}
+			r, err := c.open(record)
+			if err != nil {
+				return fmt.Errorf("record %d: %w", record.Seq, err)
+			}
+			commands = append(commands, Command{
+				Command:       r.Command,
+				Timestamp:     r.Timestamp.Local(),
+				ExitCode:      r.ExitCode,
+				Duration:      r.DurationMS,
+				WorkingDir:    r.WorkingDir,
+				OutputPreview: r.OutputPreview,
+				Hostname:      r.Hostname,
+				Session:       r.Session,
+				OriginDevice:  record.DeviceID,
+			})
+		}
+
+		merged, skipped, err := c.db.MergeSynced(commands)
+		if err != nil {
+			return err
+		}
+		result.Pulled += merged
+		result.Duplicates += skipped
+
+		if err := c.db.setMetadata(syncPullCursorKey, strconv.FormatInt(cursor, 10)); err != nil {
+			return err
+		}
+		if !resp.More {
+			return nil
+		}
+	}
+}
+
+// MergeSynced inserts commands from other devices that are not already
+// stored, marking them with the device they came from. A command counts as
+// stored when the same command ran at the same time, so every run is kept.
+func (db *DB) MergeSynced(cmds []Command) (merged, skipped int, err error) {
+	if len(cmds) == 0 {
+		return 0, 0, nil
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, cmd := range cmds {
+		cmd = redactCommand(cmd)
+		hash := db.commandHash(cmd.Command)
+
+		exists, err := hasRunAt(tx, hash, cmd.Timestamp)
+		if err != nil {
+			return 0, 0, err
+		}
+		if exists {
+			skipped++
+			continue
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO commands (command, timestamp, exit_code, duration_ms, working_dir, output_preview,
+			                      command_hash, last_used, embedding_status, hostname, session, origin_device)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'pending', ?, ?, ?)
+		`, db.sealText(cmd.Command), cmd.Timestamp, cmd.ExitCode, cmd.Duration, cmd.WorkingDir,
+			db.sealText(cmd.OutputPreview), hash, cmd.Timestamp, cmd.Hostname, cmd.Session, originDevice(cmd))
+		if err != nil {
+			return 0, 0, err
+		}
+		merged++
+	}
+	return merged, skipped, tx.Commit()
+}
+
+// hasRunAt reports whether a command was already recorded at t. Times are
+// compared after parsing: stored values written from time.Now() carry a
+// monotonic clock suffix, so their text never matches.
+func hasRunAt(tx *sql.Tx, hash string, t time.Time) (bool, error) {
+	rows, err := tx.Query(`SELECT timestamp FROM commands WHERE command_hash = ?`, hash)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stored time.Time
+		if err := rows.Scan(&stored); err != nil {
+			return false, err
+		}
+		if stored.Equal(t) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// seal encrypts a record. Its ID is a keyed hash of the command and time, so
+// the same command recorded on two devices (say, from a shared history file)
+// is stored once without the server learning what it is.
+func (c *SyncClient) seal(r syncRecord) (wireRecord, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return wireRecord{}, err
+	}
+	id := c.cipher.hash(r.Command + "\x00" + r.Timestamp.UTC().Format(time.RFC3339Nano))
+	return wireRecord{ID: id, Data: c.cipher.seal(string(data))}, nil
+}
+
+// open decrypts a record. Unlike stored fields, plaintext is never accepted:
+// everything on the server must have been encrypted with the sync key.
+func (c *SyncClient) open(record wireRecord) (syncRecord, error) {
+	var r syncRecord
+	if !strings.HasPrefix(record.Data, encryptedPrefix) {
+		return r, fmt.Errorf("record is not encrypted")
+	}
+	plain, err := c.cipher.open(record.Data)
+	if err != nil {
+		return r, fmt.Errorf("cannot decrypt record (is the sync key the same on every device?)")
+	}
+	if err := json.Unmarshal([]byte(plain), &r); err != nil {
+		return r, fmt.Errorf("invalid record: %w", err)
+	}
+	return r, nil
+}
+
+// do sends a request to the server and decodes the JSON response
+func (c *SyncClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return fmt.Errorf("sync server: %s", apiErr.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// originDevice stores an empty origin as NULL, which marks local commands
+func originDevice(cmd Command) sql.NullString {
+	return sql.NullString{String: cmd.OriginDevice, Valid: cmd.OriginDevice != ""}
+}
+
+// GetSyncMetadata reads a value saved by a sync backend, empty if unset
+func (db *DB) GetSyncMetadata(key string) (string, error) {
+	return db.getMetadata(key)
+}
+
+// SetSyncMetadata saves a value for a sync backend, such as a cursor
+func (db *DB) SetSyncMetadata(key, value string) error {
+	return db.setMetadata(key, value)
+}
+
+// cursor reads a numeric cursor from the metadata table, 0 if unset
+func (db *DB) cursor(key string) int64 {
+	value, _ := db.getMetadata(key)
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Task statuses
+const (
+	TaskRunning = "running"
+	TaskDone    = "done"    // The model reported the goal achieved
+	TaskFailed  = "failed"  // A step exited non-zero or the model gave no usable plan
+	TaskStopped = "stopped" // The user stopped it or a step was blocked
+	TaskBudget  = "budget"  // The step budget ran out
+)
+
+// Step statuses
+const (
+	StepRan     = "ran"
+	StepSkipped = "skipped"
+	StepBlocked = "blocked"
+)
+
+// TaskStep is one proposed command of a task and what became of it
+type TaskStep struct {
+	Command    string    `json:"command"`
+	Reason     string    `json:"reason,omitempty"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	Output     string    `json:"output,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// Task is the transcript of a `mako do` run
+type Task struct {
+	ID         int64
+	Goal       string
+	Status     string
+	Summary    string
+	WorkingDir string
+	StartedAt  time.Time
+	FinishedAt time.Time // Zero while running
+	Steps      []TaskStep
+}
+
+// taskTranscript is what the transcript column holds
+type taskTranscript struct {
+	Summary string     `json:"summary,omitempty"`
+	Steps   []TaskStep `json:"steps"`
+}
+
+// SaveTask inserts a task, setting its ID, or updates it once it has one.
+// Secrets are redacted from the goal, commands and output.
+func (db *DB) SaveTask(task *Task) error {
+	transcript := taskTranscript{Summary: redactor.RedactStoredSecrets(task.Summary)}
+	for _, step := range task.Steps {
+		step.Command = redactor.RedactStoredSecrets(step.Command)
+		step.Output = redactor.RedactStoredSecrets(step.Output)
+		transcript.Steps = append(transcript.Steps, step)
+	}
+	data, err := json.Marshal(transcript)
+	if err != nil {
+		return err
+	}
+
+	goal := db.sealText(redactor.RedactStoredSecrets(task.Goal))
+	var finished sql.NullTime
+	if !task.FinishedAt.IsZero() {
+		finished = sql.NullTime{Time: task.FinishedAt, Valid: true}
+	}
+
+	if task.ID == 0 {
+		result, err := db.conn.Exec(`
+			INSERT INTO tasks (goal, status, working_dir, started_at, finished_at, step_count, transcript)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, goal, task.Status, task.WorkingDir, task.StartedAt, finished, len(task.Steps), db.sealText(string(data)))
+		if err != nil {
+			return fmt.Errorf("failed to save task: %w", err)
+		}
+		task.ID, err = result.LastInsertId()
+		return err
+	}
+
+	_, err = db.conn.Exec(`
+		UPDATE tasks SET goal = ?, status = ?, working_dir = ?, finished_at = ?, step_count = ?, transcript = ?
+		WHERE id = ?
+	`, goal, task.Status, task.WorkingDir, finished, len(task.Steps), db.sealText(string(data)), task.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save task: %w", err)
+	}
+	return nil
+}
+
+// GetTasks returns the most recent tasks, newest first
+func (db *DB) GetTasks(limit int) ([]Task, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, goal, status, COALESCE(working_dir, ''), started_at, finished_at, COALESCE(transcript, '')
+		FROM tasks ORDER BY started_at DESC, id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		task, err := db.scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+	return tasks, rows.Err()
+}
+
+// GetTask returns a task by ID
+func (db *DB) GetTask(id int64) (*Task, error) {
+	row := db.conn.QueryRow(`
+		SELECT id, goal, status, COALESCE(working_dir, ''), started_at, finished_at, COALESCE(transcript, '')
+		FROM tasks WHERE id = ?
+	`, id)
+	task, err := db.scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no task with ID %d", id)
+	}
+	return task, err
+}
+
+func (db *DB) scanTask(row interface{ Scan(...interface{}) error }) (*Task, error) {
+	var task Task
+	var finished sql.NullTime
+	var transcript string
+	if err := row.Scan(&task.ID, &task.Goal, &task.Status, &task.WorkingDir, &task.StartedAt, &finished, &transcript); err != nil {
+		return nil, err
+	}
+	task.Goal = db.openText(task.Goal)
+	if finished.Valid {
+		task.FinishedAt = finished.Time
+	}
+
+	var stored taskTranscript
+	if transcript != "" {
+		if err := json.Unmarshal([]byte(db.openText(transcript)), &stored); err != nil {
+			task.Summary = "[unreadable transcript]"
+			return &task, nil
+		}
+	}
+	task.Summary = stored.Summary
+	task.Steps = stored.Steps
+	return &task, nil
+}
+
+// rewriteTasks re-stores task text under the target cipher, as
+// rewriteCommands does for commands
+func (db *DB) rewriteTasks(tx *sql.Tx, dst *DB) error {
+	type row struct {
+		id         int64
+		goal       string
+		transcript string
+	}
+	var stored []row
+
+	rows, err := tx.Query(`SELECT id, goal, COALESCE(transcript, '') FROM tasks`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.goal, &r.transcript); err != nil {
+			rows.Close()
+			return err
+		}
+		stored = append(stored, r)
+	}
+	rows.Close()
+
+	for _, r := range stored {
+		_, err := tx.Exec(`UPDATE tasks SET goal = ?, transcript = ? WHERE id = ?`,
+			dst.sealText(db.openText(r.goal)), dst.sealText(db.openText(r.transcript)), r.id)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite task %d: %w", r.id, err)
+		}
+	}
+	return nil
+}
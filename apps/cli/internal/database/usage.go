@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UsageRecord is one call to an AI provider
+type UsageRecord struct {
+	CalledAt     time.Time
+	Provider     string
+	Model        string
+	Feature      string // The prompt template, or "embedding"
+	InputTokens  int
+	OutputTokens int
+	Estimated    bool // The response didn't report its tokens
+	Latency      time.Duration
+	Cost         float64 // Estimated USD
+	Priced       bool    // False when the model's price isn't known
+}
+
+// UsageTotal sums the calls of one provider, model or feature
+type UsageTotal struct {
+	Key          string
+	Calls        int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+	Unpriced     int           // Calls whose cost isn't known
+	Latency      time.Duration // Average
+}
+
+// Groupings for UsageTotals
+const (
+	UsageByProvider = "provider"
+	UsageByModel    = "model"
+	UsageByFeature  = "feature"
+)
+
+// RecordUsage stores a call. Nothing the user typed is kept, only counts.
+func (db *DB) RecordUsage(record UsageRecord) error {
+	var cost sql.NullFloat64
+	if record.Priced {
+		cost = sql.NullFloat64{Float64: record.Cost, Valid: true}
+	}
+	_, err := db.conn.Exec(`
+		INSERT INTO usage (called_at, provider, model, feature, input_tokens, output_tokens, estimated, latency_ms, cost)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, record.CalledAt, record.Provider, record.Model, record.Feature, record.InputTokens, record.OutputTokens,
+		record.Estimated, record.Latency.Milliseconds(), cost)
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// UsageTotals sums the calls since a time, grouped by provider, model or
+// feature, most expensive first
+func (db *DB) UsageTotals(since time.Time, by string) ([]UsageTotal, error) {
+	switch by {
+	case UsageByProvider, UsageByModel, UsageByFeature:
+	default:
+		return nil, fmt.Errorf("unknown usage grouping %q", by)
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT `+by+`, COUNT(*), SUM(input_tokens), SUM(output_tokens),
+			COALESCE(SUM(cost), 0), SUM(cost IS NULL), AVG(latency_ms)
+		FROM usage WHERE called_at >= ?
+		GROUP BY `+by+`
+		ORDER BY COALESCE(SUM(cost), 0) DESC, SUM(input_tokens) + SUM(output_tokens) DESC
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []UsageTotal
+	for rows.Next() {
+		var total UsageTotal
+		var latency float64
+		if err := rows.Scan(&total.Key, &total.Calls, &total.InputTokens, &total.OutputTokens,
+			&total.Cost, &total.Unpriced, &latency); err != nil {
+			return nil, err
+		}
+		total.Latency = time.Duration(latency) * time.Millisecond
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
+// SpentSince is the estimated cost of the calls since a time
+func (db *DB) SpentSince(since time.Time) (float64, error) {
+	var spent float64
+	err := db.conn.QueryRow(`SELECT COALESCE(SUM(cost), 0) FROM usage WHERE called_at >= ?`, since).Scan(&spent)
+	return spent, err
+}
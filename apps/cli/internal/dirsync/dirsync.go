@@ -0,0 +1,465 @@
+// Package dirsync syncs history through a shared directory instead of a
+// server: a git repository, a Syncthing folder or a network mount. Every
+// device appends encrypted NDJSON segments under its own directory and
+// imports the segments written by the others, so devices never write the
+// same file and the folder merges without conflicts.
+//
+// Layout:
+//
+//	<dir>/mako-history/devices/<device-id>/000001.ndjson.enc
+package dirsync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/export"
+)
+
+const (
+	historyDir    = "mako-history"
+	segmentSuffix = ".ndjson.enc"
+
+	// segmentMagic starts every segment so other files are told apart from
+	// segments written with a different key
+	segmentMagic = "MAKOSEG1"
+
+	dirKey          = "dirsync_dir"
+	exportCursorKey = "dirsync_export_cursor"
+	lastSyncKey     = "dirsync_last"
+	seenKeyPrefix   = "dirsync_seen_"
+)
+
+// ErrDecrypt is returned for segments that were written with another sync
+// key or changed after they were written
+var ErrDecrypt = errors.New("cannot decrypt segment (wrong sync key or modified file)")
+
+// Result counts what a sync exported and imported. Warnings are problems
+// that did not stop the sync, such as a failed git push or a segment that
+// could not be read.
+type Result struct {
+	Exported int    // Local commands written to the new segment
+	Segment  string // Name of the new segment, empty if nothing was new
+	Imported int    // Commands new to this device
+	Updated  int    // Commands this device already had
+	Skipped  int
+	Devices  int // Other devices found in the directory
+	Warnings []string
+}
+
+// Device describes another device's segments
+type Device struct {
+	ID       string
+	Segments int // Segments in the directory
+	Seen     int // Segments already imported here
+}
+
+// Status reports the directory, this device and the other devices
+type Status struct {
+	Dir      string
+	DeviceID string
+	Git      bool
+	Pending  int // Local commands not written to a segment yet
+	Devices  []Device
+	LastSync time.Time
+}
+
+// Syncer syncs one database with one directory
+type Syncer struct {
+	db       *database.DB
+	dir      string
+	deviceID string
+	aead     cipher.AEAD
+
+	// Conflict decides how imported commands that already exist here are
+	// handled. With merge (the default) or skip every run is kept and only
+	// the same run seen twice is skipped; overwrite replaces as for
+	// `mako import --overwrite`.
+	Conflict export.ConflictStrategy
+}
+
+// New prepares syncing db with dir using the shared sync key. Cursors belong
+// to a directory, so they are reset when the directory changes.
+func New(db *database.DB, dir, syncKey string) (*Syncer, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(syncKey))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid sync key: expected 64 hex characters")
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("sync directory: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("sync directory: %s is not a directory", dir)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("mako-sync-segments"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceID, err := db.DeviceID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Syncer{db: db, dir: dir, deviceID: deviceID, aead: aead, Conflict: export.ConflictMerge}
+	if previous, _ := db.GetSyncMetadata(dirKey); previous != dir {
+		if err := s.resetCursors(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Dir returns the absolute sync directory
+func (s *Syncer) Dir() string {
+	return s.dir
+}
+
+// Sync imports the other devices' new segments, then writes the local
+// commands recorded since the last sync to a new segment. Inside a git work
+// tree it pulls first and commits and pushes the new segment afterwards.
+func (s *Syncer) Sync() (*Result, error) {
+	result := &Result{}
+	git := s.isGitRepo()
+	if git && s.hasGitRemote() {
+		if err := s.git("pull", "--rebase", "--quiet"); err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+		}
+	}
+
+	if err := s.importSegments(result); err != nil {
+		return result, err
+	}
+	if err := s.exportSegment(result); err != nil {
+		return result, err
+	}
+
+	if git && result.Segment != "" {
+		if err := s.commitSegment(result.Segment); err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+		}
+	}
+	return result, s.db.SetSyncMetadata(lastSyncKey, time.Now().Format(time.RFC3339))
+}
+
+// Status lists the devices in the directory and what is left to sync
+func (s *Syncer) Status() (*Status, error) {
+	status := &Status{Dir: s.dir, DeviceID: s.deviceID, Git: s.isGitRepo()}
+	if last, _ := s.db.GetSyncMetadata(lastSyncKey); last != "" {
+		status.LastSync, _ = time.Parse(time.RFC3339, last)
+	}
+
+	pending, err := s.db.CountCommands(database.CommandQuery{
+		AfterID:   s.cursor(exportCursorKey),
+		LocalOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	status.Pending = pending
+
+	devices, err := s.otherDevices()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range devices {
+		segments, err := s.segments(id)
+		if err != nil {
+			return nil, err
+		}
+		status.Devices = append(status.Devices, Device{
+			ID:       id,
+			Segments: len(segments),
+			Seen:     int(s.cursor(seenKeyPrefix + id)),
+		})
+	}
+	return status, nil
+}
+
+// importSegments imports every unseen segment of every other device, in
+// order, keeping each run as the sync server's pull does. A segment that
+// can't be read stops that device's import so no later segment is applied
+// before it.
+func (s *Syncer) importSegments(result *Result) error {
+	devices, err := s.otherDevices()
+	if err != nil {
+		return err
+	}
+	result.Devices = len(devices)
+
+	importer := export.NewImporter(s.db)
+	for _, id := range devices {
+		segments, err := s.segments(id)
+		if err != nil {
+			return err
+		}
+		seen := s.cursor(seenKeyPrefix + id)
+		for _, seq := range segments {
+			if seq <= seen {
+				continue
+			}
+			name := segmentName(seq)
+			data, err := s.readSegment(id, name)
+			if err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s/%s: %v", id, name, err))
+				break
+			}
+			imported, err := importer.Import(bytes.NewReader(data), export.ImportOptions{
+				ConflictStrategy: s.Conflict,
+				Format:           export.FormatNDJSON,
+				Origin:           id,
+			})
+			if err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s/%s: %v", id, name, err))
+				break
+			}
+			result.Imported += imported.ImportedNew
+			result.Updated += imported.Updated
+			result.Skipped += imported.Skipped
+			for _, e := range imported.Errors {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s/%s: %s", id, name, e))
+			}
+			if err := s.db.SetSyncMetadata(seenKeyPrefix+id, strconv.FormatInt(seq, 10)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportSegment writes the local commands after the export cursor to the
+// next segment of this device. Commands imported from other devices are
+// left out so they don't echo back.
+func (s *Syncer) exportSegment(result *Result) error {
+	after := s.cursor(exportCursorKey)
+	last, err := s.db.LastCommandID()
+	if err != nil {
+		return err
+	}
+	if last <= after {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	err = export.NewExporter(s.db).Export(&buf, export.ExportOptions{
+		Format:    export.FormatNDJSON,
+		AfterID:   after,
+		MaxID:     last,
+		LocalOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	if buf.Len() > 0 {
+		segments, err := s.segments(s.deviceID)
+		if err != nil {
+			return err
+		}
+		var seq int64 = 1
+		if len(segments) > 0 {
+			seq = segments[len(segments)-1] + 1
+		}
+		name := segmentName(seq)
+		if err := s.writeSegment(name, buf.Bytes()); err != nil {
+			return err
+		}
+		result.Segment = name
+		result.Exported = bytes.Count(buf.Bytes(), []byte("\n"))
+	}
+	return s.db.SetSyncMetadata(exportCursorKey, strconv.FormatInt(last, 10))
+}
+
+// resetCursors starts over with a new directory: every local command is
+// exported again and every segment in it is imported
+func (s *Syncer) resetCursors() error {
+	keys := []string{exportCursorKey, lastSyncKey}
+	devices, err := s.otherDevices()
+	if err != nil {
+		return err
+	}
+	for _, id := range devices {
+		keys = append(keys, seenKeyPrefix+id)
+	}
+	for _, key := range keys {
+		if err := s.db.SetSyncMetadata(key, ""); err != nil {
+			return err
+		}
+	}
+	return s.db.SetSyncMetadata(dirKey, s.dir)
+}
+
+func (s *Syncer) devicesDir() string {
+	return filepath.Join(s.dir, historyDir, "devices")
+}
+
+// otherDevices lists the device directories other than this device's
+func (s *Syncer) otherDevices() ([]string, error) {
+	entries, err := os.ReadDir(s.devicesDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != s.deviceID && !strings.HasPrefix(entry.Name(), ".") {
+			devices = append(devices, entry.Name())
+		}
+	}
+	return devices, nil
+}
+
+// segments returns a device's segment numbers in order. Temporary files and
+// anything else that isn't a segment are ignored.
+func (s *Syncer) segments(deviceID string) ([]int64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.devicesDir(), deviceID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var segments []int64
+	for _, entry := range entries {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), segmentSuffix), 10, 64)
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentSuffix) || err != nil || seq <= 0 {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// writeSegment encrypts data into a new segment of this device. The device
+// ID and segment name are authenticated, so a segment can't be passed off as
+// another device's or replayed under another name.
+func (s *Syncer) writeSegment(name string, data []byte) error {
+	dir := filepath.Join(s.devicesDir(), s.deviceID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create device directory: %w", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := append([]byte(segmentMagic), nonce...)
+	sealed = s.aead.Seal(sealed, nonce, data, segmentAAD(s.deviceID, name))
+
+	// Write to a temporary file first so other devices never see a partial
+	// segment
+	tmp, err := os.CreateTemp(dir, ".segment-*")
+	if err != nil {
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// readSegment decrypts one of a device's segments
+func (s *Syncer) readSegment(deviceID, name string) ([]byte, error) {
+	sealed, err := os.ReadFile(filepath.Join(s.devicesDir(), deviceID, name))
+	if err != nil {
+		return nil, err
+	}
+	header := len(segmentMagic) + s.aead.NonceSize()
+	if len(sealed) < header || string(sealed[:len(segmentMagic)]) != segmentMagic {
+		return nil, ErrDecrypt
+	}
+	nonce := sealed[len(segmentMagic):header]
+	data, err := s.aead.Open(nil, nonce, sealed[header:], segmentAAD(deviceID, name))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return data, nil
+}
+
+// cursor reads a numeric sync cursor, 0 when unset
+func (s *Syncer) cursor(key string) int64 {
+	value, _ := s.db.GetSyncMetadata(key)
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
+
+func segmentName(seq int64) string {
+	return fmt.Sprintf("%06d%s", seq, segmentSuffix)
+}
+
+func segmentAAD(deviceID, name string) []byte {
+	return []byte(deviceID + "/" + name)
+}
+
+// isGitRepo reports whether the directory is inside a git work tree
+func (s *Syncer) isGitRepo() bool {
+	if _, err := exec.LookPath("git"); err != nil {
+		return false
+	}
+	return exec.Command("git", "-C", s.dir, "rev-parse", "--is-inside-work-tree").Run() == nil
+}
+
+func (s *Syncer) hasGitRemote() bool {
+	out, err := exec.Command("git", "-C", s.dir, "remote").Output()
+	return err == nil && len(bytes.TrimSpace(out)) > 0
+}
+
+// commitSegment commits the new segment and pushes it if there is a remote
+func (s *Syncer) commitSegment(name string) error {
+	path := filepath.Join(s.devicesDir(), s.deviceID, name)
+	if err := s.git("add", "--", path); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("mako: history from %s", s.deviceID)
+	if err := s.git("commit", "--quiet", "-m", message, "--", path); err != nil {
+		return err
+	}
+	if s.hasGitRemote() {
+		return s.git("push", "--quiet")
+	}
+	return nil
+}
+
+func (s *Syncer) git(args ...string) error {
+	out, err := exec.Command("git", append([]string{"-C", s.dir}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package dirsync
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func newDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(testutil.TempDir(t), "history.db"))
+	if err != nil {
+		t.Fatalf("NewDB() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newSyncer(t *testing.T, db *database.DB, dir, key string) *Syncer {
+	t.Helper()
+	s, err := New(db, dir, key)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	return s
+}
+
+func mustSync(t *testing.T, s *Syncer) *Result {
+	t.Helper()
+	result, err := s.Sync()
+	if err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	if len(result.Warnings) > 0 {
+		t.Fatalf("Sync() warnings: %v", result.Warnings)
+	}
+	return result
+}
+
+func TestSyncBetweenDevices(t *testing.T) {
+	dir := testutil.TempDir(t)
+	key := database.GenerateSyncKey()
+	laptop, desktop := newDB(t), newDB(t)
+	now := time.Now()
+
+	laptop.SaveCommand(database.Command{Command: "make build", Timestamp: now.Add(-time.Minute), WorkingDir: "/src"})
+	laptop.SaveCommand(database.Command{Command: "git status", Timestamp: now, WorkingDir: "/src"})
+	desktop.SaveCommand(database.Command{Command: "htop", Timestamp: now, WorkingDir: "/home"})
+
+	a := newSyncer(t, laptop, dir, key)
+	b := newSyncer(t, desktop, dir, key)
+
+	if r := mustSync(t, a); r.Exported != 2 || r.Segment != "000001.ndjson.enc" {
+		t.Errorf("first sync exported %d to %q, want 2 to 000001.ndjson.enc", r.Exported, r.Segment)
+	}
+	if r := mustSync(t, b); r.Imported != 2 || r.Exported != 1 || r.Devices != 1 {
+		t.Errorf("second device: imported %d, exported %d, devices %d; want 2, 1, 1", r.Imported, r.Exported, r.Devices)
+	}
+	if r := mustSync(t, a); r.Imported != 1 || r.Exported != 0 {
+		t.Errorf("back on the first device: imported %d, exported %d; want 1, 0", r.Imported, r.Exported)
+	}
+
+	// Imported commands are not written back, so nothing echoes
+	if r := mustSync(t, b); r.Imported != 0 || r.Exported != 0 || r.Segment != "" {
+		t.Errorf("idle sync imported %d, exported %d to %q; want nothing", r.Imported, r.Exported, r.Segment)
+	}
+
+	for name, db := range map[string]*database.DB{"laptop": laptop, "desktop": desktop} {
+		commands, err := db.QueryCommands(database.CommandQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(commands) != 3 {
+			t.Errorf("%s has %d commands, want 3", name, len(commands))
+		}
+	}
+
+	status, err := a.Status()
+	if err != nil {
+		t.Fatalf("Status() failed: %v", err)
+	}
+	if status.Pending != 0 || len(status.Devices) != 1 || status.Devices[0].Seen != 1 {
+		t.Errorf("Status() = %+v, want nothing pending and one device seen", status)
+	}
+
+	// Every run of a command is kept, including repeats in one segment and
+	// runs of a command the other device has run too
+	laptop.SaveCommand(database.Command{Command: "htop", Timestamp: now.Add(time.Minute), WorkingDir: "/home"})
+	laptop.SaveCommand(database.Command{Command: "make build", Timestamp: now.Add(2 * time.Minute), WorkingDir: "/src"})
+	laptop.SaveCommand(database.Command{Command: "make build", Timestamp: now.Add(3 * time.Minute), WorkingDir: "/src"})
+	mustSync(t, a)
+	if r := mustSync(t, b); r.Imported != 3 || r.Updated != 0 || r.Skipped != 0 {
+		t.Errorf("repeated runs: imported %d, updated %d, skipped %d; want 3, 0, 0", r.Imported, r.Updated, r.Skipped)
+	}
+	commands, err := desktop.QueryCommands(database.CommandQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := map[string]int{}
+	for _, cmd := range commands {
+		runs[cmd.Command]++
+	}
+	if runs["htop"] != 2 || runs["make build"] != 3 {
+		t.Errorf("desktop runs = %v, want 2 of htop and 3 of make build", runs)
+	}
+}
+
+func TestSegmentsAreEncrypted(t *testing.T) {
+	dir := testutil.TempDir(t)
+	key := database.GenerateSyncKey()
+	laptop := newDB(t)
+	laptop.SaveCommand(database.Command{Command: "ssh prod-db-01", Timestamp: time.Now(), WorkingDir: "/"})
+	mustSync(t, newSyncer(t, laptop, dir, key))
+
+	deviceID, _ := laptop.DeviceID()
+	segment := filepath.Join(dir, historyDir, "devices", deviceID, "000001.ndjson.enc")
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatalf("segment not written: %v", err)
+	}
+	if strings.Contains(string(data), "prod-db-01") {
+		t.Error("segment contains the command in plain text")
+	}
+
+	// Another key can't read it
+	r, err := newSyncer(t, newDB(t), dir, database.GenerateSyncKey()).Sync()
+	if err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	if r.Imported != 0 || len(r.Warnings) != 1 {
+		t.Errorf("wrong key: imported %d with warnings %v, want 0 and one warning", r.Imported, r.Warnings)
+	}
+
+	// Nor can the right key once the segment is modified, and the segment is
+	// retried rather than marked as seen
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(segment, data, 0600)
+	desktop := newDB(t)
+	b := newSyncer(t, desktop, dir, key)
+	if r, _ := b.Sync(); r.Imported != 0 || len(r.Warnings) != 1 {
+		t.Errorf("tampered segment: imported %d with warnings %v, want 0 and one warning", r.Imported, r.Warnings)
+	}
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(segment, data, 0600)
+	if r := mustSync(t, b); r.Imported != 1 {
+		t.Errorf("restored segment: imported %d, want 1", r.Imported)
+	}
+
+	if _, err := New(desktop, dir, "not-a-key"); err == nil {
+		t.Error("New() accepted an invalid sync key")
+	}
+}
+
+func TestGitRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := testutil.TempDir(t)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"config", "user.name", "Test"},
+		{"config", "user.email", "test@example.com"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	db := newDB(t)
+	db.SaveCommand(database.Command{Command: "go test ./...", Timestamp: time.Now(), WorkingDir: "/src"})
+	s := newSyncer(t, db, dir, database.GenerateSyncKey())
+	mustSync(t, s)
+
+	out, err := exec.Command("git", "-C", dir, "log", "--name-only", "--format=%s").Output()
+	if err != nil {
+		t.Fatalf("git log: %v", err)
+	}
+	if !strings.Contains(string(out), "mako: history from") || !strings.Contains(string(out), "000001.ndjson.enc") {
+		t.Errorf("segment not committed:\n%s", out)
+	}
+	if status, _ := s.Status(); !status.Git {
+		t.Error("Status() did not detect the git repository")
+	}
+}
@@ -0,0 +1,264 @@
+package explain
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupTimeout bounds each man or --help lookup
+const lookupTimeout = 3 * time.Second
+
+// doc is what a program's documentation says about it
+type doc struct {
+	Summary string
+	Options map[string]option
+	Source  string // "man ls" or "ls --help"
+}
+
+// option is one documented flag
+type option struct {
+	Help       string
+	TakesValue bool // Needs a value, as the next word unless attached with =
+}
+
+// builtins are documented in the shell's own man page, too long to parse
+// per command, so their summaries are kept here
+var builtins = map[string]string{
+	"cd":      "change the current directory",
+	"export":  "make variables available to commands run from this shell",
+	"source":  "run the commands in a file in the current shell",
+	".":       "run the commands in a file in the current shell",
+	"alias":   "define or list shell aliases",
+	"unset":   "remove variables or functions",
+	"exit":    "exit the shell",
+	"set":     "set shell options and positional parameters",
+	"eval":    "run its arguments as a shell command",
+	"read":    "read a line from standard input into variables",
+	"exec":    "replace the shell with the command",
+	"command": "run a command, bypassing shell functions",
+	"type":    "show how a name would be interpreted as a command",
+}
+
+var (
+	docCache   = map[string]*doc{}
+	docCacheMu sync.Mutex
+)
+
+// lookupDoc finds the documentation for a program, or nil. It is a variable
+// so tests can stand in for the machine's man pages.
+var lookupDoc = func(name string) *doc {
+	docCacheMu.Lock()
+	defer docCacheMu.Unlock()
+	if d, ok := docCache[name]; ok {
+		return d
+	}
+
+	var d *doc
+	if summary, ok := builtins[name]; ok {
+		d = &doc{Summary: summary, Options: map[string]option{}, Source: "shell builtin"}
+	} else if text := manPage(name); text != "" {
+		d = parseDoc(text)
+		d.Source = "man " + name
+	} else if text := helpOutput(name); text != "" {
+		d = parseDoc(text)
+		d.Source = name + " --help"
+	}
+	docCache[name] = d
+	return d
+}
+
+var overstrike = regexp.MustCompile(".\x08")
+
+// manPage renders a man page as plain text, or returns "" when there isn't one
+func manPage(name string) string {
+	if _, err := exec.LookPath("man"); err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "man", name)
+	cmd.Env = append(os.Environ(), "MANPAGER=cat", "PAGER=cat", "MANWIDTH=200", "MAN_KEEP_FORMATTING=")
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return overstrike.ReplaceAllString(string(out), "")
+}
+
+// systemDirs hold the programs whose documentation is looked up. Anything
+// else on $PATH may be the user's or a project's own code.
+var systemDirs = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin"}
+
+// systemProgram reports whether name resolves on $PATH to a program in a
+// system directory, following symlinks (e.g. through /etc/alternatives) to
+// a file that is still under /usr, /bin or /sbin
+func systemProgram(name string) bool {
+	if strings.Contains(name, "/") {
+		return false
+	}
+	path, err := exec.LookPath(name)
+	if err != nil || !inDirs(filepath.Dir(path), systemDirs) {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	return inDirs(resolved, []string{"/usr", "/bin", "/sbin"})
+}
+
+// inDirs reports whether path is one of dirs or inside one
+func inDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// helpOutput runs a program's --help. Only programs in system directories
+// are asked, so a script on $PATH is never run to document itself.
+func helpOutput(name string) string {
+	if !systemProgram(name) {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, "--help")
+	cmd.Stdin = nil
+	out, _ := cmd.CombinedOutput()
+	text := string(out)
+	// Programs that don't know --help usually complain in a line or two
+	if strings.Count(text, "\n") < 3 || !strings.Contains(text, "-") {
+		return ""
+	}
+	return text
+}
+
+var (
+	optionHeader = regexp.MustCompile(`^(\s*)(-{1,2}[A-Za-z0-9?#@][\w-]*.*)$`)
+	optionFlag   = regexp.MustCompile(`(-{1,2}[A-Za-z0-9?#@][\w-]*)(\[?[= ][<A-Z{[+][^,\s]*\]?)?`)
+	columnGap    = regexp.MustCompile(`\s{2,}|\t`)
+)
+
+// parseDoc reads the summary and documented flags out of a man page or
+// --help text. Option entries are lines starting with a flag, described on
+// the same line after a gap or on the more indented lines below.
+func parseDoc(text string) *doc {
+	d := &doc{Options: map[string]option{}}
+	lines := strings.Split(strings.ReplaceAll(text, "\r", ""), "\n")
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if d.Summary == "" && trimmed == "NAME" {
+			for _, next := range lines[i+1:] {
+				if next = strings.TrimSpace(next); next != "" {
+					if _, summary, ok := cutAny(next, " - ", " — ", " -- "); ok {
+						d.Summary = summary
+					}
+					break
+				}
+			}
+		}
+		if d.Summary == "" && strings.HasPrefix(strings.ToLower(trimmed), "usage:") {
+			var summary []string
+			for _, next := range lines[i+1:] {
+				indented := strings.HasPrefix(next, " ") || strings.HasPrefix(next, "\t")
+				if len(summary) == 0 && (next == "" || indented) {
+					continue
+				}
+				if next == "" || indented || strings.HasPrefix(next, "-") || strings.HasSuffix(strings.TrimSpace(next), ":") {
+					break
+				}
+				summary = append(summary, strings.TrimSpace(next))
+			}
+			d.Summary = firstSentence(strings.Join(summary, " "))
+		}
+
+		m := optionHeader.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		indent := len(m[1])
+		flags, help := m[2], ""
+		if loc := columnGap.FindStringIndex(flags); loc != nil {
+			flags, help = flags[:loc[0]], strings.TrimSpace(flags[loc[1]:])
+		}
+
+		if help == "" {
+			var paragraph []string
+			for _, next := range lines[i+1:] {
+				nextIndent := len(next) - len(strings.TrimLeft(next, " \t"))
+				if strings.TrimSpace(next) == "" || nextIndent <= indent {
+					break
+				}
+				paragraph = append(paragraph, strings.TrimSpace(next))
+			}
+			help = strings.Join(paragraph, " ")
+		} else {
+			// A description that wraps continues on lines indented further
+			// than the flags
+			for _, next := range lines[i+1:] {
+				nextIndent := len(next) - len(strings.TrimLeft(next, " \t"))
+				if strings.TrimSpace(next) == "" || nextIndent <= indent+4 || optionHeader.MatchString(next) {
+					break
+				}
+				help += " " + strings.TrimSpace(next)
+			}
+		}
+		help = firstSentence(help)
+		if help == "" {
+			continue
+		}
+
+		// A value one spelling requires, as in "-k, --key=KEYDEF", the
+		// others require too
+		matches := optionFlag.FindAllStringSubmatch(flags, -1)
+		takesValue := false
+		for _, fm := range matches {
+			if fm[2] != "" && !strings.HasPrefix(fm[2], "[") {
+				takesValue = true
+			}
+		}
+		for _, fm := range matches {
+			if _, seen := d.Options[fm[1]]; !seen {
+				d.Options[fm[1]] = option{Help: help, TakesValue: takesValue}
+			}
+		}
+	}
+	return d
+}
+
+func cutAny(s string, seps ...string) (string, string, bool) {
+	for _, sep := range seps {
+		if before, after, ok := strings.Cut(s, sep); ok {
+			return strings.TrimSpace(before), strings.TrimSpace(after), true
+		}
+	}
+	return s, "", false
+}
+
+// firstSentence keeps descriptions to a line
+func firstSentence(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if i := strings.Index(s, ". "); i > 0 {
+		s = s[:i]
+	}
+	// "entries starting with ." keeps its dot
+	if strings.HasSuffix(s, ".") && !strings.HasSuffix(s, " .") {
+		s = strings.TrimSuffix(s, ".")
+	}
+	if len(s) > 160 {
+		s = s[:157] + "..."
+	}
+	return s
+}
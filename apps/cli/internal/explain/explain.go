@@ -0,0 +1,352 @@
+// Package explain annotates a shell command from the documentation on the
+// machine, explainshell-style: each program with the summary from its man
+// page or --help, each flag with the text documenting it, and each
+// operator and redirection with what it does.
+package explain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Kinds of part
+const (
+	KindProgram    = "program"
+	KindSubcommand = "subcommand"
+	KindFlag       = "flag"
+	KindArgument   = "argument"
+	KindOperator   = "operator"
+	KindRedirect   = "redirect"
+	KindAssignment = "assignment"
+)
+
+// Part is a piece of the command and what it means
+type Part struct {
+	Text   string // As written in the command
+	Kind   string
+	Help   string // Empty when nothing documents it
+	Source string // Where Help came from, like "man ls"
+}
+
+// Explanation is a command broken into annotated parts, in order
+type Explanation struct {
+	Command string
+	Parts   []Part
+}
+
+// Unknown returns the programs and flags no documentation covered
+func (e *Explanation) Unknown() []string {
+	var unknown []string
+	seen := map[string]bool{}
+	for _, p := range e.Parts {
+		if p.Help == "" && (p.Kind == KindProgram || p.Kind == KindSubcommand || p.Kind == KindFlag) && !seen[p.Text] {
+			seen[p.Text] = true
+			unknown = append(unknown, p.Text)
+		}
+	}
+	return unknown
+}
+
+// Sources lists where the documentation came from
+func (e *Explanation) Sources() []string {
+	var sources []string
+	seen := map[string]bool{}
+	for _, p := range e.Parts {
+		if p.Source != "" && !seen[p.Source] {
+			seen[p.Source] = true
+			sources = append(sources, p.Source)
+		}
+	}
+	return sources
+}
+
+// Command parses a command or script and annotates its parts from the
+// documentation installed on the machine
+func Command(command string) (*Explanation, error) {
+	file, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	if err != nil {
+		return nil, fmt.Errorf("can't parse command: %w", err)
+	}
+
+	w := &walker{src: command, e: &Explanation{Command: command}}
+	for _, stmt := range file.Stmts {
+		w.stmt(stmt)
+	}
+	return w.e, nil
+}
+
+// wrappers run the command that follows their own flags
+var wrappers = map[string]bool{
+	"sudo": true, "env": true, "time": true, "nohup": true, "nice": true,
+	"xargs": true, "exec": true, "command": true, "watch": true, "timeout": true,
+}
+
+// wrapperValues are the wrapper flags that take a value, for when the
+// wrapper itself isn't documented on the machine
+var wrapperValues = map[string][]string{
+	"sudo":    {"-u", "-g", "-C", "-D", "-h", "-p", "-r", "-t", "-U"},
+	"env":     {"-u", "-C", "-S"},
+	"nice":    {"-n"},
+	"timeout": {"-s", "-k"},
+	"watch":   {"-n", "-d"},
+	"xargs":   {"-n", "-I", "-P", "-L", "-d", "-E", "-s", "-a"},
+}
+
+var operators = map[syntax.BinCmdOperator]string{
+	syntax.AndStmt: "run the next command only if this one succeeds",
+	syntax.OrStmt:  "run the next command only if this one fails",
+	syntax.Pipe:    "send this command's output to the next one's input",
+	syntax.PipeAll: "send this command's output and errors to the next one's input",
+}
+
+var redirects = map[syntax.RedirOperator]string{
+	syntax.RdrOut:   "write output to %s, replacing its contents",
+	syntax.AppOut:   "append output to %s",
+	syntax.RdrIn:    "read input from %s",
+	syntax.RdrInOut: "open %s for reading and writing",
+	syntax.DplIn:    "read input from file descriptor %s",
+	syntax.DplOut:   "send output to file descriptor %s",
+	syntax.ClbOut:   "write output to %s, even if noclobber is set",
+	syntax.Hdoc:     "read input from the here-document ending at %s",
+	syntax.DashHdoc: "read input from the here-document ending at %s, without leading tabs",
+	syntax.WordHdoc: "read input from the string %s",
+	syntax.RdrAll:   "write output and errors to %s",
+	syntax.AppAll:   "append output and errors to %s",
+}
+
+type walker struct {
+	src string
+	e   *Explanation
+}
+
+func (w *walker) text(node syntax.Node) string {
+	return w.src[node.Pos().Offset():node.End().Offset()]
+}
+
+func (w *walker) add(p Part) {
+	w.e.Parts = append(w.e.Parts, p)
+}
+
+func (w *walker) stmt(s *syntax.Stmt) {
+	if s.Negated {
+		w.add(Part{Text: "!", Kind: KindOperator, Help: "negate the command's exit status"})
+	}
+
+	switch cmd := s.Cmd.(type) {
+	case *syntax.CallExpr:
+		w.call(cmd)
+	case *syntax.BinaryCmd:
+		w.stmt(cmd.X)
+		w.add(Part{Text: cmd.Op.String(), Kind: KindOperator, Help: operators[cmd.Op]})
+		w.stmt(cmd.Y)
+	case *syntax.Subshell:
+		w.add(Part{Text: "( )", Kind: KindOperator, Help: "run the commands inside in a subshell"})
+		for _, inner := range cmd.Stmts {
+			w.stmt(inner)
+		}
+	case *syntax.Block:
+		for _, inner := range cmd.Stmts {
+			w.stmt(inner)
+		}
+	default:
+		// Loops, conditionals and the like: explain the commands they run
+		if cmd != nil {
+			w.nested(cmd)
+		}
+	}
+
+	for _, r := range s.Redirs {
+		target := ""
+		if r.Word != nil {
+			target = w.text(r.Word)
+		}
+		help := ""
+		if format, ok := redirects[r.Op]; ok {
+			help = fmt.Sprintf(format, target)
+		}
+		if r.N != nil {
+			switch r.N.Value {
+			case "2":
+				help = strings.Replace(help, "output", "errors", 1)
+			case "1":
+			default:
+				help = fmt.Sprintf("for file descriptor %s, %s", r.N.Value, help)
+			}
+		}
+		if r.Op == syntax.DplOut && r.N != nil && r.N.Value == "2" && target == "1" {
+			help = "send errors to the same place as output"
+		}
+		w.add(Part{Text: w.src[r.Pos().Offset():r.End().Offset()], Kind: KindRedirect, Help: help})
+	}
+	if s.Background {
+		w.add(Part{Text: "&", Kind: KindOperator, Help: "run the command in the background"})
+	}
+}
+
+// nested explains the simple commands inside a compound one
+func (w *walker) nested(node syntax.Node) {
+	syntax.Walk(node, func(n syntax.Node) bool {
+		if s, ok := n.(*syntax.Stmt); ok {
+			if _, simple := s.Cmd.(*syntax.CallExpr); simple {
+				w.stmt(s)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (w *walker) call(call *syntax.CallExpr) {
+	for _, a := range call.Assigns {
+		name := ""
+		if a.Name != nil {
+			name = a.Name.Value
+		}
+		help := "set " + name + " for this command"
+		if len(call.Args) == 0 {
+			help = "set the shell variable " + name
+		}
+		w.add(Part{Text: w.text(a), Kind: KindAssignment, Help: help})
+	}
+
+	args := call.Args
+	for len(args) > 0 {
+		program := unquote(w.text(args[0]))
+		d := lookupDoc(program)
+		part := Part{Text: w.text(args[0]), Kind: KindProgram}
+		if d != nil {
+			part.Help, part.Source = d.Summary, d.Source
+		}
+		w.add(part)
+		// A wrapper's command follows its flags
+		args = w.args(program, args[1:], d)
+	}
+
+	for _, arg := range call.Args {
+		syntax.Walk(arg, func(n syntax.Node) bool {
+			if cs, ok := n.(*syntax.CmdSubst); ok {
+				for _, inner := range cs.Stmts {
+					w.stmt(inner)
+				}
+				return false
+			}
+			return true
+		})
+	}
+}
+
+// args annotates a program's arguments. For a wrapper it stops at the first
+// argument that isn't a flag, returning the command it wraps.
+func (w *walker) args(program string, args []*syntax.Word, d *doc) []*syntax.Word {
+	wrapper := wrappers[program]
+	var sub *doc
+	positional := false
+	endOfFlags := false
+	for i := 0; i < len(args); i++ {
+		text := w.text(args[i])
+		value := unquote(text)
+
+		if value == "--" && !endOfFlags {
+			endOfFlags = true
+			w.add(Part{Text: text, Kind: KindOperator, Help: "end of options; what follows are arguments"})
+			continue
+		}
+		if endOfFlags || !strings.HasPrefix(value, "-") || value == "-" {
+			if wrapper {
+				if strings.Contains(value, "=") {
+					w.add(Part{Text: text, Kind: KindAssignment, Help: "set " + strings.SplitN(value, "=", 2)[0] + " for the command"})
+					continue
+				}
+				return args[i:]
+			}
+			// git commit, docker run: the first argument may be a
+			// subcommand with its own man page
+			if !positional && d != nil && strings.HasPrefix(d.Source, "man ") && isWord(value) {
+				if sub = lookupDoc(program + "-" + value); sub != nil {
+					w.add(Part{Text: text, Kind: KindSubcommand, Help: sub.Summary, Source: sub.Source})
+					positional = true
+					continue
+				}
+			}
+			positional = true
+			w.add(Part{Text: text, Kind: KindArgument})
+			continue
+		}
+
+		help, source, takesValue := flagHelp(value, sub, d)
+		if d == nil && wrapper && slices.Contains(wrapperValues[program], value) {
+			takesValue = true
+		}
+		if takesValue && i+1 < len(args) {
+			i++
+			text += " " + w.text(args[i])
+		}
+		w.add(Part{Text: text, Kind: KindFlag, Help: help, Source: source})
+	}
+	return nil
+}
+
+// flagHelp finds a flag in the subcommand's documentation, then the
+// program's. Combined short flags like -la are explained letter by letter.
+func flagHelp(flag string, docs ...*doc) (string, string, bool) {
+	name := flag
+	attached := false
+	if before, _, ok := strings.Cut(flag, "="); ok {
+		name, attached = before, true
+	}
+
+	for _, d := range docs {
+		if d == nil {
+			continue
+		}
+		if opt, ok := d.Options[name]; ok {
+			return opt.Help, d.Source, opt.TakesValue && !attached
+		}
+		if strings.HasPrefix(name, "--") || len(name) <= 2 {
+			continue
+		}
+
+		// -n5: a short flag with its value attached
+		if opt, ok := d.Options[name[:2]]; ok && opt.TakesValue {
+			return opt.Help, d.Source, false
+		}
+		// -la: short flags combined, the last of which may take a value
+		var helps []string
+		takesValue := false
+		for _, r := range name[1:] {
+			opt, ok := d.Options["-"+string(r)]
+			if !ok {
+				helps = nil
+				break
+			}
+			helps = append(helps, fmt.Sprintf("-%c: %s", r, opt.Help))
+			takesValue = opt.TakesValue
+		}
+		if len(helps) > 0 {
+			return strings.Join(helps, "; "), d.Source, takesValue
+		}
+	}
+	return "", "", false
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func isWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
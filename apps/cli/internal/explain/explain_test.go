@@ -0,0 +1,230 @@
+package explain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+const lsHelp = `Usage: ls [OPTION]... [FILE]...
+List information about the FILEs (the current directory by default).
+Sort entries alphabetically if none of -cftuvSUX nor --sort is specified.
+
+Mandatory arguments to long options are mandatory for short options too.
+  -a, --all                  do not ignore entries starting with .
+      --color[=WHEN]         color the output WHEN; more info below
+  -I, --ignore=PATTERN       do not list implied entries matching shell
+                               PATTERN
+  -l                         use a long listing format
+`
+
+const gitCommitMan = `GIT-COMMIT(1)                Git Manual                GIT-COMMIT(1)
+
+NAME
+       git-commit - Record changes to the repository
+
+OPTIONS
+       -a, --all
+           Automatically stage files that have been modified and deleted, but
+           new files you have not told Git about are not affected.
+
+       -m <msg>, --message=<msg>
+           Use the given <msg> as the commit message. If multiple -m options
+           are given, their values are concatenated as separate paragraphs.
+`
+
+const gitMan = `NAME
+       git - the stupid content tracker
+
+OPTIONS
+       -C <path>
+           Run as if git was started in <path> instead of the current working
+           directory.
+`
+
+func stubDocs(t *testing.T, docs map[string]string) {
+	t.Helper()
+	old := lookupDoc
+	t.Cleanup(func() { lookupDoc = old })
+	lookupDoc = func(name string) *doc {
+		text, ok := docs[name]
+		if !ok {
+			return nil
+		}
+		d := parseDoc(text)
+		d.Source = "man " + name
+		return d
+	}
+}
+
+func TestParseDoc(t *testing.T) {
+	d := parseDoc(lsHelp)
+	if d.Summary != "List information about the FILEs (the current directory by default)" {
+		t.Errorf("Summary = %q", d.Summary)
+	}
+	want := map[string]option{
+		"-a":       {Help: "do not ignore entries starting with ."},
+		"--all":    {Help: "do not ignore entries starting with ."},
+		"--color":  {Help: "color the output WHEN; more info below"},
+		"-I":       {Help: "do not list implied entries matching shell PATTERN", TakesValue: true},
+		"--ignore": {Help: "do not list implied entries matching shell PATTERN", TakesValue: true},
+		"-l":       {Help: "use a long listing format"},
+	}
+	for name, opt := range want {
+		if got := d.Options[name]; got != opt {
+			t.Errorf("Options[%q] = %+v, want %+v", name, got, opt)
+		}
+	}
+
+	d = parseDoc(gitCommitMan)
+	if d.Summary != "Record changes to the repository" {
+		t.Errorf("Summary = %q", d.Summary)
+	}
+	if opt := d.Options["-m"]; opt.Help != "Use the given <msg> as the commit message" || !opt.TakesValue {
+		t.Errorf("Options[-m] = %+v", opt)
+	}
+	if opt := d.Options["-a"]; !strings.HasPrefix(opt.Help, "Automatically stage files") || opt.TakesValue {
+		t.Errorf("Options[-a] = %+v", opt)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	stubDocs(t, map[string]string{"ls": lsHelp, "git": gitMan, "git-commit": gitCommitMan})
+
+	e, err := Command(`git -C repo commit -am "fix it" && ls -la --color=auto 2>/dev/null | frob --wat`)
+	if err != nil {
+		t.Fatalf("Command() failed: %v", err)
+	}
+
+	want := []struct{ text, kind, help string }{
+		{"git", KindProgram, "the stupid content tracker"},
+		{"-C repo", KindFlag, "Run as if git was started in <path> instead of the current working directory"},
+		{"commit", KindSubcommand, "Record changes to the repository"},
+		{`-am "fix it"`, KindFlag, "-a: Automatically stage files that have been modified and deleted, but new files you have not told Git about are not affected; -m: Use the given <msg> as the commit message"},
+	}
+	for i, w := range want {
+		p := e.Parts[i]
+		if p.Text != w.text || p.Kind != w.kind || p.Help != w.help {
+			t.Errorf("Parts[%d] = %+v, want %+v", i, p, w)
+		}
+	}
+
+	byText := map[string]Part{}
+	for _, p := range e.Parts {
+		byText[p.Text] = p
+	}
+	checks := map[string]string{
+		"&&":           "run the next command only if this one succeeds",
+		"-la":          "-l: use a long listing format; -a: do not ignore entries starting with .",
+		"--color=auto": "color the output WHEN; more info below",
+		"2>/dev/null":  "write errors to /dev/null, replacing its contents",
+		"|":            "send this command's output to the next one's input",
+	}
+	for text, help := range checks {
+		if got := byText[text].Help; got != help {
+			t.Errorf("help for %q = %q, want %q", text, got, help)
+		}
+	}
+
+	unknown := e.Unknown()
+	if strings.Join(unknown, " ") != "frob --wat" {
+		t.Errorf("Unknown() = %q, want [frob --wat]", unknown)
+	}
+}
+
+func TestCommandSubcommand(t *testing.T) {
+	stubDocs(t, map[string]string{"git": gitMan, "git-commit": gitCommitMan})
+
+	e, err := Command(`git commit -m "first" --all`)
+	if err != nil {
+		t.Fatalf("Command() failed: %v", err)
+	}
+	if len(e.Parts) != 4 {
+		t.Fatalf("Parts = %+v, want 4", e.Parts)
+	}
+	if p := e.Parts[1]; p.Kind != KindSubcommand || p.Help != "Record changes to the repository" || p.Source != "man git-commit" {
+		t.Errorf("subcommand = %+v", p)
+	}
+	if p := e.Parts[2]; p.Text != `-m "first"` || p.Kind != KindFlag {
+		t.Errorf("flag with value = %+v", p)
+	}
+	if got := e.Sources(); strings.Join(got, ",") != "man git,man git-commit" {
+		t.Errorf("Sources() = %q", got)
+	}
+}
+
+func TestCommandWrapper(t *testing.T) {
+	stubDocs(t, map[string]string{"ls": lsHelp})
+
+	e, err := Command("sudo -u root ls -l")
+	if err != nil {
+		t.Fatalf("Command() failed: %v", err)
+	}
+	var kinds []string
+	for _, p := range e.Parts {
+		kinds = append(kinds, p.Text+"="+p.Kind)
+	}
+	if got := strings.Join(kinds, " "); got != "sudo=program -u root=flag ls=program -l=flag" {
+		t.Errorf("parts = %s", got)
+	}
+}
+
+func TestCommandInvalid(t *testing.T) {
+	if _, err := Command("echo 'unterminated"); err == nil {
+		t.Error("Command() accepted an unterminated quote")
+	}
+}
+
+func TestHelpOutputSkipsUserPrograms(t *testing.T) {
+	dir := testutil.TempDir(t)
+	marker := filepath.Join(dir, "ran")
+	script := "#!/bin/sh\ntouch " + marker + "\nprintf 'Usage: tool\\n  -a  all\\n  -b  brief\\n  -c  count\\n'\n"
+	if err := os.WriteFile(filepath.Join(dir, "mako-test-tool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if systemProgram("mako-test-tool") {
+		t.Error("Expected a program outside the system directories not to count as one")
+	}
+	if text := helpOutput("mako-test-tool"); text != "" {
+		t.Errorf("Expected no --help output, got %q", text)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Program outside the system directories was run")
+	}
+}
+
+func TestCommandSubcommandFromManPages(t *testing.T) {
+	// man only reads pages, so programs that aren't installed in a system
+	// directory, and subcommands that aren't programs at all, are looked up
+	dir := testutil.TempDir(t)
+	pages := map[string]string{"mako-test-git": gitMan, "mako-test-git-commit": gitCommitMan}
+	for name, text := range pages {
+		if err := os.WriteFile(filepath.Join(dir, name+".1"), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := "#!/bin/sh\n[ -f " + dir + "/\"$1\".1 ] || exit 16\ncat " + dir + "/\"$1\".1\n"
+	if err := os.WriteFile(filepath.Join(dir, "man"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	e, err := Command(`mako-test-git commit -m "first"`)
+	if err != nil {
+		t.Fatalf("Command() failed: %v", err)
+	}
+	if len(e.Parts) != 3 {
+		t.Fatalf("Parts = %+v, want 3", e.Parts)
+	}
+	if p := e.Parts[0]; p.Help != "the stupid content tracker" || p.Source != "man mako-test-git" {
+		t.Errorf("program = %+v", p)
+	}
+	if p := e.Parts[1]; p.Kind != KindSubcommand || p.Help != "Record changes to the repository" || p.Source != "man mako-test-git-commit" {
+		t.Errorf("subcommand = %+v", p)
+	}
+}
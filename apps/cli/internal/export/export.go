@@ -7,22 +7,45 @@ import (
 	"time"
 
 	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/safety"
 )
 
+// redactor masks exports more thoroughly than history is masked on insert:
+// an export leaves the machine, and a masked identifier costs little there
+var redactor = safety.NewValidator()
+
 // ExportOptions configures what to export
 type ExportOptions struct {
 	Last         int       // Last N commands
-	Semantic     string    // Semantic search query
+	Semantic     string    // Semantic search query (needs an Embedder)
 	DateFrom     time.Time // Start date
 	DateTo       time.Time // End date
 	WorkingDir   string    // Filter by directory
 	SuccessOnly  bool      // Only successful commands
 	FailedOnly   bool      // Only failed commands
+	Unredacted   bool      // Skip secret redaction (redacted by default)
+	Format       Format    // Output format (JSON by default)
+	AfterID      int64     // Only commands stored after this ID
+	MaxID        int64     // Only commands up to this ID
+	LocalOnly    bool      // Skip commands synced from other devices
+}
+
+// Semantic selection considers this many of the closest commands before
+// the other filters apply
+const (
+	semanticCandidates = 1000
+	semanticThreshold  = 0.5
+)
+
+// Embedder generates the query embedding for semantic exports
+type Embedder interface {
+	GenerateEmbedding(text string) ([]byte, error)
 }
 
 // Exporter handles command history export
 type Exporter struct {
-	db *database.DB
+	db       *database.DB
+	embedder Embedder
 }
 
 // NewExporter creates a new exporter
@@ -30,9 +53,36 @@ func NewExporter(db *database.DB) *Exporter {
 	return &Exporter{db: db}
 }
 
-// Export writes commands to the writer in JSON format
+// SetEmbedder enables semantic selection with ExportOptions.Semantic
+func (e *Exporter) SetEmbedder(embedder Embedder) {
+	e.embedder = embedder
+}
+
+// Export writes commands to the writer in the requested format
 func (e *Exporter) Export(w io.Writer, opts ExportOptions) error {
-	commands, err := e.fetchCommands(opts)
+	switch opts.Format {
+	case "", FormatJSON:
+		return e.writeJSON(w, opts)
+	case FormatNDJSON:
+		return e.writeNDJSON(w, opts)
+	case FormatCSV:
+		return e.writeCSV(w, opts)
+	case FormatShell:
+		return e.writeShell(w, opts)
+	case FormatMarkdown:
+		return e.writeMarkdown(w, opts)
+	default:
+		return fmt.Errorf("unknown export format: %s", opts.Format)
+	}
+}
+
+// writeJSON writes a single pretty-printed JSON document
+func (e *Exporter) writeJSON(w io.Writer, opts ExportOptions) error {
+	query, err := e.buildQuery(opts)
+	if err != nil {
+		return err
+	}
+	commands, err := e.db.QueryCommands(query)
 	if err != nil {
 		return fmt.Errorf("failed to fetch commands: %w", err)
 	}
@@ -45,14 +95,7 @@ func (e *Exporter) Export(w io.Writer, opts ExportOptions) error {
 	}
 
 	for _, cmd := range commands {
-		exportData.Commands = append(exportData.Commands, ExportedCommand{
-			Command:       cmd.Command,
-			Timestamp:     cmd.Timestamp,
-			ExitCode:      cmd.ExitCode,
-			DurationMS:    cmd.Duration,
-			WorkingDir:    cmd.WorkingDir,
-			OutputPreview: cmd.OutputPreview,
-		})
+		exportData.Commands = append(exportData.Commands, toExported(cmd, opts))
 	}
 
 	// Encode to JSON with pretty printing
@@ -66,101 +109,86 @@ func (e *Exporter) Export(w io.Writer, opts ExportOptions) error {
 	return nil
 }
 
-// fetchCommands retrieves commands based on options
-func (e *Exporter) fetchCommands(opts ExportOptions) ([]database.Command, error) {
-	// Handle semantic search
-	if opts.Semantic != "" {
-		// This would require embedding generation and semantic search
-		// For now, fall back to FTS search
-		return e.db.SearchCommands(opts.Semantic, 10000)
+// each calls fn for every exported command, oldest first, streaming them
+// from the database rather than loading them up front
+func (e *Exporter) each(opts ExportOptions, fn func(ExportedCommand) error) error {
+	query, err := e.buildQuery(opts)
+	if err != nil {
+		return err
 	}
+	query.OldestFirst = true
 
-	// Determine the limit
-	limit := 10000
-	if opts.Last > 0 {
-		limit = opts.Last
+	err = e.db.EachCommand(query, func(cmd database.Command) error {
+		return fn(toExported(cmd, opts))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch commands: %w", err)
 	}
+	return nil
+}
 
-	// Handle success/failed filter
-	if opts.SuccessOnly {
-		return e.db.GetCommandsByExitCode(true, limit)
+// toExported converts a database command, redacting it unless asked not to
+func toExported(cmd database.Command, opts ExportOptions) ExportedCommand {
+	if !opts.Unredacted {
+		cmd.Command = redactor.RedactSecretsThorough(cmd.Command)
+		cmd.OutputPreview = redactor.RedactSecretsThorough(cmd.OutputPreview)
 	}
-	if opts.FailedOnly {
-		return e.db.GetCommandsByExitCode(false, limit)
+	return ExportedCommand{
+		Command:       cmd.Command,
+		Timestamp:     cmd.Timestamp,
+		ExitCode:      cmd.ExitCode,
+		DurationMS:    cmd.Duration,
+		WorkingDir:    cmd.WorkingDir,
+		OutputPreview: cmd.OutputPreview,
+		Hostname:      cmd.Hostname,
+		Session:       cmd.Session,
 	}
+}
 
-	// Handle directory filter
-	if opts.WorkingDir != "" {
-		// Get recent commands and filter by directory
-		allCommands, err := e.db.GetRecentCommands(limit * 2) // Get more to ensure enough after filtering
-		if err != nil {
-			return nil, err
-		}
-		var filtered []database.Command
-		for _, cmd := range allCommands {
-			if cmd.WorkingDir == opts.WorkingDir {
-				filtered = append(filtered, cmd)
-				if len(filtered) >= limit {
-					break
-				}
-			}
-		}
-		return filtered, nil
+// buildQuery combines every option into a single database query
+func (e *Exporter) buildQuery(opts ExportOptions) (database.CommandQuery, error) {
+	query := database.CommandQuery{
+		WorkingDir:  opts.WorkingDir,
+		SuccessOnly: opts.SuccessOnly,
+		FailedOnly:  opts.FailedOnly,
+		From:        opts.DateFrom,
+		To:          opts.DateTo,
+		Limit:       opts.Last,
+		AfterID:     opts.AfterID,
+		MaxID:       opts.MaxID,
+		LocalOnly:   opts.LocalOnly,
 	}
 
-	// Handle date range
-	if !opts.DateFrom.IsZero() || !opts.DateTo.IsZero() {
-		return e.fetchByDateRange(opts.DateFrom, opts.DateTo)
+	if opts.Semantic != "" {
+		ids, err := e.semanticMatches(opts.Semantic)
+		if err != nil {
+			return query, err
+		}
+		query.IDs = ids
 	}
 
-	// Default: recent commands
-	return e.db.GetRecentCommands(limit)
+	return query, nil
 }
 
-// fetchByDateRange retrieves commands within a date range
-func (e *Exporter) fetchByDateRange(from, to time.Time) ([]database.Command, error) {
-	query := `
-		SELECT id, command, timestamp, exit_code, duration_ms, working_dir, output_preview
-		FROM commands
-		WHERE 1=1
-	`
-	args := []interface{}{}
-
-	if !from.IsZero() {
-		query += " AND timestamp >= ?"
-		args = append(args, from)
+// semanticMatches returns the IDs of the commands closest in meaning to text
+func (e *Exporter) semanticMatches(text string) ([]int64, error) {
+	if e.embedder == nil {
+		return nil, fmt.Errorf("semantic export needs an embedding provider")
 	}
 
-	if !to.IsZero() {
-		query += " AND timestamp <= ?"
-		args = append(args, to)
+	embedding, err := e.embedder.GenerateEmbedding(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
 	}
 
-	query += " ORDER BY timestamp DESC LIMIT 10000"
-
-	rows, err := e.db.GetConn().Query(query, args...)
+	matches, err := e.db.SearchCommandsSemantic(text, embedding, semanticCandidates, semanticThreshold)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var commands []database.Command
-	for rows.Next() {
-		var cmd database.Command
-		err := rows.Scan(
-			&cmd.ID,
-			&cmd.Command,
-			&cmd.Timestamp,
-			&cmd.ExitCode,
-			&cmd.Duration,
-			&cmd.WorkingDir,
-			&cmd.OutputPreview,
-		)
-		if err != nil {
-			continue
-		}
-		commands = append(commands, cmd)
+		return nil, fmt.Errorf("semantic search failed: %w", err)
 	}
 
-	return commands, nil
+	ids := make([]int64, 0, len(matches))
+	for _, cmd := range matches {
+		ids = append(ids, cmd.ID)
+	}
+	return ids, nil
 }
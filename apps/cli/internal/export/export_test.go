@@ -2,9 +2,12 @@ package export
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -78,6 +81,33 @@ func TestExportBasic(t *testing.T) {
 	}
 }
 
+func TestExportRedactsByDefault(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Row stored before redaction on insert existed
+	db.GetConn().Exec(`INSERT INTO commands (command, timestamp, working_dir, output_preview) VALUES (?, ?, ?, ?)`,
+		"export API_KEY=supersecretvalue", time.Now(), "/home/user", "password=hunter2")
+
+	exporter := NewExporter(db)
+
+	var buf bytes.Buffer
+	if err := exporter.Export(&buf, ExportOptions{Last: 10}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("supersecretvalue")) || bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+		t.Errorf("Expected secrets to be redacted, got %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := exporter.Export(&buf, ExportOptions{Last: 10, Unredacted: true}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("supersecretvalue")) {
+		t.Error("Expected raw export with Unredacted option")
+	}
+}
+
 func TestExportWithFilters(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -182,6 +212,95 @@ func TestExportByWorkingDir(t *testing.T) {
 	}
 }
 
+func TestExportCombinedFilters(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	
+	now := time.Now()
+	commands := []database.Command{
+		{Command: "make", WorkingDir: "/src", ExitCode: 0, Timestamp: now.Add(-48 * time.Hour)},
+		{Command: "make test", WorkingDir: "/src", ExitCode: 2, Timestamp: now.Add(-time.Hour)},
+		{Command: "make lint", WorkingDir: "/src", ExitCode: 1, Timestamp: now.Add(-time.Minute)},
+		{Command: "ls missing", WorkingDir: "/tmp", ExitCode: 1, Timestamp: now.Add(-time.Minute)},
+	}
+	for _, cmd := range commands {
+		db.SaveCommand(cmd)
+	}
+	
+	// Directory, exit status and date range all apply together
+	var buf bytes.Buffer
+	err := NewExporter(db).Export(&buf, ExportOptions{
+		WorkingDir: "/src",
+		FailedOnly: true,
+		DateFrom:   now.Add(-2 * time.Hour),
+		Last:       10,
+	})
+	if err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	
+	var exportData ExportFormat
+	json.Unmarshal(buf.Bytes(), &exportData)
+	
+	if len(exportData.Commands) != 2 {
+		t.Fatalf("Expected 2 commands, got %+v", exportData.Commands)
+	}
+	if exportData.Commands[0].Command != "make lint" || exportData.Commands[1].Command != "make test" {
+		t.Errorf("Unexpected commands: %+v", exportData.Commands)
+	}
+}
+
+// fakeEmbedder returns fixed two-dimensional embeddings
+type fakeEmbedder map[string][2]float32
+
+func (f fakeEmbedder) GenerateEmbedding(text string) ([]byte, error) {
+	return vector(f[text]), nil
+}
+
+func vector(v [2]float32) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, math.Float32bits(v[0]))
+	binary.LittleEndian.PutUint32(buf[4:], math.Float32bits(v[1]))
+	return buf
+}
+
+func TestExportSemantic(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	
+	commands := []database.Command{
+		{Command: "git commit -m wip", Embedding: vector([2]float32{1, 0}), ExitCode: 0},
+		{Command: "git push --force", Embedding: vector([2]float32{0.9, 0.1}), ExitCode: 1},
+		{Command: "npm install", Embedding: vector([2]float32{0, 1}), ExitCode: 1},
+	}
+	for _, cmd := range commands {
+		cmd.Timestamp = time.Now()
+		cmd.EmbeddingStatus = "completed"
+		db.SaveCommand(cmd)
+	}
+	
+	exporter := NewExporter(db)
+	if err := exporter.Export(&bytes.Buffer{}, ExportOptions{Semantic: "version control"}); err == nil {
+		t.Error("Expected error without an embedder")
+	}
+	
+	exporter.SetEmbedder(fakeEmbedder{"version control": {1, 0}})
+	
+	// Semantic selection combines with the other filters
+	var buf bytes.Buffer
+	err := exporter.Export(&buf, ExportOptions{Semantic: "version control", FailedOnly: true})
+	if err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	
+	var exportData ExportFormat
+	json.Unmarshal(buf.Bytes(), &exportData)
+	
+	if len(exportData.Commands) != 1 || exportData.Commands[0].Command != "git push --force" {
+		t.Errorf("Expected only the failed git command, got %+v", exportData.Commands)
+	}
+}
+
 func TestImportBasic(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -439,6 +558,180 @@ func TestImportStats(t *testing.T) {
 	}
 }
 
+func saveFormatFixtures(t *testing.T, db *database.DB) {
+	t.Helper()
+	base := time.Now().Add(-time.Hour)
+	
+	db.SaveCommand(database.Command{
+		Command:       "make build",
+		Timestamp:     base,
+		Duration:      1500,
+		WorkingDir:    "/home/user/it's here",
+		OutputPreview: "ok",
+	})
+	db.SaveCommand(database.Command{
+		Command:    "echo \"a,b\"",
+		Timestamp:  base.Add(time.Minute),
+		ExitCode:   2,
+		WorkingDir: "/tmp",
+	})
+}
+
+func TestExportFormatsRoundtrip(t *testing.T) {
+	for _, format := range []Format{FormatNDJSON, FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			db1 := setupTestDB(t)
+			defer db1.Close()
+			saveFormatFixtures(t, db1)
+			
+			var buf bytes.Buffer
+			if err := NewExporter(db1).Export(&buf, ExportOptions{Format: format}); err != nil {
+				t.Fatalf("Export() failed: %v", err)
+			}
+			
+			db2 := setupTestDB(t)
+			defer db2.Close()
+			
+			// Format is detected from the content
+			stats, err := NewImporter(db2).Import(&buf, ImportOptions{ConflictStrategy: ConflictSkip})
+			if err != nil {
+				t.Fatalf("Import() failed: %v", err)
+			}
+			if stats.ImportedNew != 2 || len(stats.Errors) != 0 {
+				t.Fatalf("Expected 2 imported without errors, got %+v", stats)
+			}
+			
+			commands, _ := db2.GetRecentCommands(10)
+			if len(commands) != 2 {
+				t.Fatalf("Expected 2 commands, got %d", len(commands))
+			}
+			if commands[0].Command != "echo \"a,b\"" || commands[0].ExitCode != 2 {
+				t.Errorf("Unexpected newest command: %+v", commands[0])
+			}
+			if commands[1].Duration != 1500 || commands[1].WorkingDir != "/home/user/it's here" {
+				t.Errorf("Metadata not preserved: %+v", commands[1])
+			}
+		})
+	}
+}
+
+func TestExportImportKeepsHostAndSession(t *testing.T) {
+	for _, format := range []Format{FormatNDJSON, FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			db1 := setupTestDB(t)
+			defer db1.Close()
+			db1.GetConn().Exec(`INSERT INTO commands (command, timestamp, working_dir, output_preview, hostname, session) VALUES (?, ?, ?, ?, ?, ?)`,
+				"make deploy", time.Now(), "/srv", "", "buildbox", "atuin-42")
+
+			var buf bytes.Buffer
+			if err := NewExporter(db1).Export(&buf, ExportOptions{Format: format}); err != nil {
+				t.Fatalf("Export() failed: %v", err)
+			}
+
+			db2 := setupTestDB(t)
+			defer db2.Close()
+			if _, err := NewImporter(db2).Import(&buf, ImportOptions{ConflictStrategy: ConflictSkip}); err != nil {
+				t.Fatalf("Import() failed: %v", err)
+			}
+
+			commands, err := db2.QueryCommands(database.CommandQuery{})
+			if err != nil {
+				t.Fatalf("QueryCommands() failed: %v", err)
+			}
+			if len(commands) != 1 || commands[0].Hostname != "buildbox" || commands[0].Session != "atuin-42" {
+				t.Errorf("Expected hostname and session to survive the round trip, got %+v", commands)
+			}
+		})
+	}
+}
+
+func TestExportNDJSONLines(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	saveFormatFixtures(t, db)
+	
+	var buf bytes.Buffer
+	if err := NewExporter(db).Export(&buf, ExportOptions{Format: FormatNDJSON}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	
+	// Oldest first
+	var first ExportedCommand
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.Command != "make build" {
+		t.Errorf("Unexpected first line %q: %v", lines[0], err)
+	}
+}
+
+func TestExportShellScript(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	saveFormatFixtures(t, db)
+	
+	var buf bytes.Buffer
+	if err := NewExporter(db).Export(&buf, ExportOptions{Format: FormatShell}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	script := buf.String()
+	
+	if !strings.HasPrefix(script, "#!/bin/sh\n") {
+		t.Error("Expected shebang")
+	}
+	if !strings.Contains(script, `cd '/home/user/it'\''s here' || exit 1`) {
+		t.Errorf("Expected quoted cd, got:\n%s", script)
+	}
+	if strings.Index(script, "make build") > strings.Index(script, "cd '/tmp'") {
+		t.Error("Expected commands in the order they were run")
+	}
+	if !strings.Contains(script, "(exited with 2)") {
+		t.Error("Expected failed command to be marked")
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	saveFormatFixtures(t, db)
+	
+	var buf bytes.Buffer
+	if err := NewExporter(db).Export(&buf, ExportOptions{Format: FormatMarkdown}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	doc := buf.String()
+	
+	for _, want := range []string{"## /home/user/it's here", "## /tmp", "```sh\nmake build\n```", "```text\nok\n```", "exit 2"} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("Expected %q in runbook:\n%s", want, doc)
+		}
+	}
+}
+
+func TestImportCSVRequiresColumns(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	
+	_, err := NewImporter(db).Import(strings.NewReader("cmd,when\nls,now\n"), ImportOptions{ConflictStrategy: ConflictSkip})
+	if err == nil {
+		t.Error("Expected error for CSV without command and timestamp columns")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if format, err := ParseFormat("markdown"); err != nil || format != FormatMarkdown {
+		t.Errorf("ParseFormat(markdown) = %v, %v", format, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	if FormatForFile("history.jsonl") != FormatNDJSON {
+		t.Error("Expected NDJSON for .jsonl")
+	}
+}
+
 func BenchmarkExport(b *testing.B) {
 	tmpDir, _ := os.MkdirTemp("", "mako-bench-*")
 	defer os.RemoveAll(tmpDir)
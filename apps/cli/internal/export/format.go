@@ -1,6 +1,9 @@
 package export
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -19,6 +22,48 @@ type ExportedCommand struct {
 	DurationMS   int64     `json:"duration_ms"`
 	WorkingDir   string    `json:"working_dir"`
 	OutputPreview string   `json:"output_preview,omitempty"`
+	Hostname     string    `json:"hostname,omitempty"`
+	Session      string    `json:"session,omitempty"`
 }
 
 const CurrentVersion = "1.0"
+
+// Format is an export file format
+type Format string
+
+const (
+	FormatJSON     Format = "json"   // Single JSON document (default)
+	FormatNDJSON   Format = "ndjson" // One JSON command per line, streamed
+	FormatCSV      Format = "csv"    // Spreadsheet friendly
+	FormatShell    Format = "sh"     // Replayable shell script
+	FormatMarkdown Format = "md"     // Runbook grouped by directory and session
+)
+
+// Formats lists the supported export formats
+var Formats = []Format{FormatJSON, FormatNDJSON, FormatCSV, FormatShell, FormatMarkdown}
+
+// ParseFormat accepts a format name or common alias
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "", "json":
+		return FormatJSON, nil
+	case "ndjson", "jsonl":
+		return FormatNDJSON, nil
+	case "csv":
+		return FormatCSV, nil
+	case "sh", "shell", "bash":
+		return FormatShell, nil
+	case "md", "markdown":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown export format: %s (supported: json, ndjson, csv, sh, md)", name)
+}
+
+// FormatForFile guesses the format from a file extension, defaulting to JSON
+func FormatForFile(path string) Format {
+	format, err := ParseFormat(filepath.Ext(path))
+	if err != nil {
+		return FormatJSON
+	}
+	return format
+}
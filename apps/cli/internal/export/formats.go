@@ -0,0 +1,283 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader is the column order of CSV exports. Imports match columns by
+// name, so hand-edited files may reorder or drop optional ones.
+var csvHeader = []string{"timestamp", "command", "exit_code", "duration_ms", "working_dir", "hostname", "session", "output_preview"}
+
+// writeNDJSON writes each command as one JSON line as it is read
+func (e *Exporter) writeNDJSON(w io.Writer, opts ExportOptions) error {
+	encoder := json.NewEncoder(w)
+	return e.each(opts, func(cmd ExportedCommand) error {
+		return encoder.Encode(cmd)
+	})
+}
+
+func (e *Exporter) writeCSV(w io.Writer, opts ExportOptions) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	err := e.each(opts, func(cmd ExportedCommand) error {
+		return writer.Write([]string{
+			cmd.Timestamp.Format(time.RFC3339Nano),
+			cmd.Command,
+			strconv.Itoa(cmd.ExitCode),
+			strconv.FormatInt(cmd.DurationMS, 10),
+			cmd.WorkingDir,
+			cmd.Hostname,
+			cmd.Session,
+			cmd.OutputPreview,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeShell writes a script that replays the commands in order, changing
+// directory whenever the original working directory changed
+func (e *Exporter) writeShell(w io.Writer, opts ExportOptions) error {
+	fmt.Fprintf(w, "#!/bin/sh\n")
+	fmt.Fprintf(w, "# Mako history export, %s\n", time.Now().Format("2006-01-02 15:04"))
+	fmt.Fprintf(w, "# Review before running: failed commands do not stop the script.\n")
+
+	dir := ""
+	return e.each(opts, func(cmd ExportedCommand) error {
+		fmt.Fprintf(w, "\n# %s", cmd.Timestamp.Format("2006-01-02 15:04:05"))
+		if cmd.ExitCode != 0 {
+			fmt.Fprintf(w, " (exited with %d)", cmd.ExitCode)
+		}
+		fmt.Fprintln(w)
+
+		if cmd.WorkingDir != "" && cmd.WorkingDir != dir {
+			fmt.Fprintf(w, "cd %s || exit 1\n", shellQuote(cmd.WorkingDir))
+			dir = cmd.WorkingDir
+		}
+		_, err := fmt.Fprintln(w, cmd.Command)
+		return err
+	})
+}
+
+// writeMarkdown writes a runbook with a section for each run of commands in
+// the same directory and session, including their output
+func (e *Exporter) writeMarkdown(w io.Writer, opts ExportOptions) error {
+	fmt.Fprintf(w, "# Command history\n\n")
+	fmt.Fprintf(w, "Exported from Mako on %s.\n", time.Now().Format("2006-01-02 15:04"))
+
+	var section string
+	return e.each(opts, func(cmd ExportedCommand) error {
+		key := cmd.WorkingDir + "\x00" + cmd.Session
+		if key != section {
+			section = key
+			dir := cmd.WorkingDir
+			if dir == "" {
+				dir = "Unknown directory"
+			}
+			fmt.Fprintf(w, "\n## %s\n", dir)
+
+			var details []string
+			if cmd.Session != "" {
+				details = append(details, "session "+cmd.Session)
+			}
+			if cmd.Hostname != "" {
+				details = append(details, "on "+cmd.Hostname)
+			}
+			if len(details) > 0 {
+				fmt.Fprintf(w, "\n_%s_\n", strings.Join(details, ", "))
+			}
+		}
+
+		status := "ok"
+		if cmd.ExitCode != 0 {
+			status = fmt.Sprintf("exit %d", cmd.ExitCode)
+		}
+		fmt.Fprintf(w, "\n**%s** · %s", cmd.Timestamp.Format("2006-01-02 15:04:05"), status)
+		if cmd.DurationMS > 0 {
+			fmt.Fprintf(w, " · %s", time.Duration(cmd.DurationMS)*time.Millisecond)
+		}
+		fmt.Fprintln(w)
+
+		fence := codeFence(cmd.Command)
+		fmt.Fprintf(w, "\n%ssh\n%s\n%s\n", fence, cmd.Command, fence)
+
+		if output := strings.TrimRight(cmd.OutputPreview, "\n"); output != "" {
+			fence := codeFence(output)
+			fmt.Fprintf(w, "\n%stext\n%s\n%s\n", fence, output, fence)
+		}
+		return nil
+	})
+}
+
+// shellQuote quotes s for POSIX sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// codeFence returns a backtick fence longer than any run of backticks in s
+func codeFence(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// readCommands parses a JSON, NDJSON or CSV export, calling fn for each
+// command as it is read. An empty format is detected from the content.
+func readCommands(r io.Reader, format Format, fn func(ExportedCommand)) error {
+	reader := bufio.NewReader(r)
+	if format == "" {
+		format = detectFormat(reader)
+	}
+
+	switch format {
+	case FormatJSON, FormatNDJSON:
+		return readJSON(reader, fn)
+	case FormatCSV:
+		return readCSV(reader, fn)
+	default:
+		return fmt.Errorf("%s exports cannot be imported (use json, ndjson or csv)", format)
+	}
+}
+
+// detectFormat tells JSON from CSV by the first non-blank character
+func detectFormat(reader *bufio.Reader) Format {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return FormatJSON
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			reader.ReadByte()
+		case '{':
+			return FormatJSON
+		default:
+			return FormatCSV
+		}
+	}
+}
+
+// readJSON reads either a single export document or NDJSON lines
+func readJSON(r io.Reader, fn func(ExportedCommand)) error {
+	decoder := json.NewDecoder(r)
+
+	var first json.RawMessage
+	if err := decoder.Decode(&first); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	var document struct {
+		Commands json.RawMessage `json:"commands"`
+	}
+	if err := json.Unmarshal(first, &document); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	if document.Commands != nil {
+		var exportData ExportFormat
+		if err := json.Unmarshal(first, &exportData); err != nil {
+			return fmt.Errorf("failed to decode JSON: %w", err)
+		}
+		if exportData.Version != CurrentVersion {
+			return fmt.Errorf("unsupported export version: %s (expected %s)", exportData.Version, CurrentVersion)
+		}
+		for _, cmd := range exportData.Commands {
+			fn(cmd)
+		}
+		return nil
+	}
+
+	var cmd ExportedCommand
+	if err := json.Unmarshal(first, &cmd); err != nil {
+		return fmt.Errorf("failed to decode line 1: %w", err)
+	}
+	fn(cmd)
+
+	for line := 2; ; line++ {
+		var cmd ExportedCommand
+		err := decoder.Decode(&cmd)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode line %d: %w", line, err)
+		}
+		fn(cmd)
+	}
+}
+
+// readCSV reads a CSV export. Rows with an unparsable timestamp are passed
+// on with a zero time so validation reports them.
+func readCSV(r io.Reader, fn func(ExportedCommand)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, required := range []string{"command", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("CSV is missing the %q column", required)
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		cmd := ExportedCommand{
+			Command:       field("command"),
+			WorkingDir:    field("working_dir"),
+			Hostname:      field("hostname"),
+			Session:       field("session"),
+			OutputPreview: field("output_preview"),
+		}
+		cmd.Timestamp, _ = time.Parse(time.RFC3339Nano, field("timestamp"))
+		cmd.ExitCode, _ = strconv.Atoi(field("exit_code"))
+		cmd.DurationMS, _ = strconv.ParseInt(field("duration_ms"), 10, 64)
+		fn(cmd)
+	}
+}
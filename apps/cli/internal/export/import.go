@@ -1,7 +1,6 @@
 package export
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 
@@ -20,7 +19,9 @@ const (
 // ImportOptions configures import behavior
 type ImportOptions struct {
 	ConflictStrategy ConflictStrategy
-	DryRun           bool // Don't actually import, just validate
+	DryRun           bool   // Don't actually import, just validate
+	Format           Format // JSON, NDJSON or CSV; detected when empty
+	Origin           string // Sync device the commands came from, if any
 }
 
 // Importer handles command history import
@@ -42,43 +43,35 @@ type ImportResult struct {
 	Errors          []string
 }
 
-// Import reads commands from JSON and imports them
+// Import reads commands from a JSON, NDJSON or CSV export and imports them
 func (i *Importer) Import(r io.Reader, opts ImportOptions) (*ImportResult, error) {
-	// Decode JSON
-	var exportData ExportFormat
-	decoder := json.NewDecoder(r)
-	
-	if err := decoder.Decode(&exportData); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON: %w", err)
-	}
-
-	// Validate version
-	if exportData.Version != CurrentVersion {
-		return nil, fmt.Errorf("unsupported export version: %s (expected %s)", exportData.Version, CurrentVersion)
-	}
-
 	result := &ImportResult{
-		TotalCommands: len(exportData.Commands),
-		Errors:        make([]string, 0),
+		Errors: make([]string, 0),
 	}
 
-	// Process each command
-	for idx, exportCmd := range exportData.Commands {
+	// Process each command as it is read
+	err := readCommands(r, opts.Format, func(exportCmd ExportedCommand) {
+		idx := result.TotalCommands
+		result.TotalCommands++
+
 		// Validate command
 		if err := i.validateCommand(exportCmd); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("command %d: %v", idx, err))
-			continue
+			return
 		}
 
 		if opts.DryRun {
 			result.ImportedNew++
-			continue
+			return
 		}
 
 		// Import command
-		if err := i.importCommand(exportCmd, opts.ConflictStrategy, result); err != nil {
+		if err := i.importCommand(exportCmd, opts, result); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("command %d: %v", idx, err))
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return result, nil
@@ -101,7 +94,7 @@ func (i *Importer) validateCommand(cmd ExportedCommand) error {
 }
 
 // importCommand imports a single command
-func (i *Importer) importCommand(exportCmd ExportedCommand, strategy ConflictStrategy, result *ImportResult) error {
+func (i *Importer) importCommand(exportCmd ExportedCommand, opts ImportOptions, result *ImportResult) error {
 	// Convert to database command
 	cmd := database.Command{
 		Command:       exportCmd.Command,
@@ -110,9 +103,27 @@ func (i *Importer) importCommand(exportCmd ExportedCommand, strategy ConflictStr
 		Duration:      exportCmd.DurationMS,
 		WorkingDir:    exportCmd.WorkingDir,
 		OutputPreview: exportCmd.OutputPreview,
+		Hostname:      exportCmd.Hostname,
+		Session:       exportCmd.Session,
+		OriginDevice:  opts.Origin,
 	}
 
-	switch strategy {
+	// Synced commands are kept per run: another device's runs of a command
+	// are history too, and must not move last_used backwards
+	if opts.Origin != "" && opts.ConflictStrategy != ConflictOverwrite {
+		merged, _, err := i.db.MergeSynced([]database.Command{cmd})
+		if err != nil {
+			return err
+		}
+		if merged > 0 {
+			result.ImportedNew++
+		} else {
+			result.Skipped++
+		}
+		return nil
+	}
+
+	switch opts.ConflictStrategy {
 	case ConflictSkip:
 		// Try to save with deduplication
 		isNew, _, err := i.db.SaveCommandDeduplicated(cmd)
@@ -139,9 +150,10 @@ func (i *Importer) importCommand(exportCmd ExportedCommand, strategy ConflictStr
 
 	case ConflictOverwrite:
 		// Delete existing and insert new
-		hash := hashCommand(cmd.Command)
-		i.db.GetConn().Exec("DELETE FROM commands WHERE command_hash = ?", hash)
-		
+		if err := i.db.DeleteCommand(cmd.Command); err != nil {
+			return err
+		}
+
 		_, err := i.db.SaveCommandAsync(cmd)
 		if err != nil {
 			return err
@@ -149,16 +161,8 @@ func (i *Importer) importCommand(exportCmd ExportedCommand, strategy ConflictStr
 		result.ImportedNew++
 
 	default:
-		return fmt.Errorf("unknown conflict strategy: %s", strategy)
+		return fmt.Errorf("unknown conflict strategy: %s", opts.ConflictStrategy)
 	}
 
 	return nil
 }
-
-// hashCommand generates a hash for the command (helper)
-func hashCommand(command string) string {
-	// Simple implementation - would be better to share this with database/db.go
-	// For now, return the command itself as placeholder
-	// The actual hashing is done in SaveCommandDeduplicated
-	return command
-}
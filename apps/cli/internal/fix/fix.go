@@ -0,0 +1,409 @@
+// Package fix proposes corrections for a command that failed, from rules
+// that read the command and its output: mistyped programs and subcommands,
+// a missing sudo and git branch names that don't exist.
+package fix
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+)
+
+// Suggestion is a corrected command for a failed one
+type Suggestion struct {
+	Command string
+	Reason  string
+	Score   float64 // 0-1; higher ranks first
+}
+
+// word is a whitespace-separated word of a command and where it sits
+type word struct {
+	text       string
+	start, end int
+}
+
+// rule proposes fixes for a failed command given the output it printed
+type rule func(command string, words []word, output []string) []Suggestion
+
+var rules = []rule{commandTypo, subcommandTypo, missingSudo, gitBranch}
+
+// Suggest runs the rules over a failed command and its output and returns
+// the distinct fixes, best first
+func Suggest(command string, output []string) []Suggestion {
+	command = strings.TrimSpace(command)
+	words := splitWords(command)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var all []Suggestion
+	for _, r := range rules {
+		all = append(all, r(command, words, output)...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Score > all[j].Score })
+
+	seen := map[string]bool{command: true}
+	var ranked []Suggestion
+	for _, s := range all {
+		if !seen[s.Command] {
+			seen[s.Command] = true
+			ranked = append(ranked, s)
+		}
+	}
+	return ranked
+}
+
+// These are variables so tests can stand in for the system
+var (
+	pathExecutables = scanPath
+	listBranches    = gitBranches
+	commandExists   = ai.CheckCommandExists
+)
+
+// commandTypo fixes a program that isn't on $PATH by the closest names that are
+func commandTypo(command string, words []word, output []string) []Suggestion {
+	p := programIndex(words)
+	if p < 0 || ai.AnalyzeRecentOutput(output)["missing_command"] != "true" {
+		return nil
+	}
+	program := words[p].text
+	if strings.Contains(program, "/") || commandExists(program) {
+		return nil
+	}
+
+	var fixes []Suggestion
+	for _, m := range closest(program, pathExecutables(), 3) {
+		fixes = append(fixes, Suggestion{
+			Command: replaceWord(command, words[p], m.name),
+			Reason:  program + " is not installed; " + m.name + " is",
+			Score:   0.9 - 0.1*float64(m.distance-1),
+		})
+	}
+	return fixes
+}
+
+var (
+	unknownSubcommand = regexp.MustCompile(`(?i)(is not a \S+ command|unknown (sub)?command|no such (sub)?command|unrecognized (sub)?command|invalid choice|not a valid command)`)
+	didYouMean        = regexp.MustCompile(`(?i)(did you mean|most similar commands? (is|are))`)
+	quotedWord        = regexp.MustCompile("[`'\"]([A-Za-z0-9][A-Za-z0-9_.:-]*)[`'\"]")
+)
+
+// knownSubcommands covers tools whose subcommands aren't separate
+// executables on $PATH
+var knownSubcommands = map[string][]string{
+	"git":     {"add", "bisect", "blame", "branch", "checkout", "cherry-pick", "clean", "clone", "commit", "config", "diff", "fetch", "grep", "init", "log", "merge", "mv", "pull", "push", "rebase", "reflog", "remote", "reset", "restore", "revert", "rm", "show", "stash", "status", "switch", "tag", "worktree"},
+	"docker":  {"build", "compose", "exec", "images", "inspect", "kill", "login", "logs", "network", "ps", "pull", "push", "restart", "rm", "rmi", "run", "start", "stop", "system", "tag", "volume"},
+	"go":      {"build", "clean", "doc", "env", "fmt", "generate", "get", "install", "list", "mod", "run", "test", "tool", "version", "vet", "work"},
+	"npm":     {"audit", "ci", "config", "init", "install", "link", "list", "outdated", "publish", "run", "start", "test", "uninstall", "update"},
+	"cargo":   {"add", "bench", "build", "check", "clean", "clippy", "doc", "fmt", "init", "install", "new", "publish", "run", "test", "update"},
+	"kubectl": {"apply", "config", "create", "delete", "describe", "edit", "exec", "explain", "get", "logs", "patch", "port-forward", "rollout", "scale"},
+}
+
+// subcommandTypo fixes a subcommand the tool rejected, preferring the
+// tool's own "did you mean" over subcommands found on $PATH or known above
+func subcommandTypo(command string, words []word, output []string) []Suggestion {
+	p := programIndex(words)
+	if p < 0 || !unknownSubcommand.MatchString(strings.Join(output, "\n")) {
+		return nil
+	}
+	s := p + 1
+	for s < len(words) && strings.HasPrefix(words[s].text, "-") {
+		s++
+	}
+	if s >= len(words) {
+		return nil
+	}
+	program, sub := filepath.Base(words[p].text), words[s].text
+
+	var fixes []Suggestion
+	for i, name := range toolSuggestions(output) {
+		if name == sub {
+			continue
+		}
+		fixes = append(fixes, Suggestion{
+			Command: replaceWord(command, words[s], name),
+			Reason:  program + " suggests " + name,
+			Score:   0.95 - 0.05*float64(i),
+		})
+	}
+	if len(fixes) > 0 {
+		return fixes
+	}
+
+	candidates := append([]string(nil), knownSubcommands[program]...)
+	for _, name := range pathExecutables() {
+		if rest, ok := strings.CutPrefix(name, program+"-"); ok && rest != "" {
+			candidates = append(candidates, rest)
+		}
+	}
+	for _, m := range closest(sub, candidates, 3) {
+		fixes = append(fixes, Suggestion{
+			Command: replaceWord(command, words[s], m.name),
+			Reason:  sub + " is not a " + program + " subcommand",
+			Score:   0.8 - 0.1*float64(m.distance-1),
+		})
+	}
+	return fixes
+}
+
+// toolSuggestions collects the names a tool offers after "did you mean",
+// either quoted on the same line or indented on the lines below it
+func toolSuggestions(output []string) []string {
+	var names []string
+	for i, line := range output {
+		loc := didYouMean.FindStringIndex(line)
+		if loc == nil {
+			continue
+		}
+		for _, m := range quotedWord.FindAllStringSubmatch(line[loc[1]:], -1) {
+			names = append(names, m[1])
+		}
+		for _, next := range output[i+1:] {
+			fields := strings.Fields(next)
+			if len(fields) != 1 || !(strings.HasPrefix(next, " ") || strings.HasPrefix(next, "\t")) {
+				break
+			}
+			names = append(names, fields[0])
+		}
+		if len(names) > 0 {
+			break
+		}
+	}
+	return names
+}
+
+// missingSudo reruns with sudo what failed for lack of permission
+func missingSudo(command string, words []word, output []string) []Suggestion {
+	if words[0].text == "sudo" || ai.AnalyzeRecentOutput(output)["needs_sudo"] != "true" {
+		return nil
+	}
+	// sudo wouldn't let a script run that isn't executable
+	if p := programIndex(words); p >= 0 && strings.HasPrefix(words[p].text, "./") {
+		return nil
+	}
+	return []Suggestion{{
+		Command: "sudo " + command,
+		Reason:  "permission denied; run it as root",
+		Score:   0.85,
+	}}
+}
+
+var badRef = []*regexp.Regexp{
+	regexp.MustCompile(`pathspec '([^']+)' did not match`),
+	regexp.MustCompile(`invalid reference: (\S+)`),
+	regexp.MustCompile(`(?i)not a valid object name:? '?([^'\s]+?)'?\.?$`),
+	regexp.MustCompile(`couldn't find remote ref (\S+)`),
+	regexp.MustCompile(`src refspec (\S+) does not match any`),
+	regexp.MustCompile(`branch '([^']+)' not found`),
+}
+
+// gitBranch fixes a git command that named a branch that doesn't exist,
+// with the closest branch that does
+func gitBranch(command string, words []word, output []string) []Suggestion {
+	p := programIndex(words)
+	if p < 0 || filepath.Base(words[p].text) != "git" {
+		return nil
+	}
+
+	var ref string
+	for _, line := range output {
+		for _, re := range badRef {
+			if m := re.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+				ref = m[1]
+				break
+			}
+		}
+		if ref != "" {
+			break
+		}
+	}
+	if ref == "" {
+		return nil
+	}
+	target := -1
+	for i := len(words) - 1; i > p; i-- {
+		if words[i].text == ref {
+			target = i
+			break
+		}
+	}
+	if target < 0 {
+		return nil
+	}
+
+	branches := map[string]bool{}
+	var names []string
+	for _, b := range listBranches() {
+		for _, name := range []string{b, strings.TrimPrefix(b, "origin/")} {
+			if name != "origin/HEAD" && name != "origin" && !branches[name] {
+				branches[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	var fixes []Suggestion
+	add := func(name, reason string, score float64) {
+		fixes = append(fixes, Suggestion{Command: replaceWord(command, words[target], name), Reason: reason, Score: score})
+	}
+	for _, m := range closest(ref, names, 2) {
+		add(m.name, "no branch named "+ref, 0.9-0.1*float64(m.distance-1))
+	}
+	for _, pair := range [][2]string{{"main", "master"}, {"master", "main"}} {
+		if ref == pair[0] && branches[pair[1]] {
+			add(pair[1], "this repository's default branch is "+pair[1], 0.9)
+		}
+	}
+	for _, name := range names {
+		if name != ref && strings.HasSuffix(name, "/"+ref) {
+			add(name, "no branch named "+ref, 0.75)
+		}
+	}
+
+	// Perhaps the branch was meant to be created
+	if sub := words[p+1 : target]; len(sub) == 1 {
+		switch sub[0].text {
+		case "checkout":
+			fixes = append(fixes, Suggestion{Command: replaceWord(command, sub[0], "checkout -b"), Reason: "create " + ref, Score: 0.5})
+		case "switch":
+			fixes = append(fixes, Suggestion{Command: replaceWord(command, sub[0], "switch -c"), Reason: "create " + ref, Score: 0.5})
+		}
+	}
+	return fixes
+}
+
+// programIndex returns the index of the program being run, past sudo and
+// environment assignments, or -1
+func programIndex(words []word) int {
+	for i, w := range words {
+		if w.text == "sudo" || w.text == "env" || (strings.Contains(w.text, "=") && !strings.HasPrefix(w.text, "=")) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func splitWords(command string) []word {
+	var words []word
+	start := -1
+	for i, r := range command {
+		if r == ' ' || r == '\t' || r == '\n' {
+			if start >= 0 {
+				words = append(words, word{command[start:i], start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, word{command[start:], start, len(command)})
+	}
+	return words
+}
+
+func replaceWord(command string, w word, replacement string) string {
+	return command[:w.start] + replacement + command[w.end:]
+}
+
+type match struct {
+	name     string
+	distance int
+}
+
+// closest returns up to limit candidates within a few edits of target,
+// nearest first. Short names allow a single edit.
+func closest(target string, candidates []string, limit int) []match {
+	maxDistance := 1
+	if len(target) >= 5 {
+		maxDistance = 2
+	}
+
+	seen := map[string]bool{}
+	var matches []match
+	for _, c := range candidates {
+		if c == target || seen[c] {
+			continue
+		}
+		seen[c] = true
+		if d := distance(target, c); d <= maxDistance {
+			matches = append(matches, match{c, d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// distance is the edit distance between a and b, counting a swap of two
+// adjacent characters as one edit, as in "gti" for "git"
+func distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+// scanPath lists the executable names on $PATH
+func scanPath() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if seen[name] || entry.IsDir() {
+				continue
+			}
+			if info, err := entry.Info(); err == nil && info.Mode()&0111 != 0 {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// gitBranches lists the local and remote-tracking branches of the current
+// repository
+func gitBranches() []string {
+	out, err := exec.Command("git", "for-each-ref", "--format=%(refname:short)", "refs/heads", "refs/remotes").Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(out))
+}
@@ -0,0 +1,120 @@
+package fix
+
+import (
+	"testing"
+)
+
+func stubSystem(t *testing.T, executables, branches []string) {
+	t.Helper()
+	oldPath, oldBranches, oldExists := pathExecutables, listBranches, commandExists
+	t.Cleanup(func() {
+		pathExecutables, listBranches, commandExists = oldPath, oldBranches, oldExists
+	})
+	pathExecutables = func() []string { return executables }
+	listBranches = func() []string { return branches }
+	commandExists = func(name string) bool {
+		for _, e := range executables {
+			if e == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	stubSystem(t,
+		[]string{"git", "git-lfs", "grep", "ls", "docker", "apt"},
+		[]string{"main", "feature/login", "origin/main", "origin/HEAD", "develop"},
+	)
+
+	tests := []struct {
+		name    string
+		command string
+		output  []string
+		want    string // Top suggestion
+	}{
+		{"mistyped program", "gti status", []string{"bash: gti: command not found"}, "git status"},
+		{"program after sudo", "sudo atp install jq", []string{"sudo: atp: command not found"}, "sudo apt install jq"},
+		{"git did you mean", "git stauts -s", []string{
+			"git: 'stauts' is not a git command. See 'git --help'.",
+			"",
+			"The most similar command is",
+			"\tstatus",
+		}, "git status -s"},
+		{"subcommand on PATH", "git lsf pull", []string{"git: 'lsf' is not a git command. See 'git --help'."}, "git lfs pull"},
+		{"quoted suggestion", "cargo biuld --release", []string{"error: no such command: `biuld`", "", "\tDid you mean `build`?"}, "cargo build --release"},
+		{"missing sudo", "apt install jq", []string{"E: Could not open lock file - open (13: Permission denied)"}, "sudo apt install jq"},
+		{"branch typo", "git checkout devlop", []string{"error: pathspec 'devlop' did not match any file(s) known to git"}, "git checkout develop"},
+		{"default branch", "git merge master", []string{"merge: master - not something we can merge", "fatal: invalid reference: master"}, "git merge main"},
+		{"branch prefix", "git switch login", []string{"fatal: invalid reference: login"}, "git switch feature/login"},
+		{"push refspec", "git push origin mian", []string{"error: src refspec mian does not match any"}, "git push origin main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixes := Suggest(tt.command, tt.output)
+			if len(fixes) == 0 {
+				t.Fatalf("Suggest(%q) found no fixes, want %q", tt.command, tt.want)
+			}
+			if fixes[0].Command != tt.want {
+				t.Errorf("Suggest(%q)[0] = %q (%s), want %q", tt.command, fixes[0].Command, fixes[0].Reason, tt.want)
+			}
+			for i := 1; i < len(fixes); i++ {
+				if fixes[i].Score > fixes[i-1].Score {
+					t.Errorf("fixes not ranked: %+v", fixes)
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestNothing(t *testing.T) {
+	stubSystem(t, []string{"git", "ls"}, []string{"main"})
+
+	tests := []struct {
+		command string
+		output  []string
+	}{
+		{"ls /nope", []string{"ls: cannot access '/nope': No such file or directory"}},
+		{"sudo rm /x", []string{"rm: cannot remove '/x': Permission denied"}},
+		{"./build.sh", []string{"bash: ./build.sh: Permission denied"}},
+		{"qqqqqq", []string{"bash: qqqqqq: command not found"}},
+	}
+	for _, tt := range tests {
+		if fixes := Suggest(tt.command, tt.output); len(fixes) != 0 {
+			t.Errorf("Suggest(%q) = %+v, want none", tt.command, fixes)
+		}
+	}
+}
+
+func TestCreateBranchOffered(t *testing.T) {
+	stubSystem(t, []string{"git"}, []string{"main"})
+
+	fixes := Suggest("git checkout new-thing", []string{"error: pathspec 'new-thing' did not match any file(s) known to git"})
+	for _, f := range fixes {
+		if f.Command == "git checkout -b new-thing" {
+			return
+		}
+	}
+	t.Errorf("Suggest() = %+v, want the branch creation offered", fixes)
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"git", "git", 0},
+		{"gti", "git", 1},
+		{"stauts", "status", 1},
+		{"devlop", "develop", 1},
+		{"master", "main", 4},
+		{"", "ls", 2},
+	}
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
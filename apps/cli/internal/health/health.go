@@ -7,6 +7,7 @@ import (
 	"github.com/fabiobrug/mako.git/internal/cache"
 	"github.com/fabiobrug/mako.git/internal/config"
 	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/secrets"
 )
 
 // HealthStatus represents the health status of a component
@@ -195,6 +196,21 @@ func (c *Checker) checkAPIKey() ComponentHealth {
 		return health
 	}
 
+	// Keys kept in the secrets store are referenced as secret://name
+	if secrets.IsRef(apiKey) {
+		health.Details["secret"] = apiKey
+		resolved, err := config.ResolveSecret(apiKey)
+		if err != nil {
+			health.Status = StatusError
+			health.Message = fmt.Sprintf("Cannot read %s API key from secrets store", provider)
+			health.Details["error"] = err.Error()
+			return health
+		}
+		apiKey = resolved
+	} else if apiKey == "" {
+		apiKey = config.LookupProviderKey(provider)
+	}
+
 	// Check API key for cloud providers
 	if apiKey == "" {
 		health.Status = StatusError
@@ -337,6 +353,9 @@ func (c *Checker) checkEmbeddingProvider() ComponentHealth {
 		llmAPIKey = os.Getenv("GEMINI_API_KEY")
 	}
 	
+	llmAPIKey, _ = config.ResolveSecret(llmAPIKey)
+	embeddingAPIKey, _ = config.ResolveSecret(embeddingAPIKey)
+	
 	// Determine which provider is being used for embeddings
 	if embeddingProvider == "" {
 		embeddingProvider = llmProvider
@@ -0,0 +1,207 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/database"
+)
+
+// Entry is one command run read from another tool's history
+type Entry struct {
+	Command    string
+	Timestamp  time.Time
+	Duration   time.Duration // Zero when the source does not record it
+	ExitCode   int
+	WorkingDir string
+	Hostname   string
+	Session    string
+}
+
+// Source reads the history of a shell or history tool
+type Source interface {
+	// Name is the value accepted by --from
+	Name() string
+
+	// DefaultPath is where the history usually lives for the current user
+	DefaultPath() string
+
+	// Read parses the history at path, oldest first
+	Read(path string) ([]Entry, error)
+}
+
+var sources = map[string]Source{
+	"bash":  bashSource{},
+	"zsh":   zshSource{},
+	"fish":  fishSource{},
+	"atuin": atuinSource{},
+	"mcfly": mcflySource{},
+}
+
+// Get returns the source with the given name
+func Get(name string) (Source, error) {
+	source, ok := sources[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown history source: %s (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	return source, nil
+}
+
+// Names lists the supported sources
+func Names() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForFile picks a plain-text source from a history file name, defaulting to
+// bash. Used for the shell's own history file, whose format is not chosen by
+// the user.
+func ForFile(path string) Source {
+	switch base := filepath.Base(path); {
+	case strings.Contains(base, "zsh") || base == ".zhistory":
+		return zshSource{}
+	case base == "fish_history":
+		return fishSource{}
+	default:
+		return bashSource{}
+	}
+}
+
+// Result summarizes an import
+type Result struct {
+	Source   string
+	Path     string
+	Total    int // Entries read from the source
+	Imported int
+	Skipped  int // Already in the database or mako's own commands
+}
+
+// Import reads path with source and stores the entries. With an empty path
+// the source's default location is used. In dry-run mode nothing is stored
+// and Imported counts what would be.
+func Import(db *database.DB, source Source, path string, dryRun bool) (*Result, error) {
+	if path == "" {
+		path = source.DefaultPath()
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%s history not found at %s", source.Name(), path)
+	}
+
+	entries, err := source.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s history: %w", source.Name(), err)
+	}
+
+	result := &Result{Source: source.Name(), Path: path, Total: len(entries)}
+
+	commands := make([]database.Command, 0, len(entries))
+	for _, entry := range entries {
+		// Skip mako commands to avoid recursive storage
+		if strings.HasPrefix(entry.Command, "mako ") {
+			result.Skipped++
+			continue
+		}
+		commands = append(commands, ToCommand(entry))
+	}
+
+	if dryRun {
+		result.Imported = len(commands)
+		return result, nil
+	}
+
+	imported, skipped, err := db.ImportCommands(commands)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store commands: %w", err)
+	}
+	result.Imported = imported
+	result.Skipped += skipped
+	return result, nil
+}
+
+// Sync stores up to limit of the newest runs added to the shell's own
+// history file since the last sync. The file is parsed in its own format, so
+// zsh's ": <start>:<elapsed>;" prefix is not stored as part of a command.
+func Sync(db *database.DB, path string, limit int) (int, error) {
+	lastSync, err := db.GetLastSyncTime()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last sync time: %w", err)
+	}
+
+	entries, err := ForFile(path).Read(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var fresh []Entry
+	for _, entry := range entries {
+		if entry.Timestamp.After(lastSync) {
+			fresh = append(fresh, entry)
+		}
+	}
+	if len(fresh) > limit {
+		fresh = fresh[len(fresh)-limit:]
+	}
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+
+	latest := lastSync
+	commands := make([]database.Command, 0, len(fresh))
+	for _, entry := range fresh {
+		if entry.Timestamp.After(latest) {
+			latest = entry.Timestamp
+		}
+		// Skip mako commands to avoid recursive storage
+		if strings.HasPrefix(entry.Command, "mako ") {
+			continue
+		}
+		commands = append(commands, ToCommand(entry))
+	}
+
+	imported, _, err := db.ImportCommands(commands)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store commands: %w", err)
+	}
+	if err := db.SetLastSyncTime(latest); err != nil {
+		return 0, fmt.Errorf("failed to update sync time: %w", err)
+	}
+	return imported, nil
+}
+
+// ToCommand converts an entry to a database command
+func ToCommand(entry Entry) database.Command {
+	return database.Command{
+		Command:         entry.Command,
+		Timestamp:       entry.Timestamp,
+		ExitCode:        entry.ExitCode,
+		Duration:        entry.Duration.Milliseconds(),
+		WorkingDir:      entry.WorkingDir,
+		Hostname:        entry.Hostname,
+		Session:         entry.Session,
+		EmbeddingStatus: "pending",
+	}
+}
+
+// dataHome returns $XDG_DATA_HOME or ~/.local/share
+func dataHome() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "share")
+}
+
+// fileModTime is the timestamp for entries from files without one
+func fileModTime(path string) time.Time {
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
@@ -0,0 +1,241 @@
+package importer
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func TestZshExtendedHistory(t *testing.T) {
+	dir := testutil.TempDir(t)
+	path := testutil.TempFile(t, dir, ".zsh_history", ": 1700000000:0;git status\n"+
+		": 1700000010:12;make test\n"+
+		": 1700000030:1;for f in *; do\\\necho $f\\\ndone\n"+
+		"ls -la\n")
+
+	entries, err := zshSource{}.Read(path)
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d: %+v", len(entries), entries)
+	}
+
+	if entries[0].Command != "git status" || !entries[0].Timestamp.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Duration != 12*time.Second {
+		t.Errorf("Expected 12s duration, got %v", entries[1].Duration)
+	}
+	if entries[2].Command != "for f in *; do\necho $f\ndone" {
+		t.Errorf("Multi-line command not joined: %q", entries[2].Command)
+	}
+	if entries[3].Command != "ls -la" || entries[3].Timestamp.IsZero() {
+		t.Errorf("Plain line not read: %+v", entries[3])
+	}
+}
+
+func TestZshUnmetafy(t *testing.T) {
+	// zsh writes 0x89 as 0x83 0xA9
+	if got := unmetafy("echo \x83\xa9"); got != "echo \x89" {
+		t.Errorf("unmetafy() = %q", got)
+	}
+}
+
+func TestBashHistoryTimestamps(t *testing.T) {
+	dir := testutil.TempDir(t)
+	path := testutil.TempFile(t, dir, ".bash_history", "#1700000000\ncd /tmp\nls\n")
+
+	entries, err := bashSource{}.Read(path)
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if !entries[0].Timestamp.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Expected epoch timestamp, got %v", entries[0].Timestamp)
+	}
+	if entries[1].Command != "ls" {
+		t.Errorf("Expected ls, got %q", entries[1].Command)
+	}
+}
+
+func TestFishHistory(t *testing.T) {
+	dir := testutil.TempDir(t)
+	path := testutil.TempFile(t, dir, "fish_history", `- cmd: git status
+  when: 1700000000
+- cmd: echo "a\\b"\necho c
+  when: 1700000005
+  paths:
+    - /tmp
+`)
+
+	entries, err := fishSource{}.Read(path)
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if !entries[0].Timestamp.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Unexpected timestamp: %v", entries[0].Timestamp)
+	}
+	if entries[1].Command != "echo \"a\\b\"\necho c" {
+		t.Errorf("Escapes not undone: %q", entries[1].Command)
+	}
+}
+
+func TestAtuinHistory(t *testing.T) {
+	path := filepath.Join(testutil.TempDir(t), "history.db")
+	createFixtureDB(t, path, `
+		CREATE TABLE history (
+			id TEXT PRIMARY KEY, timestamp INTEGER NOT NULL, duration INTEGER NOT NULL,
+			exit INTEGER NOT NULL, command TEXT NOT NULL, cwd TEXT NOT NULL,
+			session TEXT NOT NULL, hostname TEXT NOT NULL, deleted_at INTEGER
+		);
+		INSERT INTO history VALUES ('a', 1700000000000000000, 2500000000, 0, 'cargo build', '/src', 's1', 'laptop:me', NULL);
+		INSERT INTO history VALUES ('b', 1700000100000000000, -1, 101, 'cargo test', '/src', 's1', 'laptop:me', NULL);
+		INSERT INTO history VALUES ('c', 1700000200000000000, 1000, 0, 'rm secret', '/', 's1', 'laptop:me', 1700000300000000000);
+	`)
+
+	entries, err := atuinSource{}.Read(path)
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected deleted entry to be skipped, got %d entries", len(entries))
+	}
+
+	first := entries[0]
+	if first.Duration != 2500*time.Millisecond || first.WorkingDir != "/src" || first.Session != "s1" || first.Hostname != "laptop:me" {
+		t.Errorf("Unexpected first entry: %+v", first)
+	}
+	if entries[1].ExitCode != 101 || entries[1].Duration != 0 {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+}
+
+func TestMcFlyHistory(t *testing.T) {
+	path := filepath.Join(testutil.TempDir(t), "history.db")
+	createFixtureDB(t, path, `
+		CREATE TABLE commands (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, cmd TEXT NOT NULL, cmd_tpl TEXT,
+			session_id TEXT NOT NULL, when_run INTEGER NOT NULL, exit_code INTEGER NOT NULL,
+			selected INTEGER NOT NULL, dir TEXT, old_dir TEXT
+		);
+		INSERT INTO commands (cmd, session_id, when_run, exit_code, selected, dir) VALUES ('npm install', 'abc', 1700000000, 0, 0, '/app');
+		INSERT INTO commands (cmd, session_id, when_run, exit_code, selected, dir) VALUES ('npm test', 'abc', 1700000050, 1, 0, NULL);
+	`)
+
+	entries, err := mcflySource{}.Read(path)
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].WorkingDir != "/app" || entries[0].Session != "abc" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].ExitCode != 1 || !entries[1].Timestamp.Equal(time.Unix(1700000050, 0)) {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+}
+
+func TestImportSkipsDuplicates(t *testing.T) {
+	dir := testutil.TempDir(t)
+	path := testutil.TempFile(t, dir, ".zsh_history", ": 1700000000:3;git status\n"+
+		": 1700000010:0;git status\n"+
+		": 1700000020:0;mako stats\n")
+
+	db, err := database.NewDB(filepath.Join(dir, "mako.db"))
+	if err != nil {
+		t.Fatalf("NewDB() failed: %v", err)
+	}
+	defer db.Close()
+
+	result, err := Import(db, zshSource{}, path, false)
+	if err != nil {
+		t.Fatalf("Import() failed: %v", err)
+	}
+	// Both runs of git status are kept, the mako command is not
+	if result.Total != 3 || result.Imported != 2 || result.Skipped != 1 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	result, err = Import(db, zshSource{}, path, false)
+	if err != nil {
+		t.Fatalf("Second Import() failed: %v", err)
+	}
+	if result.Imported != 0 || result.Skipped != 3 {
+		t.Errorf("Expected re-import to skip everything, got %+v", result)
+	}
+
+	commands, _ := db.GetRecentCommands(10)
+	if len(commands) != 2 {
+		t.Fatalf("Expected 2 stored commands, got %d", len(commands))
+	}
+	if commands[1].Duration != 3000 {
+		t.Errorf("Expected duration in ms, got %d", commands[1].Duration)
+	}
+}
+
+func TestSyncParsesZshHistory(t *testing.T) {
+	dir := testutil.TempDir(t)
+	path := testutil.TempFile(t, dir, ".zsh_history", ": 1700000000:0;git status\n"+
+		": 1700000010:2;make test\n"+
+		": 1700000020:0;mako stats\n")
+
+	db, err := database.NewDB(filepath.Join(dir, "mako.db"))
+	if err != nil {
+		t.Fatalf("NewDB() failed: %v", err)
+	}
+	defer db.Close()
+
+	count, err := Sync(db, path, 100)
+	if err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 synced commands, got %d", count)
+	}
+
+	commands, _ := db.GetRecentCommands(10)
+	if len(commands) != 2 || commands[0].Command != "make test" || commands[1].Command != "git status" {
+		t.Fatalf("Expected commands without the zsh prefix, got %+v", commands)
+	}
+
+	// Nothing is newer than the last sync
+	if count, err = Sync(db, path, 100); err != nil || count != 0 {
+		t.Errorf("Expected a second sync to store nothing, got %d, %v", count, err)
+	}
+}
+
+func TestGetUnknownSource(t *testing.T) {
+	if _, err := Get("nushell"); err == nil {
+		t.Error("Expected error for unknown source")
+	}
+	if source, err := Get("Atuin"); err != nil || source.Name() != "atuin" {
+		t.Errorf("Get(Atuin) = %v, %v", source, err)
+	}
+	if ForFile("/home/me/.zsh_history").Name() != "zsh" {
+		t.Error("Expected zsh source for .zsh_history")
+	}
+}
+
+func createFixtureDB(t *testing.T, path, schema string) {
+	t.Helper()
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(schema); err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// atuinSource reads atuin's history database. Timestamps and durations are
+// stored in nanoseconds, with -1 for an unknown duration or exit code.
+type atuinSource struct{}
+
+func (atuinSource) Name() string { return "atuin" }
+
+func (atuinSource) DefaultPath() string {
+	return filepath.Join(dataHome(), "atuin", "history.db")
+}
+
+func (atuinSource) Read(path string) ([]Entry, error) {
+	conn, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(`
+		SELECT timestamp, duration, exit, command, cwd, session, hostname
+		FROM history
+		WHERE deleted_at IS NULL
+		ORDER BY timestamp ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("not an atuin database: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var timestamp, duration, exit int64
+		var entry Entry
+		if err := rows.Scan(&timestamp, &duration, &exit, &entry.Command, &entry.WorkingDir, &entry.Session, &entry.Hostname); err != nil {
+			return nil, err
+		}
+		entry.Timestamp = time.Unix(0, timestamp)
+		if duration > 0 {
+			entry.Duration = time.Duration(duration)
+		}
+		if exit > 0 {
+			entry.ExitCode = int(exit)
+		}
+		if entry.Command != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, rows.Err()
+}
+
+// mcflySource reads McFly's history database
+type mcflySource struct{}
+
+func (mcflySource) Name() string { return "mcfly" }
+
+func (mcflySource) DefaultPath() string {
+	path := filepath.Join(dataHome(), "mcfly", "history.db")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	// Older versions kept it in ~/.mcfly
+	return filepath.Join(os.Getenv("HOME"), ".mcfly", "history.db")
+}
+
+func (mcflySource) Read(path string) ([]Entry, error) {
+	conn, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(`
+		SELECT cmd, when_run, exit_code, COALESCE(dir, ''), COALESCE(session_id, '')
+		FROM commands
+		ORDER BY when_run ASC, id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("not a McFly database: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var whenRun int64
+		var exitCode sql.NullInt64
+		var entry Entry
+		if err := rows.Scan(&entry.Command, &whenRun, &exitCode, &entry.WorkingDir, &entry.Session); err != nil {
+			return nil, err
+		}
+		entry.Timestamp = time.Unix(whenRun, 0)
+		entry.ExitCode = int(exitCode.Int64)
+		if entry.Command != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, rows.Err()
+}
+
+// openReadOnly opens another tool's database without taking write locks,
+// so it can be read while that tool is running
+func openReadOnly(path string) (*sql.DB, error) {
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,224 @@
+package importer
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bashSource reads ~/.bash_history, using the "#<epoch>" lines bash writes
+// when HISTTIMEFORMAT is set
+type bashSource struct{}
+
+func (bashSource) Name() string { return "bash" }
+
+func (bashSource) DefaultPath() string {
+	if path := os.Getenv("HISTFILE"); path != "" && !strings.Contains(path, "zsh") {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".bash_history")
+}
+
+func (bashSource) Read(path string) ([]Entry, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback := fileModTime(path)
+	var entries []Entry
+	var when time.Time
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			if epoch, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				when = time.Unix(epoch, 0)
+				continue
+			}
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		entry := Entry{Command: line, Timestamp: when}
+		if when.IsZero() {
+			entry.Timestamp = fallback
+		}
+		entries = append(entries, entry)
+		when = time.Time{}
+	}
+	return entries, nil
+}
+
+// zshSource reads ~/.zsh_history. Lines in EXTENDED_HISTORY format look like
+// ": <start>:<elapsed>;<command>", and multi-line commands continue with a
+// trailing backslash.
+type zshSource struct{}
+
+func (zshSource) Name() string { return "zsh" }
+
+func (zshSource) DefaultPath() string {
+	if path := os.Getenv("HISTFILE"); path != "" && strings.Contains(path, "zsh") {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".zsh_history")
+}
+
+func (zshSource) Read(path string) ([]Entry, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback := fileModTime(path)
+	var entries []Entry
+	for i := 0; i < len(lines); i++ {
+		line := unmetafy(lines[i])
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + "\n" + unmetafy(lines[i])
+		}
+
+		entry := Entry{Timestamp: fallback}
+		if start, elapsed, command, ok := parseZshExtended(line); ok {
+			entry.Timestamp = time.Unix(start, 0)
+			entry.Duration = time.Duration(elapsed) * time.Second
+			line = command
+		}
+
+		entry.Command = strings.TrimSpace(line)
+		if entry.Command != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// parseZshExtended splits ": 1700000000:5;make test" into its parts
+func parseZshExtended(line string) (start, elapsed int64, command string, ok bool) {
+	if !strings.HasPrefix(line, ": ") {
+		return 0, 0, "", false
+	}
+	meta, command, found := strings.Cut(line[2:], ";")
+	if !found {
+		return 0, 0, "", false
+	}
+	startStr, elapsedStr, found := strings.Cut(meta, ":")
+	if !found {
+		return 0, 0, "", false
+	}
+
+	start, err := strconv.ParseInt(strings.TrimSpace(startStr), 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	elapsed, err = strconv.ParseInt(strings.TrimSpace(elapsedStr), 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return start, elapsed, command, true
+}
+
+// unmetafy undoes zsh's history encoding, which writes bytes 0x83-0xA2 as
+// 0x83 followed by the byte XOR 32
+func unmetafy(line string) string {
+	if strings.IndexByte(line, 0x83) < 0 {
+		return line
+	}
+	out := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] == 0x83 && i+1 < len(line) {
+			i++
+			out = append(out, line[i]^32)
+			continue
+		}
+		out = append(out, line[i])
+	}
+	return string(out)
+}
+
+// fishSource reads fish_history, a YAML-like file where each entry starts
+// with "- cmd: <command>" followed by an indented "when: <epoch>" line
+type fishSource struct{}
+
+func (fishSource) Name() string { return "fish" }
+
+func (fishSource) DefaultPath() string {
+	return filepath.Join(dataHome(), "fish", "fish_history")
+}
+
+func (fishSource) Read(path string) ([]Entry, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback := fileModTime(path)
+	var entries []Entry
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			command := unescapeFish(strings.TrimPrefix(line, "- cmd: "))
+			entries = append(entries, Entry{Command: strings.TrimSpace(command), Timestamp: fallback})
+
+		case strings.HasPrefix(line, "  when: ") && len(entries) > 0:
+			epoch, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "  when: ")), 10, 64)
+			if err == nil {
+				entries[len(entries)-1].Timestamp = time.Unix(epoch, 0)
+			}
+		}
+	}
+
+	// Drop empty commands left by malformed entries
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Command != "" {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+// unescapeFish reverses fish's escaping of backslashes and newlines in cmd
+func unescapeFish(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readLines reads a history file, allowing for very long lines
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
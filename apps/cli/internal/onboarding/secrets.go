@@ -0,0 +1,109 @@
+package onboarding
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/secrets"
+	"golang.org/x/term"
+)
+
+// UnlockSecrets asks for the secrets file passphrase when needed and moves
+// any plaintext API keys into the secrets store. It runs on every start and
+// does nothing once keys are migrated to a backend without a passphrase.
+func UnlockSecrets() {
+	if config.SecretsNeedPassphrase() && !promptSecretsPassphrase() {
+		return
+	}
+
+	count, err := config.MigrateSecrets()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s⚠  Could not move API keys to the secrets store: %v%s\n", ColorYellow, err, ColorReset)
+		return
+	}
+	if count > 0 {
+		fmt.Printf("%s✓ Moved %d API key(s) out of plaintext config into the secrets store%s\n", ColorGreen, count, ColorReset)
+	}
+}
+
+// storeAPIKeys saves the wizard's keys in the secrets store and returns the
+// secret:// references to write in their place. If the store can't be used
+// the keys are returned unchanged and end up in ~/.mako/.env as before.
+func storeAPIKeys(cfg *config.Config, apiKeys map[string]string) map[string]string {
+	if len(apiKeys) == 0 {
+		return apiKeys
+	}
+
+	store, err := cfg.SecretStore()
+	if err != nil {
+		fmt.Printf("%s⚠  Secrets store unavailable (%v), keeping keys in ~/.mako/.env%s\n", ColorYellow, err, ColorReset)
+		return apiKeys
+	}
+	if store.Name() == secrets.BackendFile && !secrets.HasPassphrase() && !promptSecretsPassphrase() {
+		fmt.Printf("%s⚠  No passphrase set, keeping keys in ~/.mako/.env%s\n", ColorYellow, ColorReset)
+		return apiKeys
+	}
+
+	refs := make(map[string]string)
+	for provider, key := range apiKeys {
+		ref, err := cfg.StoreSecret(provider, key)
+		if err != nil {
+			fmt.Printf("%s⚠  Could not store %s key (%v), keeping keys in ~/.mako/.env%s\n", ColorYellow, provider, err, ColorReset)
+			return apiKeys
+		}
+		refs[provider] = ref
+	}
+
+	fmt.Printf("%s✓ API keys stored in the %s secrets backend%s\n", ColorGreen, store.Name(), ColorReset)
+	return refs
+}
+
+// promptSecretsPassphrase unlocks an existing secrets file, or sets the
+// passphrase for a new one. It returns false if the user skips.
+func promptSecretsPassphrase() bool {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return false
+	}
+
+	path := secrets.FilePath(config.GetMakoDir())
+	if _, err := os.Stat(path); err == nil {
+		for attempt := 0; attempt < 3; attempt++ {
+			passphrase, err := readPassphrase(fd, "Secrets passphrase (Enter to skip): ")
+			if err != nil || passphrase == "" {
+				return false
+			}
+			secrets.SetPassphrase(passphrase)
+			if _, err := secrets.NewFileBackend(path).List(); !errors.Is(err, secrets.ErrWrongPassphrase) {
+				return err == nil
+			}
+			fmt.Printf("%sWrong passphrase, try again.%s\n", ColorRed, ColorReset)
+		}
+		secrets.SetPassphrase("")
+		return false
+	}
+
+	fmt.Printf("\n%sAPI keys are kept in an encrypted file (~/.mako/secrets.enc).%s\n", ColorDimBlue, ColorReset)
+	fmt.Printf("%sSet %s or choose a passphrase now.%s\n", ColorDimBlue, secrets.PassphraseEnv, ColorReset)
+	passphrase, err := readPassphrase(fd, "New secrets passphrase (Enter to skip): ")
+	if err != nil || passphrase == "" {
+		return false
+	}
+	confirm, err := readPassphrase(fd, "Confirm passphrase: ")
+	if err != nil || confirm != passphrase {
+		fmt.Printf("%sPassphrases do not match.%s\n", ColorRed, ColorReset)
+		return false
+	}
+
+	secrets.SetPassphrase(passphrase)
+	return true
+}
+
+func readPassphrase(fd int, prompt string) (string, error) {
+	fmt.Printf("%s%s%s", ColorLightBlue, prompt, ColorReset)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Println()
+	return string(passphrase), err
+}
@@ -331,6 +331,9 @@ func saveConfiguration(defaultProvider string, apiKeys map[string]string, safety
 	cfg.SafetyLevel = safetyLevel
 	cfg.AutoUpdate = autoUpdate
 	
+	// Keep keys out of plaintext files, storing secret:// references instead
+	apiKeys = storeAPIKeys(cfg, apiKeys)
+	
 	// For backward compatibility, set APIKey field if using single provider
 	if key, ok := apiKeys[defaultProvider]; ok {
 		cfg.APIKey = key
@@ -341,7 +344,7 @@ func saveConfiguration(defaultProvider string, apiKeys map[string]string, safety
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 	
-	// Save API keys to .env file for multi-provider support
+	// Save API keys (or their references) to .env file for multi-provider support
 	if len(apiKeys) > 0 {
 		if err := saveAPIKeys(apiKeys); err != nil {
 			return fmt.Errorf("failed to save API keys: %w", err)
@@ -0,0 +1,227 @@
+// Package prompts holds the templates mako's AI prompts are built from, so
+// every provider sends the same wording. Each template has a built-in
+// default; a file of the same name in ~/.mako/prompts overrides it.
+//
+// Templates are Go text/templates. An optional "system" block holds the
+// instructions providers send as the system message; the rest is the
+// prompt itself. The first line records the template's version, so an
+// override written for an older default can be flagged.
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/fabiobrug/mako.git/internal/config"
+)
+
+// Template names
+const (
+	Command        = "command"
+	Chat           = "chat"
+	Task           = "task"
+	ExplainError   = "explain-error"
+	ExplainCommand = "explain-command"
+	ExplainParts   = "explain-parts"
+	Alternatives   = "alternatives"
+)
+
+// Descriptions says what each template is for
+var Descriptions = map[string]string{
+	Command:        "turn a request into a command (mako ask)",
+	Chat:           "reply to a message in mako chat",
+	Task:           "plan the next steps of mako do",
+	ExplainError:   "explain a failed command (mako why, mako fix)",
+	ExplainCommand: "explain a whole command",
+	ExplainParts:   "explain undocumented parts (mako explain)",
+	Alternatives:   "suggest alternatives to a command",
+}
+
+// ErrUnknown is returned for a template name that doesn't exist
+var ErrUnknown = errors.New("unknown prompt template")
+
+//go:embed defaults/*.tmpl
+var defaults embed.FS
+
+var versionLine = regexp.MustCompile(`^\{\{/\*\s*version\s+(\d+)`)
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	// last keeps the final n items of a list
+	"last": func(n int, items []string) []string {
+		if len(items) > n {
+			return items[len(items)-n:]
+		}
+		return items
+	},
+}
+
+// Template is a prompt template as it is in effect
+type Template struct {
+	Name           string
+	Text           string // The override when it is used, else the default
+	Version        int    // Of Text; 0 when it has no version line
+	DefaultVersion int
+	Path           string // Where an override goes, whether or not there is one
+	Overridden     bool   // The override is in effect
+	Err            error  // Why an override exists but isn't used
+
+	tmpl *template.Template
+}
+
+// Names lists the templates in a stable order
+func Names() []string {
+	return []string{Command, Chat, Task, ExplainError, ExplainCommand, ExplainParts, Alternatives}
+}
+
+// Dir is where overrides are kept
+func Dir() string {
+	return filepath.Join(config.GetMakoDir(), "prompts")
+}
+
+// Default returns the built-in text of a template
+func Default(name string) (string, error) {
+	if !slices.Contains(Names(), name) {
+		return "", fmt.Errorf("%w: %s", ErrUnknown, name)
+	}
+	data, err := defaults.ReadFile("defaults/" + name + ".tmpl")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Load returns the template in effect: the override in Dir when there is
+// one that parses, otherwise the default
+func Load(name string) (*Template, error) {
+	text, err := Default(name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := parse(name, text)
+	if err != nil {
+		return nil, fmt.Errorf("built-in %s template: %w", name, err)
+	}
+	t := &Template{
+		Name:           name,
+		Text:           text,
+		Version:        version(text),
+		DefaultVersion: version(text),
+		Path:           filepath.Join(Dir(), name+".tmpl"),
+		tmpl:           tmpl,
+	}
+
+	data, err := os.ReadFile(t.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			t.Err = err
+		}
+		return t, nil
+	}
+	override, err := parse(name, string(data))
+	if err != nil {
+		t.Err = err
+		return t, nil
+	}
+	t.Text, t.Version, t.Overridden, t.tmpl = string(data), version(string(data)), true, override
+	return t, nil
+}
+
+// Stale reports whether the override was written for an older default
+func (t *Template) Stale() bool {
+	return t.Overridden && t.Version < t.DefaultVersion
+}
+
+// Execute renders the template, returning the system instructions (empty
+// when the template has none) and the prompt
+func (t *Template) Execute(data any) (string, string, error) {
+	var system, prompt bytes.Buffer
+	if block := t.tmpl.Lookup("system"); block != nil {
+		if err := block.Execute(&system, data); err != nil {
+			return "", "", err
+		}
+	}
+	if err := t.tmpl.Execute(&prompt, data); err != nil {
+		return "", "", err
+	}
+	return tidy(system.String()), tidy(prompt.String()), nil
+}
+
+// Render executes the named template. An override that fails to render
+// falls back to the default, so a broken edit never stops mako from
+// working; `mako prompts show` reports it.
+func Render(name string, data any) (string, string, error) {
+	t, err := Load(name)
+	if err != nil {
+		return "", "", err
+	}
+	system, prompt, err := t.Execute(data)
+	if err == nil || !t.Overridden {
+		return system, prompt, err
+	}
+	text, _ := Default(name)
+	tmpl, _ := parse(name, text)
+	return (&Template{Name: name, tmpl: tmpl}).Execute(data)
+}
+
+// Create writes the default as an override to edit, unless one exists,
+// and returns its path
+func Create(name string) (string, error) {
+	text, err := Default(name)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(Dir(), name+".tmpl")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, []byte(text), 0644)
+}
+
+// Reset deletes the override of a template, reporting whether there was one
+func Reset(name string) (bool, error) {
+	if _, err := Default(name); err != nil {
+		return false, err
+	}
+	err := os.Remove(filepath.Join(Dir(), name+".tmpl"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func parse(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+func version(text string) int {
+	m := versionLine.FindStringSubmatch(text)
+	if m == nil {
+		return 0
+	}
+	v, _ := strconv.Atoi(m[1])
+	return v
+}
+
+// tidy drops the blank lines left where sections were empty
+func tidy(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
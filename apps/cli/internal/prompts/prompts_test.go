@@ -0,0 +1,141 @@
+package prompts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+// sampleData has every field a built-in template uses
+func sampleData() map[string]any {
+	return map[string]any{
+		"Request":     "list big files",
+		"Command":     "du -sh *",
+		"Error":       "du: cannot access 'x': Permission denied",
+		"Goal":        "free some disk space",
+		"Parts":       []string{"-sh"},
+		"MaxCommands": 3,
+		"OS":          "linux/amd64",
+		"Shell":       "bash",
+		"Dir":         "/srv",
+		"Steps": []map[string]any{
+			{"Number": 1, "Command": "df -h", "Skipped": false, "ExitCode": 0, "Output": []string{"/dev/sda1 91%"}},
+			{"Number": 2, "Command": "rm -rf /tmp/*", "Skipped": true, "ExitCode": 0, "Output": []string(nil)},
+		},
+		"Context": map[string]any{
+			"Conversation":   "CONVERSATION HISTORY (most recent at bottom):\n1. User: show disk usage",
+			"Preferences":    "LEARNED USER PREFERENCES:\n- User typically uses 'du -sh' (used 4 times)",
+			"Project":        []string{"Project type: Go"},
+			"Files":          []string{"go.mod", "main.go"},
+			"RecentCommands": []string{"df -h"},
+			"OutputNotes":    []string{"Recent output contains errors"},
+			"RecentOutput":   []string{"1", "2", "3", "4", "5", "6"},
+		},
+	}
+}
+
+func useHome(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", testutil.TempDir(t))
+}
+
+func TestDefaultsRender(t *testing.T) {
+	useHome(t)
+	for _, name := range Names() {
+		if Descriptions[name] == "" {
+			t.Errorf("%s has no description", name)
+		}
+		tmpl, err := Load(name)
+		if err != nil {
+			t.Fatalf("Load(%s) failed: %v", name, err)
+		}
+		if tmpl.Overridden || tmpl.DefaultVersion < 1 {
+			t.Errorf("Load(%s) = %+v", name, tmpl)
+		}
+		_, prompt, err := tmpl.Execute(sampleData())
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if strings.Contains(prompt, "\n\n\n") || strings.Contains(prompt, "version 1") {
+			t.Errorf("%s rendered untidily:\n%s", name, prompt)
+		}
+	}
+
+	system, prompt, _ := Render(Command, sampleData())
+	if !strings.HasPrefix(system, "You are a shell command generator") {
+		t.Errorf("system = %q", system)
+	}
+	for _, want := range []string{"Project type: Go", "du -sh", "(Note: Recent output contains errors)", "  2\n  3", "User request: list big files"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("command prompt lacks %q:\n%s", want, prompt)
+		}
+	}
+
+	_, prompt, _ = Render(Task, sampleData())
+	if !strings.Contains(prompt, "2. rm -rf /tmp/* (skipped by the user)") || !strings.Contains(prompt, "   /dev/sda1 91%") {
+		t.Errorf("task prompt:\n%s", prompt)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	useHome(t)
+	path, err := Create(ExplainCommand)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if path != filepath.Join(Dir(), "explain-command.tmpl") {
+		t.Errorf("Create() = %s", path)
+	}
+	os.WriteFile(path, []byte("{{/* version 0 */}}In one line: what does {{.Command}} do?"), 0644)
+
+	tmpl, _ := Load(ExplainCommand)
+	if !tmpl.Overridden || tmpl.Version != 0 || !tmpl.Stale() {
+		t.Errorf("Load() = %+v", tmpl)
+	}
+	if _, prompt, _ := Render(ExplainCommand, sampleData()); prompt != "In one line: what does du -sh * do?" {
+		t.Errorf("Render() = %q", prompt)
+	}
+
+	// Creating again keeps the edits
+	Create(ExplainCommand)
+	if data, _ := os.ReadFile(path); !strings.HasPrefix(string(data), "{{/* version 0 */}}In one line") {
+		t.Error("Create() overwrote an override")
+	}
+
+	if removed, err := Reset(ExplainCommand); err != nil || !removed {
+		t.Errorf("Reset() = %v, %v", removed, err)
+	}
+	if removed, _ := Reset(ExplainCommand); removed {
+		t.Error("Reset() removed an override twice")
+	}
+	if tmpl, _ := Load(ExplainCommand); tmpl.Overridden {
+		t.Error("override still in effect after Reset()")
+	}
+}
+
+func TestBrokenOverride(t *testing.T) {
+	useHome(t)
+	os.MkdirAll(Dir(), 0755)
+
+	// One that doesn't parse isn't used
+	os.WriteFile(filepath.Join(Dir(), "alternatives.tmpl"), []byte("{{.Command"), 0644)
+	tmpl, _ := Load(Alternatives)
+	if tmpl.Overridden || tmpl.Err == nil {
+		t.Errorf("Load() = %+v", tmpl)
+	}
+
+	// One that fails to render falls back to the default
+	os.WriteFile(filepath.Join(Dir(), "explain-error.tmpl"), []byte("{{.NoSuchField}}"), 0644)
+	_, prompt, err := Render(ExplainError, sampleData())
+	if err != nil || !strings.HasPrefix(prompt, "Shell debugging assistant") {
+		t.Errorf("Render() = %q, %v", prompt, err)
+	}
+
+	if _, err := Load("nope"); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Load(nope) error = %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrUnsupported is returned when the platform cannot run sandboxed commands
+var ErrUnsupported = errors.New("sandboxed execution is only supported on Linux")
+
+// ChangeKind describes what a sandboxed command did to a path
+type ChangeKind string
+
+const (
+	ChangeCreated  ChangeKind = "created"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+	ChangeReplaced ChangeKind = "replaced" // Deleted and recreated, e.g. a directory made anew
+)
+
+// Change is a single filesystem change captured in the sandbox
+type Change struct {
+	Path  string // Relative to the sandboxed directory
+	Kind  ChangeKind
+	IsDir bool
+}
+
+// Result holds the outcome of a sandboxed run
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Changes  []Change
+
+	dir     string // Sandboxed (lower) directory
+	workdir string // Temporary directory holding the overlay layers
+	upper   string // Overlay upper directory with the captured writes
+}
+
+// Dir returns the directory the command was sandboxed in
+func (r *Result) Dir() string {
+	return r.dir
+}
+
+// Apply copies the captured changes onto the real directory and cleans up
+func (r *Result) Apply() error {
+	defer r.Discard()
+
+	for _, change := range r.Changes {
+		target := filepath.Join(r.dir, change.Path)
+
+		switch change.Kind {
+		case ChangeDeleted:
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", change.Path, err)
+			}
+		case ChangeCreated, ChangeModified, ChangeReplaced:
+			// A replaced entry doesn't keep anything of the old one
+			if change.Kind == ChangeReplaced {
+				if err := os.RemoveAll(target); err != nil {
+					return fmt.Errorf("failed to replace %s: %w", change.Path, err)
+				}
+			}
+			source := filepath.Join(r.upper, change.Path)
+			if err := copyEntry(source, target); err != nil {
+				return fmt.Errorf("failed to apply %s: %w", change.Path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Discard throws away the captured changes
+func (r *Result) Discard() error {
+	if r.workdir == "" {
+		return nil
+	}
+	err := os.RemoveAll(r.workdir)
+	r.workdir = ""
+	return err
+}
+
+// collectChanges walks the overlay upper directory and compares it with the
+// lower directory to work out what was created, modified or deleted
+func collectChanges(upper, lower string) ([]Change, error) {
+	var changes []Change
+
+	err := filepath.Walk(upper, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == upper {
+			return nil
+		}
+
+		rel, err := filepath.Rel(upper, path)
+		if err != nil {
+			return err
+		}
+		lowerPath := filepath.Join(lower, rel)
+		lowerInfo, lowerErr := os.Lstat(lowerPath)
+		existsBelow := lowerErr == nil
+
+		// Overlayfs records deletions as 0/0 character device whiteouts
+		if isWhiteout(info) {
+			if existsBelow {
+				changes = append(changes, Change{Path: rel, Kind: ChangeDeleted, IsDir: lowerInfo.IsDir()})
+			}
+			return nil
+		}
+
+		// An opaque directory hides everything below it, as does an entry
+		// that took the place of one of another type
+		if existsBelow && (lowerInfo.IsDir() != info.IsDir() || (info.IsDir() && isOpaque(path))) {
+			changes = append(changes, Change{Path: rel, Kind: ChangeReplaced, IsDir: info.IsDir()})
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if !existsBelow {
+				changes = append(changes, Change{Path: rel, Kind: ChangeCreated, IsDir: true})
+				return filepath.SkipDir
+			}
+			// Existing directories are only copied up to hold changed children
+			return nil
+		}
+
+		kind := ChangeCreated
+		if existsBelow {
+			kind = ChangeModified
+		}
+		changes = append(changes, Change{Path: rel, Kind: kind})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+// copyEntry copies a file, symlink or directory tree from src to dst
+func copyEntry(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.RemoveAll(dst)
+		return os.Symlink(link, dst)
+
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyEntry(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case info.Mode().IsRegular():
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+
+	default:
+		// Devices, sockets and fifos are not applied
+		return nil
+	}
+}
+
+// workspaceFor picks a temporary directory for the overlay layers that does
+// not live inside the directory being sandboxed
+func workspaceFor(dir string) (string, error) {
+	candidates := []string{os.TempDir()}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".mako", "sandbox"))
+	}
+
+	for _, base := range candidates {
+		if isWithin(base, dir) {
+			continue
+		}
+		if err := os.MkdirAll(base, 0700); err != nil {
+			continue
+		}
+		return os.MkdirTemp(base, "mako-sandbox-*")
+	}
+
+	return "", fmt.Errorf("no sandbox workspace available outside %s", dir)
+}
+
+// isWithin reports whether path is dir or one of its descendants
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
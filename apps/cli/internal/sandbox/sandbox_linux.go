@@ -0,0 +1,185 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// setupFailedCode is the exit code the init script uses when the sandbox
+// itself could not be set up (as opposed to the command failing)
+const setupFailedCode = 125
+
+// initScript runs as root inside the new user namespace. It mounts an
+// overlay on top of the working directory so writes land in the upper dir,
+// makes the remaining mounts read-only where the kernel allows it and then
+// runs the command. It fails closed: if / or $HOME is still writable the
+// command doesn't run. The network namespace has no interfaces configured.
+const initScript = `
+fail() { echo "mako-sandbox: $1" >&2; exit 125; }
+mount --make-rprivate / 2>/dev/null
+mount -t overlay overlay -o "lowerdir=$MAKO_SB_LOWER,upperdir=$MAKO_SB_UPPER,workdir=$MAKO_SB_WORK,userxattr" "$MAKO_SB_LOWER" 2>/dev/null ||
+	mount -t overlay overlay -o "lowerdir=$MAKO_SB_LOWER,upperdir=$MAKO_SB_UPPER,workdir=$MAKO_SB_WORK" "$MAKO_SB_LOWER" ||
+	fail "could not mount overlay on $MAKO_SB_LOWER"
+case "$MAKO_SB_LOWER" in
+	/tmp|/tmp/*) ;;
+	*) mount -t tmpfs tmpfs /tmp 2>/dev/null ;;
+esac
+for m in $(awk '{print $2}' /proc/self/mounts | sort -r); do
+	case "$m" in
+		/proc|/proc/*|/sys|/sys/*|/dev|/dev/*|/tmp|"$MAKO_SB_LOWER") continue ;;
+	esac
+	mount -o remount,bind,ro "$m" 2>/dev/null ||
+		{ [ "$m" = / ] && fail "could not make / read-only"; }
+done
+case "$HOME" in
+	""|"$MAKO_SB_LOWER"|/tmp|/tmp/*) ;;
+	*)
+		probe="$HOME/.mako-sandbox-probe.$$"
+		if (: > "$probe") 2>/dev/null; then
+			rm -f "$probe"
+			fail "could not make $HOME read-only"
+		fi
+		;;
+esac
+cd "$MAKO_SB_LOWER" || fail "could not enter $MAKO_SB_LOWER"
+exec bash -c "$MAKO_SB_CMD"
+`
+
+var (
+	supportOnce sync.Once
+	supported   bool
+)
+
+// Supported reports whether sandboxed execution is available: mount is
+// installed and this process may create the namespaces Run uses, which
+// distributions can turn off for unprivileged users
+func Supported() bool {
+	supportOnce.Do(func() {
+		if _, err := exec.LookPath("mount"); err != nil {
+			return
+		}
+		probe := exec.Command("/bin/sh", "-c", "exit 0")
+		probe.SysProcAttr = namespaceAttr()
+		supported = probe.Run() == nil
+	})
+	return supported
+}
+
+// namespaceAttr puts a process in new user, mount and network namespaces
+// as root mapped to the current user
+func namespaceAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+	}
+}
+
+// Run executes command in dir inside user, mount and network namespaces with
+// an overlay on dir, so that file changes are captured instead of applied.
+// The caller must call Apply or Discard on the returned result.
+func Run(command, dir string) (*Result, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(dir, ",:") {
+		return nil, fmt.Errorf("cannot sandbox %s: path contains ',' or ':'", dir)
+	}
+
+	workdir, err := workspaceFor(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	upper := filepath.Join(workdir, "upper")
+	work := filepath.Join(workdir, "work")
+	for _, d := range []string{upper, work} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			os.RemoveAll(workdir)
+			return nil, fmt.Errorf("failed to create sandbox workspace: %w", err)
+		}
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", initScript)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"MAKO_SB_LOWER="+dir,
+		"MAKO_SB_UPPER="+upper,
+		"MAKO_SB_WORK="+work,
+		"MAKO_SB_CMD="+command,
+	)
+	cmd.SysProcAttr = namespaceAttr()
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			os.RemoveAll(workdir)
+			return nil, fmt.Errorf("failed to start sandbox (are unprivileged user namespaces enabled?): %w", err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	if exitCode == setupFailedCode && strings.Contains(stderr.String(), "mako-sandbox:") {
+		os.RemoveAll(workdir)
+		return nil, fmt.Errorf("sandbox setup failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	changes, err := collectChanges(upper, dir)
+	if err != nil {
+		os.RemoveAll(workdir)
+		return nil, fmt.Errorf("failed to collect sandbox changes: %w", err)
+	}
+
+	return &Result{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+		Changes:  changes,
+		dir:      dir,
+		workdir:  workdir,
+		upper:    upper,
+	}, nil
+}
+
+// isWhiteout reports whether an upper dir entry is overlayfs's record of a
+// deletion: a 0/0 character device
+func isWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// isOpaque reports whether overlayfs marked an upper directory opaque,
+// meaning it was deleted and recreated. The mark is a user.* xattr when
+// the overlay is mounted with userxattr, as Run tries first, and a
+// trusted.* one otherwise.
+func isOpaque(dir string) bool {
+	for _, name := range []string{"user.overlay.opaque", "trusted.overlay.opaque"} {
+		value := make([]byte, 1)
+		if n, err := syscall.Getxattr(dir, name, value); err == nil && n == 1 && value[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
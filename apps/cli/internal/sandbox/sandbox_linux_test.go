@@ -0,0 +1,48 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func TestCollectChangesWhiteout(t *testing.T) {
+	lower, upper := setupLayers(t)
+
+	if err := syscall.Mknod(filepath.Join(upper, "old.txt"), syscall.S_IFCHR, 0); err != nil {
+		t.Skipf("cannot create whiteout device: %v", err)
+	}
+
+	changes, err := collectChanges(upper, lower)
+	if err != nil {
+		t.Fatalf("collectChanges() failed: %v", err)
+	}
+
+	if len(changes) != 1 || changes[0].Path != "old.txt" || changes[0].Kind != ChangeDeleted {
+		t.Errorf("Expected old.txt to be deleted, got %+v", changes)
+	}
+}
+
+func TestCollectChangesOpaqueDir(t *testing.T) {
+	lower, upper := setupLayers(t)
+
+	// src was deleted and made again with other contents
+	os.MkdirAll(filepath.Join(upper, "src"), 0755)
+	testutil.TempFile(t, filepath.Join(upper, "src"), "lib.go", "package lib")
+	if err := syscall.Setxattr(filepath.Join(upper, "src"), "user.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("cannot set user xattrs here: %v", err)
+	}
+
+	changes, err := collectChanges(upper, lower)
+	if err != nil {
+		t.Fatalf("collectChanges() failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Path != "src" || changes[0].Kind != ChangeReplaced || !changes[0].IsDir {
+		t.Errorf("Expected src to be replaced, got %+v", changes)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package sandbox
+
+import "os"
+
+// Supported reports whether sandboxed execution is available
+func Supported() bool {
+	return false
+}
+
+// Run is not available outside Linux
+func Run(command, dir string) (*Result, error) {
+	return nil, ErrUnsupported
+}
+
+// isWhiteout has nothing to detect without overlayfs
+func isWhiteout(info os.FileInfo) bool {
+	return false
+}
+
+// isOpaque has nothing to detect without overlayfs
+func isOpaque(dir string) bool {
+	return false
+}
@@ -0,0 +1,198 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func setupLayers(t *testing.T) (lower, upper string) {
+	t.Helper()
+	lower = filepath.Join(testutil.TempDir(t), "lower")
+	upper = filepath.Join(testutil.TempDir(t), "upper")
+	os.MkdirAll(filepath.Join(lower, "src"), 0755)
+	os.MkdirAll(upper, 0755)
+
+	testutil.TempFile(t, lower, "README.md", "original")
+	testutil.TempFile(t, lower, "old.txt", "remove me")
+	testutil.TempFile(t, filepath.Join(lower, "src"), "main.go", "package main")
+	return lower, upper
+}
+
+func TestCollectChanges(t *testing.T) {
+	lower, upper := setupLayers(t)
+
+	// Modified file and new file at the top level
+	testutil.TempFile(t, upper, "README.md", "changed")
+	testutil.TempFile(t, upper, "new.txt", "hello")
+
+	// New directory with contents is reported once
+	os.MkdirAll(filepath.Join(upper, "build", "out"), 0755)
+	testutil.TempFile(t, filepath.Join(upper, "build", "out"), "app", "binary")
+
+	// Existing directory copied up to hold a new child
+	os.MkdirAll(filepath.Join(upper, "src"), 0755)
+	testutil.TempFile(t, filepath.Join(upper, "src"), "util.go", "package main")
+
+	changes, err := collectChanges(upper, lower)
+	if err != nil {
+		t.Fatalf("collectChanges() failed: %v", err)
+	}
+
+	want := map[string]ChangeKind{
+		"README.md":   ChangeModified,
+		"build":       ChangeCreated,
+		"new.txt":     ChangeCreated,
+		"src/util.go": ChangeCreated,
+	}
+
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %d: %+v", len(want), len(changes), changes)
+	}
+	for _, change := range changes {
+		if want[change.Path] != change.Kind {
+			t.Errorf("Change %s: expected %s, got %s", change.Path, want[change.Path], change.Kind)
+		}
+	}
+}
+
+func TestCollectChangesReplacedType(t *testing.T) {
+	lower, upper := setupLayers(t)
+
+	// src was removed and a file put in its place
+	testutil.TempFile(t, upper, "src", "now a file")
+
+	changes, err := collectChanges(upper, lower)
+	if err != nil {
+		t.Fatalf("collectChanges() failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Path != "src" || changes[0].Kind != ChangeReplaced || changes[0].IsDir {
+		t.Errorf("Expected src to be replaced by a file, got %+v", changes)
+	}
+}
+
+func TestApply(t *testing.T) {
+	lower, upper := setupLayers(t)
+	workdir := filepath.Dir(upper)
+
+	testutil.TempFile(t, upper, "README.md", "changed")
+	os.MkdirAll(filepath.Join(upper, "build"), 0755)
+	testutil.TempFile(t, filepath.Join(upper, "build"), "app", "binary")
+	os.MkdirAll(filepath.Join(upper, "src"), 0755)
+	testutil.TempFile(t, filepath.Join(upper, "src"), "lib.go", "package lib")
+
+	result := &Result{
+		Changes: []Change{
+			{Path: "README.md", Kind: ChangeModified},
+			{Path: "build", Kind: ChangeCreated, IsDir: true},
+			{Path: "old.txt", Kind: ChangeDeleted},
+			{Path: "src", Kind: ChangeReplaced, IsDir: true},
+		},
+		dir:     lower,
+		workdir: workdir,
+		upper:   upper,
+	}
+
+	if err := result.Apply(); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(lower, "README.md"))
+	if string(data) != "changed" {
+		t.Errorf("Expected README.md to be updated, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(lower, "build", "app")); err != nil {
+		t.Errorf("Expected build/app to be created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lower, "old.txt")); !os.IsNotExist(err) {
+		t.Error("Expected old.txt to be deleted")
+	}
+	// A replaced directory keeps nothing of the old one
+	if _, err := os.Stat(filepath.Join(lower, "src", "main.go")); !os.IsNotExist(err) {
+		t.Error("Expected src/main.go to go with the replaced directory")
+	}
+	if _, err := os.Stat(filepath.Join(lower, "src", "lib.go")); err != nil {
+		t.Errorf("Expected src/lib.go in the new directory: %v", err)
+	}
+	if _, err := os.Stat(workdir); !os.IsNotExist(err) {
+		t.Error("Expected sandbox workspace to be removed after Apply")
+	}
+}
+
+func TestRunCapturesChanges(t *testing.T) {
+	if !Supported() {
+		t.Skip("sandbox not supported on this platform")
+	}
+	lower, _ := setupLayers(t)
+
+	result, err := Run("echo hi > created.txt && echo more >> README.md && rm old.txt && echo done", lower)
+	if err != nil {
+		t.Skipf("sandbox unavailable in this environment: %v", err)
+	}
+	defer result.Discard()
+
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", result.ExitCode, result.Stderr)
+	}
+	if result.Stdout != "done\n" {
+		t.Errorf("Expected stdout 'done', got %q", result.Stdout)
+	}
+
+	// The real directory must be untouched
+	if _, err := os.Stat(filepath.Join(lower, "created.txt")); !os.IsNotExist(err) {
+		t.Error("Sandboxed command leaked a file into the real directory")
+	}
+	if _, err := os.Stat(filepath.Join(lower, "old.txt")); err != nil {
+		t.Error("Sandboxed command deleted a real file")
+	}
+
+	kinds := make(map[string]ChangeKind)
+	for _, change := range result.Changes {
+		kinds[change.Path] = change.Kind
+	}
+	if kinds["created.txt"] != ChangeCreated || kinds["README.md"] != ChangeModified || kinds["old.txt"] != ChangeDeleted {
+		t.Errorf("Unexpected changes: %+v", result.Changes)
+	}
+}
+
+func TestRunHasNoNetwork(t *testing.T) {
+	if !Supported() {
+		t.Skip("sandbox not supported on this platform")
+	}
+	dir := testutil.TempDir(t)
+
+	result, err := Run(`awk -F: 'NR > 2 { gsub(/ /, "", $1); print $1 }' /proc/net/dev`, dir)
+	if err != nil {
+		t.Skipf("sandbox unavailable in this environment: %v", err)
+	}
+	defer result.Discard()
+
+	// Only the loopback device exists in a fresh network namespace
+	if result.Stdout != "lo\n" {
+		t.Errorf("Expected only a loopback interface, got %q", result.Stdout)
+	}
+}
+
+func TestRunKeepsHomeReadOnly(t *testing.T) {
+	if !Supported() {
+		t.Skip("sandbox not supported on this platform")
+	}
+	home := testutil.TempDir(t)
+	t.Setenv("HOME", home)
+	dir := testutil.TempDir(t)
+
+	result, err := Run(`touch "$HOME/escaped"`, dir)
+	if err != nil {
+		t.Skipf("sandbox unavailable in this environment: %v", err)
+	}
+	defer result.Discard()
+
+	if result.ExitCode == 0 {
+		t.Error("Expected writing to $HOME to fail inside the sandbox")
+	}
+	if _, err := os.Stat(filepath.Join(home, "escaped")); !os.IsNotExist(err) {
+		t.Error("Sandboxed command wrote to the real $HOME")
+	}
+}
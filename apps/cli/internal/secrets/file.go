@@ -0,0 +1,236 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	fileName         = "secrets.enc"
+	fileMagic        = "MAKOSEC1"
+	saltSize         = 16
+	pbkdf2Iterations = 600000
+
+	// PassphraseEnv can hold the passphrase for the encrypted secrets file
+	PassphraseEnv = "MAKO_SECRETS_PASSPHRASE"
+)
+
+var (
+	passphraseMu sync.Mutex
+	passphrase   string
+
+	// Deriving the key is deliberately slow, so keep the last one around
+	cachedPass string
+	cachedSalt []byte
+	cachedKey  []byte
+)
+
+// SetPassphrase provides the passphrase for the file backend for the rest of
+// the process, e.g. after prompting for it at startup
+func SetPassphrase(p string) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	passphrase = p
+}
+
+// HasPassphrase reports whether a passphrase was set or is in the environment
+func HasPassphrase() bool {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	return passphrase != "" || os.Getenv(PassphraseEnv) != ""
+}
+
+// FilePath returns the location of the encrypted secrets file
+func FilePath(makoDir string) string {
+	return filepath.Join(makoDir, fileName)
+}
+
+// FileBackend keeps secrets in a single AES-GCM encrypted JSON file whose key
+// is derived from a passphrase
+type FileBackend struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileBackend returns a file backend stored at path
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{path: path}
+}
+
+func (b *FileBackend) Name() string {
+	return BackendFile
+}
+
+func (b *FileBackend) Get(name string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	values, _, err := b.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (b *FileBackend) Set(name, value string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	values, salt, err := b.load()
+	if err != nil {
+		return err
+	}
+	values[name] = value
+	return b.save(values, salt)
+}
+
+func (b *FileBackend) Delete(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	values, salt, err := b.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := values[name]; !ok {
+		return nil
+	}
+	delete(values, name)
+	return b.save(values, salt)
+}
+
+func (b *FileBackend) List() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	values, _, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load decrypts the file. A missing file is an empty store with a fresh salt.
+func (b *FileBackend) load() (map[string]string, []byte, error) {
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		return make(map[string]string), salt, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := len(fileMagic) + saltSize
+	if len(data) < header || string(data[:len(fileMagic)]) != fileMagic {
+		return nil, nil, fmt.Errorf("%s is not a mako secrets file", b.path)
+	}
+	salt := data[len(fileMagic):header]
+
+	aead, err := fileCipher(salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed := data[header:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, nil, fmt.Errorf("%s is truncated", b.path)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(fileMagic))
+	if err != nil {
+		return nil, nil, ErrWrongPassphrase
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse secrets file: %w", err)
+	}
+	return values, salt, nil
+}
+
+// save encrypts values and atomically replaces the file
+func (b *FileBackend) save(values map[string]string, salt []byte) error {
+	aead, err := fileCipher(salt)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fileMagic)
+	buf.Write(salt)
+	buf.Write(nonce)
+	buf.Write(aead.Seal(nil, nonce, plain, []byte(fileMagic)))
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// fileCipher derives the file key for salt from the current passphrase
+func fileCipher(salt []byte) (cipher.AEAD, error) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+
+	p := passphrase
+	if p == "" {
+		p = os.Getenv(PassphraseEnv)
+	}
+	if p == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	if cachedKey == nil || cachedPass != p || !bytes.Equal(cachedSalt, salt) {
+		key, err := pbkdf2.Key(sha256.New, p, salt, pbkdf2Iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+		cachedPass = p
+		cachedSalt = append([]byte(nil), salt...)
+		cachedKey = key
+	}
+
+	block, err := aes.NewCipher(cachedKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// RefPrefix marks a config value that names a secret instead of holding it
+const RefPrefix = "secret://"
+
+// Backend names accepted by Open
+const (
+	BackendFile          = "file"
+	BackendSecretService = "secret-service"
+	BackendPass          = "pass"
+	BackendCommand       = "command"
+)
+
+var (
+	// ErrNotFound is returned when a secret does not exist in the backend
+	ErrNotFound = errors.New("secret not found")
+
+	// ErrPassphraseRequired is returned by the file backend when no
+	// passphrase has been provided
+	ErrPassphraseRequired = errors.New("secrets file is locked, passphrase required")
+
+	// ErrWrongPassphrase is returned when the secrets file cannot be decrypted
+	ErrWrongPassphrase = errors.New("wrong passphrase for secrets file")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Backend stores named secrets
+type Backend interface {
+	// Name returns the backend identifier (e.g. "file", "pass")
+	Name() string
+
+	// Get returns the secret value or ErrNotFound
+	Get(name string) (string, error)
+
+	// Set creates or replaces a secret
+	Set(name, value string) error
+
+	// Delete removes a secret; deleting a missing secret is not an error
+	Delete(name string) error
+
+	// List returns the names of all stored secrets
+	List() ([]string, error)
+}
+
+// Open returns the named backend. An empty name picks one with Detect.
+// command is only used by the command backend.
+func Open(name, command, makoDir string) (Backend, error) {
+	if name == "" {
+		name = Detect()
+	}
+
+	switch name {
+	case BackendFile:
+		return NewFileBackend(FilePath(makoDir)), nil
+	case BackendSecretService:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, fmt.Errorf("secret-service backend requires secret-tool (libsecret-tools)")
+		}
+		return &secretServiceBackend{}, nil
+	case BackendPass:
+		if _, err := exec.LookPath("pass"); err != nil {
+			return nil, fmt.Errorf("pass backend requires pass to be installed")
+		}
+		return &passBackend{}, nil
+	case BackendCommand:
+		if command == "" {
+			return nil, fmt.Errorf("command backend requires secrets_command to be set")
+		}
+		return &commandBackend{command: command}, nil
+	default:
+		return nil, fmt.Errorf("unknown secrets backend: %s (use file, secret-service, pass or command)", name)
+	}
+}
+
+// Detect picks the best available backend: the desktop keyring when a
+// session bus is available, then an initialized pass store, then the
+// encrypted file
+func Detect() string {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return BackendSecretService
+		}
+	}
+	if _, err := exec.LookPath("pass"); err == nil {
+		if _, err := os.Stat(passStoreDir()); err == nil {
+			return BackendPass
+		}
+	}
+	return BackendFile
+}
+
+// IsRef reports whether value is a secret reference
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, RefPrefix)
+}
+
+// Ref returns the reference for a secret name
+func Ref(name string) string {
+	return RefPrefix + name
+}
+
+// RefName returns the secret name from a reference
+func RefName(value string) string {
+	return strings.TrimPrefix(value, RefPrefix)
+}
+
+// ValidateName checks that name is safe to use as a key, file or attribute
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q: use letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// Resolve returns value unchanged unless it is a secret reference, in which
+// case the secret is read from backend
+func Resolve(backend Backend, value string) (string, error) {
+	if !IsRef(value) {
+		return value, nil
+	}
+	name := RefName(value)
+	secret, err := backend.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %q from %s: %w", name, backend.Name(), err)
+	}
+	return secret, nil
+}
+
+// toolError is a failed external program run, keeping stderr for the message
+type toolError struct {
+	tool   string
+	stderr string
+	err    error
+}
+
+func (e *toolError) Error() string {
+	if e.stderr != "" {
+		return e.tool + ": " + e.stderr
+	}
+	return e.tool + ": " + e.err.Error()
+}
+
+func (e *toolError) Unwrap() error {
+	return e.err
+}
+
+// isSilentMiss reports whether a lookup failed without output or a message,
+// which is how secret-tool and most password manager CLIs signal "not found"
+func isSilentMiss(out string, err error) bool {
+	var te *toolError
+	var exitErr *exec.ExitError
+	return strings.TrimSpace(out) == "" && errors.As(err, &te) && te.stderr == "" && errors.As(err, &exitErr)
+}
+
+// runTool runs an external program, feeding stdin and returning stdout
+// without the trailing newline
+func runTool(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), &toolError{tool: name, stderr: strings.TrimSpace(stderr.String()), err: err}
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
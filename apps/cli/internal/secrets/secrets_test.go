@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func TestFileBackendRoundTrip(t *testing.T) {
+	testutil.SetEnv(t, PassphraseEnv, "correct horse battery")
+	path := filepath.Join(testutil.TempDir(t), "secrets.enc")
+	backend := NewFileBackend(path)
+
+	if _, err := backend.Get("gemini"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from empty store, got %v", err)
+	}
+
+	if err := backend.Set("gemini", "AIzaSyTestKey123456789"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := backend.Set("openai", "sk-test"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "AIzaSyTestKey") {
+		t.Error("Secrets file contains the plaintext key")
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected 0600 permissions, got %v", info.Mode().Perm())
+	}
+
+	// A fresh backend reads what the first one wrote
+	value, err := NewFileBackend(path).Get("gemini")
+	if err != nil || value != "AIzaSyTestKey123456789" {
+		t.Errorf("Get() = %q, %v", value, err)
+	}
+
+	if err := backend.Delete("openai"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	names, _ := backend.List()
+	if len(names) != 1 || names[0] != "gemini" {
+		t.Errorf("Expected [gemini], got %v", names)
+	}
+}
+
+func TestFileBackendPassphrase(t *testing.T) {
+	path := filepath.Join(testutil.TempDir(t), "secrets.enc")
+	testutil.SetEnv(t, PassphraseEnv, "")
+
+	if err := NewFileBackend(path).Set("gemini", "key"); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+
+	SetPassphrase("first passphrase")
+	defer SetPassphrase("")
+	if err := NewFileBackend(path).Set("gemini", "key"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	SetPassphrase("second passphrase")
+	if _, err := NewFileBackend(path).Get("gemini"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+}
+
+func TestCommandBackend(t *testing.T) {
+	dir := testutil.TempDir(t)
+	script := testutil.TempFile(t, dir, "store.sh", `#!/bin/sh
+dir="$(dirname "$0")/store"
+mkdir -p "$dir"
+case "$1" in
+get) [ -f "$dir/$2" ] || exit 1; cat "$dir/$2" ;;
+set) cat > "$dir/$2" ;;
+delete) rm -f "$dir/$2" ;;
+list) ls "$dir" ;;
+esac
+`)
+	os.Chmod(script, 0755)
+
+	backend, err := Open(BackendCommand, script, dir)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	if _, err := backend.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := backend.Set("anthropic", "sk-ant-test"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	value, err := Resolve(backend, Ref("anthropic"))
+	if err != nil || value != "sk-ant-test" {
+		t.Errorf("Resolve() = %q, %v", value, err)
+	}
+
+	names, err := backend.List()
+	if err != nil || len(names) != 1 || names[0] != "anthropic" {
+		t.Errorf("List() = %v, %v", names, err)
+	}
+}
+
+func TestResolvePlainValue(t *testing.T) {
+	// Plain values never touch the backend
+	value, err := Resolve(nil, "plain-key")
+	if err != nil || value != "plain-key" {
+		t.Errorf("Resolve() = %q, %v", value, err)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"gemini", "api_key", "work.openai-2"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("Expected %q to be valid: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../etc/passwd", "has space", "a/b"} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+}
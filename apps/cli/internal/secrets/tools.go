@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// secretServiceBackend stores secrets in the desktop keyring (GNOME Keyring,
+// KWallet, KeePassXC) through the Secret Service D-Bus API, using secret-tool
+type secretServiceBackend struct{}
+
+func (b *secretServiceBackend) Name() string {
+	return BackendSecretService
+}
+
+func (b *secretServiceBackend) Get(name string) (string, error) {
+	value, err := runTool("", "secret-tool", "lookup", "service", "mako", "name", name)
+	if err != nil {
+		// secret-tool exits 1 without output for missing items
+		if isSilentMiss(value, err) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+func (b *secretServiceBackend) Set(name, value string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	_, err := runTool(value, "secret-tool", "store", "--label", "Mako: "+name, "service", "mako", "name", name)
+	return err
+}
+
+func (b *secretServiceBackend) Delete(name string) error {
+	_, err := runTool("", "secret-tool", "clear", "service", "mako", "name", name)
+	return err
+}
+
+func (b *secretServiceBackend) List() ([]string, error) {
+	out, err := runTool("", "secret-tool", "search", "--all", "--unlock", "service", "mako")
+	if err != nil && strings.TrimSpace(out) == "" {
+		// No matching items is reported as an error
+		return nil, nil
+	}
+
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(line, " = "); ok && strings.TrimSpace(key) == "attribute.name" {
+			names = append(names, strings.TrimSpace(value))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// passBackend stores secrets as mako/<name> entries in the pass password store
+type passBackend struct{}
+
+func (b *passBackend) Name() string {
+	return BackendPass
+}
+
+func (b *passBackend) Get(name string) (string, error) {
+	value, err := runTool("", "pass", "show", "mako/"+name)
+	if err != nil {
+		if strings.Contains(err.Error(), "is not in the password store") {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	// Only the first line is the secret by pass convention
+	first, _, _ := strings.Cut(value, "\n")
+	return first, nil
+}
+
+func (b *passBackend) Set(name, value string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	_, err := runTool(value+"\n", "pass", "insert", "--multiline", "--force", "mako/"+name)
+	return err
+}
+
+func (b *passBackend) Delete(name string) error {
+	_, err := runTool("", "pass", "rm", "--force", "mako/"+name)
+	if err != nil && strings.Contains(err.Error(), "is not in the password store") {
+		return nil
+	}
+	return err
+}
+
+func (b *passBackend) List() ([]string, error) {
+	dir := filepath.Join(passStoreDir(), "mako")
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".gpg") {
+			names = append(names, strings.TrimSuffix(d.Name(), ".gpg"))
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	sort.Strings(names)
+	return names, err
+}
+
+func passStoreDir() string {
+	if dir := os.Getenv("PASSWORD_STORE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".password-store")
+}
+
+// commandBackend delegates to a user command, run through sh with the
+// action and secret name appended as arguments:
+//
+//	<command> get <name>     print the secret; exit non-zero with no output if missing
+//	<command> set <name>     read the secret from stdin
+//	<command> delete <name>
+//	<command> list           print one name per line
+//
+// This covers password managers with a CLI (1Password, Bitwarden, Vault)
+// through a small wrapper script.
+type commandBackend struct {
+	command string
+}
+
+func (b *commandBackend) Name() string {
+	return BackendCommand
+}
+
+func (b *commandBackend) run(stdin string, args ...string) (string, error) {
+	shArgs := append([]string{"-c", b.command + ` "$@"`, "mako-secrets"}, args...)
+	return runTool(stdin, "sh", shArgs...)
+}
+
+func (b *commandBackend) Get(name string) (string, error) {
+	value, err := b.run("", "get", name)
+	if err != nil {
+		if isSilentMiss(value, err) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+func (b *commandBackend) Set(name, value string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	_, err := b.run(value, "set", name)
+	return err
+}
+
+func (b *commandBackend) Delete(name string) error {
+	_, err := b.run("", "delete", name)
+	return err
+}
+
+func (b *commandBackend) List() ([]string, error) {
+	out, err := b.run("", "list")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -2,14 +2,19 @@ package shell
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/fabiobrug/mako.git/internal/ai"
 	"github.com/fabiobrug/mako.git/internal/alias"
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/context"
 	"github.com/fabiobrug/mako.git/internal/database"
 )
 
@@ -22,21 +27,36 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 	gray := "\033[38;2;150;150;150m"
 	reset := "\033[0m"
 
-	store, err := alias.NewAliasStore()
-	if err != nil {
-		return "", err
-	}
-
 	if len(args) == 0 {
-		return fmt.Sprintf("\n%sUsage:%s mako alias <save|list|delete|run> [args]\n\n", lightBlue, reset), nil
+		return fmt.Sprintf("\n%sUsage:%s mako alias <save|list|delete|run|sources|checksum> [args]\n\n", lightBlue, reset), nil
 	}
 
 	subcommand := args[0]
 
+	// --project edits the project's aliases instead of your own
+	project := false
+	if subcommand == "save" || subcommand == "delete" {
+		var rest []string
+		for _, arg := range args {
+			if arg == "--project" {
+				project = true
+			} else {
+				rest = append(rest, arg)
+			}
+		}
+		args = rest
+	}
+
+	layers, err := loadAliases(subcommand == "sources" && len(args) > 1 && args[1] == "--refresh")
+	if err != nil {
+		return "", err
+	}
+	store := layers.User
+
 	switch subcommand {
 	case "save":
 		if len(args) < 3 {
-			return fmt.Sprintf("\n%sUsage:%s mako alias save <name> <command> [--tags tag1,tag2,...]\n\n", lightBlue, reset), nil
+			return fmt.Sprintf("\n%sUsage:%s mako alias save [--project] <name> <command> [--tags tag1,tag2,...]\n\n", lightBlue, reset), nil
 		}
 		name := args[1]
 		
@@ -66,7 +86,14 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 			tags = []string{}
 		}
 
-		if err := store.Set(name, command, tags); err != nil {
+		if _, err := alias.Params(command); err != nil {
+			return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+		}
+		if project {
+			if err := layers.SetProject(name, command, tags); err != nil {
+				return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+			}
+		} else if err := store.Set(name, command, tags); err != nil {
 			return "", err
 		}
 
@@ -74,7 +101,11 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 		if len(tags) > 0 {
 			tagStr = fmt.Sprintf(" %s[tags: %s]%s", dimBlue, strings.Join(tags, ", "), reset)
 		}
-		result := fmt.Sprintf("\r\n%s✓ Saved alias '%s':%s %s%s", green, name, reset, command, tagStr)
+		where := ""
+		if project {
+			where = " to the project"
+		}
+		result := fmt.Sprintf("\r\n%s✓ Saved alias '%s'%s:%s %s%s", green, name, where, reset, command, tagStr)
 		
 		// Check if parameters are missing (user might have forgotten to escape)
 		if strings.Contains(command, "cmd/mako/") && !strings.Contains(command, "$") {
@@ -85,17 +116,26 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 		return result, nil
 
 	case "list":
-		// Check for --tag filter
+		// Check for --tag and --source filters
 		var filterTag string
-		if len(args) > 1 && args[1] == "--tag" && len(args) > 2 {
-			filterTag = args[2]
+		var source alias.Source
+		for i := 1; i < len(args)-1; i++ {
+			switch args[i] {
+			case "--tag":
+				filterTag = args[i+1]
+			case "--source":
+				source = alias.Source(args[i+1])
+			}
+		}
+		if source != "" && layers.Layer(source) == nil {
+			return fmt.Sprintf("\r\n%sNo %s aliases: sources are user, project (inside a project) and team (alias_team_source)%s\r\n\r\n", dimBlue, source, reset), nil
 		}
 
-		var aliases map[string]alias.AliasInfo
-		if filterTag != "" {
-			aliases = store.ListByTag(filterTag)
-		} else {
-			aliases = store.List()
+		var aliases []alias.Entry
+		for _, entry := range layers.List(source) {
+			if filterTag == "" || slices.Contains(entry.Tags, filterTag) {
+				aliases = append(aliases, entry)
+			}
 		}
 
 		if len(aliases) == 0 {
@@ -108,32 +148,51 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 		}
 
 		var output strings.Builder
-		if filterTag != "" {
+		switch {
+		case filterTag != "":
 			output.WriteString(fmt.Sprintf("\r\n%s╭─ Aliases tagged '%s'%s\r\n", lightBlue, filterTag, reset))
-		} else {
+		case source != "":
+			output.WriteString(fmt.Sprintf("\r\n%s╭─ %s Aliases%s\r\n", lightBlue, strings.ToUpper(string(source[:1]))+string(source[1:]), reset))
+		default:
 			output.WriteString(fmt.Sprintf("\r\n%s╭─ Saved Aliases%s\r\n", lightBlue, reset))
 		}
 		
-		for name, info := range aliases {
+		for _, info := range aliases {
 			tagStr := ""
 			if len(info.Tags) > 0 {
 				tagStr = fmt.Sprintf(" %s[%s]%s", dimBlue, strings.Join(info.Tags, ", "), reset)
 			}
-			output.WriteString(fmt.Sprintf("%s│%s  %s%s%s → %s%s\r\n",
+			sourceStr := ""
+			if info.Source != alias.SourceUser {
+				sourceStr = fmt.Sprintf(" %s(%s)%s", gray, info.Source, reset)
+			}
+			if len(info.Shadows) > 0 {
+				sourceStr += fmt.Sprintf(" %soverrides %s%s", gray, joinSources(info.Shadows), reset)
+			}
+			output.WriteString(fmt.Sprintf("%s│%s  %s%s%s → %s%s%s\r\n",
 				lightBlue, reset,
-				cyan, name, reset,
-				info.Command, tagStr))
+				cyan, info.Name, reset,
+				info.Command, tagStr, sourceStr))
 		}
 		output.WriteString(fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset))
 		return output.String(), nil
 
 	case "delete":
 		if len(args) < 2 {
-			return fmt.Sprintf("\r\n%sUsage:%s mako alias delete <name>\r\n\r\n", lightBlue, reset), nil
+			return fmt.Sprintf("\r\n%sUsage:%s mako alias delete [--project] <name>\r\n\r\n", lightBlue, reset), nil
 		}
 		name := args[1]
 
-		if err := store.Delete(name); err != nil {
+		if project {
+			err = layers.DeleteProject(name)
+		} else if entry, ok := layers.Resolve(name); ok && entry.Source == alias.SourceTeam {
+			err = fmt.Errorf("alias '%s' comes from the team source, which is read-only", name)
+		} else if ok && entry.Source == alias.SourceProject {
+			err = fmt.Errorf("alias '%s' is a project alias; use --project to delete it", name)
+		} else {
+			err = store.Delete(name)
+		}
+		if err != nil {
 			result := fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset)
 			return result, nil
 		}
@@ -148,13 +207,18 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 		name := args[1]
 		aliasArgs := args[2:] // Extra arguments for parameter substitution
 
-		command, ok := store.Get(name)
+		entry, ok := layers.Resolve(name)
 		if !ok {
 			return fmt.Sprintf("\n%s✗ Alias '%s' not found%s\n\n", red, name, reset), nil
 		}
 
-		// Expand parameters ($1, $2, $@, etc.)
-		command = alias.ExpandParameters(command, aliasArgs)
+		// Expand parameters ({{name}}, $1, $@, etc.), asking for missing ones
+		command, err := alias.Expand(entry.Command, aliasArgs, promptAliasParam)
+		if errors.Is(err, errInputCancelled) {
+			return fmt.Sprintf("\r\n%sℹ Cancelled%s\r\n\r\n", gray, reset), nil
+		} else if err != nil {
+			return fmt.Sprintf("\r\n%s✗ Alias '%s': %v%s\r\n\r\n", red, name, err, reset), nil
+		}
 
 		// Execute the aliased command
 		tty, _ := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
@@ -167,7 +231,11 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 			}
 		}
 
-		writeTTY(fmt.Sprintf("\r\n%s╭─ Running Alias '%s'%s\r\n", lightBlue, name, reset))
+		if entry.Source != alias.SourceUser {
+			writeTTY(fmt.Sprintf("\r\n%s╭─ Running %s Alias '%s'%s\r\n", lightBlue, entry.Source, name, reset))
+		} else {
+			writeTTY(fmt.Sprintf("\r\n%s╭─ Running Alias '%s'%s\r\n", lightBlue, name, reset))
+		}
 		writeTTY(fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, command, reset))
 		writeTTY(fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset))
 
@@ -212,7 +280,7 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 				}
 			}
 
-			safeCommand := validator.RedactSecrets(command)
+			safeCommand := database.RedactSecrets(command)
 
 			embedService, _ := ai.NewEmbeddingProvider()
 			var embeddingBytes []byte
@@ -266,7 +334,114 @@ func handleAlias(args []string, db *database.DB) (string, error) {
 		result := fmt.Sprintf("\r\n%s✓ Imported aliases from '%s'%s\r\n\r\n", green, importPath, reset)
 		return result, nil
 
+	case "sources":
+		var output strings.Builder
+		output.WriteString(fmt.Sprintf("\r\n%s╭─ Alias Sources%s %s(highest precedence first)%s\r\n", lightBlue, reset, gray, reset))
+		for _, layer := range layers.Layers() {
+			status := fmt.Sprintf("%d aliases", len(layer.Aliases))
+			switch {
+			case layer.Err != nil:
+				status = fmt.Sprintf("%s✗ %v%s", red, layer.Err, reset)
+			case layer.Source == alias.SourceUser:
+			case layer.Verified:
+				status += fmt.Sprintf(", %s✓ sha256 %s%s", green, layer.Checksum[:12], reset)
+			default:
+				status += fmt.Sprintf(", %sunverified sha256 %s%s", gray, layer.Checksum[:12], reset)
+			}
+			if layer.ReadOnly {
+				status += fmt.Sprintf(" %s(read-only)%s", gray, reset)
+			}
+			output.WriteString(fmt.Sprintf("%s│%s  %s%-8s%s %s\r\n", lightBlue, reset, cyan, layer.Source, reset, layer.Location))
+			output.WriteString(fmt.Sprintf("%s│%s           %s\r\n", lightBlue, reset, status))
+		}
+		if layers.Layer(alias.SourceTeam) == nil {
+			output.WriteString(fmt.Sprintf("%s│%s  %sNo team source: mako config set alias_team_source <url|git repo|file>%s\r\n", lightBlue, reset, gray, reset))
+		}
+		output.WriteString(fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset))
+		return output.String(), nil
+
+	case "checksum":
+		if len(args) < 2 {
+			return fmt.Sprintf("\r\n%sUsage:%s mako alias checksum <file>\r\n\r\n", lightBlue, reset), nil
+		}
+		sum, err := alias.SealFile(args[1])
+		if err != nil {
+			return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+		}
+		result := fmt.Sprintf("\r\n%s✓ Wrote sha256 %s to '%s'%s\r\n", green, sum, args[1], reset)
+		result += fmt.Sprintf("%s  To pin it for a team source: mako config set alias_team_checksum %s%s\r\n\r\n", gray, sum, reset)
+		return result, nil
+
 	default:
 		return fmt.Sprintf("\n%sUnknown alias subcommand: %s%s\n\n", red, subcommand, reset), nil
 	}
 }
+
+// promptAliasParam asks for an alias parameter that wasn't given: choices
+// are picked with mako-menu, anything else is typed in
+func promptAliasParam(p alias.Param) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if len(p.Choices) > 0 {
+		menuArgs := []string{fmt.Sprintf("%sChoose %s%s", lightBlue, p.Name, reset)}
+		for _, choice := range p.Choices {
+			menuArgs = append(menuArgs, choice+"|"+choice)
+		}
+		choice, err := withInputPaused(func() (string, error) {
+			menuCmd := exec.Command(findMenuPath(), menuArgs...)
+			menuCmd.Stderr = os.Stderr
+			out, err := menuCmd.Output()
+			return strings.TrimSpace(string(out)), err
+		})
+		if err != nil {
+			return "", fmt.Errorf("menu failed: %w", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if !slices.Contains(p.Choices, choice) {
+			return "", errInputCancelled
+		}
+		return choice, nil
+	}
+
+	return withInputPaused(func() (string, error) {
+		tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+		if err != nil {
+			return "", err
+		}
+		defer tty.Close()
+
+		hint := ""
+		if p.Pattern != nil {
+			hint = fmt.Sprintf(" %s(%s)%s", gray, strings.TrimSuffix(strings.TrimPrefix(p.Pattern.String(), "^(?:"), ")$"), reset)
+		}
+		fmt.Fprintf(tty, "\r\n%s%s%s%s: ", lightBlue, p.Name, reset, hint)
+		value, err := readLineFromTTY("")
+		tty.WriteString("\r\n")
+		return strings.TrimSpace(value), err
+	})
+}
+
+// loadAliases loads your aliases together with the current project's and
+// the team source from the config
+func loadAliases(refresh bool) (*alias.LayeredStore, error) {
+	opts := alias.LayerOptions{
+		ProjectRoot: context.FindProjectRoot(),
+		CacheDir:    filepath.Join(os.Getenv("HOME"), ".mako", "cache"),
+		Refresh:     refresh,
+	}
+	if cfg, err := config.LoadConfig(); err == nil {
+		opts.TeamSource = cfg.AliasTeamSource
+		opts.TeamChecksum = cfg.AliasTeamChecksum
+	}
+	return alias.NewLayeredStore(opts)
+}
+
+func joinSources(sources []alias.Source) string {
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		names[i] = string(source)
+	}
+	return strings.Join(names, ", ")
+}
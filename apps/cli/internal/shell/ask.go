@@ -2,6 +2,7 @@ package shell
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -13,41 +14,32 @@ import (
 	"github.com/fabiobrug/mako.git/internal/ai"
 	"github.com/fabiobrug/mako.git/internal/database"
 	"github.com/fabiobrug/mako.git/internal/safety"
+	"github.com/fabiobrug/mako.git/internal/sandbox"
 )
 
-func handleAsk(query string, db *database.DB) (string, error) {
+func handleAsk(query, thread string, verbose bool, db *database.DB) (string, error) {
 	client, err := ai.NewAIProvider()
 	if err != nil {
 		return "", err
 	}
 
 	// Load conversation history
-	conversation, err := ai.LoadConversation()
+	conversation, err := loadAskThread(thread)
 	if err != nil {
 		// Log error but continue without conversation
 		fmt.Fprintf(os.Stderr, "Warning: Failed to load conversation: %v\n", err)
 		conversation = nil
 	}
 
-	// ENHANCED: Get recent output and commands for context
-	var recentOutput []string
-	if recentOutputGetter != nil {
-		recentOutput = recentOutputGetter(10) // Last 10 lines
-	}
+	// Build enhanced context
+	context := buildAskContext(db)
 
-	var recentCommands []string
-	if db != nil {
-		commands, err := db.GetRecentCommands(5)
-		if err == nil {
-			for _, cmd := range commands {
-				recentCommands = append(recentCommands, cmd.Command)
-			}
-		}
+	// The same prompt the request sends, for its token counts
+	var budget *ai.TokenBudget
+	if verbose {
+		budget = client.BuildPrompt(query, context, conversation).Budget
 	}
 
-	// Build enhanced context
-	context := ai.GetEnhancedContext(recentOutput, recentCommands)
-
 	// Generate command with conversation history
 	command, err := client.GenerateCommandWithConversation(query, context, conversation)
 	if err != nil {
@@ -84,9 +76,19 @@ func handleAsk(query string, db *database.DB) (string, error) {
 	}
 
 	// Display command
-	output := fmt.Sprintf("\r\n%s╭─ Generated Command%s\r\n", lightBlue, reset)
+	title := "Generated Command"
+	if conversation != nil && !conversation.Ephemeral {
+		title += " · " + conversation.Name
+	}
+	output := fmt.Sprintf("\r\n%s╭─ %s%s\r\n", lightBlue, title, reset)
 	output += fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, command, reset)
 	output += fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset)
+	if conversation != nil && conversation.Expired() {
+		output += fmt.Sprintf("%sThe previous conversation was idle for over %s, so this one started fresh.\r\nUse --thread <name> to keep one going.%s\r\n", gray, conversation.Limits().Timeout, reset)
+	}
+	if budget != nil {
+		output += formatTokenBudget(budget, gray, reset) + "\r\n"
+	}
 	writeTTY(output)
 
 	// Block critical commands
@@ -115,46 +117,23 @@ func handleAsk(query string, db *database.DB) (string, error) {
 	}
 
 	if !validationResult.Safe {
-		menuArgs = append(menuArgs,
-			"Confirm and run|run",
-			"Explain what this does|explain",
-			"Suggest alternatives|alternatives",
-			"Edit before running|edit",
-			"Copy to clipboard|copy",
-			"Cancel|cancel",
-		)
+		menuArgs = append(menuArgs, "Confirm and run|run")
 	} else {
-		menuArgs = append(menuArgs,
-			"Run command|run",
-			"Explain what this does|explain",
-			"Suggest alternatives|alternatives",
-			"Edit before running|edit",
-			"Copy to clipboard|copy",
-			"Cancel|cancel",
-		)
+		menuArgs = append(menuArgs, "Run command|run")
 	}
-
-	// Call menu
-	var menuPath string
-	possiblePaths := []string{
-		"./mako-menu",
-		filepath.Join(filepath.Dir(os.Args[0]), "mako-menu"),
-		"mako-menu",
-	}
-
-	for _, path := range possiblePaths {
-		if absPath, err := filepath.Abs(path); err == nil {
-			if _, err := os.Stat(absPath); err == nil {
-				menuPath = absPath
-				break
-			}
-		}
-	}
-
-	if menuPath == "" {
-		menuPath = "mako-menu"
+	if sandbox.Supported() {
+		menuArgs = append(menuArgs, "Run in sandbox|sandbox")
 	}
+	menuArgs = append(menuArgs,
+		"Explain what this does|explain",
+		"Suggest alternatives|alternatives",
+		"Edit before running|edit",
+		"Copy to clipboard|copy",
+		"Cancel|cancel",
+	)
 
+	// Call menu
+	menuPath := findMenuPath()
 	menuCmd := exec.Command(menuPath, menuArgs...)
 	menuCmd.Stderr = os.Stderr
 
@@ -173,6 +152,9 @@ func handleAsk(query string, db *database.DB) (string, error) {
 	case "run":
 		return handleAskRun(query, command, db, client, conversation, context, writeTTY, cyan, lightBlue, green, red, gray, reset)
 
+	case "sandbox":
+		return handleAskSandbox(query, command, conversation, writeTTY, cyan, lightBlue, green, red, gray, reset)
+
 	case "explain":
 		return handleAskExplain(command, client, context, writeTTY, cyan, lightBlue, red, reset)
 
@@ -193,95 +175,87 @@ func handleAsk(query string, db *database.DB) (string, error) {
 	}
 }
 
-func handleAskRun(query, command string, db *database.DB, client ai.AIProvider, conversation *ai.ConversationHistory, context ai.SystemContext, writeTTY func(string), cyan, lightBlue, green, red, gray, reset string) (string, error) {
-	writeTTY(fmt.Sprintf("\r\n%s▸ Executing...%s\r\n\r\n", cyan, reset))
-
-	cmd := exec.Command("bash", "-c", command)
-	cmd.Stdin = os.Stdin
+// buildAskContext gathers recent output and commands for the prompt
+func buildAskContext(db *database.DB) ai.SystemContext {
+	var recentOutput []string
+	if recentOutputGetter != nil {
+		recentOutput = recentOutputGetter(10) // Last 10 lines
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	var recentCommands []string
+	if db != nil {
+		commands, err := db.GetRecentCommands(5)
+		if err == nil {
+			for _, cmd := range commands {
+				recentCommands = append(recentCommands, cmd.Command)
+			}
+		}
+	}
 
-	startTime := time.Now()
-	execErr := cmd.Run()
-	duration := time.Since(startTime).Milliseconds()
+	ctx := ai.GetEnhancedContext(recentOutput, recentCommands)
+	ctx.Preferences = loadPreferences(db)
+	return ctx
+}
 
-	// Output with proper line endings
-	outputStr := ""
-	if stdout.Len() > 0 {
-		outputStr = stdout.String()
-		output := strings.ReplaceAll(outputStr, "\n", "\r\n")
-		writeTTY(output)
+// formatTokenBudget reports how much of the model's window a prompt used
+// and what was trimmed to fit
+func formatTokenBudget(budget *ai.TokenBudget, gray, reset string) string {
+	line := fmt.Sprintf("%sTokens: %s%s", gray, budget, reset)
+	if len(budget.Trimmed) > 0 {
+		line += fmt.Sprintf("\r\n%sTrimmed to fit: %s%s", gray, strings.Join(budget.Trimmed, ", "), reset)
 	}
-	if stderr.Len() > 0 {
-		errOutput := stderr.String()
-		if outputStr != "" {
-			outputStr += "\n" + errOutput
-		} else {
-			outputStr = errOutput
-		}
-		errOutput = strings.ReplaceAll(errOutput, "\n", "\r\n")
-		writeTTY(errOutput)
+	return line
+}
+
+// handleAskShowPrompt prints the exact prompt mako ask would send, without
+// contacting the provider, so users can audit what leaves the machine
+func handleAskShowPrompt(query, thread string, db *database.DB) (string, error) {
+	client, err := ai.NewAIProvider()
+	if err != nil {
+		return "", err
 	}
 
-	// Save to database
-	if db != nil {
-		workingDir, _ := os.Getwd()
-		exitCode := 0
-		if execErr != nil {
-			if exitErr, ok := execErr.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
-			} else {
-				exitCode = 1
-			}
-		}
+	conversation, err := loadAskThread(thread)
+	if err != nil {
+		conversation = nil
+	}
 
-		safeCommand := validator.RedactSecrets(command)
+	prompt := client.BuildPrompt(query, buildAskContext(db), conversation)
 
-		embedService, _ := ai.NewEmbeddingProvider()
-		var embeddingBytes []byte
-		if embedService != nil {
-			embeddingBytes, _ = embedService.GenerateEmbedding(safeCommand)
-		}
+	lightBlue := "\033[38;2;93;173;226m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
 
-		db.SaveCommand(database.Command{
-			Command:       safeCommand,
-			Timestamp:     time.Now(),
-			ExitCode:      exitCode,
-			Duration:      duration,
-			WorkingDir:    workingDir,
-			OutputPreview: outputStr,
-			Embedding:     embeddingBytes,
-		})
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("\n%s╭─ Prompt (secrets redacted, not sent)%s\n", lightBlue, reset))
+	for _, line := range strings.Split(prompt.String(), "\n") {
+		output.WriteString(fmt.Sprintf("%s│%s  %s\n", lightBlue, reset, line))
 	}
+	output.WriteString(fmt.Sprintf("%s╰─%s\n", lightBlue, reset))
+	if prompt.Budget != nil {
+		output.WriteString(strings.ReplaceAll(formatTokenBudget(prompt.Budget, gray, reset), "\r\n", "\n") + "\n")
+	}
+	output.WriteString(fmt.Sprintf("%sError explanations, command explanations and alternatives are redacted the same way.%s\n\n", gray, reset))
+
+	return output.String(), nil
+}
+
+func handleAskRun(query, command string, db *database.DB, client ai.AIProvider, conversation *ai.ConversationHistory, context ai.SystemContext, writeTTY func(string), cyan, lightBlue, green, red, gray, reset string) (string, error) {
+	writeTTY(fmt.Sprintf("\r\n%s▸ Executing...%s\r\n\r\n", cyan, reset))
+
+	result := runAndRecord(command, db, writeTTY)
 
 	// NEW: Auto-explain errors
-	if execErr != nil {
+	if result.Err != nil {
 		writeTTY(fmt.Sprintf("\r\n%s✗ Command failed%s\r\n", red, reset))
 
 		// Offer error explanation
-		if stderr.Len() > 0 {
+		if result.Stderr != "" {
 			writeTTY(fmt.Sprintf("\r\n%s▸ Getting error explanation...%s\r\n", cyan, reset))
 
-			explanation, explainErr := client.ExplainError(command, stderr.String(), context)
+			explanation, explainErr := client.ExplainError(command, result.Stderr, context)
 			if explainErr == nil && strings.TrimSpace(explanation) != "" {
-				writeTTY(fmt.Sprintf("\r\n%s╭─ Error Analysis%s\r\n", lightBlue, reset))
-
-				// Split into lines and display with proper formatting
-				lines := strings.Split(explanation, "\n")
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if line != "" {
-						// Wrap long lines to fit terminal width
-						wrappedLines := wrapLine(line, 76) // 76 chars to account for "│  " prefix
-						for _, wrappedLine := range wrappedLines {
-							writeTTY(fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, wrappedLine))
-						}
-					}
-				}
-
-				writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+				writeErrorAnalysis(explanation, writeTTY)
 			} else if explainErr != nil {
 				writeTTY(fmt.Sprintf("%s⚠ Could not get explanation: %v%s\r\n", gray, explainErr, reset))
 			}
@@ -299,38 +273,101 @@ func handleAskRun(query, command string, db *database.DB, client ai.AIProvider,
 		}
 	}
 
-	// Learn from executed command
-	if context.Preferences != nil {
-		context.Preferences.LearnFromCommand(command)
-		if err := context.Preferences.Save(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to save preferences: %v\n", err)
+	return "", nil
+}
+
+func handleAskSandbox(query, command string, conversation *ai.ConversationHistory, writeTTY func(string), cyan, lightBlue, green, red, gray, reset string) (string, error) {
+	workingDir, _ := os.Getwd()
+	writeTTY(fmt.Sprintf("\r\n%s▸ Executing in sandbox (network disabled, writes captured)...%s\r\n\r\n", cyan, reset))
+
+	result, err := sandbox.Run(command, workingDir)
+	if err != nil {
+		writeTTY(fmt.Sprintf("%s✗ Sandbox unavailable: %v%s\r\n\r\n", red, err, reset))
+		return "", nil
+	}
+	defer result.Discard()
+
+	if result.Stdout != "" {
+		writeTTY(strings.ReplaceAll(result.Stdout, "\n", "\r\n"))
+	}
+	if result.Stderr != "" {
+		writeTTY(strings.ReplaceAll(result.Stderr, "\n", "\r\n"))
+	}
+
+	if result.ExitCode != 0 {
+		writeTTY(fmt.Sprintf("\r\n%s✗ Command failed in sandbox (exit code %d)%s\r\n", red, result.ExitCode, reset))
+	} else {
+		writeTTY(fmt.Sprintf("\r\n%s✓ Command finished in sandbox%s\r\n", green, reset))
+	}
+
+	// Save conversation turn (not executed against the real filesystem yet)
+	if conversation != nil {
+		conversation.AddTurn(query, command, false)
+		if err := conversation.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save conversation: %v\n", err)
+		}
+	}
+
+	if len(result.Changes) == 0 {
+		writeTTY(fmt.Sprintf("\r\n%sℹ No file changes in %s%s\r\n\r\n", gray, workingDir, reset))
+		return "", nil
+	}
+
+	writeTTY(fmt.Sprintf("\r\n%s╭─ Sandbox Changes%s\r\n", lightBlue, reset))
+	for _, change := range result.Changes {
+		path := change.Path
+		if change.IsDir {
+			path += "/"
+		}
+		switch change.Kind {
+		case sandbox.ChangeCreated:
+			writeTTY(fmt.Sprintf("%s│%s  %s+ %s%s\r\n", lightBlue, reset, green, path, reset))
+		case sandbox.ChangeModified:
+			writeTTY(fmt.Sprintf("%s│%s  %s~ %s%s\r\n", lightBlue, reset, cyan, path, reset))
+		case sandbox.ChangeDeleted:
+			writeTTY(fmt.Sprintf("%s│%s  %s- %s%s\r\n", lightBlue, reset, red, path, reset))
+		case sandbox.ChangeReplaced:
+			writeTTY(fmt.Sprintf("%s│%s  %s! %s%s %s(replaced)%s\r\n", lightBlue, reset, cyan, path, reset, gray, reset))
 		}
 	}
+	writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+
+	time.Sleep(150 * time.Millisecond)
+
+	menuCmd := exec.Command(findMenuPath(),
+		fmt.Sprintf("%sApply these changes to %s?%s", lightBlue, workingDir, reset),
+		"Discard changes|discard",
+		"Apply changes|apply",
+	)
+	menuCmd.Stderr = os.Stderr
+
+	choiceBytes, err := menuCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("menu failed: %w", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
 
+	if strings.TrimSpace(string(choiceBytes)) != "apply" {
+		writeTTY(fmt.Sprintf("\r\n%sℹ Sandbox changes discarded%s\r\n\r\n", gray, reset))
+		return "", nil
+	}
+
+	if err := result.Apply(); err != nil {
+		writeTTY(fmt.Sprintf("\r\n%s✗ Failed to apply changes: %v%s\r\n\r\n", red, err, reset))
+		return "", nil
+	}
+
+	writeTTY(fmt.Sprintf("\r\n%s✓ Applied %d change(s)%s\r\n\r\n", green, len(result.Changes), reset))
 	return "", nil
 }
 
 func handleAskExplain(command string, client ai.AIProvider, context ai.SystemContext, writeTTY func(string), cyan, lightBlue, red, reset string) (string, error) {
-	writeTTY(fmt.Sprintf("\r\n%s▸ Getting explanation...%s\r\n", cyan, reset))
-	
-	explanation, explainErr := client.ExplainCommand(command, context)
-	if explainErr != nil {
-		writeTTY(fmt.Sprintf("\r\n%s✗ Failed to get explanation: %v%s\r\n\r\n", red, explainErr, reset))
+	if err := explainCommand(command, client, context, writeTTY); err != nil {
+		writeTTY(fmt.Sprintf("\r\n%s✗ Failed to get explanation: %v%s\r\n\r\n", red, err, reset))
 		return "", nil
 	}
-
-	writeTTY(fmt.Sprintf("\r\n%s╭─ Command Explanation%s\r\n", lightBlue, reset))
-	lines := strings.Split(explanation, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			wrappedLines := wrapLine(line, 76)
-			for _, wrappedLine := range wrappedLines {
-				writeTTY(fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, wrappedLine))
-			}
-		}
-	}
-	writeTTY(fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset))
+	writeTTY("\r\n")
 	return "", nil
 }
 
@@ -364,6 +401,9 @@ func handleAskEdit(query, command string, db *database.DB, writeTTY func(string)
 	
 	// Use simple line editor
 	editedCommand, editErr := readLineFromTTY(command)
+	if errors.Is(editErr, errInputCancelled) {
+		editedCommand, editErr = "", nil
+	}
 	if editErr != nil {
 		writeTTY(fmt.Sprintf("\r\n%s✗ Edit failed: %v%s\r\n\r\n", red, editErr, reset))
 		return "", nil
@@ -384,6 +424,82 @@ func handleAskEdit(query, command string, db *database.DB, writeTTY func(string)
 	command = editedCommand // Update command variable
 	writeTTY(fmt.Sprintf("\r\n%s▸ Executing...%s\r\n\r\n", cyan, reset))
 
+	result := runAndRecord(command, db, writeTTY)
+
+	if result.Err != nil {
+		writeTTY(fmt.Sprintf("\r\n%s✗ Command failed%s\r\n\r\n", red, reset))
+	} else {
+		writeTTY(fmt.Sprintf("\r\n%s✓ Command executed successfully%s\r\n\r\n", green, reset))
+	}
+	return "", nil
+}
+
+func handleAskCopy(query, command string, conversation *ai.ConversationHistory, writeTTY func(string), green, red, reset string) (string, error) {
+	if clipboard.WriteAll(command) == nil {
+		writeTTY(fmt.Sprintf("\r\n%s✓ Copied to clipboard!%s\r\n\r\n", green, reset))
+	} else {
+		writeTTY(fmt.Sprintf("\r\n%s✗ Failed to copy to clipboard%s\r\n\r\n", red, reset))
+	}
+	
+	// Save conversation turn (but mark as not executed since only copied)
+	if conversation != nil {
+		conversation.AddTurn(query, command, false)
+		if err := conversation.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save conversation: %v\n", err)
+		}
+	}
+	
+	return "", nil
+}
+
+func handleAskCancel(query, command string, conversation *ai.ConversationHistory, writeTTY func(string), gray, reset string) (string, error) {
+	writeTTY(fmt.Sprintf("\r\n%sℹ Cancelled%s\r\n\r\n", gray, reset))
+	
+	// Still save the conversation turn (but mark as not executed)
+	if conversation != nil {
+		conversation.AddTurn(query, command, false)
+		if err := conversation.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save conversation: %v\n", err)
+		}
+	}
+	
+	return "", nil
+}
+
+// commandResult is what runAndRecord saw of a command
+type commandResult struct {
+	Output   string // stdout followed by stderr
+	Stderr   string
+	ExitCode int
+	Err      error
+}
+
+// writeErrorAnalysis shows an ExplainError reply in a box
+func writeErrorAnalysis(explanation string, writeTTY func(string)) {
+	lightBlue := "\033[38;2;93;173;226m"
+	reset := "\033[0m"
+
+	writeTTY(fmt.Sprintf("\r\n%s╭─ Error Analysis%s\r\n", lightBlue, reset))
+
+	// Split into lines and display with proper formatting
+	lines := strings.Split(explanation, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			// Wrap long lines to fit terminal width
+			wrappedLines := wrapLine(line, 76) // 76 chars to account for "│  " prefix
+			for _, wrappedLine := range wrappedLines {
+				writeTTY(fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, wrappedLine))
+			}
+		}
+	}
+
+	writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+}
+
+// runAndRecord runs a command with bash, shows its output and saves it to
+// the history
+func runAndRecord(command string, db *database.DB, writeTTY func(string)) commandResult {
 	cmd := exec.Command("bash", "-c", command)
 	cmd.Stdin = os.Stdin
 
@@ -413,19 +529,19 @@ func handleAskEdit(query, command string, db *database.DB, writeTTY func(string)
 		writeTTY(errOutput)
 	}
 
+	exitCode := 0
+	if execErr != nil {
+		if exitErr, ok := execErr.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = 1
+		}
+	}
+
 	// Save to database
 	if db != nil {
 		workingDir, _ := os.Getwd()
-		exitCode := 0
-		if execErr != nil {
-			if exitErr, ok := execErr.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
-			} else {
-				exitCode = 1
-			}
-		}
-
-		safeCommand := validator.RedactSecrets(command)
+		safeCommand := database.RedactSecrets(command)
 
 		embedService, _ := ai.NewEmbeddingProvider()
 		var embeddingBytes []byte
@@ -444,42 +560,5 @@ func handleAskEdit(query, command string, db *database.DB, writeTTY func(string)
 		})
 	}
 
-	if execErr != nil {
-		writeTTY(fmt.Sprintf("\r\n%s✗ Command failed%s\r\n\r\n", red, reset))
-	} else {
-		writeTTY(fmt.Sprintf("\r\n%s✓ Command executed successfully%s\r\n\r\n", green, reset))
-	}
-	return "", nil
-}
-
-func handleAskCopy(query, command string, conversation *ai.ConversationHistory, writeTTY func(string), green, red, reset string) (string, error) {
-	if clipboard.WriteAll(command) == nil {
-		writeTTY(fmt.Sprintf("\r\n%s✓ Copied to clipboard!%s\r\n\r\n", green, reset))
-	} else {
-		writeTTY(fmt.Sprintf("\r\n%s✗ Failed to copy to clipboard%s\r\n\r\n", red, reset))
-	}
-	
-	// Save conversation turn (but mark as not executed since only copied)
-	if conversation != nil {
-		conversation.AddTurn(query, command, false)
-		if err := conversation.Save(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to save conversation: %v\n", err)
-		}
-	}
-	
-	return "", nil
-}
-
-func handleAskCancel(query, command string, conversation *ai.ConversationHistory, writeTTY func(string), gray, reset string) (string, error) {
-	writeTTY(fmt.Sprintf("\r\n%sℹ Cancelled%s\r\n\r\n", gray, reset))
-	
-	// Still save the conversation turn (but mark as not executed)
-	if conversation != nil {
-		conversation.AddTurn(query, command, false)
-		if err := conversation.Save(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to save conversation: %v\n", err)
-		}
-	}
-	
-	return "", nil
+	return commandResult{Output: outputStr, Stderr: stderr.String(), ExitCode: exitCode, Err: execErr}
 }
@@ -0,0 +1,150 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/backup"
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/database"
+)
+
+// handleBackup snapshots and restores the whole ~/.mako state
+func handleBackup(args []string, db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	reset := "\033[0m"
+
+	if len(args) == 0 {
+		return getBackupUsage(), nil
+	}
+
+	switch args[0] {
+	case "create":
+		return handleBackupCreate(db)
+	case "list":
+		return handleBackupList()
+	case "restore":
+		if len(args) < 2 {
+			return getBackupUsage(), nil
+		}
+		return handleBackupRestore(args[1], db)
+	default:
+		return fmt.Sprintf("\n%sUnknown backup subcommand: %s%s\n", lightBlue, args[0], reset) + getBackupUsage(), nil
+	}
+}
+
+func getBackupUsage() string {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	output := fmt.Sprintf("\n%sUsage:%s\n", lightBlue, reset)
+	output += fmt.Sprintf("  %smako backup create%s              Back up history, aliases, preferences and config\n", cyan, reset)
+	output += fmt.Sprintf("  %smako backup list%s                List backups, newest first\n", cyan, reset)
+	output += fmt.Sprintf("  %smako backup restore <file>%s      Restore a backup (or 'latest')\n\n", cyan, reset)
+	output += fmt.Sprintf("%sBackups are kept in %s. Plaintext API keys are never included.%s\n\n", gray, backup.Dir(), reset)
+	return output
+}
+
+func handleBackupCreate(db *database.DB) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	b, err := backup.Create(db, config.GetMakoDir(), backup.Dir(), backup.KindManual)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, f := range b.Manifest.Files {
+		names = append(names, f.Name)
+	}
+	output := fmt.Sprintf("\n%s✓ Backed up %s (%s)%s\n", green, strings.Join(names, ", "), formatBytes(b.Size), reset)
+	output += fmt.Sprintf("%s  %s%s\n", gray, b.Path, reset)
+	if b.Manifest.Encryption == "keyfile" {
+		output += fmt.Sprintf("%s  History is encrypted: copy ~/.mako/history.key too, it is not in the backup%s\n", gray, reset)
+	}
+	return output + "\n", nil
+}
+
+func handleBackupList() (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	backups, err := backup.List(backup.Dir())
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return fmt.Sprintf("\n%sNo backups yet. Create one with 'mako backup create'%s\n\n", gray, reset), nil
+	}
+
+	output := fmt.Sprintf("\n%s╭─ Backups (%s)%s\n", lightBlue, backup.Dir(), reset)
+	for _, b := range backups {
+		m := b.Manifest
+		output += fmt.Sprintf("%s│%s  %s%-44s%s %s  %9s  %s%s", lightBlue, reset, cyan, filepath.Base(b.Path), reset,
+			m.CreatedAt.Local().Format("2006-01-02 15:04"), formatBytes(b.Size), gray, m.Kind)
+		if m.Hostname != "" {
+			output += fmt.Sprintf(", from %s", m.Hostname)
+		}
+		output += fmt.Sprintf("%s\n", reset)
+	}
+	output += fmt.Sprintf("%s╰─%s\n\n", lightBlue, reset)
+	return output, nil
+}
+
+// handleBackupRestore restores a backup after verifying it. The current state
+// is backed up first, so a restore can itself be undone.
+func handleBackupRestore(name string, db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if db == nil {
+		return fmt.Sprintf("\n%s✗ Database not available%s\n\n", red, reset), nil
+	}
+
+	path, err := backup.Find(backup.Dir(), name)
+	if err != nil {
+		return fmt.Sprintf("\n%s✗ %v%s\n\n", red, err, reset), nil
+	}
+	manifest, err := backup.ReadManifest(path)
+	if err != nil {
+		return fmt.Sprintf("\n%s✗ %v%s\n\n", red, err, reset), nil
+	}
+
+	passphrase := os.Getenv(database.PassphraseEnv)
+	if manifest.Encryption == "passphrase" && passphrase == "" {
+		passphrase, err = withInputPaused(func() (string, error) {
+			return readSecretFromTTY(fmt.Sprintf("\r\n%sPassphrase for the backed up history:%s ", lightBlue, reset))
+		})
+		if err != nil {
+			return fmt.Sprintf("%sCancelled%s\n\n", gray, reset), nil
+		}
+	}
+
+	restored, err := backup.Restore(db, config.GetMakoDir(), backup.Dir(), path, passphrase)
+	if err != nil {
+		output := fmt.Sprintf("\n%s✗ Restore failed: %v%s\n", red, err, reset)
+		if manifest.Encryption == "keyfile" {
+			output += fmt.Sprintf("%s  The backed up history is encrypted: put its history.key in ~/.mako first%s\n", gray, reset)
+		}
+		return output + "\n", nil
+	}
+
+	var names []string
+	for _, f := range restored.Files {
+		names = append(names, f.Name)
+	}
+	output := fmt.Sprintf("\n%s✓ Restored %s from %s%s\n", green, strings.Join(names, ", "), restored.CreatedAt.Local().Format("2006-01-02 15:04"), reset)
+	output += fmt.Sprintf("%s  The previous state was saved as a pre-restore backup (see 'mako backup list')%s\n\n", gray, reset)
+	return output, nil
+}
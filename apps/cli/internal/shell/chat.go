@@ -0,0 +1,458 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/safety"
+)
+
+const (
+	chatOutputLines = 30   // Terminal lines the model sees with each message
+	chatResultLines = 40   // Lines of a command's output sent back to the model
+	chatResultBytes = 4000 // Cap on the output sent back per command
+)
+
+// activeThread is the conversation mako ask continues in this terminal. It
+// starts as the terminal's own thread, which starts over when idle, until
+// `mako chat resume` switches to a named one.
+var activeThread = terminalThread()
+
+func terminalThread() string {
+	return fmt.Sprintf("terminal-%d", os.Getpid())
+}
+
+// loadAskThread loads the given thread, or the active one when name is empty
+func loadAskThread(name string) (*ai.ConversationHistory, error) {
+	if name == "" {
+		name = activeThread
+	}
+	return ai.LoadThread(name, name == terminalThread())
+}
+
+// handleChat opens the chat REPL or manages saved conversation threads
+func handleChat(args []string, db *database.DB) (string, error) {
+	if len(args) == 0 {
+		return handleChatREPL("", db)
+	}
+
+	switch args[0] {
+	case "--thread":
+		if len(args) < 2 {
+			return getChatUsage(), nil
+		}
+		return handleChatREPL(args[1], db)
+	case "list":
+		return handleChatList()
+	case "show":
+		name := activeThread
+		if len(args) > 1 {
+			name = args[1]
+		}
+		return handleChatShow(name)
+	case "resume":
+		if len(args) < 2 {
+			activeThread = terminalThread()
+			return "\r\n\033[38;2;100;255;100m✓ Back to this terminal's own conversation\033[0m\r\n\r\n", nil
+		}
+		return handleChatResume(args[1])
+	case "delete":
+		if len(args) < 2 {
+			return getChatUsage(), nil
+		}
+		return handleChatDelete(args[1])
+	default:
+		return fmt.Sprintf("\r\n\033[38;2;93;173;226mUnknown chat subcommand: %s\033[0m\r\n", args[0]) + getChatUsage(), nil
+	}
+}
+
+// handleChatREPL talks with the model about the terminal until the user
+// types exit or presses Ctrl-C. Commands it suggests are shown as cards that
+// go through the safety validator, and the output of those the user runs is
+// sent back as the next message.
+func handleChatREPL(thread string, db *database.DB) (string, error) {
+	client, err := ai.NewAIProvider()
+	if err != nil {
+		return "", err
+	}
+	conversation, err := loadAskThread(thread)
+	if err != nil {
+		return "", err
+	}
+
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	writeTTY := func(s string) {
+		fmt.Fprint(tty, s)
+	}
+
+	writeTTY(fmt.Sprintf("\r\n%s╭─ Mako Chat%s %s· %s%s\r\n", lightBlue, reset, gray, conversation.Name, reset))
+	writeTTY(fmt.Sprintf("%s│%s  Ask about what's in your terminal. Suggested commands can be run,\r\n", lightBlue, reset))
+	writeTTY(fmt.Sprintf("%s│%s  explained or edited first, and their output is shared with the chat.\r\n", lightBlue, reset))
+	writeTTY(fmt.Sprintf("%s│%s  %sType exit or press Ctrl-C to leave.%s\r\n", lightBlue, reset, gray, reset))
+	writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+
+	return withInputPaused(func() (string, error) {
+		for {
+			writeTTY(fmt.Sprintf("\r\n%s›%s ", cyan, reset))
+			line, err := readLineFromTTY("")
+			writeTTY("\r\n")
+			if errors.Is(err, errInputCancelled) {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if line == "exit" || line == "quit" || line == "/exit" || line == "/quit" {
+				break
+			}
+			for message := line; message != ""; {
+				message = chatExchange(message, client, conversation, db, writeTTY)
+			}
+		}
+
+		if len(conversation.Turns) > 0 {
+			return fmt.Sprintf("\r\n%sChat kept in %s. mako ask continues it, mako chat show %s reviews it.%s\r\n\r\n", gray, conversation.Name, conversation.Name, reset), nil
+		}
+		return "\r\n", nil
+	})
+}
+
+// chatExchange sends one message, shows the reply and offers the commands
+// it proposes. It returns the output of those the user ran, to be sent as
+// the next message, or "" when there's nothing to follow up.
+func chatExchange(message string, client ai.AIProvider, conversation *ai.ConversationHistory, db *database.DB, writeTTY func(string)) string {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	red := "\033[38;2;255;100;100m"
+	reset := "\033[0m"
+
+	context := buildAskContext(db)
+	if recentOutputGetter != nil {
+		context.RecentOutput = recentOutputGetter(chatOutputLines)
+	}
+
+	writeTTY(fmt.Sprintf("%s▸ Thinking...%s\r\n", cyan, reset))
+	reply, err := client.Chat(message, context, conversation)
+	if err != nil {
+		writeTTY(fmt.Sprintf("%s✗ %v%s\r\n", red, err, reset))
+		return ""
+	}
+
+	conversation.AddTurn(message, reply, false)
+	if err := conversation.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save conversation: %v\n", err)
+	}
+
+	text, commands := ai.ParseChatReply(reply)
+	if text != "" {
+		writeTTY(fmt.Sprintf("\r\n%s╭─ Mako%s\r\n", lightBlue, reset))
+		for _, line := range strings.Split(text, "\n") {
+			for _, wrapped := range wrapLine(line, 76) {
+				writeTTY(fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, wrapped))
+			}
+		}
+		writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+	}
+
+	var results []string
+	for _, command := range commands {
+		decision, command := reviewCommand("Suggested Command", command, false, client, context, writeTTY)
+		if decision == "run" {
+			result := runSuggestedCommand(command, context, db, writeTTY)
+			results = append(results, formatChatResult(command, result))
+		}
+	}
+	return strings.Join(results, "\n\n")
+}
+
+// runSuggestedCommand runs a command the user approved, reporting how it went
+func runSuggestedCommand(command string, context ai.SystemContext, db *database.DB, writeTTY func(string)) commandResult {
+	cyan := "\033[38;2;0;209;255m"
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	reset := "\033[0m"
+
+	writeTTY(fmt.Sprintf("\r\n%s▸ Executing...%s\r\n\r\n", cyan, reset))
+	result := runAndRecord(command, db, writeTTY)
+	if result.Err != nil {
+		writeTTY(fmt.Sprintf("\r\n%s✗ Command failed (exit code %d)%s\r\n", red, result.ExitCode, reset))
+		return result
+	}
+	writeTTY(fmt.Sprintf("\r\n%s✓ Command executed successfully%s\r\n", green, reset))
+	return result
+}
+
+// reviewCommand shows a command card and lets the user explain or edit the
+// command until they decide what to do with it. It returns "run", "skip",
+// "stop" (only offered when canStop is set) or "blocked" for commands the
+// validator refuses, along with the command as edited.
+func reviewCommand(title, command string, canStop bool, client ai.AIProvider, context ai.SystemContext, writeTTY func(string)) (string, string) {
+	cyan := "\033[38;2;0;209;255m"
+	lightBlue := "\033[38;2;93;173;226m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	for {
+		validationResult := validator.ValidateCommand(command)
+
+		writeTTY(fmt.Sprintf("\r\n%s╭─ %s%s\r\n", lightBlue, title, reset))
+		for _, line := range strings.Split(command, "\n") {
+			writeTTY(fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, line, reset))
+		}
+		writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+
+		if validationResult.Risk == safety.RiskCritical {
+			writeTTY(validator.FormatWarning(validationResult))
+			writeTTY(fmt.Sprintf("\r\n%s✗ Command blocked for safety%s\r\n", red, reset))
+			return "blocked", command
+		}
+		if !validationResult.Safe {
+			writeTTY(validator.FormatWarning(validationResult))
+		}
+
+		menuArgs := []string{fmt.Sprintf("%sWhat would you like to do?%s", lightBlue, reset)}
+		if validationResult.Safe {
+			menuArgs = append(menuArgs, "Run command|run")
+		} else {
+			menuArgs = append(menuArgs, "Confirm and run|run")
+		}
+		menuArgs = append(menuArgs,
+			"Explain what this does|explain",
+			"Edit before running|edit",
+			"Skip|skip",
+		)
+		if canStop {
+			menuArgs = append(menuArgs, "Stop|stop")
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		menuCmd := exec.Command(findMenuPath(), menuArgs...)
+		menuCmd.Stderr = os.Stderr
+		choiceBytes, err := menuCmd.Output()
+		if err != nil {
+			writeTTY(fmt.Sprintf("%s✗ menu failed: %v%s\r\n", red, err, reset))
+			return "stop", command
+		}
+		time.Sleep(150 * time.Millisecond)
+
+		switch choice := strings.TrimSpace(string(choiceBytes)); choice {
+		case "run":
+			return choice, command
+		case "skip":
+			writeTTY(fmt.Sprintf("%sℹ Skipped%s\r\n", gray, reset))
+			return choice, command
+		case "explain":
+			handleAskExplain(command, client, context, writeTTY, cyan, lightBlue, red, reset)
+		case "edit":
+			writeTTY(fmt.Sprintf("\r\n%s▸ Edit command (press Enter when done):%s\r\n%s> %s", cyan, reset, gray, reset))
+			edited, err := readLineFromTTY(command)
+			writeTTY("\r\n")
+			if err == nil && strings.TrimSpace(edited) != "" {
+				command = strings.TrimSpace(edited)
+			}
+		default:
+			// Stop, or Ctrl-C in the menu
+			if canStop {
+				return "stop", command
+			}
+			return "skip", command
+		}
+	}
+}
+
+// formatChatResult describes a command the user ran for the model
+func formatChatResult(command string, result commandResult) string {
+	output := tailOutput(result.Output, chatResultLines, chatResultBytes)
+	if output == "" {
+		output = "(no output)"
+	}
+	return fmt.Sprintf("I ran `%s` (exit code %d). Output:\n%s", command, result.ExitCode, output)
+}
+
+// tailOutput keeps the end of a command's output, at most maxLines lines
+// and maxBytes bytes
+func tailOutput(output string, maxLines, maxBytes int) string {
+	output = strings.TrimSpace(output)
+	if lines := strings.Split(output, "\n"); len(lines) > maxLines {
+		output = "...\n" + strings.Join(lines[len(lines)-maxLines:], "\n")
+	}
+	if len(output) > maxBytes {
+		// Start on a whole character
+		start := len(output) - maxBytes
+		for start < len(output) && !utf8.RuneStart(output[start]) {
+			start++
+		}
+		output = "..." + output[start:]
+	}
+	return output
+}
+
+func getChatUsage() string {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	output := fmt.Sprintf("\r\n%sUsage:%s\r\n", lightBlue, reset)
+	output += fmt.Sprintf("  %smako chat%s                       Chat about your terminal and run what it suggests\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako chat --thread <name>%s       Chat within a named thread\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako chat list%s                  List conversation threads\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako chat show [name]%s           Show a thread, the active one by default\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako chat resume [name]%s         Continue a thread in this terminal\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako chat delete <name>%s         Delete a thread\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako ask --thread <name> ...%s    Ask within a named thread\r\n", cyan, reset)
+	output += fmt.Sprintf("\r\n%sWithout a thread, each terminal has its own conversation that starts over when idle.%s\r\n\r\n", gray, reset)
+	return output
+}
+
+func handleChatList() (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	threads, err := ai.ListThreads()
+	if err != nil {
+		return "", err
+	}
+	if len(threads) == 0 {
+		return fmt.Sprintf("\r\n%sNo conversations yet. Start one with: mako ask --thread <name> <question>%s\r\n\r\n", gray, reset), nil
+	}
+
+	output := fmt.Sprintf("\r\n%s╭─ Conversations%s\r\n", lightBlue, reset)
+	for _, conv := range threads {
+		marker := " "
+		if conv.Name == activeThread {
+			marker = "*"
+		}
+		turns := fmt.Sprintf("%d turns", len(conv.Turns)+len(conv.Summary))
+		if conv.Ephemeral {
+			turns += ", terminal"
+		}
+		output += fmt.Sprintf("%s│%s %s %s%-24s%s %s%s, %s%s\r\n", lightBlue, reset, marker, cyan, conv.Name, reset,
+			gray, turns, formatAge(conv.LastActivity), reset)
+		if last := conv.GetLastUserRequest(); last != "" {
+			output += fmt.Sprintf("%s│%s     %s\r\n", lightBlue, reset, truncateForDisplay(last, 70))
+		}
+	}
+	output += fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset)
+	output += fmt.Sprintf("%s* active in this terminal. mako chat resume <name> to switch.%s\r\n\r\n", gray, reset)
+	return output, nil
+}
+
+func handleChatShow(name string) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	green := "\033[38;2;100;255;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	conv, err := findThread(name)
+	if err != nil {
+		return "", err
+	}
+
+	output := fmt.Sprintf("\r\n%s╭─ %s%s %s(started %s)%s\r\n", lightBlue, conv.Name, reset, gray, conv.CreatedAt.Format("2006-01-02 15:04"), reset)
+	if len(conv.Summary) > 0 {
+		output += fmt.Sprintf("%s│%s  %sEarlier, summarised:%s\r\n", lightBlue, reset, gray, reset)
+		for _, line := range conv.Summary {
+			output += fmt.Sprintf("%s│%s  %s- %s%s\r\n", lightBlue, reset, gray, line, reset)
+		}
+		output += fmt.Sprintf("%s│%s\r\n", lightBlue, reset)
+	}
+	for _, turn := range conv.Turns {
+		executed := ""
+		if turn.Executed {
+			executed = fmt.Sprintf(" %s✓%s", green, reset)
+		}
+		output += fmt.Sprintf("%s│%s  %s%s%s  %s\r\n", lightBlue, reset, gray, turn.Timestamp.Format("15:04"), reset, turn.UserRequest)
+		for i, line := range strings.Split(strings.TrimSpace(turn.AIResponse), "\n") {
+			if i > 0 {
+				executed = ""
+			}
+			output += fmt.Sprintf("%s│%s         %s%s%s%s\r\n", lightBlue, reset, cyan, line, reset, executed)
+		}
+	}
+	output += fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset)
+	return output, nil
+}
+
+func handleChatResume(name string) (string, error) {
+	conv, err := findThread(name)
+	if err != nil {
+		return "", err
+	}
+	activeThread = conv.Name
+	return fmt.Sprintf("\r\n\033[38;2;100;255;100m✓ mako ask now continues %s (%d turns)\033[0m\r\n\r\n", conv.Name, len(conv.Turns)+len(conv.Summary)), nil
+}
+
+func handleChatDelete(name string) (string, error) {
+	if err := ai.DeleteThread(name); err != nil {
+		return "", err
+	}
+	if name == activeThread {
+		activeThread = terminalThread()
+	}
+	return fmt.Sprintf("\r\n\033[38;2;100;255;100m✓ Deleted conversation %s\033[0m\r\n\r\n", name), nil
+}
+
+// findThread looks up a saved thread by name
+func findThread(name string) (*ai.ConversationHistory, error) {
+	threads, err := ai.ListThreads()
+	if err != nil {
+		return nil, err
+	}
+	for _, conv := range threads {
+		if conv.Name == name {
+			return conv, nil
+		}
+	}
+	if name == activeThread {
+		return nil, errors.New("no conversation in this terminal yet")
+	}
+	return nil, fmt.Errorf("%w: %s (start it with: mako ask --thread %s <question>)", ai.ErrThreadNotFound, name, name)
+}
+
+func formatAge(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+func truncateForDisplay(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len([]rune(s)) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,21 @@
+package shell
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTailOutput(t *testing.T) {
+	output := "first\nsecond\nthird"
+	if got := tailOutput(output, 2, 100); got != "...\nsecond\nthird" {
+		t.Errorf("tailOutput() = %q, want the last 2 lines", got)
+	}
+
+	// A byte cut inside a multi-byte character moves to the next one
+	output = "ü" + strings.Repeat("x", 9)
+	got := tailOutput(output, 10, 10)
+	if !utf8.ValidString(got) || got != "..."+strings.Repeat("x", 9) {
+		t.Errorf("tailOutput() = %q, want whole characters only", got)
+	}
+}
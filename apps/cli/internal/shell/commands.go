@@ -36,11 +36,53 @@ func InterceptCommand(line string, db *database.DB) (bool, string, error) {
 		}
 		switch parts[1] {
 		case "ask":
-			if len(parts) < 3 {
-				return true, "Usage: mako ask <question>\n", nil
+			args := parts[2:]
+			showPrompt := false
+			verbose := false
+			thread := ""
+		flags:
+			for len(args) > 0 {
+				switch {
+				case args[0] == "--show-prompt":
+					showPrompt = true
+					args = args[1:]
+				case args[0] == "--verbose" || args[0] == "-v":
+					verbose = true
+					args = args[1:]
+				case args[0] == "--thread" && len(args) > 1:
+					thread = args[1]
+					args = args[2:]
+				case strings.HasPrefix(args[0], "--thread="):
+					thread = strings.TrimPrefix(args[0], "--thread=")
+					args = args[1:]
+				default:
+					break flags
+				}
+			}
+			if len(args) == 0 {
+				return true, "Usage: mako ask [--show-prompt] [--verbose] [--thread <name>] <question>\n", nil
 			}
-			query := strings.Join(parts[2:], " ")
-			output, err := handleAsk(query, db)
+			query := strings.Join(args, " ")
+			if showPrompt {
+				output, err := handleAskShowPrompt(query, thread, db)
+				return true, output, err
+			}
+			output, err := handleAsk(query, thread, verbose, db)
+			return true, output, err
+		case "chat":
+			output, err := handleChat(parts[2:], db)
+			return true, output, err
+		case "do":
+			output, err := handleDo(parts[2:], db)
+			return true, output, err
+		case "explain":
+			output, err := handleExplain(parts[2:], db)
+			return true, output, err
+		case "fix":
+			output, err := handleFix(db)
+			return true, output, err
+		case "why":
+			output, err := handleWhy(db)
 			return true, output, err
 		case "history":
 			output, err := handleHistory(parts[2:], db)
@@ -51,6 +93,15 @@ func InterceptCommand(line string, db *database.DB) (bool, string, error) {
 		case "alias":
 			output, err := handleAlias(parts[2:], db)
 			return true, output, err
+		case "prefs":
+			output, err := handlePrefs(parts[2:], db)
+			return true, output, err
+		case "prompts":
+			output, err := handlePrompts(parts[2:])
+			return true, output, err
+		case "usage":
+			output, err := handleUsage(parts[2:], db)
+			return true, output, err
 		case "help":
 			// Support contextual help like "mako help quickstart" or "mako help --alias"
 			if len(parts) > 2 {
@@ -77,12 +128,21 @@ func InterceptCommand(line string, db *database.DB) (bool, string, error) {
 		case "import":
 			output, err := handleImport(parts[2:], db)
 			return true, output, err
+		case "db":
+			output, err := handleDB(parts[2:], db)
+			return true, output, err
+		case "backup":
+			output, err := handleBackup(parts[2:], db)
+			return true, output, err
 		case "sync":
-			output, err := handleSync(db)
+			output, err := handleSync(parts[2:], db)
 			return true, output, err
 		case "config":
 			output, err := handleConfig(parts[2:])
 			return true, output, err
+		case "secrets":
+			output, err := handleSecrets(parts[2:])
+			return true, output, err
 		case "update":
 			output, err := handleUpdate(parts[2:])
 			return true, output, err
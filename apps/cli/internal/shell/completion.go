@@ -29,7 +29,7 @@ func getBashCompletion() string {
     cur="${COMP_WORDS[COMP_CWORD]}"
     prev="${COMP_WORDS[COMP_CWORD-1]}"
     
-    commands="ask history stats help version config alias export import health update sync draw clear completion uninstall"
+    commands="ask chat do explain fix why history stats help version config alias prefs prompts usage export import health update sync db backup secrets draw clear completion uninstall"
     
     if [ $COMP_CWORD -eq 1 ]; then
         COMPREPLY=($(compgen -W "${commands}" -- ${cur}))
@@ -41,7 +41,16 @@ func getBashCompletion() string {
             COMPREPLY=($(compgen -W "semantic" -- ${cur}))
             ;;
         alias)
-            COMPREPLY=($(compgen -W "save list delete run" -- ${cur}))
+            COMPREPLY=($(compgen -W "save list delete run sources checksum" -- ${cur}))
+            ;;
+        prefs)
+            COMPREPLY=($(compgen -W "show forget reset" -- ${cur}))
+            ;;
+        prompts)
+            COMPREPLY=($(compgen -W "show edit reset" -- ${cur}))
+            ;;
+        usage)
+            COMPREPLY=($(compgen -W "--since --by provider model command" -- ${cur}))
             ;;
         config)
             COMPREPLY=($(compgen -W "list get set reset" -- ${cur}))
@@ -49,9 +58,33 @@ func getBashCompletion() string {
         update)
             COMPREPLY=($(compgen -W "check install" -- ${cur}))
             ;;
+        db)
+            COMPREPLY=($(compgen -W "encrypt decrypt migrate stats vacuum optimize prune" -- ${cur}))
+            ;;
+        backup)
+            COMPREPLY=($(compgen -W "create list restore" -- ${cur}))
+            ;;
+        chat)
+            COMPREPLY=($(compgen -W "list show resume delete" -- ${cur}))
+            ;;
+        do)
+            COMPREPLY=($(compgen -W "list show" -- ${cur}))
+            ;;
+        sync)
+            COMPREPLY=($(compgen -W "init join dir now status key off" -- ${cur}))
+            ;;
+        secrets)
+            COMPREPLY=($(compgen -W "list set delete migrate" -- ${cur}))
+            ;;
         completion)
             COMPREPLY=($(compgen -W "bash zsh fish" -- ${cur}))
             ;;
+        --from)
+            COMPREPLY=($(compgen -W "bash zsh fish atuin mcfly" -- ${cur}))
+            ;;
+        --format)
+            COMPREPLY=($(compgen -W "json ndjson csv sh md" -- ${cur}))
+            ;;
         export|import)
             COMPREPLY=($(compgen -f -- ${cur}))
             ;;
@@ -68,15 +101,26 @@ _mako() {
     local -a commands
     commands=(
         'ask:Generate command from natural language'
+        'chat:Chat about your terminal'
+        'do:Plan and run a multi-step task'
+        'explain:Explain a command from its man pages'
+        'fix:Suggest fixes for the last failed command'
+        'why:Explain the last failed command'
         'history:Search command history'
         'stats:Show usage statistics'
         'alias:Manage command aliases'
+        'prefs:Inspect learned preferences'
+        'prompts:Customise AI prompt templates'
+        'usage:Show AI calls, tokens and cost'
         'config:Manage configuration'
         'update:Check for updates'
         'export:Export command history'
         'import:Import command history'
         'health:Show system health'
-        'sync:Sync bash history'
+        'sync:Sync history across devices'
+        'db:Manage the history database'
+        'backup:Back up and restore Mako state'
+        'secrets:Manage stored API keys'
         'help:Show help'
         'version:Show version'
         'draw:Show shark art'
@@ -95,7 +139,16 @@ _mako() {
             _arguments '2:mode:(semantic)'
             ;;
         alias)
-            _arguments '2:action:(save list delete run)'
+            _arguments '2:action:(save list delete run sources checksum)'
+            ;;
+        prefs)
+            _arguments '2:action:(show forget reset)'
+            ;;
+        prompts)
+            _arguments '2:action:(show edit reset)'
+            ;;
+        usage)
+            _arguments '--since[from date or age]:time:' '--by[group by]:group:(provider model command)'
             ;;
         config)
             _arguments '2:action:(list get set reset)'
@@ -103,11 +156,34 @@ _mako() {
         update)
             _arguments '2:action:(check install)'
             ;;
+        db)
+            _arguments '2:action:(encrypt decrypt migrate stats vacuum optimize prune)'
+            ;;
+        backup)
+            _arguments '2:action:(create list restore)' '3:backup:_files -g "*.tar.gz"'
+            ;;
+        sync)
+            _arguments '2:action:(init join dir now status key off)'
+            ;;
+        chat)
+            _arguments '2:action:(list show resume delete)'
+            ;;
+        do)
+            _arguments '2:action:(list show)'
+            ;;
+        secrets)
+            _arguments '2:action:(list set delete migrate)'
+            ;;
         completion)
             _arguments '2:shell:(bash zsh fish)'
             ;;
-        export|import)
-            _files
+        export)
+            _arguments '--format[output format]:format:(json ndjson csv sh md)' '(-o --output)'{-o,--output}'[output file]:file:_files' \
+                '--last[last N commands]:count:' '--dir[only this directory]:dir:_directories' '(--failed)--success[only successful commands]' \
+                '(--success)--failed[only failed commands]' '--since[from date or age]:time:' '--until[to date]:time:' '--semantic[select by meaning]:query:'
+            ;;
+        import)
+            _arguments '--from[import from another tool]:tool:(bash zsh fish atuin mcfly)' '--dry-run[show what would be imported]' '*:file:_files'
             ;;
     esac
 }
@@ -120,15 +196,26 @@ func getFishCompletion() string {
 
 # Commands
 complete -c mako -n "__fish_use_subcommand" -a ask -d "Generate command from natural language"
+complete -c mako -n "__fish_use_subcommand" -a chat -d "Chat about your terminal"
+complete -c mako -n "__fish_use_subcommand" -a do -d "Plan and run a multi-step task"
+complete -c mako -n "__fish_use_subcommand" -a explain -d "Explain a command from its man pages"
+complete -c mako -n "__fish_use_subcommand" -a fix -d "Suggest fixes for the last failed command"
+complete -c mako -n "__fish_use_subcommand" -a why -d "Explain the last failed command"
 complete -c mako -n "__fish_use_subcommand" -a history -d "Search command history"
 complete -c mako -n "__fish_use_subcommand" -a stats -d "Show usage statistics"
 complete -c mako -n "__fish_use_subcommand" -a alias -d "Manage command aliases"
+complete -c mako -n "__fish_use_subcommand" -a prefs -d "Inspect learned preferences"
+complete -c mako -n "__fish_use_subcommand" -a prompts -d "Customise AI prompt templates"
+complete -c mako -n "__fish_use_subcommand" -a usage -d "Show AI calls, tokens and cost"
 complete -c mako -n "__fish_use_subcommand" -a config -d "Manage configuration"
 complete -c mako -n "__fish_use_subcommand" -a update -d "Check for updates"
 complete -c mako -n "__fish_use_subcommand" -a export -d "Export command history"
 complete -c mako -n "__fish_use_subcommand" -a import -d "Import command history"
 complete -c mako -n "__fish_use_subcommand" -a health -d "Show system health"
-complete -c mako -n "__fish_use_subcommand" -a sync -d "Sync bash history"
+complete -c mako -n "__fish_use_subcommand" -a sync -d "Sync history across devices"
+complete -c mako -n "__fish_use_subcommand" -a db -d "Manage the history database"
+complete -c mako -n "__fish_use_subcommand" -a backup -d "Back up and restore Mako state"
+complete -c mako -n "__fish_use_subcommand" -a secrets -d "Manage stored API keys"
 complete -c mako -n "__fish_use_subcommand" -a help -d "Show help"
 complete -c mako -n "__fish_use_subcommand" -a version -d "Show version"
 complete -c mako -n "__fish_use_subcommand" -a completion -d "Generate shell completion"
@@ -136,8 +223,24 @@ complete -c mako -n "__fish_use_subcommand" -a uninstall -d "Show uninstall inst
 
 # Subcommands
 complete -c mako -n "__fish_seen_subcommand_from history" -a semantic -d "Semantic search"
-complete -c mako -n "__fish_seen_subcommand_from alias" -a "save list delete run"
+complete -c mako -n "__fish_seen_subcommand_from alias" -a "save list delete run sources checksum"
+complete -c mako -n "__fish_seen_subcommand_from prefs" -a "show forget reset"
+complete -c mako -n "__fish_seen_subcommand_from prompts" -a "show edit reset"
+complete -c mako -n "__fish_seen_subcommand_from usage" -l since -d "From date or age"
+complete -c mako -n "__fish_seen_subcommand_from usage" -l by -a "provider model command" -d "Group by"
 complete -c mako -n "__fish_seen_subcommand_from config" -a "list get set reset"
 complete -c mako -n "__fish_seen_subcommand_from update" -a "check install"
-complete -c mako -n "__fish_seen_subcommand_from completion" -a "bash zsh fish"`
+complete -c mako -n "__fish_seen_subcommand_from db" -a "encrypt decrypt migrate stats vacuum optimize prune"
+complete -c mako -n "__fish_seen_subcommand_from backup" -a "create list restore"
+complete -c mako -n "__fish_seen_subcommand_from sync" -a "init join dir now status key off"
+complete -c mako -n "__fish_seen_subcommand_from chat" -a "list show resume delete"
+complete -c mako -n "__fish_seen_subcommand_from do" -a "list show"
+complete -c mako -n "__fish_seen_subcommand_from secrets" -a "list set delete migrate"
+complete -c mako -n "__fish_seen_subcommand_from completion" -a "bash zsh fish"
+complete -c mako -n "__fish_seen_subcommand_from export" -l format -xa "json ndjson csv sh md" -d "Output format"
+complete -c mako -n "__fish_seen_subcommand_from export" -s o -l output -r -d "Output file"
+complete -c mako -n "__fish_seen_subcommand_from export" -l semantic -x -d "Select commands by meaning"
+complete -c mako -n "__fish_seen_subcommand_from export" -l since -x -d "From date or age (7d)"
+complete -c mako -n "__fish_seen_subcommand_from export" -l until -x -d "Up to date"
+complete -c mako -n "__fish_seen_subcommand_from import" -l from -xa "bash zsh fish atuin mcfly" -d "Import from another tool"`
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/secrets"
 )
 
 // handleConfig handles the 'mako config' command
@@ -43,12 +44,13 @@ func handleConfig(args []string) (string, error) {
 		output += fmt.Sprintf("%sFile Config (~/.mako/config.json):%s\r\n", dimBlue, reset)
 		settings := cfg.List()
 		for key, value := range settings {
-			// Hide API key (show only first 10 chars)
+			// Never print API keys, only where they are kept
 			if key == "api_key" {
-				if str, ok := value.(string); ok && len(str) > 10 {
-					value = str[:10] + "..."
-				} else if str, ok := value.(string); ok && str == "" {
-					value = "(not set)"
+				if str, ok := value.(string); ok {
+					value = maskAPIKey(str)
+					if str != "" && !secrets.IsRef(str) {
+						value = "(set, plaintext: run mako secrets migrate)"
+					}
 				}
 			}
 			output += fmt.Sprintf("  %s%-20s%s %v\r\n", lightBlue, key, reset, value)
@@ -67,11 +69,7 @@ func handleConfig(args []string) (string, error) {
 		if llmModel == "" {
 			llmModel = "(provider default)"
 		}
-		if llmAPIKey == "" {
-			llmAPIKey = "(not set)"
-		} else if len(llmAPIKey) > 10 {
-			llmAPIKey = llmAPIKey[:10] + "..."
-		}
+		llmAPIKey = maskAPIKey(llmAPIKey)
 		if llmBaseURL == "" {
 			llmBaseURL = "(provider default)"
 		}
@@ -96,8 +94,8 @@ func handleConfig(args []string) (string, error) {
 		}
 		if embAPIKey == "" {
 			embAPIKey = "(using LLM API key)"
-		} else if len(embAPIKey) > 10 {
-			embAPIKey = embAPIKey[:10] + "..."
+		} else {
+			embAPIKey = maskAPIKey(embAPIKey)
 		}
 		if embBaseURL == "" {
 			embBaseURL = "(provider default)"
@@ -123,8 +121,8 @@ func handleConfig(args []string) (string, error) {
 		
 		// Hide API key
 		if args[1] == "api_key" {
-			if str, ok := value.(string); ok && len(str) > 10 {
-				value = str[:10] + "..."
+			if str, ok := value.(string); ok {
+				value = maskAPIKey(str)
 			}
 		}
 		
@@ -143,16 +141,38 @@ func handleConfig(args []string) (string, error) {
 		
 		// Type conversions for known keys
 		switch key {
-		case "cache_size", "history_limit", "embedding_batch_size":
+		case "cache_size", "history_limit", "embedding_batch_size", "history_retention_days", "embedding_retention_days", "backup_interval_days", "backup_keep", "conversation_turns", "conversation_timeout_minutes", "conversation_token_budget", "do_max_steps", "llm_context_window":
 			var intVal int
 			if _, err := fmt.Sscanf(valueStr, "%d", &intVal); err != nil {
 				return fmt.Sprintf("Error: %s must be an integer\r\n", key), nil
 			}
 			value = intVal
+		case "usage_monthly_budget":
+			var floatVal float64
+			if _, err := fmt.Sscanf(strings.TrimPrefix(valueStr, "$"), "%g", &floatVal); err != nil || floatVal < 0 {
+				return fmt.Sprintf("Error: %s must be an amount in USD, 0 for no limit\r\n", key), nil
+			}
+			value = floatVal
+		case "usage_budget_action":
+			if valueStr != "warn" && valueStr != "block" {
+				return fmt.Sprintf("Error: %s must be warn or block\r\n", key), nil
+			}
 		case "telemetry", "auto_update":
 			value = valueStr == "true" || valueStr == "1" || valueStr == "yes"
 		}
 		
+		// API keys go to the secrets store, config.json only keeps a reference
+		if key == "api_key" && valueStr != "" && !secrets.IsRef(valueStr) {
+			if err := unlockSecretsFromTTY(cfg); err != nil {
+				return fmt.Sprintf("Error: %v\r\n", err), nil
+			}
+			ref, err := cfg.StoreSecret(cfg.LLMProvider, valueStr)
+			if err != nil {
+				return fmt.Sprintf("Error: failed to store API key: %v\r\n", err), nil
+			}
+			value = ref
+		}
+		
 		if err := cfg.Set(key, value); err != nil {
 			return fmt.Sprintf("Error: %v\r\n", err), nil
 		}
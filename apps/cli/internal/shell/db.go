@@ -0,0 +1,327 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fabiobrug/mako.git/internal/database"
+)
+
+// handleDB manages the history database itself
+func handleDB(args []string, db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	dimBlue := "\033[38;2;120;150;180m"
+	reset := "\033[0m"
+
+	if db == nil {
+		return fmt.Sprintf("\n%s✗ Database not available%s\n\n", dimBlue, reset), nil
+	}
+
+	if len(args) == 0 {
+		return getDBUsage(), nil
+	}
+
+	switch args[0] {
+	case "encrypt":
+		usePassphrase := len(args) > 1 && args[1] == "--passphrase"
+		return handleDBEncrypt(db, usePassphrase)
+	case "decrypt":
+		return handleDBDecrypt(db)
+	case "migrate":
+		showStatus := len(args) > 1 && args[1] == "--status"
+		return handleDBMigrate(db, showStatus)
+	case "stats":
+		return handleDBStats(db)
+	case "vacuum":
+		return handleDBVacuum(db)
+	case "optimize":
+		return handleDBOptimize(db)
+	case "prune":
+		return handleDBPrune(args[1:], db)
+	default:
+		return fmt.Sprintf("\n%sUnknown db subcommand: %s%s\n", lightBlue, args[0], reset) + getDBUsage(), nil
+	}
+}
+
+func getDBUsage() string {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	reset := "\033[0m"
+
+	output := fmt.Sprintf("\n%sUsage:%s\n", lightBlue, reset)
+	output += fmt.Sprintf("  %smako db encrypt%s                 Encrypt copies of history (key kept in ~/.mako)\n", cyan, reset)
+	output += fmt.Sprintf("  %smako db encrypt --passphrase%s    Encrypt history with a passphrase\n", cyan, reset)
+	output += fmt.Sprintf("  %smako db decrypt%s                 Store history as plaintext again\n", cyan, reset)
+	output += fmt.Sprintf("  %smako db migrate [--status]%s      Apply or list schema migrations\n", cyan, reset)
+	output += fmt.Sprintf("  %smako db stats%s                   Show the size of each table\n", cyan, reset)
+	output += fmt.Sprintf("  %smako db vacuum%s                  Return free space to the disk\n", cyan, reset)
+	output += fmt.Sprintf("  %smako db optimize%s                Merge the search index and refresh statistics\n", cyan, reset)
+	output += fmt.Sprintf("  %smako db prune [options]%s         Delete old history or its embeddings\n\n", cyan, reset)
+	output += fmt.Sprintf("%sPrune options:%s\n", lightBlue, reset)
+	output += fmt.Sprintf("  %s--older-than <age>%s              Commands older than this (e.g. 180d)\n", cyan, reset)
+	output += fmt.Sprintf("  %s--keep <n>%s                      Everything but the newest n commands\n", cyan, reset)
+	output += fmt.Sprintf("  %s--keep-successful-unique%s        Keep the last successful run of each command\n", cyan, reset)
+	output += fmt.Sprintf("  %s--embeddings%s                    Only drop embeddings, keeping the commands\n", cyan, reset)
+	output += fmt.Sprintf("  %s--dry-run%s                       Show what would be pruned\n\n", cyan, reset)
+	return output
+}
+
+// handleDBEncrypt migrates the existing history to encrypted storage
+func handleDBEncrypt(db *database.DB, usePassphrase bool) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if db.IsEncrypted() {
+		return fmt.Sprintf("\n%sℹ History database is already encrypted%s\n\n", gray, reset), nil
+	}
+
+	passphrase := ""
+	if usePassphrase {
+		problem, err := withInputPaused(func() (string, error) {
+			var err error
+			passphrase, err = readSecretFromTTY(fmt.Sprintf("\r\n%sNew passphrase:%s ", lightBlue, reset))
+			if err != nil {
+				return "", err
+			}
+			if len(passphrase) < 8 {
+				return "Passphrase must be at least 8 characters", nil
+			}
+			confirm, err := readSecretFromTTY(fmt.Sprintf("%sConfirm passphrase:%s ", lightBlue, reset))
+			if err != nil {
+				return "", err
+			}
+			if confirm != passphrase {
+				return "Passphrases do not match", nil
+			}
+			return "", nil
+		})
+		if err != nil {
+			return fmt.Sprintf("%sCancelled%s\n\n", gray, reset), nil
+		}
+		if problem != "" {
+			return fmt.Sprintf("%s✗ %s%s\n\n", red, problem, reset), nil
+		}
+	}
+
+	backups := len(db.MigrationBackups())
+	count, err := db.EnableEncryption(passphrase)
+	if err != nil {
+		return "", fmt.Errorf("encryption failed: %w", err)
+	}
+
+	output := fmt.Sprintf("\n%s✓ Encrypted %d commands%s\n", green, count, reset)
+	if backups > 0 {
+		output += fmt.Sprintf("%s  Removed %d plaintext pre-migration backup(s)%s\n", gray, backups, reset)
+	}
+	if usePassphrase {
+		output += fmt.Sprintf("%s  Mako will ask for the passphrase on start (or set %s)%s\n", gray, database.PassphraseEnv, reset)
+		output += fmt.Sprintf("%s  There is no way to recover history if the passphrase is lost%s\n\n", gray, reset)
+	} else {
+		output += fmt.Sprintf("%s  Key stored in ~/.mako/history.key; keep a copy to read backups elsewhere%s\n", gray, reset)
+		output += fmt.Sprintf("%s  The key sits next to the database, so this protects copies of history.db (backups,%s\n", gray, reset)
+		output += fmt.Sprintf("%s  synced folders) but not against anyone who can read ~/.mako; use --passphrase for that%s\n\n", gray, reset)
+	}
+	return output, nil
+}
+
+// handleDBDecrypt migrates an encrypted history back to plaintext
+func handleDBDecrypt(db *database.DB) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if !db.IsEncrypted() {
+		return fmt.Sprintf("\n%sℹ History database is not encrypted%s\n\n", gray, reset), nil
+	}
+
+	count, err := db.DisableEncryption()
+	if err != nil {
+		return "", fmt.Errorf("decryption failed: %w", err)
+	}
+
+	return fmt.Sprintf("\n%s✓ Decrypted %d commands%s\n\n", green, count, reset), nil
+}
+
+// handleDBMigrate applies pending schema migrations and, with --status,
+// lists every migration and when it ran
+func handleDBMigrate(db *database.DB, showStatus bool) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if err := db.Migrate(); err != nil {
+		return fmt.Sprintf("\n%s✗ %v%s\n\n", red, err, reset), nil
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+
+	if !showStatus {
+		return fmt.Sprintf("\n%s✓ Schema is up to date (version %d)%s\n\n", green, version, reset), nil
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return "", fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	output := fmt.Sprintf("\n%s╭─ Schema migrations (version %d of %d)%s\n", lightBlue, version, database.LatestSchemaVersion(), reset)
+	for _, m := range status {
+		if m.Applied {
+			output += fmt.Sprintf("%s│%s  %s✓%s %2d  %-60s %s%s%s\n", lightBlue, reset, green, reset, m.Version, m.Description, gray, m.AppliedAt.Local().Format("2006-01-02 15:04"), reset)
+		} else {
+			output += fmt.Sprintf("%s│%s  %s○%s %2d  %-60s %spending%s\n", lightBlue, reset, gray, reset, m.Version, m.Description, cyan, reset)
+		}
+	}
+
+	if backups := db.MigrationBackups(); len(backups) > 0 {
+		output += fmt.Sprintf("%s│%s\n", lightBlue, reset)
+		output += fmt.Sprintf("%s│%s  %sPre-migration backup: %s%s\n", lightBlue, reset, gray, backups[0], reset)
+	}
+	output += fmt.Sprintf("%s╰─%s\n\n", lightBlue, reset)
+	return output, nil
+}
+
+// handleDBStats shows what the history database is made of
+func handleDBStats(db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	stats, err := db.StorageStats()
+	if err != nil {
+		return "", err
+	}
+
+	output := fmt.Sprintf("\n%s╭─ History database (%s)%s\n", lightBlue, formatBytes(stats.FileBytes), reset)
+	for _, table := range stats.Tables {
+		rows := ""
+		if table.Rows > 0 {
+			rows = fmt.Sprintf("%d rows", table.Rows)
+		}
+		output += fmt.Sprintf("%s│%s  %-24s %s%10s%s  %s%s%s\n", lightBlue, reset, table.Name, cyan, formatBytes(table.Bytes), reset, gray, rows, reset)
+	}
+	output += fmt.Sprintf("%s│%s\n", lightBlue, reset)
+	output += fmt.Sprintf("%s│%s  Commands: %s%d%s, with embeddings: %s%d%s (%s)\n", lightBlue, reset, cyan, stats.Commands, reset, cyan, stats.Embeddings, reset, formatBytes(stats.EmbeddingBytes))
+	if !stats.Oldest.IsZero() {
+		output += fmt.Sprintf("%s│%s  Oldest command: %s\n", lightBlue, reset, stats.Oldest.Local().Format("2006-01-02"))
+	}
+	if stats.FreeBytes > 0 {
+		output += fmt.Sprintf("%s│%s  %s%s free; run 'mako db vacuum' to reclaim it%s\n", lightBlue, reset, gray, formatBytes(stats.FreeBytes), reset)
+	}
+	output += fmt.Sprintf("%s╰─%s\n\n", lightBlue, reset)
+	return output, nil
+}
+
+func handleDBVacuum(db *database.DB) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	reset := "\033[0m"
+
+	reclaimed, err := db.Vacuum()
+	if err != nil {
+		return "", err
+	}
+	if reclaimed < 0 {
+		reclaimed = 0
+	}
+	return fmt.Sprintf("\n%s✓ Vacuumed history database, reclaimed %s%s\n\n", green, formatBytes(reclaimed), reset), nil
+}
+
+func handleDBOptimize(db *database.DB) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	reset := "\033[0m"
+
+	if err := db.Optimize(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("\n%s✓ Optimized search index and query statistics%s\n\n", green, reset), nil
+}
+
+// handleDBPrune deletes old history, or only its embeddings
+func handleDBPrune(args []string, db *database.DB) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	var opts database.PruneOptions
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--older-than":
+			if i+1 >= len(args) {
+				return fmt.Sprintf("\n%s✗ --older-than needs an age like 180d%s\n\n", red, reset), nil
+			}
+			i++
+			before, err := parseTimeArg(args[i], false)
+			if err != nil {
+				return fmt.Sprintf("\n%s✗ %v%s\n\n", red, err, reset), nil
+			}
+			opts.Before = before
+		case "--keep":
+			if i+1 >= len(args) {
+				return fmt.Sprintf("\n%s✗ --keep needs a number of commands%s\n\n", red, reset), nil
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n <= 0 {
+				return fmt.Sprintf("\n%s✗ --keep must be a positive number%s\n\n", red, reset), nil
+			}
+			opts.KeepNewest = n
+		case "--keep-successful-unique":
+			opts.KeepSuccessfulUnique = true
+		case "--embeddings":
+			opts.EmbeddingsOnly = true
+		case "--dry-run":
+			opts.DryRun = true
+		default:
+			return fmt.Sprintf("\n%s✗ Unknown prune option: %s%s\n", red, args[i], reset) + getDBUsage(), nil
+		}
+	}
+	if opts.Before.IsZero() && opts.KeepNewest == 0 {
+		return fmt.Sprintf("\n%s✗ Give --older-than or --keep%s\n", red, reset) + getDBUsage(), nil
+	}
+
+	result, err := db.Prune(opts)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.DryRun {
+		if opts.EmbeddingsOnly {
+			return fmt.Sprintf("\n%sWould drop %d embeddings%s\n\n", gray, result.Embeddings, reset), nil
+		}
+		return fmt.Sprintf("\n%sWould delete %d commands (%d with embeddings)%s\n\n", gray, result.Commands, result.Embeddings, reset), nil
+	}
+
+	output := ""
+	if opts.EmbeddingsOnly {
+		output = fmt.Sprintf("\n%s✓ Dropped %d embeddings%s\n", green, result.Embeddings, reset)
+	} else {
+		output = fmt.Sprintf("\n%s✓ Deleted %d commands%s\n", green, result.Commands, reset)
+	}
+	output += fmt.Sprintf("%s  Run 'mako db vacuum' to return the space to the disk%s\n\n", gray, reset)
+	return output, nil
+}
+
+// formatBytes renders a size like 1.5 MB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
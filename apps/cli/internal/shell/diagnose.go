@@ -0,0 +1,190 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/database"
+)
+
+// FailureOutputLines is how much terminal output is kept with a failed
+// command for mako why
+const FailureOutputLines = 30
+
+// failedCommand is the last command typed at the prompt that exited non-zero
+type failedCommand struct {
+	Command  string
+	ExitCode int
+	Output   []string // Terminal lines after the command was entered
+	At       time.Time
+}
+
+// errorOutput is what the failure printed, for ExplainError
+func (f *failedCommand) errorOutput() string {
+	output := strings.Join(f.Output, "\n")
+	if strings.TrimSpace(output) == "" {
+		return fmt.Sprintf("(no output, exit code %d)", f.ExitCode)
+	}
+	return output
+}
+
+var (
+	lastFailure   *failedCommand
+	lastFailureMu sync.Mutex
+)
+
+// RecordFailure keeps a command that failed at the prompt, reported by the
+// shell hooks, for mako why. recent is the terminal output up to the
+// failure. It returns the hint to show under the failure, or "" for none.
+func RecordFailure(command string, exitCode int, recent []string) string {
+	command = strings.TrimSpace(command)
+	// Interrupted and suspended commands didn't fail in a way worth explaining
+	if command == "" || exitCode == 130 || exitCode == 148 || strings.HasPrefix(command, "mako ") {
+		return ""
+	}
+
+	lastFailureMu.Lock()
+	lastFailure = &failedCommand{
+		Command:  command,
+		ExitCode: exitCode,
+		Output:   outputAfterCommand(command, recent),
+		At:       time.Now(),
+	}
+	lastFailureMu.Unlock()
+
+	if cfg, err := config.LoadConfig(); err == nil && cfg.FailureHints == "off" {
+		return ""
+	}
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+	return fmt.Sprintf("%s↳ exit %d · press Alt-E to explain%s\r\n", gray, exitCode, reset)
+}
+
+// outputAfterCommand drops the lines before the prompt line the command was
+// entered on, when it can be found
+func outputAfterCommand(command string, recent []string) []string {
+	for i := len(recent) - 1; i >= 0; i-- {
+		if strings.HasSuffix(recent[i], command) {
+			return append([]string(nil), recent[i+1:]...)
+		}
+	}
+	return append([]string(nil), recent...)
+}
+
+// latestFailure returns the most recent failure: the last one the shell
+// hooks reported, or the last command in history if it failed after that
+func latestFailure(db *database.DB) *failedCommand {
+	lastFailureMu.Lock()
+	failure := lastFailure
+	lastFailureMu.Unlock()
+
+	if db == nil {
+		return failure
+	}
+	recent, err := db.GetRecentCommands(1)
+	if err != nil || len(recent) == 0 || recent[0].ExitCode == 0 {
+		return failure
+	}
+	if last := recent[0]; failure == nil || last.Timestamp.After(failure.At) {
+		return &failedCommand{
+			Command:  last.Command,
+			ExitCode: last.ExitCode,
+			Output:   strings.Split(strings.TrimSpace(last.OutputPreview), "\n"),
+			At:       last.Timestamp,
+		}
+	}
+	return failure
+}
+
+// handleWhy explains the last command that failed at the prompt and offers
+// the corrected command, if the explanation has one. Alt-E runs it.
+func handleWhy(db *database.DB) (string, error) {
+	failure := latestFailure(db)
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+	if failure == nil {
+		return fmt.Sprintf("%sNo failed command to explain yet%s\n", gray, reset), nil
+	}
+
+	client, err := ai.NewAIProvider()
+	if err != nil {
+		return "", err
+	}
+
+	cyan := "\033[38;2;0;209;255m"
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	writeTTY := func(s string) {
+		fmt.Fprint(tty, s)
+	}
+
+	context := buildAskContext(db)
+	context.RecentOutput = failure.Output
+
+	writeFailedCommand(failure, writeTTY)
+	writeTTY(fmt.Sprintf("\r\n%s▸ Getting error explanation...%s\r\n", cyan, reset))
+
+	explanation, err := client.ExplainError(failure.Command, failure.errorOutput(), context)
+	if err != nil {
+		return "", fmt.Errorf("could not get explanation: %w", err)
+	}
+	writeErrorAnalysis(explanation, writeTTY)
+
+	fix := ai.SuggestedFix(explanation)
+	if fix == "" || fix == failure.Command {
+		writeTTY("\r\n")
+		return "", nil
+	}
+
+	return withInputPaused(func() (string, error) {
+		decision, fix := reviewCommand("Suggested Fix", fix, false, client, context, writeTTY)
+		if decision != "run" {
+			writeTTY("\r\n")
+			return "", nil
+		}
+
+		result := runSuggestedCommand(fix, context, db, writeTTY)
+		noteFixResult(failure, fix, result)
+		writeTTY("\r\n")
+		return "", nil
+	})
+}
+
+// writeFailedCommand shows the failed command being worked on
+func writeFailedCommand(failure *failedCommand, writeTTY func(string)) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	writeTTY(fmt.Sprintf("\r\n%s╭─ Failed Command%s\r\n", lightBlue, reset))
+	writeTTY(fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, failure.Command, reset))
+	writeTTY(fmt.Sprintf("%s│%s  %sexit code %d, %s%s\r\n", lightBlue, reset, gray, failure.ExitCode, formatAge(failure.At), reset))
+	writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+}
+
+// noteFixResult makes a fix that failed too the command to explain or fix
+// next, and forgets the original failure once a fix works
+func noteFixResult(failure *failedCommand, fix string, result commandResult) {
+	lastFailureMu.Lock()
+	defer lastFailureMu.Unlock()
+	if result.Err != nil {
+		lastFailure = &failedCommand{
+			Command:  fix,
+			ExitCode: result.ExitCode,
+			Output:   strings.Split(tailOutput(result.Output, FailureOutputLines, chatResultBytes), "\n"),
+			At:       time.Now(),
+		}
+	} else if lastFailure == failure {
+		lastFailure = nil
+	}
+}
@@ -0,0 +1,51 @@
+package shell
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func TestRecordFailure(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+	t.Cleanup(func() { lastFailure = nil })
+
+	recent := []string{"earlier output", "~/src ❯ make build", "make: *** No rule to make target 'build'"}
+	hint := RecordFailure("make build", 2, recent)
+	if !strings.Contains(hint, "exit 2") {
+		t.Errorf("Expected a hint with the exit code, got %q", hint)
+	}
+
+	failure := latestFailure(nil)
+	if failure == nil || failure.Command != "make build" || failure.ExitCode != 2 {
+		t.Fatalf("Expected the failure to be kept, got %+v", failure)
+	}
+	// Only the output after the prompt line is kept
+	if len(failure.Output) != 1 || failure.Output[0] != "make: *** No rule to make target 'build'" {
+		t.Errorf("Unexpected output %q", failure.Output)
+	}
+}
+
+func TestRecordFailureIgnoresInterruptsAndMako(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+	t.Cleanup(func() { lastFailure = nil })
+	lastFailure = nil
+
+	for _, tt := range []struct {
+		command string
+		code    int
+	}{
+		{"sleep 100", 130}, // Ctrl-C
+		{"vim notes", 148}, // Ctrl-Z
+		{"mako why", 1},
+		{"   ", 1},
+	} {
+		if hint := RecordFailure(tt.command, tt.code, nil); hint != "" {
+			t.Errorf("RecordFailure(%q, %d) = %q, want no hint", tt.command, tt.code, hint)
+		}
+	}
+	if lastFailure != nil {
+		t.Errorf("Expected nothing to be kept, got %+v", lastFailure)
+	}
+}
@@ -0,0 +1,309 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/database"
+)
+
+// handleDo carries out a multi-step task, or shows the transcripts of past ones
+func handleDo(args []string, db *database.DB) (string, error) {
+	if len(args) == 0 {
+		return getDoUsage(), nil
+	}
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		return handleDoList(db)
+	case len(args) == 2 && args[0] == "show":
+		id, err := strconv.ParseInt(strings.TrimPrefix(args[1], "#"), 10, 64)
+		if err != nil {
+			return getDoUsage(), nil
+		}
+		return handleDoShow(id, db)
+	}
+
+	budget := 0
+	if cfg, err := config.LoadConfig(); err == nil {
+		budget = cfg.DoMaxSteps
+	}
+	if value, ok := strings.CutPrefix(args[0], "--steps="); ok {
+		budget, _ = strconv.Atoi(value)
+		args = args[1:]
+	} else if args[0] == "--steps" && len(args) > 1 {
+		budget, _ = strconv.Atoi(args[1])
+		args = args[2:]
+	}
+	goal := strings.Trim(strings.Join(args, " "), `"'`)
+	if goal == "" || budget <= 0 {
+		return getDoUsage(), nil
+	}
+	return runTask(goal, budget, db)
+}
+
+func getDoUsage() string {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	output := fmt.Sprintf("\r\n%sUsage:%s\r\n", lightBlue, reset)
+	output += fmt.Sprintf("  %smako do \"<goal>\"%s                Plan and run a task step by step\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako do --steps <n> \"<goal>\"%s    Allow up to n steps (do_max_steps)\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako do list%s                    Show recent tasks\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako do show <id>%s               Show a task's transcript\r\n", cyan, reset)
+	output += fmt.Sprintf("\r\n%sEvery command is checked and needs your approval. The task stops when a step fails.%s\r\n\r\n", gray, reset)
+	return output
+}
+
+// runTask asks the model for a plan, runs its next step once the user
+// approves it and feeds the result back for the next plan, until the model
+// reports the goal done, a step fails, the user stops or the budget runs out
+func runTask(goal string, budget int, db *database.DB) (string, error) {
+	client, err := ai.NewAIProvider()
+	if err != nil {
+		return "", err
+	}
+
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	writeTTY := func(s string) {
+		fmt.Fprint(tty, s)
+	}
+
+	workingDir, _ := os.Getwd()
+	task := &database.Task{Goal: goal, Status: database.TaskRunning, WorkingDir: workingDir, StartedAt: time.Now()}
+	saveTask := func() {
+		if db == nil {
+			return
+		}
+		if err := db.SaveTask(task); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save task: %v\n", err)
+		}
+	}
+	saveTask()
+
+	writeTTY(fmt.Sprintf("\r\n%s╭─ Task%s\r\n", lightBlue, reset))
+	writeTTY(fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, goal, reset))
+	writeTTY(fmt.Sprintf("%s│%s  %sUp to %d steps, each run only with your approval%s\r\n", lightBlue, reset, gray, budget, reset))
+	writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+
+	return withInputPaused(func() (string, error) {
+		var executed []ai.ExecutedStep
+		for task.Status == database.TaskRunning {
+			n := len(task.Steps) + 1
+			if n > budget {
+				task.Status = database.TaskBudget
+				task.Summary = fmt.Sprintf("Reached the limit of %d steps before finishing.", budget)
+				break
+			}
+
+			context := buildAskContext(db)
+			writeTTY(fmt.Sprintf("\r\n%s▸ Planning...%s\r\n", cyan, reset))
+			reply, err := client.PlanTask(goal, executed, context)
+			var plan *ai.TaskPlan
+			if err == nil {
+				plan, err = ai.ParseTaskPlan(reply)
+			}
+			if err != nil {
+				writeTTY(fmt.Sprintf("%s✗ %v%s\r\n", red, err, reset))
+				task.Status = database.TaskFailed
+				task.Summary = err.Error()
+				break
+			}
+			if plan.Done {
+				task.Status = database.TaskDone
+				task.Summary = plan.Summary
+				break
+			}
+
+			writeTTY(fmt.Sprintf("\r\n%s╭─ Plan%s\r\n", lightBlue, reset))
+			for i, step := range plan.Steps {
+				color := gray
+				if i == 0 {
+					color = cyan
+				}
+				writeTTY(fmt.Sprintf("%s│%s  %s%d. %s%s\r\n", lightBlue, reset, color, n+i, step.Command, reset))
+				if i == 0 && step.Reason != "" {
+					for _, line := range wrapLine(step.Reason, 72) {
+						writeTTY(fmt.Sprintf("%s│%s     %s%s%s\r\n", lightBlue, reset, gray, line, reset))
+					}
+				}
+			}
+			writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+
+			next := plan.Steps[0]
+			decision, command := reviewCommand(fmt.Sprintf("Step %d of up to %d", n, budget), next.Command, true, client, context, writeTTY)
+			step := database.TaskStep{Command: command, Reason: next.Reason, StartedAt: time.Now()}
+			switch decision {
+			case "run":
+				result := runSuggestedCommand(command, context, db, writeTTY)
+				step.Status = database.StepRan
+				step.ExitCode = result.ExitCode
+				step.Output = tailOutput(result.Output, chatResultLines, chatResultBytes)
+				step.DurationMS = time.Since(step.StartedAt).Milliseconds()
+				executed = append(executed, ai.ExecutedStep{Command: command, ExitCode: result.ExitCode, Output: result.Output})
+				if result.Err != nil {
+					task.Status = database.TaskFailed
+					task.Summary = fmt.Sprintf("Step %d failed with exit code %d.", n, result.ExitCode)
+				}
+			case "skip":
+				step.Status = database.StepSkipped
+				executed = append(executed, ai.ExecutedStep{Command: command, Skipped: true})
+			case "blocked":
+				step.Status = database.StepBlocked
+				task.Status = database.TaskStopped
+				task.Summary = fmt.Sprintf("Step %d was blocked for safety.", n)
+			default:
+				step.Status = database.StepSkipped
+				task.Status = database.TaskStopped
+				task.Summary = fmt.Sprintf("Stopped at step %d.", n)
+			}
+			task.Steps = append(task.Steps, step)
+			saveTask()
+		}
+
+		task.FinishedAt = time.Now()
+		saveTask()
+		return formatTaskOutcome(task), nil
+	})
+}
+
+// taskStatusLabel describes a task status with its colour
+func taskStatusLabel(status string) (string, string) {
+	switch status {
+	case database.TaskDone:
+		return "Task done", "\033[38;2;100;255;100m"
+	case database.TaskFailed:
+		return "Task failed", "\033[38;2;255;100;100m"
+	case database.TaskBudget:
+		return "Step limit reached", "\033[38;2;255;200;100m"
+	case database.TaskRunning:
+		return "Running", "\033[38;2;0;209;255m"
+	default:
+		return "Task stopped", "\033[38;2;255;200;100m"
+	}
+}
+
+func formatTaskOutcome(task *database.Task) string {
+	lightBlue := "\033[38;2;93;173;226m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	label, color := taskStatusLabel(task.Status)
+	ran := 0
+	for _, step := range task.Steps {
+		if step.Status == database.StepRan {
+			ran++
+		}
+	}
+
+	output := fmt.Sprintf("\r\n%s╭─ %s%s%s\r\n", lightBlue, color, label, reset)
+	for _, line := range wrapLine(task.Summary, 76) {
+		output += fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, line)
+	}
+	output += fmt.Sprintf("%s│%s  %s%d of %d proposed commands run", lightBlue, reset, gray, ran, len(task.Steps))
+	if task.ID != 0 {
+		output += fmt.Sprintf(" · mako do show %d", task.ID)
+	}
+	output += fmt.Sprintf("%s\r\n%s╰─%s\r\n\r\n", reset, lightBlue, reset)
+	return output
+}
+
+func handleDoList(db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if db == nil {
+		return "", fmt.Errorf("history database is not available")
+	}
+	tasks, err := db.GetTasks(20)
+	if err != nil {
+		return "", err
+	}
+	if len(tasks) == 0 {
+		return fmt.Sprintf("\r\n%sNo tasks yet. Start one with: mako do \"<goal>\"%s\r\n\r\n", gray, reset), nil
+	}
+
+	output := fmt.Sprintf("\r\n%s╭─ Recent Tasks%s\r\n", lightBlue, reset)
+	for _, task := range tasks {
+		label, color := taskStatusLabel(task.Status)
+		output += fmt.Sprintf("%s│%s  %s#%-4d%s %s%-18s%s %s%d steps, %s%s\r\n", lightBlue, reset, gray, task.ID, reset,
+			color, label, reset, gray, len(task.Steps), formatAge(task.StartedAt), reset)
+		output += fmt.Sprintf("%s│%s        %s\r\n", lightBlue, reset, truncateForDisplay(task.Goal, 70))
+	}
+	output += fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset)
+	return output, nil
+}
+
+func handleDoShow(id int64, db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if db == nil {
+		return "", fmt.Errorf("history database is not available")
+	}
+	task, err := db.GetTask(id)
+	if err != nil {
+		return "", err
+	}
+
+	label, color := taskStatusLabel(task.Status)
+	output := fmt.Sprintf("\r\n%s╭─ Task #%d%s %s(%s in %s)%s\r\n", lightBlue, task.ID, reset, gray, task.StartedAt.Format("2006-01-02 15:04"), task.WorkingDir, reset)
+	output += fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, task.Goal, reset)
+	output += fmt.Sprintf("%s│%s\r\n", lightBlue, reset)
+	for i, step := range task.Steps {
+		var marker string
+		switch {
+		case step.Status == database.StepSkipped:
+			marker = gray + "–" + reset
+		case step.Status == database.StepBlocked:
+			marker = red + "⊘" + reset
+		case step.ExitCode != 0:
+			marker = red + "✗" + reset
+		default:
+			marker = green + "✓" + reset
+		}
+		output += fmt.Sprintf("%s│%s  %s %d. %s", lightBlue, reset, marker, i+1, step.Command)
+		if step.Status == database.StepRan {
+			output += fmt.Sprintf(" %s(exit %d, %dms)%s", gray, step.ExitCode, step.DurationMS, reset)
+		} else {
+			output += fmt.Sprintf(" %s(%s)%s", gray, step.Status, reset)
+		}
+		output += "\r\n"
+		if step.Reason != "" {
+			output += fmt.Sprintf("%s│%s       %s%s%s\r\n", lightBlue, reset, gray, truncateForDisplay(step.Reason, 70), reset)
+		}
+		for _, line := range strings.Split(tailOutput(step.Output, 5, chatResultBytes), "\n") {
+			if strings.TrimSpace(line) != "" {
+				output += fmt.Sprintf("%s│%s       %s\r\n", lightBlue, reset, line)
+			}
+		}
+	}
+	output += fmt.Sprintf("%s│%s\r\n", lightBlue, reset)
+	output += fmt.Sprintf("%s│%s  %s%s%s", lightBlue, reset, color, label, reset)
+	if task.Summary != "" {
+		output += ": " + task.Summary
+	}
+	output += fmt.Sprintf("\r\n%s╰─%s\r\n\r\n", lightBlue, reset)
+	return output, nil
+}
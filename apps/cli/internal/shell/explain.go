@@ -0,0 +1,167 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/explain"
+)
+
+// explainColumn is the widest part shown beside its meaning; longer parts
+// get their meaning on the line below
+const explainColumn = 22
+
+// handleExplain handles `mako explain [--offline] <command>`
+func handleExplain(args []string, db *database.DB) (string, error) {
+	offline := false
+	if len(args) > 0 && args[0] == "--offline" {
+		offline = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return "Usage: mako explain [--offline] \"<command>\"\n", nil
+	}
+	command := strings.Join(args, " ")
+
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	writeTTY := func(s string) {
+		fmt.Fprint(tty, s)
+	}
+
+	var client ai.AIProvider
+	if !offline {
+		if client, err = ai.NewAIProvider(); err != nil {
+			writeTTY(fmt.Sprintf("%sℹ No AI provider (%v); explaining from local documentation only%s\r\n", gray, err, reset))
+			client = nil
+		}
+	}
+
+	if err := explainCommand(command, client, buildAskContext(db), writeTTY); err != nil {
+		return "", err
+	}
+	writeTTY("\r\n")
+	return "", nil
+}
+
+// explainCommand explains a command part by part from the machine's man pages
+// and the --help output of system programs, asking the AI only about the parts nothing
+// documents. With a nil client it stays offline. Commands that don't parse
+// are explained by the AI as a whole.
+func explainCommand(command string, client ai.AIProvider, context ai.SystemContext, writeTTY func(string)) error {
+	cyan := "\033[38;2;0;209;255m"
+	lightBlue := "\033[38;2;93;173;226m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	e, err := explain.Command(command)
+	if err != nil {
+		if client == nil {
+			return err
+		}
+		return explainWithAI(command, client, context, writeTTY)
+	}
+
+	fromAI := map[string]bool{}
+	if unknown := e.Unknown(); len(unknown) > 0 && client != nil {
+		writeTTY(fmt.Sprintf("\r\n%s▸ Asking AI about %d undocumented part(s)...%s\r\n", cyan, len(unknown), reset))
+		reply, err := client.ExplainParts(command, unknown, context)
+		if err != nil {
+			writeTTY(fmt.Sprintf("%s⚠ Could not get explanation: %v%s\r\n", gray, err, reset))
+		}
+		meanings := ai.ParsePartExplanations(reply, unknown)
+		for i, part := range e.Parts {
+			if meaning, ok := meanings[part.Text]; ok && part.Help == "" {
+				e.Parts[i].Help = meaning
+				fromAI[part.Text] = true
+			}
+		}
+	}
+
+	width := 0
+	for _, part := range e.Parts {
+		if part.Kind != explain.KindArgument && len(part.Text) <= explainColumn {
+			width = max(width, len(part.Text))
+		}
+	}
+
+	writeTTY(fmt.Sprintf("\r\n%s╭─ Command Explanation%s\r\n", lightBlue, reset))
+	for _, part := range e.Parts {
+		// Arguments are the user's own: file names, patterns, messages
+		if part.Kind == explain.KindArgument {
+			continue
+		}
+
+		help, color := part.Help, reset
+		switch {
+		case help == "":
+			help, color = "no documentation found", gray
+		case fromAI[part.Text]:
+			help += " (AI)"
+		}
+
+		lines := wrapLine(help, 74-width)
+		if len(part.Text) > explainColumn {
+			writeTTY(fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, part.Text, reset))
+			for _, line := range wrapLine(help, 72) {
+				writeTTY(fmt.Sprintf("%s│%s    %s%s%s\r\n", lightBlue, reset, color, line, reset))
+			}
+			continue
+		}
+		for i, line := range lines {
+			text := ""
+			if i == 0 {
+				text = part.Text
+			}
+			writeTTY(fmt.Sprintf("%s│%s  %s%-*s%s  %s%s%s\r\n", lightBlue, reset, cyan, width, text, reset, color, line, reset))
+		}
+	}
+
+	sources := e.Sources()
+	if len(fromAI) > 0 {
+		sources = append(sources, "AI")
+	}
+	if len(sources) > 0 {
+		writeTTY(fmt.Sprintf("%s╰─ %sfrom %s%s\r\n", lightBlue, gray, strings.Join(sources, ", "), reset))
+	} else {
+		writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+	}
+	return nil
+}
+
+// explainWithAI has the AI explain the whole command
+func explainWithAI(command string, client ai.AIProvider, context ai.SystemContext, writeTTY func(string)) error {
+	cyan := "\033[38;2;0;209;255m"
+	lightBlue := "\033[38;2;93;173;226m"
+	reset := "\033[0m"
+
+	writeTTY(fmt.Sprintf("\r\n%s▸ Getting explanation...%s\r\n", cyan, reset))
+
+	explanation, err := client.ExplainCommand(command, context)
+	if err != nil {
+		return fmt.Errorf("failed to get explanation: %w", err)
+	}
+
+	writeTTY(fmt.Sprintf("\r\n%s╭─ Command Explanation%s\r\n", lightBlue, reset))
+	lines := strings.Split(explanation, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			wrappedLines := wrapLine(line, 76)
+			for _, wrappedLine := range wrappedLines {
+				writeTTY(fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, wrappedLine))
+			}
+		}
+	}
+	writeTTY(fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset))
+	return nil
+}
@@ -6,8 +6,10 @@ import (
 	"os"
 	"strings"
 
+	"github.com/fabiobrug/mako.git/internal/ai"
 	"github.com/fabiobrug/mako.git/internal/database"
 	"github.com/fabiobrug/mako.git/internal/export"
+	"github.com/fabiobrug/mako.git/internal/importer"
 )
 
 func handleExport(args []string, db *database.DB) (string, error) {
@@ -16,10 +18,12 @@ func handleExport(args []string, db *database.DB) (string, error) {
 	}
 	
 	if len(args) == 0 {
-		return "Usage: mako export [--last N] [--dir /path] > output.json\r\n", nil
+		return "Usage: mako export [--last N] [--dir /path] [--success|--failed] [--since T] [--until T]\r\n" +
+			"                   [--semantic <query>] [--format json|ndjson|csv|sh|md] [--no-redact] [-o file]\r\n", nil
 	}
 	
 	opts := export.ExportOptions{}
+	var outputPath, formatName string
 	
 	// Parse arguments
 	for i := 0; i < len(args); i++ {
@@ -38,16 +42,85 @@ func handleExport(args []string, db *database.DB) (string, error) {
 			opts.SuccessOnly = true
 		case "--failed":
 			opts.FailedOnly = true
+		case "--since", "--until":
+			if i+1 < len(args) {
+				t, err := parseTimeArg(args[i+1], args[i] == "--until")
+				if err != nil {
+					return fmt.Sprintf("Error: %v\r\n", err), nil
+				}
+				if args[i] == "--since" {
+					opts.DateFrom = t
+				} else {
+					opts.DateTo = t
+				}
+				i++
+			}
+		case "--semantic":
+			// The query runs until the next flag, so it needn't be quoted
+			var words []string
+			for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") && args[i+1] != ">" {
+				words = append(words, strings.Trim(args[i+1], `"'`))
+				i++
+			}
+			opts.Semantic = strings.Join(words, " ")
+		case "--no-redact":
+			opts.Unredacted = true
+		case "--format":
+			if i+1 < len(args) {
+				formatName = args[i+1]
+				i++
+			}
+		case "-o", "--output", ">":
+			if i+1 < len(args) {
+				outputPath = args[i+1]
+				i++
+			}
+		}
+	}
+	
+	// Without --format, pick the format from the output file's extension
+	if formatName != "" {
+		format, err := export.ParseFormat(formatName)
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
 		}
+		opts.Format = format
+	} else if outputPath != "" {
+		opts.Format = export.FormatForFile(outputPath)
 	}
 	
 	// Default to last 1000 if nothing specified
-	if opts.Last == 0 && opts.WorkingDir == "" && !opts.SuccessOnly && !opts.FailedOnly {
+	if opts.Last == 0 && opts.WorkingDir == "" && !opts.SuccessOnly && !opts.FailedOnly &&
+		opts.Semantic == "" && opts.DateFrom.IsZero() && opts.DateTo.IsZero() {
 		opts.Last = 1000
 	}
 	
 	// Create exporter
 	exporter := export.NewExporter(db)
+	if opts.Semantic != "" {
+		embedService, err := ai.NewEmbeddingProvider()
+		if err != nil {
+			return fmt.Sprintf("Error: semantic export needs an embedding provider: %v\r\n", err), nil
+		}
+		exporter.SetEmbedder(embedService)
+	}
+	
+	// Stream straight to the file so large histories aren't buffered
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to create file: %w", err)
+		}
+		defer file.Close()
+		
+		if err := exporter.Export(file, opts); err != nil {
+			return "", fmt.Errorf("export failed: %w", err)
+		}
+		if opts.Format == export.FormatShell {
+			os.Chmod(outputPath, 0755)
+		}
+		return fmt.Sprintf("✓ Exported history to %s\r\n", outputPath), nil
+	}
 	
 	// Export to stdout
 	var buf bytes.Buffer
@@ -66,7 +139,14 @@ func handleImport(args []string, db *database.DB) (string, error) {
 	}
 	
 	if len(args) == 0 {
-		return "Usage: mako import [--merge|--skip|--overwrite] <file.json>\r\n", nil
+		return "Usage: mako import [--merge|--skip|--overwrite] [--format json|ndjson|csv] <file>\r\n" +
+			"       mako import --from <bash|zsh|fish|atuin|mcfly> [path] [--dry-run]\r\n", nil
+	}
+	
+	for _, arg := range args {
+		if arg == "--from" || strings.HasPrefix(arg, "--from=") {
+			return handleHistoryImport(args, db)
+		}
 	}
 	
 	opts := export.ImportOptions{
@@ -86,6 +166,15 @@ func handleImport(args []string, db *database.DB) (string, error) {
 			opts.ConflictStrategy = export.ConflictOverwrite
 		case "--dry-run":
 			opts.DryRun = true
+		case "--format":
+			if i+1 < len(args) {
+				format, err := export.ParseFormat(args[i+1])
+				if err != nil {
+					return fmt.Sprintf("Error: %v\r\n", err), nil
+				}
+				opts.Format = format
+				i++
+			}
 		default:
 			filename = args[i]
 		}
@@ -133,10 +222,61 @@ func handleImport(args []string, db *database.DB) (string, error) {
 	return output, nil
 }
 
-func handleSync(db *database.DB) (string, error) {
+// handleHistoryImport imports another shell's or history tool's history,
+// keeping exit codes, durations and directories where the source has them
+func handleHistoryImport(args []string, db *database.DB) (string, error) {
+	var from, path string
+	dryRun := false
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--from":
+			if i+1 < len(args) {
+				from = args[i+1]
+				i++
+			}
+		case strings.HasPrefix(args[i], "--from="):
+			from = strings.TrimPrefix(args[i], "--from=")
+		case args[i] == "--dry-run":
+			dryRun = true
+		default:
+			path = args[i]
+		}
+	}
+
+	if from == "" {
+		return fmt.Sprintf("Error: --from requires one of: %s\r\n", strings.Join(importer.Names(), ", ")), nil
+	}
+
+	source, err := importer.Get(from)
+	if err != nil {
+		return fmt.Sprintf("Error: %v\r\n", err), nil
+	}
+
+	result, err := importer.Import(db, source, path, dryRun)
+	if err != nil {
+		return fmt.Sprintf("Error: %v\r\n", err), nil
+	}
+
+	output := fmt.Sprintf("\r\nImport from %s complete:\r\n", result.Source)
+	if dryRun {
+		output = fmt.Sprintf("\r\nDry run, nothing was stored (%s):\r\n", result.Source)
+	}
+	output += fmt.Sprintf("  File: %s\r\n", result.Path)
+	output += fmt.Sprintf("  Total: %d\r\n", result.Total)
+	output += fmt.Sprintf("  Imported: %d\r\n", result.Imported)
+	output += fmt.Sprintf("  Skipped: %d\r\n", result.Skipped)
+
+	return output, nil
+}
+
+func handleSync(args []string, db *database.DB) (string, error) {
 	if db == nil {
 		return "\r\n✗ Database not available\r\n\r\n", nil
 	}
+	if len(args) > 0 {
+		return handleRemoteSync(args, db)
+	}
 	
 	// Get default history path
 	historyPath := database.GetDefaultHistoryPath()
@@ -146,11 +286,23 @@ func handleSync(db *database.DB) (string, error) {
 	}
 	
 	// Sync with limit of 100 new commands
-	count, err := db.SyncBashHistory(historyPath, 100)
+	count, err := importer.Sync(db, historyPath, 100)
 	if err != nil {
 		return "", fmt.Errorf("sync failed: %w", err)
 	}
 	
 	output := fmt.Sprintf("Synced %d new commands from %s\r\n", count, historyPath)
+	
+	// Also sync with the sync server and folder, if they are set up
+	if remote, err := syncWithServer(db); err != nil {
+		output += fmt.Sprintf("Sync server: %v\r\n", err)
+	} else if remote != nil {
+		output += fmt.Sprintf("Sync server: pushed %d, pulled %d\r\n", remote.Pushed, remote.Pulled)
+	}
+	if folder, err := syncWithDir(db); err != nil {
+		output += fmt.Sprintf("Sync folder: %v\r\n", err)
+	} else if folder != nil {
+		output += fmt.Sprintf("Sync folder: wrote %d, imported %d\r\n", folder.Exported, folder.Imported)
+	}
 	return output, nil
 }
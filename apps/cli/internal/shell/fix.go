@@ -0,0 +1,164 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/fix"
+	"github.com/fabiobrug/mako.git/internal/safety"
+)
+
+// maxFixes caps how many fixes the menu offers
+const maxFixes = 5
+
+// handleFix offers ranked corrections for the last failed command: the
+// rules in package fix first, then the AI when they find nothing or the
+// user asks for it
+func handleFix(db *database.DB) (string, error) {
+	failure := latestFailure(db)
+
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+	if failure == nil {
+		return fmt.Sprintf("%sNo failed command to fix yet%s\n", gray, reset), nil
+	}
+
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	red := "\033[38;2;255;100;100m"
+
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	writeTTY := func(s string) {
+		fmt.Fprint(tty, s)
+	}
+
+	writeFailedCommand(failure, writeTTY)
+
+	fixes := fix.Suggest(failure.Command, failure.Output)
+	askedAI := false
+	if len(fixes) == 0 {
+		writeTTY(fmt.Sprintf("\r\n%s▸ No rule matches, asking AI...%s\r\n", cyan, reset))
+		fixes, err = aiFix(failure, db)
+		askedAI = true
+		if err != nil {
+			return "", err
+		}
+		if len(fixes) == 0 {
+			writeTTY(fmt.Sprintf("%sℹ No fix found; try mako why for an explanation%s\r\n\r\n", gray, reset))
+			return "", nil
+		}
+	}
+	if len(fixes) > maxFixes {
+		fixes = fixes[:maxFixes]
+	}
+
+	return withInputPaused(func() (string, error) {
+		for {
+			menuArgs := []string{fmt.Sprintf("%sPick a fix%s", lightBlue, reset)}
+			for i, f := range fixes {
+				// mako-menu splits items on the first |
+				label := strings.ReplaceAll(f.Command, "|", "¦")
+				if f.Reason != "" {
+					label += "  · " + strings.ReplaceAll(f.Reason, "|", "¦")
+				}
+				menuArgs = append(menuArgs, fmt.Sprintf("%s|%d", truncateForDisplay(label, 76), i))
+			}
+			if !askedAI {
+				menuArgs = append(menuArgs, "Ask AI for a fix|ai")
+			}
+			menuArgs = append(menuArgs, "Cancel|cancel")
+
+			time.Sleep(150 * time.Millisecond)
+			menuCmd := exec.Command(findMenuPath(), menuArgs...)
+			menuCmd.Stderr = os.Stderr
+			choiceBytes, err := menuCmd.Output()
+			if err != nil {
+				return "", fmt.Errorf("menu failed: %w", err)
+			}
+			time.Sleep(150 * time.Millisecond)
+
+			choice := strings.TrimSpace(string(choiceBytes))
+			switch choice {
+			case "cancel", "":
+				writeTTY(fmt.Sprintf("%sℹ Cancelled%s\r\n\r\n", gray, reset))
+				return "", nil
+			case "ai":
+				writeTTY(fmt.Sprintf("\r\n%s▸ Asking AI...%s\r\n", cyan, reset))
+				suggested, err := aiFix(failure, db)
+				askedAI = true
+				if err != nil {
+					writeTTY(fmt.Sprintf("%s✗ %v%s\r\n", red, err, reset))
+					continue
+				}
+				if len(suggested) == 0 {
+					writeTTY(fmt.Sprintf("%sℹ The AI had no fix to offer%s\r\n", gray, reset))
+					continue
+				}
+				fixes = append(suggested, fixes...)
+				continue
+			}
+
+			i, err := strconv.Atoi(choice)
+			if err != nil || i < 0 || i >= len(fixes) {
+				return "", fmt.Errorf("unexpected menu choice: %q", choice)
+			}
+			command := fixes[i].Command
+
+			validationResult := validator.ValidateCommand(command)
+			if validationResult.Risk == safety.RiskCritical {
+				writeTTY(validator.FormatWarning(validationResult))
+				writeTTY(fmt.Sprintf("\r\n%s✗ Command blocked for safety%s\r\n\r\n", red, reset))
+				return "", nil
+			}
+			if !validationResult.Safe {
+				writeTTY(validator.FormatWarning(validationResult))
+				confirmCmd := exec.Command(findMenuPath(), fmt.Sprintf("%sRun %s?%s", lightBlue, command, reset), "Confirm and run|run", "Back|back")
+				confirmCmd.Stderr = os.Stderr
+				confirm, err := confirmCmd.Output()
+				if err != nil {
+					return "", fmt.Errorf("menu failed: %w", err)
+				}
+				if strings.TrimSpace(string(confirm)) != "run" {
+					continue
+				}
+			}
+
+			result := runSuggestedCommand(command, buildAskContext(db), db, writeTTY)
+			noteFixResult(failure, command, result)
+			writeTTY("\r\n")
+			return "", nil
+		}
+	})
+}
+
+// aiFix asks the provider to explain the failure and returns the corrected
+// command its explanation suggests, if any
+func aiFix(failure *failedCommand, db *database.DB) ([]fix.Suggestion, error) {
+	client, err := ai.NewAIProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	context := buildAskContext(db)
+	context.RecentOutput = failure.Output
+
+	explanation, err := client.ExplainError(failure.Command, failure.errorOutput(), context)
+	if err != nil {
+		return nil, fmt.Errorf("could not get a fix: %w", err)
+	}
+	command := ai.SuggestedFix(explanation)
+	if command == "" || command == failure.Command {
+		return nil, nil
+	}
+	return []fix.Suggestion{{Command: command, Reason: "suggested by AI"}}, nil
+}
@@ -52,9 +52,9 @@ func handleClear() (string, error) {
 	green := "\033[38;2;100;255;100m"
 	reset := "\033[0m"
 	
-	if err := ai.ClearConversation(); err != nil {
+	if err := ai.ClearThread(activeThread); err != nil {
 		return "", fmt.Errorf("failed to clear conversation: %w", err)
 	}
 	
-	return fmt.Sprintf("\n%s✓ Conversation history cleared%s\n\n", green, reset), nil
+	return fmt.Sprintf("\n%s✓ Conversation history cleared (%s)%s\n\n", green, activeThread, reset), nil
 }
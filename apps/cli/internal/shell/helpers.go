@@ -1,10 +1,36 @@
 package shell
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
+// findMenuPath locates the mako-menu binary next to mako, falling back to PATH
+func findMenuPath() string {
+	possiblePaths := []string{
+		"./mako-menu",
+		filepath.Join(filepath.Dir(os.Args[0]), "mako-menu"),
+		"mako-menu",
+	}
+
+	for _, path := range possiblePaths {
+		if absPath, err := filepath.Abs(path); err == nil {
+			if _, err := os.Stat(absPath); err == nil {
+				return absPath
+			}
+		}
+	}
+
+	return "mako-menu"
+}
+
 // readLineFromTTY reads a line of input from /dev/tty with the prefilled text
 func readLineFromTTY(prefill string) (string, error) {
 	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
@@ -29,6 +55,10 @@ func readLineFromTTY(prefill string) (string, error) {
 			if buf[0] == '\n' || buf[0] == '\r' {
 				break
 			}
+			// Ctrl-C, or Ctrl-D on an empty line
+			if buf[0] == 3 || (buf[0] == 4 && len(result) == 0) {
+				return "", errInputCancelled
+			}
 			// Handle backspace
 			if buf[0] == 127 || buf[0] == 8 {
 				if len(result) > 0 {
@@ -47,6 +77,54 @@ func readLineFromTTY(prefill string) (string, error) {
 	return string(result), nil
 }
 
+// errInputCancelled is returned when the user presses Ctrl-C at a prompt
+var errInputCancelled = errors.New("cancelled")
+
+// readSecretFromTTY reads a line from /dev/tty without echoing it. The
+// terminal is put in raw mode for the read, so echo is off even when mako
+// didn't leave it raw, and restored afterwards.
+func readSecretFromTTY(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	state, err := term.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return "", fmt.Errorf("failed to turn off echo: %w", err)
+	}
+	defer term.Restore(int(tty.Fd()), state)
+
+	tty.WriteString(prompt)
+
+	var result []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			continue
+		}
+		switch buf[0] {
+		case '\n', '\r':
+			tty.WriteString("\r\n")
+			return string(result), nil
+		case 3: // Ctrl-C
+			tty.WriteString("\r\n")
+			return "", errInputCancelled
+		case 127, 8:
+			if len(result) > 0 {
+				result = result[:len(result)-1]
+			}
+		default:
+			result = append(result, buf[0])
+		}
+	}
+}
+
 // wrapLine wraps a line of text to fit within maxWidth characters
 func wrapLine(text string, maxWidth int) []string {
 	if len(text) <= maxWidth {
@@ -86,3 +164,27 @@ func wrapLine(text string, maxWidth int) []string {
 
 	return lines
 }
+
+// parseTimeArg reads a --since/--until value: a date (2006-01-02), an RFC
+// 3339 timestamp, or an age such as 7d, 12h or 30m. With endOfDay a bare
+// date means the end of that day.
+func parseTimeArg(value string, endOfDay bool) (time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			return day.Add(24*time.Hour - time.Nanosecond), nil
+		}
+		return day, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+		return time.Now().Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use YYYY-MM-DD or an age like 7d)", value)
+}
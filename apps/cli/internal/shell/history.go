@@ -26,6 +26,10 @@ func handleHistory(args []string, db *database.DB) (string, error) {
 		return fmt.Sprintf("\n%s✗ Database not available%s\n\n", dimBlue, reset), nil
 	}
 	
+	if len(args) > 0 && args[0] == "scrub" {
+		return handleHistoryScrub(db)
+	}
+	
 	// Check for flags
 	filterFailed := false
 	filterSuccess := false
@@ -437,7 +441,7 @@ func handleInteractiveHistory(db *database.DB, filterFailed bool, filterSuccess
 				}
 			}
 
-			safeCommand := validator.RedactSecrets(selectedCmd.Command)
+			safeCommand := database.RedactSecrets(selectedCmd.Command)
 
 			embedService, _ := ai.NewEmbeddingProvider()
 			var embeddingBytes []byte
@@ -496,3 +500,39 @@ func handleInteractiveHistory(db *database.DB, filterFailed bool, filterSuccess
 		return "", nil
 	}
 }
+
+// handleHistoryScrub redacts secrets in history saved before redaction was
+// applied on insert
+func handleHistoryScrub(db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	green := "\033[38;2;100;255;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	result, err := db.ScrubSecrets()
+	if err != nil {
+		return "", fmt.Errorf("scrub failed: %w", err)
+	}
+
+	// The in-memory cache may still hold unredacted keys and is written back on exit
+	if embeddingCache != nil {
+		embeddingCache.Clear()
+	}
+
+	output := fmt.Sprintf("\n%s╭─ History Scrub%s\n", lightBlue, reset)
+	output += fmt.Sprintf("%s│%s  Commands scanned:      %d\n", lightBlue, reset, result.CommandsScanned)
+	output += fmt.Sprintf("%s│%s  Commands redacted:     %d\n", lightBlue, reset, result.CommandsRedacted)
+	output += fmt.Sprintf("%s│%s  Cache entries removed: %d\n", lightBlue, reset, result.CacheEntries)
+	if result.BackupsRemoved > 0 {
+		output += fmt.Sprintf("%s│%s  Old backups removed:   %d\n", lightBlue, reset, result.BackupsRemoved)
+	}
+	output += fmt.Sprintf("%s╰─%s\n", lightBlue, reset)
+
+	if result.CommandsRedacted > 0 {
+		output += fmt.Sprintf("%s✓ Secrets removed; affected embeddings will be regenerated%s\n\n", green, reset)
+	} else {
+		output += fmt.Sprintf("%sℹ No secrets found in history%s\n\n", gray, reset)
+	}
+
+	return output, nil
+}
@@ -0,0 +1,121 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/context"
+	"github.com/fabiobrug/mako.git/internal/database"
+)
+
+// maxShownPreferences bounds the list mako prefs show prints
+const maxShownPreferences = 25
+
+// currentProject is the project root of the working directory, or ""
+func currentProject() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return context.ProjectRootOf(dir)
+}
+
+// loadPreferences builds the store of preferences that apply in the
+// current directory, or returns nil without a database
+func loadPreferences(db *database.DB) *ai.PersonalizationStore {
+	if db == nil {
+		return nil
+	}
+	prefs, err := db.Preferences(currentProject())
+	if err != nil {
+		return nil
+	}
+	store := ai.NewPersonalizationStore()
+	for _, pref := range prefs {
+		store.Add(pref.Project, pref.BaseCommand, pref.Flags, pref.Uses, pref.LastUsed)
+	}
+	return store
+}
+
+// handlePrefs handles `mako prefs show|forget <command>|reset`
+func handlePrefs(args []string, db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	green := "\033[38;2;100;255;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if db == nil {
+		return fmt.Sprintf("\r\n%s✗ Database not available%s\r\n\r\n", gray, reset), nil
+	}
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+
+	switch args[0] {
+	case "show":
+		project := currentProject()
+		prefs, err := db.Preferences(project)
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+
+		output := fmt.Sprintf("\r\n%s╭─ Learned Preferences%s\r\n", lightBlue, reset)
+		if len(prefs) == 0 {
+			output += fmt.Sprintf("%s│%s  %sNothing learned yet; preferences come from commands you run with flags%s\r\n", lightBlue, reset, gray, reset)
+		}
+		for i, pref := range prefs {
+			if i == maxShownPreferences {
+				output += fmt.Sprintf("%s│%s  %s... and %d more%s\r\n", lightBlue, reset, gray, len(prefs)-i, reset)
+				break
+			}
+			where := "everywhere"
+			if pref.Project != "" {
+				where = "this project"
+			}
+			output += fmt.Sprintf("%s│%s  %s%-32s%s %s%3d uses · last %s · %s%s\r\n", lightBlue, reset,
+				cyan, truncateForDisplay(pref.BaseCommand+" "+pref.Flags, 32), reset,
+				gray, pref.Uses, formatAge(pref.LastUsed), where, reset)
+		}
+		output += fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset)
+
+		output += fmt.Sprintf("\r\n%s╭─ What the AI is told%s\r\n", lightBlue, reset)
+		hints := ""
+		if store := loadPreferences(db); store != nil {
+			hints = store.GetPreferenceHints()
+		}
+		if hints == "" {
+			output += fmt.Sprintf("%s│%s  %sNothing yet: a command needs %d uses with the same flags%s\r\n", lightBlue, reset, gray, ai.MinHintUses, reset)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(hints), "\n") {
+			if line != "" {
+				output += fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, line)
+			}
+		}
+		output += fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset)
+		return output, nil
+
+	case "forget":
+		if len(args) < 2 {
+			return "Usage: mako prefs forget <command>\r\n", nil
+		}
+		command := strings.Join(args[1:], " ")
+		count, err := db.ForgetPreference(command)
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		return fmt.Sprintf("%s✓ Forgot %d preference(s) for %s%s %s(earlier uses won't be learned again)%s\r\n",
+			green, count, command, reset, gray, reset), nil
+
+	case "reset":
+		if err := db.ResetPreferences(); err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		return fmt.Sprintf("%s✓ Preferences reset%s %s(learning starts again from the next command)%s\r\n", green, reset, gray, reset), nil
+
+	default:
+		return "Usage: mako prefs <show|forget <command>|reset>\r\n", nil
+	}
+}
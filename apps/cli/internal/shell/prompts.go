@@ -0,0 +1,144 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/prompts"
+)
+
+// handlePrompts handles `mako prompts show [name]|edit <name>|reset [name]`
+func handlePrompts(args []string) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	green := "\033[38;2;100;255;100m"
+	yellow := "\033[38;2;255;200;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+	usage := "Usage: mako prompts <show [name]|edit <name>|reset [name]>\r\n"
+
+	switch args[0] {
+	case "show":
+		if len(args) > 1 {
+			tmpl, err := prompts.Load(args[1])
+			if err != nil {
+				return fmt.Sprintf("Error: %v\r\n", err), nil
+			}
+			source := fmt.Sprintf("built-in, version %d", tmpl.Version)
+			if tmpl.Overridden {
+				source = fmt.Sprintf("%s, version %d", tmpl.Path, tmpl.Version)
+			}
+			output := fmt.Sprintf("\r\n%s╭─ Prompt '%s' %s(%s)%s\r\n", lightBlue, tmpl.Name, gray, source, reset)
+			for _, line := range strings.Split(strings.TrimRight(tmpl.Text, "\n"), "\n") {
+				output += fmt.Sprintf("%s│%s  %s\r\n", lightBlue, reset, line)
+			}
+			output += fmt.Sprintf("%s╰─%s\r\n", lightBlue, reset)
+			output += promptWarnings(tmpl, yellow, reset)
+			return output + "\r\n", nil
+		}
+
+		output := fmt.Sprintf("\r\n%s╭─ Prompt Templates%s\r\n", lightBlue, reset)
+		var warnings string
+		for _, name := range prompts.Names() {
+			tmpl, err := prompts.Load(name)
+			if err != nil {
+				return fmt.Sprintf("Error: %v\r\n", err), nil
+			}
+			status := fmt.Sprintf("%sbuilt-in v%d%s", gray, tmpl.DefaultVersion, reset)
+			if tmpl.Overridden {
+				status = fmt.Sprintf("%scustomised v%d%s", green, tmpl.Version, reset)
+			}
+			output += fmt.Sprintf("%s│%s  %s%-16s%s %-46s %s\r\n", lightBlue, reset, cyan, name, reset, prompts.Descriptions[name], status)
+			warnings += promptWarnings(tmpl, yellow, reset)
+		}
+		output += fmt.Sprintf("%s╰─ %sOverrides live in %s%s\r\n", lightBlue, gray, prompts.Dir(), reset)
+		return output + warnings + "\r\n", nil
+
+	case "edit":
+		if len(args) < 2 {
+			return "Usage: mako prompts edit <name>\r\n", nil
+		}
+		path, err := prompts.Create(args[1])
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		if err := editFile(path); err != nil {
+			return fmt.Sprintf("%sEdit the template at %s (%v)%s\r\n", gray, path, err, reset), nil
+		}
+		tmpl, _ := prompts.Load(args[1])
+		if warnings := promptWarnings(tmpl, yellow, reset); warnings != "" {
+			return warnings, nil
+		}
+		return fmt.Sprintf("%s✓ Saved %s%s %s(mako prompts reset %s restores the default)%s\r\n", green, path, reset, gray, args[1], reset), nil
+
+	case "reset":
+		names := args[1:]
+		if len(names) == 0 {
+			names = prompts.Names()
+		}
+		count := 0
+		for _, name := range names {
+			removed, err := prompts.Reset(name)
+			if err != nil {
+				return fmt.Sprintf("Error: %v\r\n", err), nil
+			}
+			if removed {
+				count++
+			}
+		}
+		if count == 0 {
+			return fmt.Sprintf("%sℹ Already using the built-in templates%s\r\n", gray, reset), nil
+		}
+		return fmt.Sprintf("%s✓ Restored %d built-in template(s)%s\r\n", green, count, reset), nil
+
+	default:
+		return usage, nil
+	}
+}
+
+// promptWarnings says why an override isn't used or may be out of date
+func promptWarnings(tmpl *prompts.Template, yellow, reset string) string {
+	switch {
+	case tmpl.Err != nil:
+		return fmt.Sprintf("%s⚠ %s: override ignored, using the built-in template: %v%s\r\n", yellow, tmpl.Name, tmpl.Err, reset)
+	case tmpl.Stale():
+		return fmt.Sprintf("%s⚠ %s: override was written for version %d; the built-in template is now version %d (see mako prompts show %s)%s\r\n",
+			yellow, tmpl.Name, tmpl.Version, tmpl.DefaultVersion, tmpl.Name, reset)
+	}
+	return ""
+}
+
+// editFile opens a file in $VISUAL or $EDITOR on the terminal
+func editFile(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	fields := strings.Fields(editor)
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return errors.New("no editor found; set $EDITOR")
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	_, err = withInputPaused(func() (string, error) {
+		cmd := exec.Command(fields[0], append(fields[1:], path)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+		return "", cmd.Run()
+	})
+	return err
+}
@@ -0,0 +1,170 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/secrets"
+)
+
+// handleSecrets manages the API keys kept in the secrets store
+func handleSecrets(args []string) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	green := "\033[38;2;100;255;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		store, err := cfg.SecretStore()
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		if err := unlockSecretsFromTTY(cfg); err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		names, err := store.List()
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+
+		output := fmt.Sprintf("\r\n%s╭─ Secrets (%s backend)%s\r\n", lightBlue, store.Name(), reset)
+		if len(names) == 0 {
+			output += fmt.Sprintf("%s│%s  %sNo secrets stored%s\r\n", lightBlue, reset, gray, reset)
+		}
+		for _, name := range names {
+			output += fmt.Sprintf("%s│%s  %s%s%s\r\n", lightBlue, reset, cyan, secrets.Ref(name), reset)
+		}
+		output += fmt.Sprintf("%s╰─%s\r\n\r\n", lightBlue, reset)
+		return output, nil
+
+	case "set":
+		if len(args) < 2 {
+			return "Usage: mako secrets set <name>\r\n", nil
+		}
+		name := args[1]
+		if err := secrets.ValidateName(name); err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		if err := unlockSecretsFromTTY(cfg); err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+
+		value, err := withInputPaused(func() (string, error) {
+			return readSecretFromTTY(fmt.Sprintf("%sValue for %s:%s ", lightBlue, name, reset))
+		})
+		if err != nil || value == "" {
+			return fmt.Sprintf("%sCancelled%s\r\n", gray, reset), nil
+		}
+
+		ref, err := cfg.StoreSecret(name, value)
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		if err := cfg.Save(); err != nil {
+			return "", fmt.Errorf("failed to save config: %w", err)
+		}
+		return fmt.Sprintf("%s✓ Stored %s%s (use it as a config value)\r\n", green, ref, reset), nil
+
+	case "delete":
+		if len(args) < 2 {
+			return "Usage: mako secrets delete <name>\r\n", nil
+		}
+		store, err := cfg.SecretStore()
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		if err := unlockSecretsFromTTY(cfg); err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		if err := store.Delete(args[1]); err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		return fmt.Sprintf("%s✓ Deleted %s%s\r\n", green, secrets.Ref(args[1]), reset), nil
+
+	case "migrate":
+		if err := unlockSecretsFromTTY(cfg); err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		count, err := config.MigrateSecrets()
+		if err != nil {
+			return fmt.Sprintf("Error: %v\r\n", err), nil
+		}
+		if count == 0 {
+			return fmt.Sprintf("%sℹ No plaintext API keys found%s\r\n", gray, reset), nil
+		}
+		return fmt.Sprintf("%s✓ Moved %d API key(s) into the secrets store%s\r\n", green, count, reset), nil
+
+	default:
+		return "Usage: mako secrets <list|set|delete|migrate>\r\n", nil
+	}
+}
+
+// unlockSecretsFromTTY prompts for the secrets file passphrase if the file
+// backend is in use and it has not been provided yet
+func unlockSecretsFromTTY(cfg *config.Config) error {
+	store, err := cfg.SecretStore()
+	if err != nil {
+		return err
+	}
+	if store.Name() != secrets.BackendFile || secrets.HasPassphrase() {
+		return nil
+	}
+
+	prompt := "Secrets passphrase:"
+	if _, err := os.Stat(secrets.FilePath(config.GetMakoDir())); os.IsNotExist(err) {
+		prompt = "New secrets passphrase:"
+	}
+
+	passphrase, err := withInputPaused(func() (string, error) {
+		return readSecretFromTTY(fmt.Sprintf("\033[38;2;93;173;226m%s\033[0m ", prompt))
+	})
+	if err != nil || passphrase == "" {
+		return secrets.ErrPassphraseRequired
+	}
+
+	secrets.SetPassphrase(passphrase)
+	if _, err := store.List(); err != nil {
+		secrets.SetPassphrase("")
+		if errors.Is(err, secrets.ErrWrongPassphrase) {
+			return err
+		}
+		return fmt.Errorf("failed to unlock secrets: %w", err)
+	}
+	return nil
+}
+
+// withInputPaused stops the PTY from receiving keystrokes while fn reads
+// from /dev/tty
+func withInputPaused(fn func() (string, error)) (string, error) {
+	pauseFile := filepath.Join(os.Getenv("HOME"), ".mako", "pause_input")
+	os.WriteFile(pauseFile, []byte("1"), 0644)
+	defer os.Remove(pauseFile)
+	return fn()
+}
+
+// maskAPIKey describes an API key without revealing any of it
+func maskAPIKey(value string) string {
+	switch {
+	case value == "":
+		return "(not set)"
+	case secrets.IsRef(value):
+		return value
+	default:
+		return fmt.Sprintf("(set, %d chars)", len(strings.TrimSpace(value)))
+	}
+}
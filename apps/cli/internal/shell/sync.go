@@ -0,0 +1,392 @@
+package shell
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/dirsync"
+	"github.com/fabiobrug/mako.git/internal/export"
+)
+
+// syncKeySecret is the name of the shared sync key in the secrets store
+const syncKeySecret = "sync-key"
+
+// handleRemoteSync manages syncing history with a mako-sync server or a
+// shared directory
+func handleRemoteSync(args []string, db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	reset := "\033[0m"
+
+	switch args[0] {
+	case "init":
+		if len(args) < 2 {
+			return getSyncUsage(), nil
+		}
+		return handleSyncSetup(db, args[1], "")
+	case "join":
+		if len(args) < 3 {
+			return getSyncUsage(), nil
+		}
+		return handleSyncSetup(db, args[1], args[2])
+	case "dir":
+		return handleSyncDir(db, args[1:])
+	case "now":
+		return handleSyncNow(db)
+	case "status":
+		return handleSyncStatus(db)
+	case "key":
+		return handleSyncKey()
+	case "off":
+		return handleSyncOff()
+	default:
+		return fmt.Sprintf("\r\n%sUnknown sync subcommand: %s%s\r\n", lightBlue, args[0], reset) + getSyncUsage(), nil
+	}
+}
+
+func getSyncUsage() string {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	output := fmt.Sprintf("\r\n%sUsage:%s\r\n", lightBlue, reset)
+	output += fmt.Sprintf("  %smako sync%s                       Import bash history, then sync with the server\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako sync init <url>%s            Start syncing with a new sync key\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako sync join <url> <key>%s      Sync with devices that share a key\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako sync dir <path> [key]%s      Sync through a shared folder or git repo\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako sync now%s                   Push and pull history now\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako sync status%s                Show device ID, server, folder and pending commands\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako sync key%s                   Show the sync key to join another device\r\n", cyan, reset)
+	output += fmt.Sprintf("  %smako sync off%s                   Stop syncing\r\n\r\n", cyan, reset)
+	output += fmt.Sprintf("%sHistory is encrypted with the sync key before upload; run your own server with mako-sync.%s\r\n", gray, reset)
+	output += fmt.Sprintf("%sWith a folder, use --skip, --merge (default) or --overwrite for commands both devices ran.%s\r\n\r\n", gray, reset)
+	return output
+}
+
+// handleSyncSetup stores the server and key, then runs a first sync. An
+// empty key starts a new shared history with a generated one.
+func handleSyncSetup(db *database.DB, server, key string) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	cyan := "\033[38;2;0;209;255m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	created := key == ""
+	if created {
+		key = database.GenerateSyncKey()
+	}
+	client, err := database.NewSyncClient(db, server, key)
+	if err != nil {
+		return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := unlockSecretsFromTTY(cfg); err != nil {
+		return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+	}
+	ref, err := cfg.StoreSecret(syncKeySecret, key)
+	if err != nil {
+		return fmt.Sprintf("\r\n%s✗ Failed to store sync key: %v%s\r\n\r\n", red, err, reset), nil
+	}
+	cfg.SyncServer = server
+	cfg.SyncKey = ref
+	if err := cfg.Save(); err != nil {
+		return "", fmt.Errorf("failed to save config: %w", err)
+	}
+
+	output := fmt.Sprintf("\r\n%s✓ Syncing with %s%s\r\n", green, server, reset)
+	if result, err := client.Sync(); err != nil {
+		output += fmt.Sprintf("%s  First sync failed: %v%s\r\n", red, err, reset)
+	} else {
+		output += fmt.Sprintf("%s  Pushed %d, pulled %d commands%s\r\n", gray, result.Pushed, result.Pulled, reset)
+	}
+	if created {
+		output += fmt.Sprintf("\r\n%s  To add another device, run there:%s\r\n", gray, reset)
+		output += fmt.Sprintf("  %smako sync join %s %s%s\r\n", cyan, server, key, reset)
+		output += fmt.Sprintf("%s  Keep the key secret: anyone with it can read your synced history%s\r\n", gray, reset)
+	}
+	return output + "\r\n", nil
+}
+
+func handleSyncNow(db *database.DB) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	reset := "\033[0m"
+
+	result, err := syncWithServer(db)
+	if err != nil {
+		return fmt.Sprintf("\r\n%s✗ Sync failed: %v%s\r\n\r\n", red, err, reset), nil
+	}
+	dirResult, dirErr := syncWithDir(db)
+	if result == nil && dirResult == nil && dirErr == nil {
+		return "\r\nSync is not set up. Run 'mako sync init <url>' or 'mako sync dir <path>' first.\r\n\r\n", nil
+	}
+
+	output := "\r\n"
+	if result != nil {
+		output += fmt.Sprintf("%s✓ Pushed %d, pulled %d commands (%d already synced)%s\r\n", green, result.Pushed, result.Pulled, result.Duplicates, reset)
+	}
+	output += formatDirSync(dirResult, dirErr)
+	return output + "\r\n", nil
+}
+
+// handleSyncDir sets up syncing through a shared directory. The sync key is
+// reused when remote sync already has one, so both backends share a history.
+func handleSyncDir(db *database.DB, args []string) (string, error) {
+	green := "\033[38;2;100;255;100m"
+	cyan := "\033[38;2;0;209;255m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	conflict := export.ConflictMerge
+	var positional []string
+	for _, arg := range args {
+		switch arg {
+		case "--skip":
+			conflict = export.ConflictSkip
+		case "--merge":
+			conflict = export.ConflictMerge
+		case "--overwrite":
+			conflict = export.ConflictOverwrite
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 {
+		return getSyncUsage(), nil
+	}
+	dir := positional[0]
+
+	cfg, key, err := loadSyncKey()
+	if err != nil {
+		return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+	}
+	if len(positional) > 1 {
+		key = positional[1]
+	}
+	created := key == ""
+	if created {
+		key = database.GenerateSyncKey()
+	}
+	syncer, err := dirsync.New(db, dir, key)
+	if err != nil {
+		return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+	}
+	syncer.Conflict = conflict
+
+	if err := unlockSecretsFromTTY(cfg); err != nil {
+		return fmt.Sprintf("\r\n%s✗ %v%s\r\n\r\n", red, err, reset), nil
+	}
+	ref, err := cfg.StoreSecret(syncKeySecret, key)
+	if err != nil {
+		return fmt.Sprintf("\r\n%s✗ Failed to store sync key: %v%s\r\n\r\n", red, err, reset), nil
+	}
+	cfg.SyncDir = syncer.Dir()
+	cfg.SyncConflict = string(conflict)
+	cfg.SyncKey = ref
+	if err := cfg.Save(); err != nil {
+		return "", fmt.Errorf("failed to save config: %w", err)
+	}
+
+	output := fmt.Sprintf("\r\n%s✓ Syncing through %s%s\r\n", green, syncer.Dir(), reset)
+	result, err := syncer.Sync()
+	output += formatDirSync(result, err)
+	if created {
+		output += fmt.Sprintf("\r\n%s  To add another device, point it at the same folder and run:%s\r\n", gray, reset)
+		output += fmt.Sprintf("  %smako sync dir <path> %s%s\r\n", cyan, key, reset)
+		output += fmt.Sprintf("%s  Keep the key secret: anyone with it can read your synced history%s\r\n", gray, reset)
+	}
+	return output + "\r\n", nil
+}
+
+// formatDirSync describes a directory sync, or nothing if none ran
+func formatDirSync(result *dirsync.Result, err error) string {
+	green := "\033[38;2;100;255;100m"
+	red := "\033[38;2;255;100;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if err != nil {
+		return fmt.Sprintf("%s✗ Folder sync failed: %v%s\r\n", red, err, reset)
+	}
+	if result == nil {
+		return ""
+	}
+	output := fmt.Sprintf("%s✓ Wrote %d, imported %d commands from %d devices (%d already here)%s\r\n",
+		green, result.Exported, result.Imported, result.Devices, result.Updated+result.Skipped, reset)
+	for _, warning := range result.Warnings {
+		output += fmt.Sprintf("%s  ! %s%s\r\n", gray, warning, reset)
+	}
+	return output
+}
+
+func handleSyncStatus(db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	reset := "\033[0m"
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %w", err)
+	}
+	status, err := db.RemoteSyncStatus()
+	if err != nil {
+		return "", err
+	}
+
+	server := cfg.SyncServer
+	if server == "" {
+		server = "(not set up)"
+	}
+	lastSync := "never"
+	if !status.LastSync.IsZero() {
+		lastSync = status.LastSync.Local().Format("2006-01-02 15:04") + fmt.Sprintf(" (%s ago)", time.Since(status.LastSync).Round(time.Second))
+	}
+
+	output := "\r\n"
+	output += fmt.Sprintf("  %s%-12s%s %s\r\n", lightBlue, "server", reset, server)
+	if cfg.SyncDir != "" {
+		output += fmt.Sprintf("  %s%-12s%s %s\r\n", lightBlue, "folder", reset, cfg.SyncDir)
+	}
+	output += fmt.Sprintf("  %s%-12s%s %s\r\n", lightBlue, "device", reset, status.DeviceID)
+	output += fmt.Sprintf("  %s%-12s%s %s\r\n", lightBlue, "last sync", reset, lastSync)
+	output += fmt.Sprintf("  %s%-12s%s %d commands\r\n", lightBlue, "to push", reset, status.Pending)
+	output += fmt.Sprintf("  %s%-12s%s %d\r\n", lightBlue, "pull cursor", reset, status.PullCursor)
+
+	if cfg.SyncDir == "" {
+		return output + "\r\n", nil
+	}
+	syncer, err := newDirSyncer(db)
+	if err != nil {
+		return output + fmt.Sprintf("\r\n  Folder: %v\r\n\r\n", err), nil
+	}
+	dirStatus, err := syncer.Status()
+	if err != nil {
+		return "", err
+	}
+	folderSync := "never"
+	if !dirStatus.LastSync.IsZero() {
+		folderSync = dirStatus.LastSync.Local().Format("2006-01-02 15:04") + fmt.Sprintf(" (%s ago)", time.Since(dirStatus.LastSync).Round(time.Second))
+	}
+	kind := "folder"
+	if dirStatus.Git {
+		kind = "git repository"
+	}
+	output += fmt.Sprintf("\r\n  %s%-12s%s %s\r\n", lightBlue, "folder sync", reset, folderSync)
+	output += fmt.Sprintf("  %s%-12s%s %s\r\n", lightBlue, "folder type", reset, kind)
+	output += fmt.Sprintf("  %s%-12s%s %d commands\r\n", lightBlue, "to write", reset, dirStatus.Pending)
+	for _, device := range dirStatus.Devices {
+		output += fmt.Sprintf("  %s%-12s%s %s: %d of %d segments imported\r\n", lightBlue, "device", reset, device.ID, device.Seen, device.Segments)
+	}
+	return output + "\r\n", nil
+}
+
+func handleSyncKey() (string, error) {
+	cyan := "\033[38;2;0;209;255m"
+	reset := "\033[0m"
+
+	cfg, key, err := loadSyncKey()
+	if err != nil {
+		return fmt.Sprintf("Error: %v\r\n", err), nil
+	}
+	if key == "" {
+		return "\r\nSync is not set up. Run 'mako sync init <url>' or 'mako sync dir <path>' first.\r\n\r\n", nil
+	}
+	output := "\r\nOn another device, run:\r\n"
+	if cfg.SyncServer != "" {
+		output += fmt.Sprintf("  %smako sync join %s %s%s\r\n", cyan, cfg.SyncServer, key, reset)
+	}
+	if cfg.SyncDir != "" {
+		output += fmt.Sprintf("  %smako sync dir <path> %s%s\r\n", cyan, key, reset)
+	}
+	return output + "\r\n", nil
+}
+
+func handleSyncOff() (string, error) {
+	green := "\033[38;2;100;255;100m"
+	reset := "\033[0m"
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.SyncServer == "" && cfg.SyncDir == "" {
+		return "\r\nSync is not set up.\r\n\r\n", nil
+	}
+	if store, err := cfg.SecretStore(); err == nil {
+		store.Delete(syncKeySecret)
+	}
+	cfg.SyncServer = ""
+	cfg.SyncDir = ""
+	cfg.SyncConflict = ""
+	cfg.SyncKey = ""
+	if err := cfg.Save(); err != nil {
+		return "", fmt.Errorf("failed to save config: %w", err)
+	}
+	return fmt.Sprintf("\r\n%s✓ Sync turned off; synced history stays on this device%s\r\n\r\n", green, reset), nil
+}
+
+// syncWithServer runs a remote sync if one is set up, returning nil otherwise
+func syncWithServer(db *database.DB) (*database.SyncResult, error) {
+	cfg, key, err := loadSyncKey()
+	if err != nil || key == "" || cfg.SyncServer == "" {
+		return nil, err
+	}
+	client, err := database.NewSyncClient(db, cfg.SyncServer, key)
+	if err != nil {
+		return nil, err
+	}
+	return client.Sync()
+}
+
+// syncWithDir runs a directory sync if one is set up, returning nil otherwise
+func syncWithDir(db *database.DB) (*dirsync.Result, error) {
+	syncer, err := newDirSyncer(db)
+	if err != nil || syncer == nil {
+		return nil, err
+	}
+	return syncer.Sync()
+}
+
+// newDirSyncer returns the configured directory syncer, or nil when
+// directory sync is not set up
+func newDirSyncer(db *database.DB) (*dirsync.Syncer, error) {
+	cfg, key, err := loadSyncKey()
+	if err != nil || key == "" || cfg.SyncDir == "" {
+		return nil, err
+	}
+	syncer, err := dirsync.New(db, cfg.SyncDir, key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SyncConflict != "" {
+		syncer.Conflict = export.ConflictStrategy(cfg.SyncConflict)
+	}
+	return syncer, nil
+}
+
+// loadSyncKey returns the config and the resolved sync key, or an empty key
+// when sync is not set up
+func loadSyncKey() (*config.Config, string, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load config: %w", err)
+	}
+	if (cfg.SyncServer == "" && cfg.SyncDir == "") || cfg.SyncKey == "" {
+		return cfg, "", nil
+	}
+	if err := unlockSecretsFromTTY(cfg); err != nil {
+		return nil, "", err
+	}
+	key, err := config.ResolveSecret(cfg.SyncKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read sync key: %w", err)
+	}
+	return cfg, key, nil
+}
@@ -14,6 +14,17 @@ func getHelpText() string {
 %s╭─ Mako Commands%s
 %s│%s
 %s│%s  %smako ask <question>%s              Generate command from natural language
+%s│%s  %smako ask --show-prompt <q>%s       Show the redacted prompt without sending
+%s│%s  %smako ask --verbose <q>%s           Also show the tokens used and what was trimmed
+%s│%s  %smako ask --thread <name> <q>%s     Ask within a named conversation thread
+%s│%s  %smako chat [--thread <name>]%s      Chat about your terminal, run suggestions
+%s│%s  %smako chat list | show [name]%s     List or show conversation threads
+%s│%s  %smako chat resume | delete <name>%s Continue or delete a thread
+%s│%s  %smako do "<goal>"%s                 Plan and run a task, approving each step
+%s│%s  %smako do list | show <id>%s         Past tasks and their transcripts
+%s│%s  %smako explain "<command>"%s         Explain each flag from man pages, offline
+%s│%s  %smako fix%s                         Pick a fix for the last failed command
+%s│%s  %smako why%s                         Explain the last failed command (Alt-E)
 %s│%s  
 %s│%s  %smako history%s                     Show recent commands
 %s│%s  %smako history <keyword>%s           Search by keyword
@@ -21,13 +32,18 @@ func getHelpText() string {
 %s│%s  %smako history --failed%s            Show only failed commands
 %s│%s  %smako history --success%s           Show only successful commands
 %s│%s  %smako history --interactive%s       Browse history interactively
+%s│%s  %smako history scrub%s               Redact secrets in saved history
 %s│%s  
 %s│%s  %smako alias save <name> <cmd>%s     Save a command alias
 %s│%s  %smako alias list [--tag <tag>]%s    List all saved aliases
 %s│%s  %smako alias run <name> [args]%s     Run a saved alias with parameters
 %s│%s  %smako alias delete <name>%s         Delete an alias
+%s│%s  %smako alias sources%s               Show user, project and team aliases
 %s│%s  %smako alias export <file>%s         Export aliases to file
 %s│%s  %smako alias import <file>%s         Import aliases from file
+%s│%s  %smako prefs show|forget|reset%s     Inspect the preferences learned for the AI
+%s│%s  %smako prompts show|edit|reset%s     Customise the prompts sent to the AI
+%s│%s  %smako usage [--since 30d] [--by]%s  AI calls, tokens and estimated cost
 %s│%s  
 %s│%s  %smako config list%s                 Show all configuration settings
 %s│%s  %smako config get <key>%s            Get configuration value
@@ -35,17 +51,32 @@ func getHelpText() string {
 %s│%s  %smako config reset%s                Reset to default configuration
 %s│%s  %smako config providers%s            List all configured AI providers
 %s│%s  %smako config switch <provider>%s    Switch active AI provider
+%s│%s  %smako secrets [list]%s              List secrets in the secrets store
+%s│%s  %smako secrets set <name>%s          Store a secret (use as secret://name)
+%s│%s  %smako secrets migrate%s             Move plaintext API keys to the store
 %s│%s  
 %s│%s  %smako update check%s                Check for updates
 %s│%s  %smako update install%s              Install latest version
 %s│%s  
 %s│%s  %smako stats%s                       Show statistics
 %s│%s  %smako health%s                      Check Mako health and performance
-%s│%s  %smako export [--last N] > file%s    Export command history to JSON
-%s│%s  %smako import <file>%s               Import commands from JSON
+%s│%s  %smako export [--format F] -o file%s  Export history as json, ndjson, csv, sh or md
+%s│%s  %smako import <file>%s               Import commands from JSON, NDJSON or CSV
+%s│%s  %smako import --from <tool> [path]%s Import bash, zsh, fish, atuin or McFly history
 %s│%s  %smako sync%s                        Sync bash history to Mako
+%s│%s  %smako sync init <url>%s             Sync history via a mako-sync server
+%s│%s  %smako sync dir <path> [key]%s       Sync history via a shared folder or git repo
+%s│%s  %smako sync now | status | key%s     Sync now, show state or share key
+%s│%s  %smako db encrypt [--passphrase]%s   Encrypt saved history at rest
+%s│%s  %smako db decrypt%s                  Store history as plaintext again
+%s│%s  %smako db migrate [--status]%s       Apply or list schema migrations
+%s│%s  %smako db stats%s                    Show per-table database sizes
+%s│%s  %smako db vacuum | optimize%s        Reclaim space, tune search index
+%s│%s  %smako db prune --older-than 180d%s  Delete old history (--embeddings)
+%s│%s  %smako backup create | list%s        Snapshot history, aliases and config
+%s│%s  %smako backup restore <file>%s       Restore a backup (or latest)
 %s│%s  
-%s│%s  %smako clear%s                       Clear conversation history
+%s│%s  %smako clear%s                       Clear this terminal's conversation
 %s│%s  %smako completion <bash|zsh|fish>%s  Generate shell completion script
 %s│%s  %smako setup%s                       Run setup wizard (exit Mako first)
 %s│%s  %smako help%s                        Show this help
@@ -56,6 +87,17 @@ func getHelpText() string {
 `, lightBlue, reset,
 		lightBlue, reset,
 		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
 		lightBlue, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
@@ -63,6 +105,7 @@ func getHelpText() string {
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
 		lightBlue, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
@@ -70,6 +113,10 @@ func getHelpText() string {
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
 		lightBlue, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
@@ -77,6 +124,9 @@ func getHelpText() string {
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
 		lightBlue, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
@@ -86,6 +136,18 @@ func getHelpText() string {
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
+		lightBlue, reset, cyan, reset,
 		lightBlue, reset,
 		lightBlue, reset, cyan, reset,
 		lightBlue, reset, cyan, reset,
@@ -180,6 +242,10 @@ func getContextualHelp(topic string) string {
 %s│%s  %smako alias save <name> <command>%s  Save a command
 %s│%s  %smako alias list%s                   List all aliases
 %s│%s  %smako alias list --tag <tag>%s       List by tag
+%s│%s  %smako alias list --source <src>%s    List user, project or team aliases
+%s│%s  %smako alias save --project ...%s     Save to .mako/aliases.json in the repo
+%s│%s  %smako alias sources [--refresh]%s    Show alias layers and checksums
+%s│%s  %smako alias checksum <file>%s        Seal a hand-edited alias file
 %s│%s  %smako alias run <name>%s             Run an alias
 %s│%s  %smako alias delete <name>%s          Delete an alias
 %s│%s  %smako alias export <file>%s          Export to file
@@ -190,6 +256,10 @@ func getContextualHelp(topic string) string {
 %s│%s  %smako alias save backup "tar -czf backup.tar.gz ."%s
 %s│%s  %smako alias run deploy%s
 %s│%s
+%s│%s  %sTemplates:%s
+%s│%s  %smako alias save k8s 'kubectl --context {{env:prod|staging}} apply -f {{file=app.yaml}}'%s
+%s│%s  Missing values are asked for; give them in order or as --env prod
+%s│%s
 %s│%s  %sTagging:%s
 %s│%s  You can organize aliases with tags in the description:
 %s│%s  %smako alias save deploy "git push" --tag git%s
@@ -207,6 +277,10 @@ func getContextualHelp(topic string) string {
 			lightBlue, reset, cyan, reset,
 			lightBlue, reset, cyan, reset,
 			lightBlue, reset, cyan, reset,
+			lightBlue, reset, cyan, reset,
+			lightBlue, reset, cyan, reset,
+			lightBlue, reset, cyan, reset,
+			lightBlue, reset, cyan, reset,
 			lightBlue, reset,
 			lightBlue, reset, cyan, reset,
 			lightBlue, reset, cyan, reset,
@@ -214,6 +288,10 @@ func getContextualHelp(topic string) string {
 			lightBlue, reset, cyan, reset,
 			lightBlue, reset,
 			lightBlue, reset, cyan, reset,
+			lightBlue, reset, cyan, reset,
+			lightBlue, reset,
+			lightBlue, reset,
+			lightBlue, reset, cyan, reset,
 			lightBlue, reset,
 			lightBlue, reset, cyan, reset,
 			lightBlue, reset,
@@ -275,11 +353,15 @@ func getContextualHelp(topic string) string {
 %s│%s  %smako config reset%s              Reset to defaults
 %s│%s
 %s│%s  %sKey settings:%s
-%s│%s  • api_key         Your AI provider API key
+%s│%s  • api_key         Your AI provider API key (kept in the secrets store)
+%s│%s  • secrets_backend file, secret-service, pass or command (auto if unset)
 %s│%s  • llm_provider    AI provider (gemini, openai, anthropic, etc.)
 %s│%s  • llm_model       Model to use for command generation
+%s│%s  • llm_context_window Tokens the model takes in (looked up if unset)
 %s│%s  • cache_size      Embedding cache size
 %s│%s  • auto_update     Check for updates on startup
+%s│%s  • usage_monthly_budget Estimated USD of AI calls a month (see mako usage)
+%s│%s  • usage_budget_action  warn or block once the budget is reached
 %s│%s
 %s│%s  %sHealth check:%s
 %s│%s  %smako health%s  Validate your configuration
@@ -302,6 +384,10 @@ func getContextualHelp(topic string) string {
 			lightBlue, reset,
 			lightBlue, reset,
 			lightBlue, reset,
+			lightBlue, reset,
+			lightBlue, reset,
+			lightBlue, reset,
+			lightBlue, reset,
 			lightBlue, reset, cyan, reset,
 			lightBlue, reset, cyan, reset,
 			lightBlue, reset,
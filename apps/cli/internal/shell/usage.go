@@ -0,0 +1,178 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fabiobrug/mako.git/internal/ai"
+	"github.com/fabiobrug/mako.git/internal/config"
+	"github.com/fabiobrug/mako.git/internal/database"
+	"github.com/fabiobrug/mako.git/internal/prompts"
+)
+
+// featureCommands names what made each kind of call
+var featureCommands = map[string]string{
+	prompts.Command:        "mako ask",
+	prompts.Chat:           "mako chat",
+	prompts.Task:           "mako do",
+	prompts.ExplainError:   "mako why / fix",
+	prompts.ExplainCommand: "mako ask: explain",
+	prompts.Alternatives:   "mako ask: alternatives",
+	prompts.ExplainParts:   "mako explain",
+	ai.FeatureEmbedding:    "embeddings",
+}
+
+// usageLog records AI calls in the history database
+type usageLog struct {
+	db *database.DB
+}
+
+// NewUsageLog returns the log main gives ai.SetUsageLog
+func NewUsageLog(db *database.DB) ai.UsageLog {
+	return usageLog{db: db}
+}
+
+func (l usageLog) RecordUsage(usage ai.Usage) error {
+	return l.db.RecordUsage(database.UsageRecord{
+		CalledAt:     usage.CalledAt,
+		Provider:     usage.Provider,
+		Model:        usage.Model,
+		Feature:      usage.Feature,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		Estimated:    usage.Estimated,
+		Latency:      usage.Latency,
+		Cost:         usage.Cost,
+		Priced:       usage.Priced,
+	})
+}
+
+func (l usageLog) SpentSince(since time.Time) (float64, error) {
+	return l.db.SpentSince(since)
+}
+
+// handleUsage handles `mako usage [--since T] [--by provider|model|command]`
+func handleUsage(args []string, db *database.DB) (string, error) {
+	lightBlue := "\033[38;2;93;173;226m"
+	cyan := "\033[38;2;0;209;255m"
+	yellow := "\033[38;2;255;200;100m"
+	gray := "\033[38;2;150;150;150m"
+	reset := "\033[0m"
+
+	if db == nil {
+		return fmt.Sprintf("\r\n%s✗ Database not available%s\r\n\r\n", gray, reset), nil
+	}
+
+	usage := "Usage: mako usage [--since 30d] [--by provider|model|command]\r\n"
+	sinceArg, by := "30d", "provider"
+	for i := 0; i < len(args); i++ {
+		switch {
+		case (args[i] == "--since" || args[i] == "--by") && i+1 < len(args):
+			if args[i] == "--since" {
+				sinceArg = args[i+1]
+			} else {
+				by = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(args[i], "--since="):
+			sinceArg = strings.TrimPrefix(args[i], "--since=")
+		case strings.HasPrefix(args[i], "--by="):
+			by = strings.TrimPrefix(args[i], "--by=")
+		default:
+			return usage, nil
+		}
+	}
+	since, err := parseTimeArg(sinceArg, false)
+	if err != nil {
+		return fmt.Sprintf("Error: %v\r\n", err), nil
+	}
+	grouping := map[string]string{
+		"provider": database.UsageByProvider,
+		"model":    database.UsageByModel,
+		"command":  database.UsageByFeature,
+	}[by]
+	if grouping == "" {
+		return usage, nil
+	}
+
+	totals, err := db.UsageTotals(since, grouping)
+	if err != nil {
+		return fmt.Sprintf("Error: %v\r\n", err), nil
+	}
+
+	output := fmt.Sprintf("\r\n%s╭─ AI Usage by %s since %s%s\r\n", lightBlue, by, since.Format("2006-01-02"), reset)
+	if len(totals) == 0 {
+		output += fmt.Sprintf("%s│%s  %sNo AI calls recorded in this period%s\r\n", lightBlue, reset, gray, reset)
+	} else {
+		output += fmt.Sprintf("%s│%s  %s%-26s %6s %10s %10s %10s %8s%s\r\n", lightBlue, reset, gray,
+			"", "calls", "input", "output", "cost", "latency", reset)
+	}
+	var all database.UsageTotal
+	for _, total := range totals {
+		name := total.Key
+		if grouping == database.UsageByFeature && featureCommands[name] != "" {
+			name = featureCommands[name]
+		}
+		output += fmt.Sprintf("%s│%s  %s%-26s%s %6d %10s %10s %10s %8s\r\n", lightBlue, reset,
+			cyan, truncateForDisplay(name, 26), reset, total.Calls,
+			formatTokens(total.InputTokens), formatTokens(total.OutputTokens),
+			formatCost(total.Cost, total.Unpriced, total.Calls), total.Latency.Round(10*time.Millisecond))
+		all.Calls += total.Calls
+		all.InputTokens += total.InputTokens
+		all.OutputTokens += total.OutputTokens
+		all.Cost += total.Cost
+		all.Unpriced += total.Unpriced
+	}
+	if len(totals) > 1 {
+		output += fmt.Sprintf("%s│%s  %-26s %6d %10s %10s %10s\r\n", lightBlue, reset, "Total", all.Calls,
+			formatTokens(all.InputTokens), formatTokens(all.OutputTokens), formatCost(all.Cost, all.Unpriced, all.Calls))
+	}
+	output += fmt.Sprintf("%s╰─ %sCosts are estimates from list prices%s\r\n", lightBlue, gray, reset)
+	if all.Unpriced > 0 {
+		output += fmt.Sprintf("%s%d call(s) used models without a known price and aren't in the cost%s\r\n", gray, all.Unpriced, reset)
+	}
+
+	if cfg, err := config.LoadConfig(); err == nil && cfg.UsageBudget > 0 {
+		spent, err := db.SpentSince(ai.BudgetPeriodStart(time.Now()))
+		if err == nil {
+			action := cfg.UsageBudgetAction
+			if action == "" {
+				action = "warn"
+			}
+			colour := gray
+			if spent >= cfg.UsageBudget {
+				colour = yellow
+			}
+			output += fmt.Sprintf("%sThis month: $%.2f of the $%.2f budget (%s when reached)%s\r\n", colour, spent, cfg.UsageBudget, action, reset)
+		}
+	}
+	return output + "\r\n", nil
+}
+
+// formatTokens renders a token count like 12.3k
+func formatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// formatCost renders an estimated cost, marking totals that leave out
+// unpriced calls
+func formatCost(cost float64, unpriced, calls int) string {
+	if unpriced == calls {
+		return "n/a"
+	}
+	text := fmt.Sprintf("$%.4f", cost)
+	if cost >= 1 {
+		text = fmt.Sprintf("$%.2f", cost)
+	}
+	if unpriced > 0 {
+		text += "+"
+	}
+	return text
+}
@@ -2,11 +2,16 @@ package stream
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/fabiobrug/mako.git/internal/buffer"
@@ -26,16 +31,50 @@ func IsMenuActive() bool {
 
 var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
 
+// The shell hooks report a command that exited non-zero with a private OSC
+// sequence carrying the session's nonce, the exit code and the base64
+// command. Terminals ignore unknown OSC sequences, so one that slips through
+// does no harm. Reports without the nonce were printed by some program, not
+// the hooks, and are dropped.
+var (
+	exitReportPrefix = []byte("\x1b]6973;mako-exit;")
+	exitReportRegex  = regexp.MustCompile(`\x1b\]6973;mako-exit;([0-9a-f]*);(\d+);([A-Za-z0-9+/=]*)\x07`)
+	exitReportBody   = regexp.MustCompile(`^[0-9a-f]*(;\d*(;[A-Za-z0-9+/=]*)?)?$`)
+)
+
+// maxExitReport bounds a held partial report. Reports grow with the
+// command, which the shell limits to ARG_MAX (2 MiB on Linux) and base64
+// makes a third longer.
+const maxExitReport = 3 << 20
+
 type Interceptor struct {
-	buffer *buffer.RingBuffer
-	db     *database.DB
-	writer io.Writer
+	buffer    *buffer.RingBuffer
+	db        *database.DB
+	writer    io.Writer
+	exitNonce string
 }
 
 func NewInterceptor(bufferSize int) *Interceptor {
 	return &Interceptor{
-		buffer: buffer.NewRingBuffer(bufferSize),
+		buffer:    buffer.NewRingBuffer(bufferSize),
+		exitNonce: newNonce(),
+	}
+}
+
+// newNonce returns 16 random bytes in hex
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
 	}
+	return hex.EncodeToString(b)
+}
+
+// ExitNonce is the secret the shell hooks put in their exit reports. It is
+// kept in an unexported shell variable, so programs run in the session
+// don't see it.
+func (i *Interceptor) ExitNonce() string {
+	return i.exitNonce
 }
 
 func (i *Interceptor) SetDatabase(db *database.DB) {
@@ -49,10 +88,22 @@ func (i *Interceptor) Tee(dst io.Writer, src io.Reader) error {
 
 	cmdFile := filepath.Join(os.Getenv("HOME"), ".mako", "last_command.txt")
 
+	var pending []byte
+
 	for {
 		n, err := src.Read(buf)
 		if n > 0 {
 			data := buf[:n]
+			if len(pending) > 0 {
+				data = append(pending, data...)
+				pending = nil
+			}
+
+			// Hold back an exit report split across reads until it's complete
+			if start := incompleteExitReport(data); start >= 0 {
+				pending = append([]byte(nil), data[start:]...)
+				data = data[:start]
+			}
 
 			// Check if this chunk contains the marker BEFORE writing to dst
 			dataStr := string(data)
@@ -100,32 +151,18 @@ func (i *Interceptor) Tee(dst io.Writer, src io.Reader) error {
 				continue
 			}
 
-			// Normal path: write to destination
-			dst.Write(data)
-
-			// Buffer for line detection
-			lineBuffer.Write(data)
-			fullData := lineBuffer.Bytes()
-			lastNewline := bytes.LastIndexByte(fullData, '\n')
-
-			if lastNewline >= 0 {
-				lines := bytes.Split(fullData[:lastNewline+1], []byte{'\n'})
-
-				for _, line := range lines {
-					lineStr := string(line)
-					cleanLine := i.stripANSI(lineStr)
-					cleanLine = strings.TrimSpace(cleanLine)
-
-					if len(cleanLine) > 0 {
-						i.buffer.Write(cleanLine)
-					}
-				}
-
-				lineBuffer.Reset()
-				if lastNewline+1 < len(fullData) {
-					lineBuffer.Write(fullData[lastNewline+1:])
+			// Normal path: write to destination, showing a hint in place
+			// of each exit report
+			for {
+				loc := exitReportRegex.FindSubmatchIndex(data)
+				if loc == nil {
+					break
 				}
+				i.forward(dst, data[:loc[0]], lineBuffer)
+				i.reportExit(dst, string(data[loc[2]:loc[3]]), string(data[loc[4]:loc[5]]), string(data[loc[6]:loc[7]]))
+				data = data[loc[1]:]
 			}
+			i.forward(dst, data, lineBuffer)
 		}
 
 		if err != nil {
@@ -145,6 +182,79 @@ func (i *Interceptor) Tee(dst io.Writer, src io.Reader) error {
 	}
 }
 
+// incompleteExitReport returns where an exit report cut off by the end of
+// data starts, or -1. A report is held however long it is, for as long as
+// everything after its prefix could still belong to one.
+func incompleteExitReport(data []byte) int {
+	start := bytes.LastIndexByte(data, 0x1b)
+	if start < 0 || len(data)-start > maxExitReport {
+		return -1
+	}
+	tail := data[start:]
+	if len(tail) < len(exitReportPrefix) {
+		if bytes.HasPrefix(exitReportPrefix, tail) {
+			return start
+		}
+		return -1
+	}
+	if bytes.HasPrefix(tail, exitReportPrefix) && exitReportBody.Match(tail[len(exitReportPrefix):]) {
+		return start
+	}
+	return -1
+}
+
+// forward writes shell output through and adds its complete lines to the
+// ring buffer
+func (i *Interceptor) forward(dst io.Writer, data []byte, lineBuffer *bytes.Buffer) {
+	if len(data) == 0 {
+		return
+	}
+	dst.Write(data)
+
+	// Buffer for line detection
+	lineBuffer.Write(data)
+	fullData := lineBuffer.Bytes()
+	lastNewline := bytes.LastIndexByte(fullData, '\n')
+
+	if lastNewline >= 0 {
+		lines := bytes.Split(fullData[:lastNewline+1], []byte{'\n'})
+
+		for _, line := range lines {
+			lineStr := string(line)
+			cleanLine := i.stripANSI(lineStr)
+			cleanLine = strings.TrimSpace(cleanLine)
+
+			if len(cleanLine) > 0 {
+				i.buffer.Write(cleanLine)
+			}
+		}
+
+		lineBuffer.Reset()
+		if lastNewline+1 < len(fullData) {
+			lineBuffer.Write(fullData[lastNewline+1:])
+		}
+	}
+}
+
+// reportExit records a failed command from the shell hooks and shows the
+// hint for explaining it
+func (i *Interceptor) reportExit(dst io.Writer, nonce, code, encoded string) {
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(i.exitNonce)) != 1 {
+		return
+	}
+	exitCode, err := strconv.Atoi(code)
+	if err != nil {
+		return
+	}
+	command, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return
+	}
+	if hint := shell.RecordFailure(string(command), exitCode, i.buffer.GetLines(shell.FailureOutputLines)); hint != "" {
+		dst.Write([]byte(hint))
+	}
+}
+
 func (i *Interceptor) stripANSI(s string) string {
 	return ansiRegex.ReplaceAllString(s, "")
 }
@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+// chunkReader returns one chunk per Read, like a pty handing over output
+// as it arrives
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if r.chunks[0] == "" {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+func exitReport(nonce string, code int, command string) string {
+	return fmt.Sprintf("\x1b]6973;mako-exit;%s;%d;%s\a", nonce, code, base64.StdEncoding.EncodeToString([]byte(command)))
+}
+
+func TestIncompleteExitReport(t *testing.T) {
+	report := exitReport("abc123", 1, "make")
+	long := exitReport("abc123", 1, "cd "+strings.Repeat("/very/long/path", 100)+" && make")
+
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"no escape", "plain output\n", -1},
+		{"complete report", "out\n" + report, -1},
+		{"cut inside the prefix", "out\n\x1b]69", 4},
+		{"lone escape at the end", "out\n\x1b", 4},
+		{"cut inside the payload", "out\n" + report[:len(report)-3], 4},
+		{"other escape sequence", "out\n\x1b[0m", -1},
+		{"other OSC cut off", "out\n\x1b]0;title", -1},
+		{"long report cut off", "out\n" + long[:len(long)-1], 4},
+		{"not a report after the prefix", "out\n\x1b]6973;mako-exit;zz\r\n", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := incompleteExitReport([]byte(tt.data)); got != tt.want {
+				t.Errorf("incompleteExitReport(%q) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTeeReportsExitSplitAcrossReads(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+	i := NewInterceptor(100)
+	report := exitReport(i.ExitNonce(), 2, "make build")
+
+	// The report arrives in three pieces, the first cut inside the prefix
+	src := &chunkReader{chunks: []string{
+		"$ make build\r\nmake: *** No rule\r\n" + report[:5],
+		report[5:20],
+		report[20:] + "$ ",
+	}}
+	var dst bytes.Buffer
+	if err := i.Tee(&dst, src); err != nil {
+		t.Fatalf("Tee() failed: %v", err)
+	}
+
+	out := dst.String()
+	if strings.Contains(out, "mako-exit") {
+		t.Errorf("Exit report leaked into the terminal: %q", out)
+	}
+	if !strings.Contains(out, "exit 2") {
+		t.Errorf("Expected the failure hint, got %q", out)
+	}
+	if !strings.HasPrefix(out, "$ make build\r\nmake: *** No rule\r\n") || !strings.HasSuffix(out, "$ ") {
+		t.Errorf("Expected the surrounding output unchanged, got %q", out)
+	}
+}
+
+func TestTeeReportsLongExit(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+	i := NewInterceptor(100)
+	command := "cd " + strings.Repeat("/very/long/path", 200) + " && make"
+	report := exitReport(i.ExitNonce(), 2, command)
+
+	// Tee reads 1 KiB at a time, so the report spans several reads
+	src := &chunkReader{chunks: []string{"output\r\n" + report + "$ "}}
+	var dst bytes.Buffer
+	if err := i.Tee(&dst, src); err != nil {
+		t.Fatalf("Tee() failed: %v", err)
+	}
+	if out := dst.String(); strings.Contains(out, "mako-exit") || !strings.Contains(out, "exit 2") {
+		t.Errorf("Expected the long report to be parsed, got %q", out)
+	}
+}
+
+func TestTeeDropsForgedExitReport(t *testing.T) {
+	t.Setenv("HOME", testutil.TempDir(t))
+	i := NewInterceptor(100)
+
+	for _, nonce := range []string{"", "0123456789abcdef0123456789abcdef"} {
+		src := &chunkReader{chunks: []string{"output\r\n" + exitReport(nonce, 1, "rm -rf ~") + "$ "}}
+		var dst bytes.Buffer
+		if err := i.Tee(&dst, src); err != nil {
+			t.Fatalf("Tee() failed: %v", err)
+		}
+		if out := dst.String(); strings.Contains(out, "exit 1") || strings.Contains(out, "mako-exit") {
+			t.Errorf("Expected a report with nonce %q to be dropped, got %q", nonce, out)
+		}
+	}
+}
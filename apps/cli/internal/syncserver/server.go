@@ -0,0 +1,225 @@
+// Package syncserver implements mako-sync, a small self-hostable server that
+// relays command history between devices. Records are encrypted by the
+// clients before upload: the server only stores opaque blobs, ordered by a
+// sequence number that clients use as their pull cursor.
+package syncserver
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// MaxBatch is the most records accepted or returned per request
+	MaxBatch = 1000
+
+	// MinTokenLength keeps accounts from being guessable
+	MinTokenLength = 32
+
+	maxBodyBytes = 16 << 20
+)
+
+// Record is one encrypted history entry. ID is derived by the client from the
+// command and its timestamp, so the same entry uploaded twice is stored once.
+type Record struct {
+	Seq      int64  `json:"seq,omitempty"`
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	Data     string `json:"data"`
+}
+
+// PushRequest uploads records from one device
+type PushRequest struct {
+	DeviceID string   `json:"device_id"`
+	Records  []Record `json:"records"`
+}
+
+// PushResponse counts what was stored
+type PushResponse struct {
+	Accepted   int `json:"accepted"`
+	Duplicates int `json:"duplicates"`
+}
+
+// PullResponse returns records in sequence order. More is set when another
+// page is waiting after the last record.
+type PullResponse struct {
+	Records []Record `json:"records"`
+	More    bool     `json:"more"`
+}
+
+// Server stores records in SQLite. Every bearer token is its own account, so
+// devices that share a sync key (and therefore a token) share a history.
+type Server struct {
+	db *sql.DB
+}
+
+// Open opens or creates the server database
+func Open(path string) (*Server, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS records (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			account TEXT NOT NULL,
+			record_id TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			data TEXT NOT NULL,
+			received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (account, record_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_records_account_seq ON records(account, seq);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+	return &Server{db: db}, nil
+}
+
+// Close closes the database
+func (s *Server) Close() error {
+	return s.db.Close()
+}
+
+// Handler returns the HTTP API:
+//
+//	GET  /v1/health                      liveness check, no auth
+//	POST /v1/records                     push a PushRequest
+//	GET  /v1/records?after=SEQ&limit=N   pull records after a cursor
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/v1/records", s.authenticated(func(w http.ResponseWriter, r *http.Request, account string) {
+		switch r.Method {
+		case http.MethodPost:
+			s.push(w, r, account)
+		case http.MethodGet:
+			s.pull(w, r, account)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}))
+	return mux
+}
+
+// authenticated resolves the bearer token to an account. Only a hash of the
+// token is stored.
+func (s *Server) authenticated(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(token) < MinTokenLength {
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		sum := sha256.Sum256([]byte(token))
+		next(w, r, hex.EncodeToString(sum[:]))
+	}
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request, account string) {
+	var req PushRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+	if len(req.Records) > MaxBatch {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d records per request", MaxBatch))
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	defer tx.Rollback()
+
+	var resp PushResponse
+	for _, record := range req.Records {
+		if record.ID == "" || record.Data == "" {
+			writeError(w, http.StatusBadRequest, "records need an id and data")
+			return
+		}
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO records (account, record_id, device_id, data)
+			VALUES (?, ?, ?, ?)
+		`, account, record.ID, req.DeviceID, record.Data)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			resp.Accepted++
+		} else {
+			resp.Duplicates++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) pull(w http.ResponseWriter, r *http.Request, account string) {
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > MaxBatch {
+		limit = MaxBatch
+	}
+
+	// Fetch one extra row to know whether there is another page
+	rows, err := s.db.Query(`
+		SELECT seq, record_id, device_id, data FROM records
+		WHERE account = ? AND seq > ?
+		ORDER BY seq
+		LIMIT ?
+	`, account, after, limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	defer rows.Close()
+
+	resp := PullResponse{Records: []Record{}}
+	for rows.Next() {
+		var record Record
+		if err := rows.Scan(&record.Seq, &record.ID, &record.DeviceID, &record.Data); err != nil {
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		resp.Records = append(resp.Records, record)
+	}
+	if len(resp.Records) > limit {
+		resp.Records = resp.Records[:limit]
+		resp.More = true
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,115 @@
+package syncserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fabiobrug/mako.git/internal/testutil"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server, err := Open(filepath.Join(testutil.TempDir(t), "sync.db"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func call(t *testing.T, ts *httptest.Server, method, path, token string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, ts.URL+path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func TestAuthRequired(t *testing.T) {
+	ts := newTestServer(t)
+
+	if status := call(t, ts, http.MethodGet, "/v1/records", "", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", status)
+	}
+	if status := call(t, ts, http.MethodGet, "/v1/records", "short", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a short token, got %d", status)
+	}
+	if status := call(t, ts, http.MethodGet, "/v1/health", "", nil, nil); status != http.StatusOK {
+		t.Errorf("Expected health check without auth, got %d", status)
+	}
+}
+
+func TestPushDeduplicatesAndPullPages(t *testing.T) {
+	ts := newTestServer(t)
+	token := strings.Repeat("a", MinTokenLength)
+
+	var records []Record
+	for i := 0; i < 5; i++ {
+		records = append(records, Record{ID: fmt.Sprintf("r%d", i), Data: "enc:x"})
+	}
+
+	var pushed PushResponse
+	call(t, ts, http.MethodPost, "/v1/records", token, PushRequest{DeviceID: "laptop", Records: records}, &pushed)
+	if pushed.Accepted != 5 {
+		t.Fatalf("Expected 5 accepted, got %+v", pushed)
+	}
+
+	// The same records from another device are stored once
+	call(t, ts, http.MethodPost, "/v1/records", token, PushRequest{DeviceID: "desktop", Records: records[3:]}, &pushed)
+	if pushed.Accepted != 0 || pushed.Duplicates != 2 {
+		t.Errorf("Expected 2 duplicates, got %+v", pushed)
+	}
+
+	var page PullResponse
+	call(t, ts, http.MethodGet, "/v1/records?after=0&limit=3", token, nil, &page)
+	if len(page.Records) != 3 || !page.More {
+		t.Fatalf("Expected a first page of 3 with more, got %d (more=%v)", len(page.Records), page.More)
+	}
+	if page.Records[0].DeviceID != "laptop" {
+		t.Errorf("Expected device ID to be returned, got %q", page.Records[0].DeviceID)
+	}
+
+	after := page.Records[2].Seq
+	call(t, ts, http.MethodGet, fmt.Sprintf("/v1/records?after=%d&limit=3", after), token, nil, &page)
+	if len(page.Records) != 2 || page.More {
+		t.Errorf("Expected a last page of 2, got %d (more=%v)", len(page.Records), page.More)
+	}
+}
+
+func TestAccountsAreIsolated(t *testing.T) {
+	ts := newTestServer(t)
+	alice := strings.Repeat("a", MinTokenLength)
+	bob := strings.Repeat("b", MinTokenLength)
+
+	call(t, ts, http.MethodPost, "/v1/records", alice, PushRequest{DeviceID: "d", Records: []Record{{ID: "1", Data: "enc:x"}}}, nil)
+
+	var page PullResponse
+	call(t, ts, http.MethodGet, "/v1/records", bob, nil, &page)
+	if len(page.Records) != 0 {
+		t.Errorf("Expected no records for another account, got %d", len(page.Records))
+	}
+}